@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestJSONAccessLogger(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		want    map[string]any
+	}{
+		{
+			name:    "request with a known caller",
+			path:    "/ok",
+			headers: map[string]string{"X-Request-ID": "req-1"},
+			want: map[string]any{
+				"msg": "access", "method": "GET", "path": "/ok", "status": float64(http.StatusOK),
+				"request_id": "req-1", "api_key_id": "key-1",
+			},
+		},
+		{
+			name:    "unknown route",
+			path:    "/nope",
+			headers: map[string]string{"X-Request-ID": "req-2"},
+			want: map[string]any{
+				"method": "GET", "path": "/nope", "status": float64(http.StatusNotFound),
+				"request_id": "req-2", "api_key_id": "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			router := gin.New()
+			router.Use(requestID, jsonAccessLogger(slog.New(requestIDHandler{slog.NewJSONHandler(&buf, nil)})))
+			router.GET("/ok", func(c *gin.Context) {
+				c.Set(ctxKeyAPIKeyID, "key-1")
+				c.JSON(http.StatusOK, gin.H{"ok": true})
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var entry map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("access log isn't a single JSON entry: %q: %v", buf.String(), err)
+			}
+			for key, want := range tt.want {
+				if entry[key] != want {
+					t.Errorf("%s = %v, want %v", key, entry[key], want)
+				}
+			}
+			// gin writes its 404 body after the middleware returned
+			if w.Code != http.StatusNotFound && entry["bytes"] != float64(w.Body.Len()) {
+				t.Errorf("bytes = %v, want %d", entry["bytes"], w.Body.Len())
+			}
+			if _, ok := entry["latency_ms"].(float64); !ok {
+				t.Errorf("latency_ms missing from %v", entry)
+			}
+		})
+	}
+}
+
+func TestConfigValidateAccessLog(t *testing.T) {
+	tests := []struct {
+		accessLog string
+		wantErr   bool
+	}{
+		{"", false},
+		{"text", false},
+		{"json", false},
+		{"xml", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accessLog, func(t *testing.T) {
+			var config Config
+			config.Logging.AccessLog = tt.accessLog
+			if err := config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// admin.go
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireAdmin guards operator endpoints with the configured admin token,
+// sent as "Authorization: Bearer <token>". Without a token configured the
+// endpoints don't exist.
+func requireAdmin(c *gin.Context) {
+	if cfg.Admin.Token == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Admin.Token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+		return
+	}
+	c.Next()
+}
+
+// FoodCheck is the outcome of resolving one mapped food
+type FoodCheck struct {
+	ObjectName   string `json:"object_name"`
+	SearchTerm   string `json:"search_term"`
+	Dataset      string `json:"dataset"`
+	OK           bool   `json:"ok"`
+	FdcID        int    `json:"fdc_id,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Portion      string `json:"portion,omitempty"`
+	MatchQuality string `json:"match_quality,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type FoodCheckReport struct {
+	Checked int         `json:"checked"`
+	Failed  int         `json:"failed"`
+	Foods   []FoodCheck `json:"foods"`
+}
+
+// checkFoods resolves every mapped food and looks for a usable portion,
+// reporting which ones would fail a calculation and why, e.g. after a data
+// refresh
+func checkFoods(c *gin.Context) {
+	terms, err := foodMappings.all(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to load food mappings", err)
+		return
+	}
+	names := make([]string, 0, len(terms))
+	for name := range terms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := FoodCheckReport{Foods: make([]FoodCheck, 0, len(names))}
+	var stats requestStats
+	for _, name := range names {
+		check := FoodCheck{
+			ObjectName: name,
+			SearchTerm: terms[name],
+			Dataset:    datasetFor(name),
+		}
+
+		if food, err := getFoodData(c.Request.Context(), check.Dataset, name, "", &stats); err != nil {
+			check.Error = err.Error()
+		} else {
+			check.FdcID = food.FdcID
+			check.Description = food.Description
+			match := findCupGrams(c.Request.Context(), Volume{ObjectName: name}, food)
+			if match.grams == 0 {
+				match = categoryDensity(c.Request.Context(), food)
+			}
+			if match.grams > 0 {
+				check.OK = true
+				check.Portion = match.portion
+				check.MatchQuality = match.quality
+			} else {
+				check.Error = "no usable cup or fallback portion, and no density for its category"
+			}
+		}
+
+		report.Checked++
+		if !check.OK {
+			report.Failed++
+		}
+		report.Foods = append(report.Foods, check)
+	}
+	if timedOut(c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminRouter serves the admin endpoints behind requireAdmin
+func adminRouter() *gin.Engine {
+	router := gin.New()
+	admin := router.Group("/admin", requireAdmin)
+	admin.GET("/foods/check", checkFoods)
+	admin.GET("/datasets/:dataset/coverage", nutrientCoverage)
+	admin.GET("/food-mappings", listFoodMappings)
+	admin.GET("/food-mappings/:name", getFoodMapping)
+	admin.PUT("/food-mappings/:name", putFoodMapping)
+	admin.DELETE("/food-mappings/:name", deleteFoodMapping)
+	admin.POST("/cache/flush", flushCache)
+	return router
+}
+
+func TestCheckFoods(t *testing.T) {
+	mappings := writeConfig(t, "banana: Banana, raw\nrice: Rice, cooked, NFS\ndragonfruit: ' '\ncucumber: Cucumber, raw\n")
+	config := testConfig(t, "admin:\n  token: s3cret\n")
+	useMappings(t, &fileMappings{path: mappings})
+	cacheTestFoods(t, config.DefaultDataset)
+	cacheFood(t, config.DefaultDataset, "Cucumber, raw", FoodData{FdcID: 6, Description: "Cucumber, raw", FoodPortions: portions("1 slice", 7)})
+
+	router := adminRouter()
+	report := decode[FoodCheckReport](t, doRequest(t, router, http.MethodGet, "/admin/foods/check", nil, "Authorization", "Bearer s3cret"), http.StatusOK)
+	if report.Checked != 4 || len(report.Foods) != 4 {
+		t.Fatalf("checked = %d, foods = %d; want 4", report.Checked, len(report.Foods))
+	}
+	checks := make(map[string]FoodCheck, len(report.Foods))
+	for _, check := range report.Foods {
+		checks[check.ObjectName] = check
+	}
+
+	tests := []struct {
+		objectName string
+		wantOK     bool
+		wantFdcID  int
+	}{
+		{"banana", true, 2},
+		{"rice", true, 1},
+		{"dragonfruit", false, 0},
+		{"cucumber", false, 6},
+	}
+	failed := 0
+	for _, tt := range tests {
+		t.Run(tt.objectName, func(t *testing.T) {
+			check, ok := checks[tt.objectName]
+			if !ok {
+				t.Fatalf("%s missing from the report", tt.objectName)
+			}
+			if check.OK != tt.wantOK || check.FdcID != tt.wantFdcID {
+				t.Errorf("ok = %v, fdc_id = %d; want %v, %d", check.OK, check.FdcID, tt.wantOK, tt.wantFdcID)
+			}
+			if tt.wantOK && (check.Portion == "" || check.MatchQuality == "" || check.Error != "") {
+				t.Errorf("check = %+v, want the portion it matched", check)
+			}
+			if !tt.wantOK && check.Error == "" {
+				t.Errorf("check = %+v, want the reason it failed", check)
+			}
+		})
+		if !tt.wantOK {
+			failed++
+		}
+	}
+	if report.Failed != failed {
+		t.Errorf("failed = %d, want %d", report.Failed, failed)
+	}
+}
+
+func TestCheckFoodsRequiresAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		headers    []string
+		wantStatus int
+	}{
+		{"disabled without a token", "", []string{"Authorization", "Bearer s3cret"}, http.StatusNotFound},
+		{"missing token", "admin:\n  token: s3cret\n", nil, http.StatusUnauthorized},
+		{"wrong token", "admin:\n  token: s3cret\n", []string{"Authorization", "Bearer nope"}, http.StatusUnauthorized},
+		{"valid token", "admin:\n  token: s3cret\n", []string{"Authorization", "Bearer s3cret"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig(t, tt.config)
+			// Only foods that resolve without a database
+			useMappings(t, &fileMappings{path: writeConfig(t, "dragonfruit: ' '\n")})
+			w := doRequest(t, adminRouter(), http.MethodGet, "/admin/foods/check", nil, tt.headers...)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
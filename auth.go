@@ -0,0 +1,306 @@
+// auth.go
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuthConfig enables JWT bearer authentication for user data (meals and
+// persisted frames). It is disabled unless a JWKS URL is configured; user
+// data is then refused unless TrustUserHeader is set.
+type AuthConfig struct {
+	// JWKSURL serves the keys tokens are signed with
+	JWKSURL string `yaml:"jwks_url"`
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// Refresh is how long fetched keys are used before fetching them again
+	Refresh time.Duration `yaml:"refresh"`
+	// TrustUserHeader takes the user from the X-User-ID header without a
+	// JWKS URL, for deployments behind a gateway that authenticates users
+	// and sets it. Anyone who can reach the API directly can act as any
+	// user.
+	TrustUserHeader bool `yaml:"trust_user_header"`
+}
+
+func (a *AuthConfig) enabled() bool {
+	return a.JWKSURL != ""
+}
+
+func (a *AuthConfig) validate() error {
+	if !a.enabled() {
+		return nil
+	}
+	if a.TrustUserHeader {
+		return errors.New("auth.trust_user_header can't be combined with auth.jwks_url")
+	}
+	if u, err := url.Parse(a.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid auth.jwks_url %q", a.JWKSURL)
+	}
+	if a.Refresh == 0 {
+		a.Refresh = time.Hour
+	}
+	if a.Refresh < 0 {
+		return errors.New("auth.refresh must be positive")
+	}
+	return nil
+}
+
+const (
+	// ctxKeyUserID holds the subject of a verified token
+	ctxKeyUserID = "user_id"
+
+	// userHeader names the user while auth is disabled and
+	// trust_user_header is set
+	userHeader = "X-User-ID"
+)
+
+// jwksMinInterval limits refetching the key set for unknown key IDs, so
+// tokens with made-up kids can't be used to hammer the JWKS endpoint
+const jwksMinInterval = time.Minute
+
+// signingMethods are the algorithms accepted in tokens
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// jwks caches the public keys of the configured JWKS URL by key ID
+type jwks struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+var authKeys *jwks
+
+func newJWKS(config AuthConfig) *jwks {
+	if !config.enabled() {
+		return nil
+	}
+	return &jwks{url: config.JWKSURL, refresh: config.Refresh, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// key returns the public key for a key ID, fetching the key set when the
+// cached one is stale or doesn't have the key
+func (j *jwks) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	key, ok := j.keys[kid]
+	stale := time.Since(j.fetchedAt) > j.refresh
+	if ok && !stale {
+		return key, nil
+	}
+	if !stale && time.Since(j.fetchedAt) < jwksMinInterval {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	keys, err := j.fetch(ctx)
+	if err != nil {
+		if ok {
+			// Keep using a known key while the endpoint is unavailable
+			return key, nil
+		}
+		return nil, err
+	}
+	j.keys, j.fetchedAt = keys, time.Now()
+	if key, ok = keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j *jwks) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Keys of unsupported types are skipped rather than failing the set
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verifyToken checks a bearer token's signature and claims and returns its
+// subject
+func verifyToken(ctx context.Context, raw string) (string, error) {
+	options := []jwt.ParserOption{jwt.WithValidMethods(signingMethods), jwt.WithExpirationRequired()}
+	if cfg.Auth.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Auth.Issuer))
+	}
+	if cfg.Auth.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Auth.Audience))
+	}
+
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return authKeys.key(ctx, kid)
+	}, options...)
+	if err != nil {
+		return "", err
+	}
+	subject, err := token.Claims.GetSubject()
+	if err != nil || subject == "" {
+		return "", errors.New("token has no subject")
+	}
+	return subject, nil
+}
+
+// authenticate verifies the bearer token of requests that carry one and
+// records its subject as the user. Requests without a token pass through;
+// handlers that need a user reject them with requireUser.
+func authenticate(c *gin.Context) {
+	if !cfg.Auth.enabled() {
+		c.Next()
+		return
+	}
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		c.Next()
+		return
+	}
+
+	raw, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "expected a bearer token"})
+		return
+	}
+	subject, err := verifyToken(c.Request.Context(), raw)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token: " + err.Error()})
+		return
+	}
+	c.Set(ctxKeyUserID, subject)
+	c.Next()
+}
+
+// currentUser returns the user the request acts for: the token's subject
+// with auth enabled, the X-User-ID header with trust_user_header set. It is
+// empty for anonymous requests.
+func currentUser(c *gin.Context) string {
+	switch {
+	case cfg.Auth.enabled():
+		return c.GetString(ctxKeyUserID)
+	case cfg.Auth.TrustUserHeader:
+		return strings.TrimSpace(c.GetHeader(userHeader))
+	}
+	return ""
+}
+
+// requireUser returns the current user, answering 401 when there is none,
+// or 403 when there is no way to identify users
+func requireUser(c *gin.Context) (string, bool) {
+	user := currentUser(c)
+	if user != "" {
+		return user, true
+	}
+	switch {
+	case cfg.Auth.enabled():
+		c.Header("WWW-Authenticate", "Bearer")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "a bearer token is required"})
+	case cfg.Auth.TrustUserHeader:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": userHeader + " header is required"})
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "user data needs auth.jwks_url or auth.trust_user_header to be configured"})
+	}
+	return "", false
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksServer serves key's public half as key ID "test-key"
+func jwksServer(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{{
+			Kty: "RSA",
+			Kid: "test-key",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// signToken signs claims with key under key ID "test-key"
+func signToken(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestBearerAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := jwksServer(t, key)
+	config := testConfig(t, "auth:\n  jwks_url: "+server.URL+"\n  issuer: https://auth.example.com\n")
+	previousKeys := authKeys
+	authKeys = newJWKS(config.Auth)
+	t.Cleanup(func() { authKeys = previousKeys })
+
+	previous := db
+	db = &Database{frames: &memoryFrames{}}
+	t.Cleanup(func() { db = previous })
+	if err := db.frames.put(context.Background(), FrameRecord{FrameID: "frame-1", UserID: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.GET("/v1/frames/:frame_id", authenticate, getFrame)
+
+	claims := func(subject, issuer string, expires time.Duration) jwt.MapClaims {
+		return jwt.MapClaims{"sub": subject, "iss": issuer, "exp": time.Now().Add(expires).Unix()}
+	}
+	const issuer = "https://auth.example.com"
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+	}{
+		{"owner's token", "Bearer " + signToken(t, key, claims("alice", issuer, time.Hour)), http.StatusOK},
+		{"other user's token", "Bearer " + signToken(t, key, claims("bob", issuer, time.Hour)), http.StatusNotFound},
+		{"no token, X-User-ID is ignored", "", http.StatusUnauthorized},
+		{"not a bearer token", "Basic YWxpY2U6c2VjcmV0", http.StatusUnauthorized},
+		{"expired token", "Bearer " + signToken(t, key, claims("alice", issuer, -time.Hour)), http.StatusUnauthorized},
+		{"wrong issuer", "Bearer " + signToken(t, key, claims("alice", "https://evil.example.com", time.Hour)), http.StatusUnauthorized},
+		{"signed with another key", "Bearer " + signToken(t, other, claims("alice", issuer, time.Hour)), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := []string{userHeader, "alice"}
+			if tt.header != "" {
+				headers = append(headers, "Authorization", tt.header)
+			}
+			if w := doRequest(t, router, http.MethodGet, "/v1/frames/frame-1", nil, headers...); w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestInvalidAuthConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		auth AuthConfig
+	}{
+		{"relative JWKS URL", AuthConfig{JWKSURL: "/jwks.json"}},
+		{"JWKS URL without http", AuthConfig{JWKSURL: "ftp://auth.example.com/jwks.json"}},
+		{"negative refresh", AuthConfig{JWKSURL: "https://auth.example.com/jwks.json", Refresh: -time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.auth.validate(); err == nil {
+				t.Error("validate() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// batch.go
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lookupResult is a prefetched lookup outcome
+type lookupResult struct {
+	lookup foodLookup
+	err    error
+	// queries are the statements that resolved it, kept for debug_query
+	queries []ExecutedQuery
+}
+
+func prefetchKey(dataset, objectName string) string {
+	return dataset + "/" + objectName
+}
+
+// prefetch resolves the mapped object names of all volumes up front with a
+// single query per dataset, instead of one query per item. Names that need
+// fuzzy matching, or that can't be prefetched, are looked up individually.
+func (cc *calcContext) prefetch(volumes []Volume) {
+	terms, err := foodMappings.all(cc.ctx)
+	if err != nil {
+		return
+	}
+
+	// dataset → object name → search term
+	batches := make(map[string]map[string]string)
+	for _, volume := range volumes {
+		volume = cc.translate(volume)
+		name := lookupName(volume, terms)
+		term, ok := terms[name]
+		if !ok || strings.TrimSpace(term) == "" {
+			continue
+		}
+		dataset := datasetFor(volume.ObjectName)
+		if batches[dataset] == nil {
+			batches[dataset] = make(map[string]string)
+		}
+		batches[dataset][name] = term
+	}
+
+	if cc.resolved == nil {
+		cc.resolved = make(map[string]lookupResult)
+	}
+	for dataset, names := range batches {
+		if !foodBreaker.allow() {
+			return
+		}
+		searchTerms := make([]string, 0, len(names))
+		for _, term := range names {
+			searchTerms = append(searchTerms, term)
+		}
+		executed := len(cc.stats.executed)
+		foods, err := queryFoodsByDescriptions(cc.ctx, dataset, searchTerms, cc.dataVersion, &cc.stats)
+		queries := cc.stats.executed[executed:len(cc.stats.executed):len(cc.stats.executed)]
+		if cc.ctx.Err() != nil {
+			foodBreaker.release()
+		} else {
+			foodBreaker.record(!isDatabaseError(err))
+		}
+
+		limit := candidateLimit()
+		for name, term := range names {
+			result := lookupResult{err: err, queries: queries}
+			if err == nil {
+				matches := foods[strings.ToLower(term)]
+				if len(matches) == 0 {
+					result.err = fmt.Errorf("%w: no matching food found for %s", errUnknownFood, term)
+				}
+				result.lookup = foodLookup{foods: matches[:min(len(matches), limit)], confidence: 1}
+			}
+			cc.resolved[prefetchKey(dataset, name)] = result
+		}
+	}
+}
+
+// lookupFoods returns the prefetched lookup for an object name, or looks
+// it up when it wasn't prefetched
+func (cc *calcContext) lookupFoods(dataset, objectName string) (foodLookup, error) {
+	if result, ok := cc.resolved[prefetchKey(dataset, normalizeFoodName(objectName))]; ok {
+		// Report the batch statement with each item it resolved
+		cc.stats.executed = append(cc.stats.executed, result.queries...)
+		return result.lookup, result.err
+	}
+	return lookupFoods(cc.ctx, dataset, objectName, cc.dataVersion, &cc.stats)
+}
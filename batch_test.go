@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPrefetch(t *testing.T) {
+	config := testConfig(t, noFuzzy)
+	cacheTestFoods(t, config.DefaultDataset)
+	useMappings(t, &fileMappings{path: writeConfig(t, "rice: "+defaultFoodMappings["rice"]+"\nbanana: "+defaultFoodMappings["banana"]+"\ndragonfruit: ' '\n")})
+
+	cc := &calcContext{ctx: context.Background(), scale: 1}
+	cc.prefetch([]Volume{
+		{ObjectName: "Rice", VolumeCups: 1},
+		{ObjectName: "banana", VolumeCups: 0.5},
+		{ObjectName: "dragonfruit", VolumeCups: 1},
+		{ObjectName: "quinoa", VolumeCups: 1},
+	})
+
+	tests := []struct {
+		objectName    string
+		wantPrefetch  bool
+		wantFdcID     int
+		wantLookupErr bool
+	}{
+		{"rice", true, 1, false},
+		{"banana", true, 2, false},
+		{"dragonfruit", false, 0, true},
+		{"quinoa", false, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.objectName, func(t *testing.T) {
+			_, ok := cc.resolved[prefetchKey(config.DefaultDataset, tt.objectName)]
+			if ok != tt.wantPrefetch {
+				t.Fatalf("prefetched = %v, want %v", ok, tt.wantPrefetch)
+			}
+			lookup, err := cc.lookupFoods(config.DefaultDataset, tt.objectName)
+			if (err != nil) != tt.wantLookupErr {
+				t.Fatalf("lookupFoods() error = %v, want error %v", err, tt.wantLookupErr)
+			}
+			if !tt.wantLookupErr && (len(lookup.foods) == 0 || lookup.foods[0].FdcID != tt.wantFdcID) {
+				t.Errorf("foods = %+v, want fdc_id %d", lookup.foods, tt.wantFdcID)
+			}
+		})
+	}
+	if cc.stats.queries != 0 {
+		t.Errorf("queries = %d, want cached foods to need none", cc.stats.queries)
+	}
+}
@@ -0,0 +1,254 @@
+// breaker.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BreakerConfig tunes the circuit breaker guarding Couchbase lookups
+type BreakerConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// Window is the number of recent lookups the error rate is computed over
+	Window int `yaml:"window"`
+	// MinRequests is how many lookups the window needs before it can trip
+	MinRequests int `yaml:"min_requests"`
+	// ErrorRate is the failure fraction (0-1] that opens the breaker
+	ErrorRate float64 `yaml:"error_rate"`
+	// OpenDuration is how long the breaker fails fast before letting a
+	// probe through
+	OpenDuration time.Duration `yaml:"open_duration"`
+}
+
+func (b *BreakerConfig) validate() error {
+	if b.Window == 0 {
+		b.Window = 20
+	}
+	if b.MinRequests == 0 {
+		b.MinRequests = 10
+	}
+	if b.ErrorRate == 0 {
+		b.ErrorRate = 0.5
+	}
+	if b.OpenDuration == 0 {
+		b.OpenDuration = 30 * time.Second
+	}
+
+	switch {
+	case b.Window < 0, b.MinRequests < 0, b.OpenDuration < 0:
+		return fmt.Errorf("breaker window, min_requests and open_duration must be positive")
+	case b.MinRequests > b.Window:
+		return fmt.Errorf("breaker.min_requests (%d) can't exceed breaker.window (%d)", b.MinRequests, b.Window)
+	case b.ErrorRate < 0 || b.ErrorRate > 1:
+		return fmt.Errorf("breaker.error_rate must be between 0 and 1, got %v", b.ErrorRate)
+	}
+	return nil
+}
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// errBreakerOpen is returned instead of querying while the breaker is open
+var errBreakerOpen = errors.New("database circuit breaker is open")
+
+// circuitBreaker stops sending lookups to Couchbase once too many of the
+// recent ones failed. After OpenDuration a single probe is let through; its
+// outcome either closes the breaker again or restarts the open period.
+type circuitBreaker struct {
+	config BreakerConfig
+
+	mu       sync.Mutex
+	state    string
+	outcomes []bool // ring buffer of recent results, true for failures
+	next     int
+	count    int
+	failures int
+	openedAt time.Time
+	probing  bool
+	trips    int
+}
+
+var foodBreaker *circuitBreaker
+
+func newCircuitBreaker(config BreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		config:   config,
+		state:    breakerClosed,
+		outcomes: make([]bool, config.Window),
+	}
+}
+
+// allow reports whether a lookup may be sent to the database
+func (b *circuitBreaker) allow() bool {
+	if b.config.Disabled {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.config.OpenDuration {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		// Only one probe at a time
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// record feeds the outcome of an allowed lookup back into the breaker
+func (b *circuitBreaker) record(success bool) {
+	if b.config.Disabled {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.probing = false
+		if success {
+			b.reset()
+		} else {
+			b.trip()
+		}
+		return
+	}
+
+	if b.count == len(b.outcomes) && b.outcomes[b.next] {
+		b.failures--
+	}
+	b.outcomes[b.next] = !success
+	if !success {
+		b.failures++
+	}
+	b.next = (b.next + 1) % len(b.outcomes)
+	b.count = min(b.count+1, len(b.outcomes))
+
+	if b.state == breakerClosed && b.count >= b.config.MinRequests &&
+		float64(b.failures)/float64(b.count) >= b.config.ErrorRate {
+		b.trip()
+	}
+}
+
+// release gives back an allowed lookup that was cancelled before it said
+// anything about the database, letting the next probe through
+func (b *circuitBreaker) release() {
+	if b.config.Disabled {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.probing = false
+	}
+}
+
+func (b *circuitBreaker) trip() {
+	if b.state == breakerClosed {
+		slog.Warn("circuit breaker opened, failing food lookups fast", "open_duration", b.config.OpenDuration)
+	}
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+	b.trips++
+}
+
+func (b *circuitBreaker) reset() {
+	b.state = breakerClosed
+	b.outcomes = make([]bool, len(b.outcomes))
+	b.next, b.count, b.failures = 0, 0, 0
+}
+
+// close closes an open breaker once the database is known to be back
+func (b *circuitBreaker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerClosed {
+		slog.Info("closing circuit breaker, the database is reachable again")
+		b.probing = false
+		b.reset()
+	}
+}
+
+// retryAfter returns how long until an open breaker lets a probe through,
+// or 0 when lookups are currently allowed
+func (b *circuitBreaker) retryAfter() time.Duration {
+	if b.config.Disabled {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerOpen {
+		return 0
+	}
+	return max(b.config.OpenDuration-time.Since(b.openedAt), 0)
+}
+
+// BreakerStats is the breaker's state as reported by /v1/stats
+type BreakerStats struct {
+	State    string `json:"state"`
+	Requests int    `json:"window_requests"`
+	Failures int    `json:"window_failures"`
+	Trips    int    `json:"trips"`
+}
+
+func (b *circuitBreaker) stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	if b.config.Disabled {
+		state = "disabled"
+	}
+	return BreakerStats{State: state, Requests: b.count, Failures: b.failures, Trips: b.trips}
+}
+
+// rejectWhileBreakerOpen fails the request with 503 when the breaker is
+// open, so clients don't wait on lookups that are bound to fail
+func rejectWhileBreakerOpen(c *gin.Context) bool {
+	wait := foodBreaker.retryAfter()
+	if wait == 0 {
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "food database is temporarily unavailable"})
+	return true
+}
+
+// degradedStatus is the status of a response some of whose lookups failed
+// in the database: 503 with Retry-After while the breaker is open, 502
+// otherwise
+func degradedStatus(c *gin.Context) int {
+	wait := foodBreaker.retryAfter()
+	if wait == 0 {
+		return http.StatusBadGateway
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return http.StatusServiceUnavailable
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const openFor = 20 * time.Millisecond
+	// Steps: "ok" and "fail" record a lookup, "wait" outlasts the open
+	// period; after each step the breaker is in the given state
+	type step struct {
+		action    string
+		wantState string
+		wantAllow bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"stays closed below min_requests", []step{
+			{"fail", breakerClosed, true},
+			{"fail", breakerClosed, true},
+			{"fail", breakerClosed, true},
+		}},
+		{"opens at the error rate", []step{
+			{"ok", breakerClosed, true},
+			{"fail", breakerClosed, true},
+			{"ok", breakerClosed, true},
+			{"fail", breakerOpen, false},
+		}},
+		{"stays closed under the error rate", []step{
+			{"ok", breakerClosed, true},
+			{"ok", breakerClosed, true},
+			{"ok", breakerClosed, true},
+			{"fail", breakerClosed, true},
+			{"ok", breakerClosed, true},
+		}},
+		{"half-opens and recovers", []step{
+			{"fail", breakerClosed, true},
+			{"fail", breakerClosed, true},
+			{"fail", breakerClosed, true},
+			{"fail", breakerOpen, false},
+			{"wait", breakerHalfOpen, true},
+			{"ok", breakerClosed, true},
+			// The window starts over after recovering
+			{"fail", breakerClosed, true},
+		}},
+		{"failed probe opens again", []step{
+			{"fail", breakerClosed, true},
+			{"fail", breakerClosed, true},
+			{"fail", breakerClosed, true},
+			{"fail", breakerOpen, false},
+			{"wait", breakerHalfOpen, true},
+			{"fail", breakerOpen, false},
+			{"wait", breakerHalfOpen, true},
+			{"ok", breakerClosed, true},
+		}},
+		{"trips once min_requests is reached", []step{
+			{"fail", breakerClosed, true},
+			{"fail", breakerClosed, true},
+			{"fail", breakerClosed, true},
+			{"ok", breakerOpen, false},
+		}},
+		{"only the window counts", []step{
+			{"ok", breakerClosed, true},
+			{"ok", breakerClosed, true},
+			{"ok", breakerClosed, true},
+			{"ok", breakerClosed, true},
+			{"fail", breakerClosed, true},
+			{"fail", breakerClosed, true},
+			// The first success drops out, leaving 3 of 6 failed
+			{"fail", breakerOpen, false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCircuitBreaker(BreakerConfig{Window: 6, MinRequests: 4, ErrorRate: 0.5, OpenDuration: openFor})
+			for i, s := range tt.steps {
+				switch s.action {
+				case "wait":
+					time.Sleep(openFor + 5*time.Millisecond)
+					// The probe that allow lets through half-opens the
+					// breaker
+					if allowed := b.allow(); allowed != s.wantAllow {
+						t.Fatalf("step %d: allow() = %v, want %v", i, allowed, s.wantAllow)
+					}
+				default:
+					b.record(s.action == "ok")
+				}
+				got := b.stats().State
+				if got != s.wantState {
+					t.Fatalf("step %d (%s): state = %s, want %s", i, s.action, got, s.wantState)
+				}
+				if s.action != "wait" && got != breakerHalfOpen {
+					if allowed := b.allow(); allowed != s.wantAllow {
+						t.Fatalf("step %d (%s): allow() = %v, want %v", i, s.action, allowed, s.wantAllow)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerClose(t *testing.T) {
+	b := newCircuitBreaker(BreakerConfig{Window: 4, MinRequests: 2, ErrorRate: 0.5, OpenDuration: time.Hour})
+	b.record(false)
+	b.record(false)
+	if b.stats().State != breakerOpen {
+		t.Fatalf("state = %s, want %s", b.stats().State, breakerOpen)
+	}
+	// Closed once the store is reachable again, without waiting out the
+	// open duration
+	b.close()
+	if state := b.stats().State; state != breakerClosed || !b.allow() || b.retryAfter() != 0 {
+		t.Errorf("state = %s, want %s and lookups allowed", state, breakerClosed)
+	}
+}
+
+func TestBreakerCancelledProbe(t *testing.T) {
+	b := newCircuitBreaker(BreakerConfig{Window: 4, MinRequests: 2, ErrorRate: 0.5, OpenDuration: time.Millisecond})
+	b.record(false)
+	b.record(false)
+	time.Sleep(2 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("allow() = false, want the probe let through")
+	}
+	// A probe cancelled by its request neither closes nor reopens the
+	// breaker, and the next lookup probes instead
+	b.release()
+	if state := b.stats().State; state != breakerHalfOpen {
+		t.Fatalf("state = %s after a cancelled probe, want %s", state, breakerHalfOpen)
+	}
+	if !b.allow() {
+		t.Fatal("allow() = false after a cancelled probe, want the next probe let through")
+	}
+	if b.allow() {
+		t.Error("allow() = true during a probe, want one probe at a time")
+	}
+}
+
+func TestBreakerFailsFast(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		failures   int
+		wantStatus int
+		wantState  string
+	}{
+		{"closed breaker serves lookups", "", 0, http.StatusOK, breakerClosed},
+		{"open breaker fails fast", "", 10, http.StatusServiceUnavailable, breakerOpen},
+		{"disabled breaker never opens", "breaker:\n  disabled: true\n", 10, http.StatusOK, "disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, noFuzzy+tt.config)
+			previous := foodBreaker
+			foodBreaker = newCircuitBreaker(config.Breaker)
+			t.Cleanup(func() { foodBreaker = previous })
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+			router.GET("/v1/stats", getStats)
+
+			for range tt.failures {
+				foodBreaker.record(false)
+			}
+
+			// An unknown food is resolved without a query, so only the
+			// breaker decides the status
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: "quinoa", VolumeCups: 1}))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if retry := w.Header().Get("Retry-After"); (retry != "") != (tt.wantStatus == http.StatusServiceUnavailable) {
+				t.Errorf("Retry-After = %q", retry)
+			}
+
+			stats := decode[StatsResponse](t, doRequest(t, router, http.MethodGet, "/v1/stats", nil), http.StatusOK)
+			if stats.Breaker.State != tt.wantState {
+				t.Errorf("stats breaker state = %s, want %s", stats.Breaker.State, tt.wantState)
+			}
+		})
+	}
+}
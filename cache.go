@@ -0,0 +1,186 @@
+// cache.go
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CacheConfig sizes the in-memory cache of food documents
+type CacheConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// Size is the maximum number of cached descriptions; defaults to 1000
+	Size int `yaml:"size"`
+	// MaxBytes additionally bounds the estimated memory of the cached
+	// documents; zero means no byte limit
+	MaxBytes int64 `yaml:"max_bytes"`
+	// TTL is how long a document is served from the cache; defaults to 1h
+	TTL time.Duration `yaml:"ttl"`
+}
+
+func (c *CacheConfig) validate() error {
+	if c.Size == 0 {
+		c.Size = 1000
+	}
+	if c.TTL == 0 {
+		c.TTL = time.Hour
+	}
+	if c.Size < 0 || c.MaxBytes < 0 || c.TTL < 0 {
+		return errors.New("cache size, max_bytes and ttl must not be negative")
+	}
+	return nil
+}
+
+// CacheStats is the cache's state as reported by /v1/stats
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// foodCache is a TTL-bound LRU of the foods matching a description,
+// bounded by entry count and optionally by estimated bytes
+type foodCache struct {
+	config CacheConfig
+
+	mu      sync.Mutex
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+	bytes   int64
+	stats   CacheStats
+}
+
+type cacheEntry struct {
+	key     string
+	foods   []FoodData
+	size    int64
+	expires time.Time
+}
+
+var foodDataCache *foodCache
+
+func newFoodCache(config CacheConfig) *foodCache {
+	return &foodCache{
+		config:  config,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// foodCacheKey identifies a description's foods within a dataset and version
+func foodCacheKey(dataset, version, description string) string {
+	return dataset + "\x00" + version + "\x00" + description
+}
+
+func (c *foodCache) get(key string) ([]FoodData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config.Disabled {
+		return nil, false
+	}
+
+	element, ok := c.entries[key]
+	if ok && time.Now().After(element.Value.(*cacheEntry).expires) {
+		c.removeLocked(element)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).foods, true
+}
+
+func (c *foodCache) put(key string, foods []FoodData) {
+	size := estimateSize(key, foods)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// An entry larger than the whole budget would only evict everything
+	if c.config.Disabled || (c.config.MaxBytes > 0 && size > c.config.MaxBytes) {
+		return
+	}
+	entry := &cacheEntry{key: key, foods: foods, size: size, expires: time.Now().Add(c.config.TTL)}
+	if element, ok := c.entries[key]; ok {
+		c.removeLocked(element)
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	c.bytes += entry.size
+	c.evictLocked()
+}
+
+// evictLocked drops the least recently used entries until the cache fits
+// its bounds
+func (c *foodCache) evictLocked() {
+	for c.order.Len() > c.config.Size || (c.config.MaxBytes > 0 && c.bytes > c.config.MaxBytes) {
+		c.removeLocked(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// reconfigure applies a reloaded cache config. Entries beyond the new
+// bounds are evicted; disabling the cache empties it. Cached entries keep
+// the expiry they were stored with.
+func (c *foodCache) reconfigure(config CacheConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config = config
+	if config.Disabled {
+		c.order.Init()
+		c.entries = make(map[string]*list.Element)
+		c.bytes = 0
+		return
+	}
+	c.evictLocked()
+}
+
+func (c *foodCache) removeLocked(element *list.Element) {
+	entry := c.order.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+// flush empties the cache and returns how many entries it held
+func (c *foodCache) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.order.Len()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.bytes = 0
+	return n
+}
+
+func (c *foodCache) snapshot() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// estimateSize approximates an entry's memory by its JSON size, which
+// tracks the strings and slices that dominate a food document
+func estimateSize(key string, foods []FoodData) int64 {
+	data, err := json.Marshal(foods)
+	if err != nil {
+		return int64(len(key))
+	}
+	return int64(len(key) + len(data))
+}
+
+// flushCache empties the food cache, e.g. after a data refresh
+func flushCache(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"flushed": foodDataCache.flush()})
+}
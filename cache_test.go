@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// sizedFoods returns a food whose estimated size grows with n
+func sizedFoods(n int) []FoodData {
+	return []FoodData{{FdcID: n, Description: strings.Repeat("x", n)}}
+}
+
+func TestFoodCacheByteEviction(t *testing.T) {
+	// Three small entries fit a 1000 byte budget, a large one and a small
+	// one don't
+	small, large := sizedFoods(10), sizedFoods(700)
+	smallSize, largeSize := estimateSize("k0", small), estimateSize("k0", large)
+	if 3*smallSize > 1000 || largeSize+smallSize <= 1000 || largeSize > 1000 || largeSize <= 500 {
+		t.Fatalf("entry sizes %d and %d no longer fit the budgets", smallSize, largeSize)
+	}
+	tests := []struct {
+		name          string
+		config        CacheConfig
+		puts          [][]FoodData
+		wantEntries   int
+		wantEvictions uint64
+		wantKept      []string
+	}{
+		{
+			name:        "small entries fit the byte budget",
+			config:      CacheConfig{Size: 100, MaxBytes: 1000},
+			puts:        [][]FoodData{small, small, small},
+			wantEntries: 3,
+			wantKept:    []string{"k0", "k1", "k2"},
+		},
+		{
+			name:          "large entries evict by bytes",
+			config:        CacheConfig{Size: 100, MaxBytes: 1000},
+			puts:          [][]FoodData{small, small, large, large},
+			wantEntries:   1,
+			wantEvictions: 3,
+			wantKept:      []string{"k3"},
+		},
+		{
+			name:          "least recently used go first",
+			config:        CacheConfig{Size: 100, MaxBytes: 1000},
+			puts:          [][]FoodData{large, small, small},
+			wantEntries:   2,
+			wantEvictions: 1,
+			wantKept:      []string{"k1", "k2"},
+		},
+		{
+			name:        "entry larger than the budget isn't cached",
+			config:      CacheConfig{Size: 100, MaxBytes: 500},
+			puts:        [][]FoodData{small, large},
+			wantEntries: 1,
+			wantKept:    []string{"k0"},
+		},
+		{
+			name:          "entry count still bounds the cache",
+			config:        CacheConfig{Size: 2, MaxBytes: 1 << 20},
+			puts:          [][]FoodData{small, small, small},
+			wantEntries:   2,
+			wantEvictions: 1,
+			wantKept:      []string{"k1", "k2"},
+		},
+		{
+			name:        "no byte limit",
+			config:      CacheConfig{Size: 100},
+			puts:        [][]FoodData{large, large, large},
+			wantEntries: 3,
+			wantKept:    []string{"k0", "k1", "k2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.TTL = time.Hour
+			cache := newFoodCache(tt.config)
+			for i, foods := range tt.puts {
+				cache.put(fmt.Sprintf("k%d", i), foods)
+			}
+
+			stats := cache.snapshot()
+			if stats.Entries != tt.wantEntries || stats.Evictions != tt.wantEvictions {
+				t.Errorf("entries = %d, evictions = %d; want %d, %d", stats.Entries, stats.Evictions, tt.wantEntries, tt.wantEvictions)
+			}
+			if tt.config.MaxBytes > 0 && stats.Bytes > tt.config.MaxBytes {
+				t.Errorf("bytes = %d, over max_bytes %d", stats.Bytes, tt.config.MaxBytes)
+			}
+			var wantBytes int64
+			for _, key := range tt.wantKept {
+				foods, ok := cache.get(key)
+				if !ok {
+					t.Errorf("%s was evicted", key)
+					continue
+				}
+				wantBytes += estimateSize(key, foods)
+			}
+			if stats.Bytes != wantBytes {
+				t.Errorf("bytes = %d, want %d", stats.Bytes, wantBytes)
+			}
+		})
+	}
+}
+
+func TestFoodCacheTTL(t *testing.T) {
+	cache := newFoodCache(CacheConfig{Size: 10, TTL: 20 * time.Millisecond})
+	cache.put("k", sizedFoods(1))
+	if _, ok := cache.get("k"); !ok {
+		t.Fatal("entry missing before its ttl")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := cache.get("k"); ok {
+		t.Error("entry served after its ttl")
+	}
+	stats := cache.snapshot()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 0 {
+		t.Errorf("stats = %+v, want one hit, one miss and the expired entry dropped", stats)
+	}
+}
+
+func TestCacheCountersAndFlush(t *testing.T) {
+	config := testConfig(t, "admin:\n  token: s3cret\n")
+	cacheTestFoods(t, config.DefaultDataset)
+	router := adminRouter()
+	router.POST("/v1/calculate-macros", calculateMacros)
+
+	w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros?meta=true", volumes(
+		Volume{ObjectName: "rice", VolumeCups: 1},
+		Volume{ObjectName: "banana", VolumeCups: 1},
+	))
+	meta := decode[MacroResponse](t, w, http.StatusOK).Meta
+	if meta == nil || meta.CacheHits != 2 || meta.CacheMisses != 0 || meta.QueriesExecuted != 0 {
+		t.Fatalf("meta = %+v, want two cache hits and no queries", meta)
+	}
+
+	flushed := decode[map[string]int](t, doRequest(t, router, http.MethodPost, "/admin/cache/flush", nil, "Authorization", "Bearer s3cret"), http.StatusOK)
+	if flushed["flushed"] != 2 {
+		t.Errorf("flushed = %v, want 2 entries", flushed)
+	}
+	if stats := foodDataCache.snapshot(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("cache stats = %+v after a flush, want it empty", stats)
+	}
+}
+
+func TestConfigValidateCache(t *testing.T) {
+	config := testConfig(t, "")
+	if config.Cache.Size != 1000 || config.Cache.MaxBytes != 0 || config.Cache.TTL != time.Hour {
+		t.Errorf("cache defaults = %+v, want size 1000, no byte limit and a 1h ttl", config.Cache)
+	}
+	for _, content := range []string{"cache:\n  size: -1\n", "cache:\n  max_bytes: -1\n", "cache:\n  ttl: -1s\n"} {
+		config := &Config{}
+		if err := yaml.Unmarshal([]byte(content), config); err != nil {
+			t.Fatal(err)
+		}
+		if err := config.validate(); err == nil {
+			t.Errorf("%q: expected an error", content)
+		}
+	}
+}
+
+func TestStatsReportCacheBytes(t *testing.T) {
+	config := testConfig(t, "cache:\n  max_bytes: 100000\n")
+	previous := foodDataCache
+	foodDataCache = newFoodCache(config.Cache)
+	t.Cleanup(func() { foodDataCache = previous })
+	router := gin.New()
+	router.GET("/v1/stats", getStats)
+
+	before := decode[StatsResponse](t, doRequest(t, router, http.MethodGet, "/v1/stats", nil), http.StatusOK)
+	if before.Cache.Bytes != 0 || before.Cache.Entries != 0 {
+		t.Fatalf("cache stats = %+v before any lookup", before.Cache)
+	}
+
+	foodDataCache.put(foodCacheKey(config.DefaultDataset, "", "banana, raw"), sizedFoods(10))
+	after := decode[StatsResponse](t, doRequest(t, router, http.MethodGet, "/v1/stats", nil), http.StatusOK)
+	if after.Cache.Entries != 1 || after.Cache.Bytes <= 0 || after.Cache.Bytes > 100000 {
+		t.Errorf("cache stats = %+v, want one entry counted in bytes", after.Cache)
+	}
+}
@@ -0,0 +1,150 @@
+// compress.go
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CompressionConfig gzips responses for clients that accept it
+type CompressionConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// MinBytes leaves smaller responses uncompressed, where gzip's overhead
+	// outweighs the savings; defaults to 1024
+	MinBytes int `yaml:"min_bytes"`
+	// Level is the gzip level, 1 (fastest) to 9 (smallest); defaults to 6
+	Level int `yaml:"level"`
+}
+
+func (c *CompressionConfig) validate() error {
+	if c.MinBytes == 0 {
+		c.MinBytes = 1024
+	}
+	if c.Level == 0 {
+		c.Level = gzip.DefaultCompression
+	}
+	if c.MinBytes < 0 {
+		return errors.New("server.compression.min_bytes must not be negative")
+	}
+	if c.Level != gzip.DefaultCompression && (c.Level < gzip.BestSpeed || c.Level > gzip.BestCompression) {
+		return fmt.Errorf("invalid server.compression.level %d: expected 1 to 9", c.Level)
+	}
+	return nil
+}
+
+var gzipWriters sync.Pool
+
+// gzipWriter holds back the start of a response until MinBytes are
+// written, then compresses the rest of it
+type gzipWriter struct {
+	gin.ResponseWriter
+	config *CompressionConfig
+
+	buffer  []byte
+	started bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if w.started {
+		if w.gz != nil {
+			return w.gz.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+	w.buffer = append(w.buffer, data...)
+	if len(w.buffer) >= w.config.MinBytes {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Written counts the held back bytes, so middleware doesn't write a second
+// response after the handler's
+func (w *gzipWriter) Written() bool {
+	return len(w.buffer) > 0 || w.ResponseWriter.Written()
+}
+
+// start sends the held back bytes, compressed when the response is large
+// enough and not encoded by its handler already
+func (w *gzipWriter) start(compress bool) error {
+	w.started = true
+	header := w.Header()
+	if compress && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		if gz, ok := gzipWriters.Get().(*gzip.Writer); ok {
+			gz.Reset(w.ResponseWriter)
+			w.gz = gz
+		} else {
+			w.gz, _ = gzip.NewWriterLevel(w.ResponseWriter, w.config.Level)
+		}
+		_, err := w.gz.Write(w.buffer)
+		w.buffer = nil
+		return err
+	}
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buffer)
+	w.buffer = nil
+	return err
+}
+
+// finish sends a response that stayed below MinBytes as is, or completes
+// the compressed stream
+func (w *gzipWriter) finish() {
+	if !w.started {
+		w.start(false)
+		return
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// compress gzips responses for clients that send Accept-Encoding: gzip
+func compress(c *gin.Context) {
+	config := &cfg.Server.Compression
+	if config.Disabled || c.Request.Method == http.MethodHead {
+		c.Next()
+		return
+	}
+	c.Writer.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		c.Next()
+		return
+	}
+
+	writer := &gzipWriter{ResponseWriter: c.Writer, config: config}
+	c.Writer = writer
+	defer func() {
+		writer.finish()
+		c.Writer = writer.ResponseWriter
+	}()
+	c.Next()
+}
+
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	router := setupServer(t, "server:\n  compression:\n    min_bytes: 4096\n")
+	useMappings(t, &fileMappings{path: writeConfig(t, "rice: Rice, cooked, NFS\n")})
+	many := make([]Volume, 20)
+	for i := range many {
+		many[i] = Volume{ObjectName: "rice", VolumeCups: 1}
+	}
+	tests := []struct {
+		name        string
+		body        any
+		headers     []string
+		wantGzip    bool
+		wantVolumes int
+	}{
+		{"large response", volumes(many...), []string{"Accept-Encoding", "gzip, deflate"}, true, 20},
+		{"small response", volumes(Volume{ObjectName: "rice", VolumeCups: 1}), []string{"Accept-Encoding", "gzip"}, false, 1},
+		{"gzip refused", volumes(many...), []string{"Accept-Encoding", "gzip;q=0"}, false, 20},
+		{"no Accept-Encoding", volumes(many...), nil, false, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", tt.body, tt.headers...)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			if !strings.Contains(strings.Join(w.Header().Values("Vary"), ","), "Accept-Encoding") {
+				t.Errorf("Vary = %v, want Accept-Encoding", w.Header().Values("Vary"))
+			}
+			var body io.Reader = w.Body
+			if gzipped := w.Header().Get("Content-Encoding") == "gzip"; gzipped != tt.wantGzip {
+				t.Fatalf("gzipped = %v, want %v", gzipped, tt.wantGzip)
+			} else if gzipped {
+				gz, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = gz
+			}
+			var response MacroResponse
+			if err := json.NewDecoder(body).Decode(&response); err != nil || len(response.Data) != tt.wantVolumes {
+				t.Errorf("response = %d items, %v; want %d", len(response.Data), err, tt.wantVolumes)
+			}
+		})
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	router := setupServer(t, "server:\n  max_body_bytes: 256\n")
+	large := volumes(make([]Volume, 20)...)
+
+	if w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", large); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d with a declared length over the limit, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	// A body without a declared length is cut off as it is read
+	data, err := json.Marshal(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/calculate-macros", io.MultiReader(strings.NewReader(string(data))))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "256 bytes") {
+		t.Errorf("status = %d: %s; want %d naming the limit", w.Code, w.Body, http.StatusRequestEntityTooLarge)
+	}
+
+	if w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: "rice", VolumeCups: 1})); w.Code != http.StatusOK {
+		t.Errorf("status = %d for a small body, want %d", w.Code, http.StatusOK)
+	}
+}
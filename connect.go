@@ -0,0 +1,147 @@
+// connect.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConnectConfig controls how the connection to the food store is set up
+// and watched. The drivers re-establish dropped connections by themselves;
+// meanwhile the breaker fails lookups fast.
+type ConnectConfig struct {
+	// Lazy starts serving before the store is reachable and connects in the
+	// background, answering 503 until it is. Otherwise startup waits for the
+	// connection and exits after Attempts failures.
+	Lazy bool `yaml:"lazy"`
+	// Attempts bounds the connection attempts of a startup that isn't lazy;
+	// defaults to 5. Lazy connections retry until they succeed.
+	Attempts int `yaml:"attempts"`
+	// InitialBackoff is the wait after the first failed attempt, doubled
+	// after each further one up to MaxBackoff; defaults to 1s and 30s
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// CheckInterval is how often the connected store is pinged, so outages
+	// and recoveries are noticed without traffic; defaults to 15s
+	CheckInterval time.Duration `yaml:"check_interval"`
+}
+
+func (c *ConnectConfig) validate() error {
+	if c.Attempts == 0 {
+		c.Attempts = 5
+	}
+	if c.InitialBackoff == 0 {
+		c.InitialBackoff = time.Second
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = 30 * time.Second
+	}
+	if c.CheckInterval == 0 {
+		c.CheckInterval = 15 * time.Second
+	}
+
+	switch {
+	case c.Attempts < 0, c.InitialBackoff < 0, c.MaxBackoff < 0, c.CheckInterval < 0:
+		return fmt.Errorf("connect attempts, initial_backoff, max_backoff and check_interval must be positive")
+	case c.MaxBackoff < c.InitialBackoff:
+		return fmt.Errorf("connect.max_backoff (%v) can't be less than connect.initial_backoff (%v)", c.MaxBackoff, c.InitialBackoff)
+	}
+	return nil
+}
+
+// storageReady is set once the food store is connected. db, foodRepo and
+// foodMappings must not be used before; requireStorage keeps requests away
+// from them until then.
+var storageReady atomic.Bool
+
+// startStorage connects to the food store, in the background when
+// connect.lazy is set
+func startStorage() error {
+	if !cfg.Connect.Lazy {
+		return connectWithRetry(cfg.Connect.Attempts)
+	}
+	go func() {
+		// Without a limit on attempts this only returns once connected
+		_ = connectWithRetry(0)
+	}()
+	return nil
+}
+
+// connectWithRetry connects to the food store, backing off exponentially
+// between failed attempts, and gives up after attempts unless it is 0. Once
+// connected the store is published and watched.
+func connectWithRetry(attempts int) error {
+	backoff := cfg.Connect.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := connectStorage()
+		if err == nil {
+			break
+		}
+		if attempts > 0 && attempt >= attempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		slog.Warn("failed to connect to the food store, retrying", "attempt", attempt, "retry_in", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, cfg.Connect.MaxBackoff)
+	}
+
+	// Loaded on first use, so startup doesn't wait on it
+	foodMappings = newMappingCache(newMappingStore(cfg, db))
+	storageReady.Store(true)
+	go watchStorage(cfg.Connect.CheckInterval)
+	if db.unresolved != nil {
+		go flushUnresolvedEvery(cfg.UnresolvedFoods.FlushInterval)
+	}
+	return nil
+}
+
+// watchStorage pings the store every interval, logging once when it becomes
+// unreachable and once when it is back. On recovery the breaker is closed,
+// so lookups resume without waiting out breaker.open_duration.
+func watchStorage(interval time.Duration) {
+	healthy := true
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+		err := foodRepo.Ping(ctx)
+		cancel()
+
+		switch {
+		case err != nil && healthy:
+			slog.Error("lost connection to the food store", "error", err)
+		case err == nil && !healthy:
+			slog.Info("connection to the food store restored")
+			foodBreaker.close()
+		}
+		healthy = err == nil
+	}
+}
+
+// storageFreeRoutes are served while the food store isn't connected yet
+var storageFreeRoutes = map[string]bool{
+	"/healthz":                    true,
+	"/v1/openapi.json":            true,
+	"/docs":                       true,
+	"/v1/calculate-macros/inline": true,
+	"/v1/stats":                   true,
+	"/v1/jobs/:id":                true,
+}
+
+// requireStorage answers 503 until the food store is connected, for every
+// route that needs it. /readyz is among them, so a lazily connecting
+// instance gets no traffic until it can serve it.
+func requireStorage(c *gin.Context) {
+	if storageReady.Load() || storageFreeRoutes[c.FullPath()] {
+		c.Next()
+		return
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(cfg.Connect.InitialBackoff.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "food database is not connected yet"})
+}
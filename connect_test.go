@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectWithRetry(t *testing.T) {
+	setupServer(t, "connect:\n  initial_backoff: 1ms\n  check_interval: 1h\n")
+	storageReady.Store(false)
+	cfg.SQLite.Path = filepath.Join(t.TempDir(), "foods.db")
+
+	// A snapshot that can't be read fails every attempt
+	cfg.SQLite.Snapshot = filepath.Join(t.TempDir(), "missing.json")
+	if err := connectWithRetry(2); err == nil || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Fatalf("connectWithRetry() error = %v, want it to give up after 2 attempts", err)
+	}
+	if storageReady.Load() {
+		t.Fatal("storage ready after failed attempts")
+	}
+
+	cfg.SQLite.Snapshot = testFoods
+	if err := connectWithRetry(2); err != nil {
+		t.Fatalf("connectWithRetry() error = %v", err)
+	}
+	t.Cleanup(func() { foodRepo.Close() })
+	if !storageReady.Load() || foodMappings == nil {
+		t.Error("storage not published once connected")
+	}
+}
+
+func TestRequireStorage(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		ready       bool
+		wantBlocked bool
+	}{
+		{"connected", http.MethodGet, "/readyz", true, false},
+		{"lookups wait for the store", http.MethodPost, "/v1/calculate-macros", false, true},
+		{"readiness waits for the store", http.MethodGet, "/readyz", false, true},
+		{"liveness is served", http.MethodGet, "/healthz", false, false},
+		{"inline calculation is served", http.MethodPost, "/v1/calculate-macros/inline", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, "")
+			storageReady.Store(tt.ready)
+
+			w := doRequest(t, router, tt.method, tt.path, "{}")
+			blocked := w.Code == http.StatusServiceUnavailable && strings.Contains(w.Body.String(), "not connected")
+			if blocked != tt.wantBlocked {
+				t.Fatalf("status = %d: %s; want blocked %v", w.Code, w.Body, tt.wantBlocked)
+			}
+			if blocked && w.Header().Get("Retry-After") == "" {
+				t.Error("503 without Retry-After")
+			}
+		})
+	}
+}
+
+func TestInvalidConnectConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		connect ConnectConfig
+	}{
+		{"negative attempts", ConnectConfig{Attempts: -1}},
+		{"negative check interval", ConnectConfig{CheckInterval: -time.Second}},
+		{"max backoff below the initial backoff", ConnectConfig{InitialBackoff: time.Minute, MaxBackoff: time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.connect.validate(); err == nil {
+				t.Error("validate() succeeded, want an error")
+			}
+		})
+	}
+	connect := ConnectConfig{}
+	if err := connect.validate(); err != nil || connect.Attempts != 5 || connect.MaxBackoff != 30*time.Second {
+		t.Errorf("validate() = %v with %+v, want the defaults filled in", err, connect)
+	}
+}
@@ -0,0 +1,49 @@
+// consensus.go
+package main
+
+// ConsensusMacros is a weighted average of a food's macros across the
+// datasets that have it, for clients that want one value instead of
+// comparing variants
+type ConsensusMacros struct {
+	Macros Macros `json:"macros"`
+	// Per100g is the averaged nutrient density the macros are scaled from
+	Per100g      Macros               `json:"per_100g"`
+	Contributors []ConsensusComponent `json:"contributors"`
+}
+
+// ConsensusComponent is one dataset record that went into the average
+type ConsensusComponent struct {
+	Dataset string  `json:"dataset"`
+	FdcID   int     `json:"fdc_id"`
+	Weight  float64 `json:"weight"`
+	Per100g Macros  `json:"per_100g"`
+}
+
+// consensusMacros averages the variants' per-100g values, weighted by
+// datasets.<name>.weight, and scales the result to grams. Averaging per
+// 100g keeps datasets whose portions disagree comparable; the weight is the
+// one computed for the primary match. It returns nil when no dataset has the
+// food.
+func consensusMacros(variants []DatasetVariant, grams float64) *ConsensusMacros {
+	var consensus ConsensusMacros
+	var total Macros
+	var totalWeight float64
+	for _, variant := range variants {
+		weight := cfg.Datasets[variant.Dataset].Weight
+		total = total.add(variant.Per100g.times(weight))
+		totalWeight += weight
+		consensus.Contributors = append(consensus.Contributors, ConsensusComponent{
+			Dataset: variant.Dataset,
+			FdcID:   variant.FdcID,
+			Weight:  weight,
+			Per100g: variant.Per100g,
+		})
+	}
+	if totalWeight == 0 {
+		return nil
+	}
+
+	consensus.Per100g = total.times(1 / totalWeight)
+	consensus.Macros = consensus.Per100g.times(grams / 100)
+	return &consensus
+}
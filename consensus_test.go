@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConsensusMacros(t *testing.T) {
+	// The sr banana has twice the nutrients of the fndds one, whose 100 g
+	// have 22.8 g of carbs; one cup, sliced, is 150 g
+	tests := []struct {
+		name             string
+		config           string
+		query            string
+		food             string
+		wantContributors int
+		wantCarbsPer100g float64
+	}{
+		{"off by default", twoDatasets, "", "banana", 0, 0},
+		{"equal weights", twoDatasets, "?consensus=true", "banana", 2, 34.2},
+		{"weighted toward sr", "datasets:\n  fndds: {}\n  sr:\n    weight: 3\ndefault_dataset: fndds\n", "?consensus=true", "banana", 2, 39.9},
+		{"weighted toward fndds", "datasets:\n  fndds:\n    weight: 3\n  sr: {}\ndefault_dataset: fndds\n", "?consensus=true", "banana", 2, 28.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig(t, tt.config)
+			banana := FoodData{
+				FdcID:         5,
+				Description:   "Banana, raw",
+				FoodNutrients: nutrients("205", 22.8, "203", 1.1, "204", 0.3, "208", 89.0),
+				FoodPortions:  portions("1 cup, sliced", 150),
+			}
+			doubled := banana
+			doubled.FdcID = 105
+			doubled.FoodNutrients = nutrients("205", 45.6, "203", 2.2, "204", 0.6, "208", 178.0)
+			cacheFood(t, "fndds", "Banana, raw", banana)
+			cacheFood(t, "sr", "Banana, raw", doubled)
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros"+tt.query, volumes(Volume{ObjectName: tt.food, VolumeCups: 1}))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if tt.wantContributors == 0 {
+				if item.Consensus != nil {
+					t.Errorf("consensus = %+v, want none", item.Consensus)
+				}
+				return
+			}
+			consensus := item.Consensus
+			if consensus == nil || len(consensus.Contributors) != tt.wantContributors {
+				t.Fatalf("consensus = %+v, want %d contributors", consensus, tt.wantContributors)
+			}
+
+			// Every averaged macro lies between the contributors' values
+			lowest, highest := consensus.Contributors[0].Per100g.byName(), consensus.Contributors[0].Per100g.byName()
+			for _, contributor := range consensus.Contributors[1:] {
+				for name, v := range contributor.Per100g.byName() {
+					lowest[name], highest[name] = min(lowest[name], v), max(highest[name], v)
+				}
+			}
+			for name, v := range consensus.Per100g.byName() {
+				if v < lowest[name]-1e-9 || v > highest[name]+1e-9 {
+					t.Errorf("%s per 100 g = %v, outside %v to %v", name, v, lowest[name], highest[name])
+				}
+			}
+			if tt.wantCarbsPer100g != 0 && math.Abs(consensus.Per100g.Carbs-tt.wantCarbsPer100g) > 1e-9 {
+				t.Errorf("carbs per 100 g = %v, want %v", consensus.Per100g.Carbs, tt.wantCarbsPer100g)
+			}
+			// Scaled to the primary match's weight
+			f := item.CalculatedWeight / 100
+			want := Macros{Calories: consensus.Per100g.Calories * f, Carbs: consensus.Per100g.Carbs * f, Fat: consensus.Per100g.Fat * f, Protein: consensus.Per100g.Protein * f}
+			if !macrosNear(consensus.Macros, want) {
+				t.Errorf("macros = %+v, want %+v for %v g", consensus.Macros, want, item.CalculatedWeight)
+			}
+		})
+	}
+}
+
+func TestConsensusOfOneDataset(t *testing.T) {
+	testConfig(t, twoDatasets)
+	per100g := Macros{Calories: 89, Carbs: 22.8, Fat: 0.3, Protein: 1.1}
+	consensus := consensusMacros([]DatasetVariant{{Dataset: "fndds", FdcID: 5, Per100g: per100g}}, 150)
+	if consensus == nil || len(consensus.Contributors) != 1 || !macrosNear(consensus.Per100g, per100g) {
+		t.Fatalf("consensus = %+v, want the only dataset's values", consensus)
+	}
+	if consensusMacros(nil, 150) != nil {
+		t.Error("consensus without variants, want none")
+	}
+}
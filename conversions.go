@@ -0,0 +1,311 @@
+// conversions.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// ConversionsConfig enables per-food conversion overrides, which say how
+// much of a food fills a volume when its portions don't tell. It is
+// disabled unless a collection to keep them in is configured.
+type ConversionsConfig struct {
+	Scope      string `yaml:"scope"`
+	Collection string `yaml:"collection"`
+}
+
+func (c *ConversionsConfig) enabled() bool {
+	return c.Collection != ""
+}
+
+func (c *ConversionsConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if c.Scope == "" {
+		c.Scope = defaultKeyspaceName
+	}
+	for _, name := range []string{c.Scope, c.Collection} {
+		if name != defaultKeyspaceName && !keyspaceNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid keyspace name %q in conversions config", name)
+		}
+	}
+	return nil
+}
+
+// FoodConversion states what a volume of a food equals: either a weight,
+// or a count of one of the food's portions, e.g. 1 cup of eggs is 4.5
+// "1 large".
+type FoodConversion struct {
+	ObjectName string  `json:"object_name"`
+	Cups       float64 `json:"cups"`
+	Grams      float64 `json:"grams,omitempty"`
+	Count      float64 `json:"count,omitempty"`
+	Portion    string  `json:"portion,omitempty"`
+}
+
+func (f FoodConversion) validate() error {
+	switch {
+	case f.Cups <= 0:
+		return errors.New("cups must be positive")
+	case f.Grams < 0 || f.Count < 0:
+		return errors.New("grams and count must not be negative")
+	case (f.Grams > 0) == (f.Count > 0):
+		return errors.New("set either grams or count")
+	case f.Count > 0 && strings.TrimSpace(f.Portion) == "":
+		return errors.New("count needs a portion")
+	case f.Grams > 0 && f.Portion != "":
+		return errors.New("portion only goes with count")
+	}
+	return nil
+}
+
+func conversionKey(name string) string {
+	return "conversion::" + name
+}
+
+// foodConversions caches every override; they are few and read on every
+// unresolved volume. Admin changes update the cache as they are stored.
+var foodConversions = struct {
+	sync.RWMutex
+	loaded  bool
+	entries map[string]FoodConversion
+}{}
+
+// loadConversions returns the cached overrides, reading them from Couchbase
+// on first use. The map must not be modified.
+func loadConversions(ctx context.Context) (map[string]FoodConversion, error) {
+	foodConversions.RLock()
+	if foodConversions.loaded {
+		defer foodConversions.RUnlock()
+		return foodConversions.entries, nil
+	}
+	foodConversions.RUnlock()
+
+	foodConversions.Lock()
+	defer foodConversions.Unlock()
+	if err := loadConversionsLocked(ctx); err != nil {
+		return nil, err
+	}
+	return foodConversions.entries, nil
+}
+
+func loadConversionsLocked(ctx context.Context) error {
+	if foodConversions.loaded {
+		return nil
+	}
+	result, err := runQuery(ctx, db.cluster, fmt.Sprintf(stmtConversions, db.conversionsKeyspace), nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load conversions: %w", err)
+	}
+	defer result.Close()
+
+	entries := make(map[string]FoodConversion)
+	for result.Next() {
+		var conversion FoodConversion
+		if err := result.Row(&conversion); err != nil {
+			return fmt.Errorf("failed to decode conversion: %w", err)
+		}
+		entries[conversion.ObjectName] = conversion
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("failed to load conversions: %w", err)
+	}
+	slog.InfoContext(ctx, "loaded conversion overrides", "conversions", len(entries))
+	foodConversions.entries = entries
+	foodConversions.loaded = true
+	return nil
+}
+
+// updateConversions stores or, with a nil conversion, removes an override
+// through store and then applies the change to the cache
+func updateConversions(ctx context.Context, name string, conversion *FoodConversion, store func() error) error {
+	foodConversions.Lock()
+	defer foodConversions.Unlock()
+	if err := loadConversionsLocked(ctx); err != nil {
+		return err
+	}
+	if err := store(); err != nil {
+		return err
+	}
+
+	// The map is replaced, never modified, so readers can keep using theirs
+	entries := make(map[string]FoodConversion, len(foodConversions.entries)+1)
+	for k, v := range foodConversions.entries {
+		if k != name {
+			entries[k] = v
+		}
+	}
+	if conversion != nil {
+		entries[name] = *conversion
+	}
+	foodConversions.entries = entries
+	return nil
+}
+
+// conversionCupGrams derives the weight of a cup of the food from its
+// conversion override. The match has zero grams when the food has none or
+// its portion doesn't exist; failures to load are logged and treated the
+// same, so overrides never fail an otherwise resolvable lookup.
+func conversionCupGrams(ctx context.Context, objectName string, portions []Portion) portionMatch {
+	if db == nil || db.conversions == nil {
+		return portionMatch{}
+	}
+	conversions, err := loadConversions(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load conversion overrides", "error", err)
+		return portionMatch{}
+	}
+	conversion, ok := conversions[normalizeFoodName(objectName)]
+	if !ok {
+		return portionMatch{}
+	}
+
+	if conversion.Grams > 0 {
+		return portionMatch{
+			grams:   conversion.Grams / conversion.Cups,
+			portion: fmt.Sprintf("%g cups = %g g", conversion.Cups, conversion.Grams),
+			quality: matchConversion,
+		}
+	}
+	portion, ok := findPortionByDescription(ctx, conversion.Portion, portions)
+	if !ok {
+		slog.WarnContext(ctx, "conversion override names a missing portion", "food", objectName, "portion", conversion.Portion)
+		return portionMatch{}
+	}
+	return portionMatch{
+		grams:   conversion.Count * portion.grams / conversion.Cups,
+		portion: fmt.Sprintf("%g cups = %g × %s", conversion.Cups, conversion.Count, portion.portion),
+		quality: matchConversion,
+	}
+}
+
+func requireConversions(c *gin.Context) bool {
+	if db.conversions == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "conversion overrides are not enabled"})
+		return false
+	}
+	return true
+}
+
+var conversionFields = listSpec[FoodConversion]{
+	fields: map[string]listField[FoodConversion]{
+		"object_name": {value: func(f FoodConversion) any { return f.ObjectName }, text: true},
+		"portion":     {value: func(f FoodConversion) any { return f.Portion }, text: true},
+		"cups":        {value: func(f FoodConversion) any { return f.Cups }},
+		"grams":       {value: func(f FoodConversion) any { return f.Grams }},
+	},
+	key:         func(f FoodConversion) string { return f.ObjectName },
+	defaultSort: "object_name",
+}
+
+// listConversions returns a page of the overrides, sorted by object name
+// unless ?sort= says otherwise
+func listConversions(c *gin.Context) {
+	if !requireConversions(c) {
+		return
+	}
+	q, err := parseListQuery(c, conversionFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entries, err := loadConversions(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to load conversions", err)
+		return
+	}
+
+	conversions := make([]FoodConversion, 0, len(entries))
+	for _, conversion := range entries {
+		conversions = append(conversions, conversion)
+	}
+	conversions, page := paginate(conversions, q, conversionFields)
+	c.JSON(http.StatusOK, page.response("conversions", conversions))
+}
+
+func getConversion(c *gin.Context) {
+	if !requireConversions(c) {
+		return
+	}
+	name := normalizeFoodName(c.Param("name"))
+	entries, err := loadConversions(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to load conversions", err)
+		return
+	}
+	conversion, ok := entries[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no conversion for %s", name)})
+		return
+	}
+	c.JSON(http.StatusOK, conversion)
+}
+
+// putConversion creates or replaces the override of an object name
+func putConversion(c *gin.Context) {
+	if !requireConversions(c) {
+		return
+	}
+	name := normalizeFoodName(c.Param("name"))
+	var conversion FoodConversion
+	if err := bindJSON(c, &conversion); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	conversion.ObjectName = name
+	conversion.Portion = strings.TrimSpace(conversion.Portion)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "object name must not be empty"})
+		return
+	}
+	if err := conversion.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := updateConversions(ctx, name, &conversion, func() error {
+		_, err := db.conversions.Upsert(conversionKey(name), conversion, &gocb.UpsertOptions{Context: ctx})
+		return err
+	})
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to store conversion", err)
+		return
+	}
+	c.JSON(http.StatusOK, conversion)
+}
+
+func deleteConversion(c *gin.Context) {
+	if !requireConversions(c) {
+		return
+	}
+	name := normalizeFoodName(c.Param("name"))
+	ctx := c.Request.Context()
+	removed := true
+	err := updateConversions(ctx, name, nil, func() error {
+		_, err := db.conversions.Remove(conversionKey(name), &gocb.RemoveOptions{Context: ctx})
+		if errors.Is(err, gocb.ErrDocumentNotFound) {
+			removed = false
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to delete conversion", err)
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no conversion for %s", name)})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// useConversions enables conversion overrides with the cache already
+// holding conversions, so they are read without Couchbase
+func useConversions(t *testing.T, conversions ...FoodConversion) {
+	t.Helper()
+	previous := db
+	db = &Database{conversions: &gocb.Collection{}}
+	entries := make(map[string]FoodConversion, len(conversions))
+	for _, conversion := range conversions {
+		entries[conversion.ObjectName] = conversion
+	}
+	foodConversions.Lock()
+	foodConversions.loaded, foodConversions.entries = true, entries
+	foodConversions.Unlock()
+	t.Cleanup(func() {
+		db = previous
+		foodConversions.Lock()
+		foodConversions.loaded, foodConversions.entries = false, nil
+		foodConversions.Unlock()
+	})
+}
+
+func TestConversionCupGrams(t *testing.T) {
+	eggs := &FoodData{FoodPortions: portions("1 large", 50, "1 medium", 44)}
+	tests := []struct {
+		name        string
+		conversions []FoodConversion
+		food        string
+		wantGrams   float64
+		wantQuality string
+	}{
+		{"count of a portion", []FoodConversion{{ObjectName: "egg", Cups: 1, Count: 4, Portion: "1 large"}}, "egg", 200, matchConversion},
+		{"weight per volume", []FoodConversion{{ObjectName: "egg", Cups: 2, Grams: 480}}, "egg", 240, matchConversion},
+		{"object names are normalized", []FoodConversion{{ObjectName: "egg", Cups: 1, Grams: 210}}, " EGG ", 210, matchConversion},
+		// Eggs fall back to the built-in eggs per cup
+		{"missing portion is ignored", []FoodConversion{{ObjectName: "egg", Cups: 1, Count: 4, Portion: "1 jumbo"}}, "egg", 225, matchFallbackDensity},
+		{"no override", nil, "egg", 225, matchFallbackDensity},
+	}
+	testConfig(t, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConversions(t, tt.conversions...)
+			match := findCupGrams(context.Background(), Volume{ObjectName: tt.food}, eggs)
+			if math.Abs(match.grams-tt.wantGrams) > 1e-9 || match.quality != tt.wantQuality {
+				t.Errorf("findCupGrams() = %v g by %q, want %v g by %q", match.grams, match.quality, tt.wantGrams, tt.wantQuality)
+			}
+		})
+	}
+}
+
+func TestFoodConversionValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		conversion FoodConversion
+		wantErr    bool
+	}{
+		{"grams", FoodConversion{Cups: 1, Grams: 240}, false},
+		{"count", FoodConversion{Cups: 1, Count: 4.5, Portion: "1 large"}, false},
+		{"no cups", FoodConversion{Grams: 240}, true},
+		{"neither grams nor count", FoodConversion{Cups: 1}, true},
+		{"both grams and count", FoodConversion{Cups: 1, Grams: 240, Count: 4, Portion: "1 large"}, true},
+		{"count without a portion", FoodConversion{Cups: 1, Count: 4, Portion: " "}, true},
+		{"portion with grams", FoodConversion{Cups: 1, Grams: 240, Portion: "1 large"}, true},
+		{"negative grams", FoodConversion{Cups: 1, Grams: -1, Count: 2, Portion: "1 large"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.conversion.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConversionEndpoints(t *testing.T) {
+	auth := []string{"Authorization", "Bearer s3cret"}
+	router := setupServer(t, "admin:\n  token: s3cret\n")
+	if w := doRequest(t, router, http.MethodGet, "/v1/admin/conversions", nil, auth...); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d while disabled, want %d", w.Code, http.StatusNotFound)
+	}
+
+	useConversions(t, FoodConversion{ObjectName: "egg", Cups: 1, Count: 4.5, Portion: "1 large"}, FoodConversion{ObjectName: "almond", Cups: 1, Grams: 143})
+	listed := decode[struct {
+		Conversions []FoodConversion `json:"conversions"`
+	}](t, doRequest(t, router, http.MethodGet, "/v1/admin/conversions", nil, auth...), http.StatusOK)
+	if len(listed.Conversions) != 2 || listed.Conversions[0].ObjectName != "almond" {
+		t.Errorf("conversions = %+v, want both sorted by object name", listed.Conversions)
+	}
+	if egg := decode[FoodConversion](t, doRequest(t, router, http.MethodGet, "/v1/admin/conversions/Egg", nil, auth...), http.StatusOK); egg.Count != 4.5 {
+		t.Errorf("egg = %+v, want 4.5 large eggs per cup", egg)
+	}
+	if w := doRequest(t, router, http.MethodGet, "/v1/admin/conversions/kiwi", nil, auth...); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d for a missing conversion, want %d", w.Code, http.StatusNotFound)
+	}
+	// Invalid conversions are rejected before anything is stored
+	if w := doRequest(t, router, http.MethodPut, "/v1/admin/conversions/egg", FoodConversion{Cups: 1}, auth...); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d for an invalid conversion, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,128 @@
+// cors.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORSConfig lets browser apps on other origins, such as the web
+// dashboard, call the API. Disabled while no origin is allowed.
+type CORSConfig struct {
+	// AllowedOrigins lists origins like "https://dashboard.bytemi.app";
+	// "https://*.bytemi.app" allows the subdomains and "*" every origin
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// AllowedMethods defaults to GET, POST, PUT, DELETE
+	AllowedMethods []string `yaml:"allowed_methods"`
+	// AllowedHeaders defaults to the request headers the API reads
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// ExposedHeaders defaults to the response headers the API sets
+	ExposedHeaders []string `yaml:"exposed_headers"`
+	// AllowCredentials lets browsers send cookies and client certificates
+	AllowCredentials bool `yaml:"allow_credentials"`
+	// MaxAge is how long browsers may cache a preflight; defaults to 10m
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+func (c *CORSConfig) enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+func (c *CORSConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return errors.New("cors: allow_credentials can't be combined with the \"*\" origin")
+			}
+			continue
+		}
+		if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return fmt.Errorf("invalid cors origin %q: expected scheme://host", origin)
+		}
+	}
+	if len(c.AllowedMethods) == 0 {
+		c.AllowedMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+	}
+	if len(c.AllowedHeaders) == 0 {
+		c.AllowedHeaders = []string{"Accept", "Accept-Language", "Authorization", "Content-Type", "If-None-Match", apiKeyHeader, idempotencyKeyHeader, "X-Request-ID"}
+	}
+	if len(c.ExposedHeaders) == 0 {
+		c.ExposedHeaders = []string{"ETag", idempotentReplayHeader, "Retry-After", "X-Request-ID"}
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = 10 * time.Minute
+	}
+	if c.MaxAge < 0 {
+		return errors.New("cors.max_age must be positive")
+	}
+	return nil
+}
+
+// allows reports whether a request origin is allowed
+func (c *CORSConfig) allows(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// "https://*.bytemi.app" matches "https://dashboard.bytemi.app"
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+			len(origin) > len(prefix)+len(suffix) && !strings.Contains(origin[len(prefix):len(origin)-len(suffix)], "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cors answers preflight requests and marks the responses to allowed
+// origins. Other origins get no CORS headers, so browsers block them;
+// requests without an Origin pass through untouched.
+func cors(c *gin.Context) {
+	config := &cfg.CORS
+	origin := c.GetHeader("Origin")
+	if !config.enabled() || origin == "" {
+		c.Next()
+		return
+	}
+	header := c.Writer.Header()
+	header.Add("Vary", "Origin")
+	if !config.allows(origin) {
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+		return
+	}
+
+	if slices.Contains(config.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", strings.Join(config.AllowedMethods, ", "))
+		header.Set("Access-Control-Allow-Headers", strings.Join(config.AllowedHeaders, ", "))
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+	header.Set("Access-Control-Expose-Headers", strings.Join(config.ExposedHeaders, ", "))
+	c.Next()
+}
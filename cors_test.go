@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	router := setupServer(t, "cors:\n  allowed_origins: [https://dashboard.bytemi.app, https://*.preview.bytemi.app]\n  max_age: 1h\n")
+	tests := []struct {
+		name        string
+		method      string
+		headers     []string
+		wantStatus  int
+		wantOrigin  string
+		wantMethods bool
+	}{
+		{"no origin", http.MethodGet, nil, http.StatusOK, "", false},
+		{"allowed origin", http.MethodGet, []string{"Origin", "https://dashboard.bytemi.app"}, http.StatusOK, "https://dashboard.bytemi.app", false},
+		{"wildcard subdomain", http.MethodGet, []string{"Origin", "https://pr-12.preview.bytemi.app"}, http.StatusOK, "https://pr-12.preview.bytemi.app", false},
+		{"other origin", http.MethodGet, []string{"Origin", "https://evil.example"}, http.StatusOK, "", false},
+		{"preflight", http.MethodOptions, []string{"Origin", "https://dashboard.bytemi.app", "Access-Control-Request-Method", "POST"}, http.StatusNoContent, "https://dashboard.bytemi.app", true},
+		{"preflight from another origin", http.MethodOptions, []string{"Origin", "https://evil.example", "Access-Control-Request-Method", "POST"}, http.StatusNoContent, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, tt.method, "/healthz", nil, tt.headers...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods") != ""; got != tt.wantMethods {
+				t.Errorf("Access-Control-Allow-Methods = %q, want set %v", w.Header().Get("Access-Control-Allow-Methods"), tt.wantMethods)
+			}
+			if tt.wantMethods && w.Header().Get("Access-Control-Max-Age") != "3600" {
+				t.Errorf("Access-Control-Max-Age = %q, want 3600", w.Header().Get("Access-Control-Max-Age"))
+			}
+		})
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	config := CORSConfig{AllowedOrigins: []string{"https://*.bytemi.app"}}
+	for origin, want := range map[string]bool{
+		"https://dashboard.bytemi.app":      true,
+		"https://bytemi.app":                false,
+		"https://.bytemi.app":               false,
+		"http://dashboard.bytemi.app":       false,
+		"https://evil.example/x.bytemi.app": false,
+	} {
+		if got := config.allows(origin); got != want {
+			t.Errorf("allows(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	for _, invalid := range []CORSConfig{
+		{AllowedOrigins: []string{"dashboard.bytemi.app"}},
+		{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("validate() of %+v succeeded, want an error", invalid)
+		}
+	}
+}
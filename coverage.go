@@ -0,0 +1,112 @@
+// coverage.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultCoverageSample = 100
+	maxCoverageSample     = 1000
+)
+
+// NutrientCoverage is the share of sampled documents carrying a nutrient
+type NutrientCoverage struct {
+	Number   string  `json:"number"`
+	Name     string  `json:"name"`
+	Present  int     `json:"present"`
+	Coverage float64 `json:"coverage_pct"`
+}
+
+type CoverageReport struct {
+	Dataset   string             `json:"dataset"`
+	Sampled   int                `json:"sampled"`
+	Nutrients []NutrientCoverage `json:"nutrients"`
+}
+
+// nutrientCoverage samples documents from a dataset and reports how many of
+// them carry each nutrient the macros are computed from, which explains
+// macros that come back as zero for some datasets
+func nutrientCoverage(c *gin.Context) {
+	if db.cluster == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "coverage reports need storage couchbase"})
+		return
+	}
+	dataset := c.Param("dataset")
+	keyspace, ok := db.keyspaces[dataset]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown dataset: %s", dataset)})
+		return
+	}
+
+	sample := defaultCoverageSample
+	if raw := c.Query("sample"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxCoverageSample {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("sample must be between 1 and %d", maxCoverageSample)})
+			return
+		}
+		sample = n
+	}
+
+	query := fmt.Sprintf(stmtNutrientCoverage, keyspace)
+	result, err := runQuery(c.Request.Context(), db.cluster, query, []interface{}{sample}, nil)
+	if err != nil {
+		if timedOut(c) {
+			return
+		}
+		respondError(c, http.StatusBadGateway, "coverage query failed", err)
+		return
+	}
+	defer result.Close()
+
+	report, err := readCoverage(dataset, result)
+	if err != nil {
+		if timedOut(c) {
+			return
+		}
+		respondError(c, http.StatusBadGateway, "coverage query failed", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// readCoverage counts the macro nutrients present across sampled rows, each
+// the nutrient numbers of one document
+func readCoverage(dataset string, result queryRows) (CoverageReport, error) {
+	report := CoverageReport{Dataset: dataset}
+	present := make(map[string]int)
+	for result.Next() {
+		var numbers []string
+		if err := result.Row(&numbers); err != nil {
+			return report, err
+		}
+
+		report.Sampled++
+		seen := make(map[string]bool, len(numbers))
+		for _, number := range numbers {
+			if !seen[number] {
+				seen[number] = true
+				present[number]++
+			}
+		}
+	}
+	if err := result.Err(); err != nil {
+		return report, err
+	}
+
+	report.Nutrients = make([]NutrientCoverage, 0, len(macroNutrients))
+	for _, nutrient := range macroNutrients {
+		coverage := NutrientCoverage{Number: nutrient.Number, Name: nutrient.Name, Present: present[nutrient.Number]}
+		if report.Sampled > 0 {
+			coverage.Coverage = 100 * float64(coverage.Present) / float64(report.Sampled)
+		}
+		report.Nutrients = append(report.Nutrients, coverage)
+	}
+	return report, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+func TestReadCoverage(t *testing.T) {
+	tests := []struct {
+		name        string
+		rows        fakeRows
+		wantSampled int
+		// wantCoverage is by nutrient number, in percent
+		wantCoverage map[string]float64
+		wantErr      bool
+	}{
+		{
+			name: "every macro present",
+			rows: fakeRows{rows: []string{
+				`["208", "203", "204", "205"]`,
+				`["208", "203", "204", "205", "291"]`,
+			}},
+			wantSampled:  2,
+			wantCoverage: map[string]float64{"208": 100, "203": 100, "204": 100, "205": 100},
+		},
+		{
+			name: "dataset missing energy in some documents",
+			rows: fakeRows{rows: []string{
+				`["203", "204", "205"]`,
+				`["208", "203", "204", "205"]`,
+				`["203", "204"]`,
+				`["203"]`,
+			}},
+			wantSampled:  4,
+			wantCoverage: map[string]float64{"208": 25, "203": 100, "204": 75, "205": 50},
+		},
+		{
+			name:         "repeated numbers count once per document",
+			rows:         fakeRows{rows: []string{`["208", "208"]`, `[]`}},
+			wantSampled:  2,
+			wantCoverage: map[string]float64{"208": 50, "203": 0, "204": 0, "205": 0},
+		},
+		{
+			name:         "empty sample",
+			rows:         fakeRows{},
+			wantCoverage: map[string]float64{"208": 0, "203": 0, "204": 0, "205": 0},
+		},
+		{
+			name:    "stream error",
+			rows:    fakeRows{rows: []string{`["208"]`}, err: errors.New("stream closed")},
+			wantErr: true,
+		},
+		{
+			name:    "undecodable row",
+			rows:    fakeRows{rows: []string{`{"208": true}`}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := readCoverage("fndds", &tt.rows)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("readCoverage() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Dataset != "fndds" || report.Sampled != tt.wantSampled {
+				t.Errorf("dataset = %s, sampled = %d; want fndds, %d", report.Dataset, report.Sampled, tt.wantSampled)
+			}
+			if len(report.Nutrients) != len(macroNutrients) {
+				t.Fatalf("nutrients = %+v, want one per macro", report.Nutrients)
+			}
+			for _, n := range report.Nutrients {
+				if want := tt.wantCoverage[n.Number]; n.Coverage != want {
+					t.Errorf("%s (%s) coverage = %v%%, want %v%%", n.Name, n.Number, n.Coverage, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNutrientCoverageValidation(t *testing.T) {
+	testConfig(t, "admin:\n  token: s3cret\n")
+	previous := db
+	// The requests are rejected before querying the cluster
+	db = &Database{cluster: &gocb.Cluster{}, keyspaces: map[string]string{"fndds": "`fdc`.`_default`.`_default`"}}
+	t.Cleanup(func() { db = previous })
+	router := adminRouter()
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"unknown dataset", "/admin/datasets/branded/coverage", http.StatusNotFound},
+		{"zero sample", "/admin/datasets/fndds/coverage?sample=0", http.StatusBadRequest},
+		{"sample too large", "/admin/datasets/fndds/coverage?sample=1001", http.StatusBadRequest},
+		{"non-numeric sample", "/admin/datasets/fndds/coverage?sample=all", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodGet, tt.path, nil, "Authorization", "Bearer s3cret")
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+
+	// Other stores have no N1QL to sample documents with
+	db = &Database{}
+	if w := doRequest(t, router, http.MethodGet, "/admin/datasets/fndds/coverage", nil, "Authorization", "Bearer s3cret"); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d without a cluster, want %d", w.Code, http.StatusNotFound)
+	}
+}
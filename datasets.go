@@ -0,0 +1,142 @@
+// datasets.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Dataset locates the collection holding one dataset's food documents
+type Dataset struct {
+	Scope      string `yaml:"scope"`
+	Collection string `yaml:"collection"`
+	// Expiry removes documents ingested into the dataset after this long,
+	// for temporary or test data; zero keeps them
+	Expiry time.Duration `yaml:"expiry"`
+	// Weight is the dataset's share in ?consensus=true averages; defaults
+	// to 1
+	Weight float64 `yaml:"weight"`
+}
+
+// DatasetVariant is a food as resolved in one particular dataset, so clients
+// can compare datasets whose nutrient values disagree
+type DatasetVariant struct {
+	Dataset          string  `json:"dataset"`
+	FdcID            int     `json:"fdc_id"`
+	Description      string  `json:"description"`
+	Found            bool    `json:"found"`
+	Macros           Macros  `json:"macros"`
+	CalculatedWeight float64 `json:"calculated_weight"`
+	// Per100g is the food's nutrient density, independent of its portions
+	Per100g Macros `json:"per_100g"`
+}
+
+// validateDatasets fills in the implicit dataset and default names, and
+// checks that every dataset points at a valid collection
+func (c *Config) validateDatasets() error {
+	if len(c.Datasets) == 0 {
+		name := c.CouchDB.Bucket
+		if name == "" {
+			name = "default"
+		}
+		c.Datasets = map[string]Dataset{
+			name: {Scope: c.CouchDB.Scope, Collection: c.CouchDB.Collection},
+		}
+	}
+
+	for name, dataset := range c.Datasets {
+		if dataset.Scope == "" {
+			dataset.Scope = defaultKeyspaceName
+		}
+		if dataset.Collection == "" {
+			dataset.Collection = defaultKeyspaceName
+		}
+		for _, n := range []string{dataset.Scope, dataset.Collection} {
+			if n != defaultKeyspaceName && !keyspaceNamePattern.MatchString(n) {
+				return fmt.Errorf("invalid keyspace name %q in dataset %s", n, name)
+			}
+		}
+		if dataset.Expiry < 0 {
+			return fmt.Errorf("expiry of dataset %s must not be negative", name)
+		}
+		if dataset.Expiry > 0 && c.Storage != storageCouchbase {
+			return fmt.Errorf("expiry of dataset %s needs storage couchbase", name)
+		}
+		if dataset.Weight == 0 {
+			dataset.Weight = 1
+		}
+		if dataset.Weight < 0 {
+			return fmt.Errorf("weight of dataset %s must not be negative", name)
+		}
+		c.Datasets[name] = dataset
+	}
+
+	if c.DefaultDataset == "" {
+		if len(c.Datasets) > 1 {
+			return fmt.Errorf("default_dataset is required when several datasets are configured")
+		}
+		for name := range c.Datasets {
+			c.DefaultDataset = name
+		}
+	}
+	if _, ok := c.Datasets[c.DefaultDataset]; !ok {
+		return fmt.Errorf("default_dataset %q is not a configured dataset", c.DefaultDataset)
+	}
+
+	foodDatasets := make(map[string]string, len(c.FoodDatasets))
+	for food, dataset := range c.FoodDatasets {
+		if _, ok := c.Datasets[dataset]; !ok {
+			return fmt.Errorf("food_datasets.%s refers to unknown dataset %q", food, dataset)
+		}
+		foodDatasets[normalizeFoodName(food)] = dataset
+	}
+	c.FoodDatasets = foodDatasets
+	return nil
+}
+
+// datasetFor returns the dataset an object name is looked up in
+func datasetFor(objectName string) string {
+	if dataset, ok := cfg.FoodDatasets[normalizeFoodName(objectName)]; ok {
+		return dataset
+	}
+	return cfg.DefaultDataset
+}
+
+// datasetNames returns the configured dataset names in a stable order
+func datasetNames() []string {
+	names := make([]string, 0, len(cfg.Datasets))
+	for name := range cfg.Datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// datasetVariants resolves the volume against every configured dataset.
+// Datasets without a matching food are left out.
+func datasetVariants(ctx context.Context, volume Volume, version string, stats *requestStats) []DatasetVariant {
+	var variants []DatasetVariant
+	for _, name := range datasetNames() {
+		foodData, err := getFoodData(ctx, name, volume.ObjectName, version, stats)
+		if err != nil || foodData == nil {
+			slog.InfoContext(ctx, "no variant in dataset", "food", volume.ObjectName, "dataset", name, "error", err)
+			continue
+		}
+
+		result, ok := computeMacros(ctx, volume, foodData)
+		per100g, _, _ := calculateMacrosForGrams(foodData.FoodNutrients, 100, 100)
+		variants = append(variants, DatasetVariant{
+			Dataset:          name,
+			FdcID:            foodData.FdcID,
+			Description:      foodData.Description,
+			Found:            ok,
+			Macros:           result.macros,
+			CalculatedWeight: result.grams,
+			Per100g:          per100g,
+		})
+	}
+	return variants
+}
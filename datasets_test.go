@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// twoDatasets configures the snapshot's dataset and a second one
+const twoDatasets = `
+datasets:
+  fndds: {}
+  sr: {}
+default_dataset: fndds
+`
+
+// storeVariant stores a copy of a snapshot food in another dataset, under
+// another fdcId and with every nutrient amount scaled
+func storeVariant(t *testing.T, dataset string, fdcID, variantID int, scale float64) {
+	t.Helper()
+	ctx := context.Background()
+	document, ok, err := foodRepo.GetByFDCID(ctx, cfg.DefaultDataset, fdcID)
+	if err != nil || !ok {
+		t.Fatalf("food %d not in the snapshot: %v", fdcID, err)
+	}
+	var food FoodData
+	if err := json.Unmarshal(document.Data, &food); err != nil {
+		t.Fatal(err)
+	}
+	food.FdcID = variantID
+	for i := range food.FoodNutrients {
+		food.FoodNutrients[i].Amount *= scale
+	}
+	data, err := json.Marshal(food)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := foodRepo.StoreFoods(ctx, dataset, []json.RawMessage{data}, 0); err != nil {
+		t.Fatalf("failed to store variant: %v", err)
+	}
+}
+
+func TestCalculateMacrosVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		food     string
+		wantIDs  map[string]int
+		wantDiff bool
+	}{
+		{"single result by default", "", "banana", nil, false},
+		{"a variant per dataset", "?variants=true", "banana", map[string]int{"fndds": 5, "sr": 105}, true},
+		{"datasets without the food are left out", "?variants=true", "egg", map[string]int{"fndds": 3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, twoDatasets)
+			storeVariant(t, "sr", 5, 105, 2)
+
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros"+tt.query, volumes(Volume{ObjectName: tt.food, VolumeCups: 1}))
+			response := decode[MacroResponse](t, w, http.StatusOK)
+			md := response.Data[0]
+			if !md.Found {
+				t.Fatalf("%s not found", tt.food)
+			}
+			if len(md.Variants) != len(tt.wantIDs) {
+				t.Fatalf("variants = %+v, want datasets %v", md.Variants, tt.wantIDs)
+			}
+			for _, variant := range md.Variants {
+				if want, ok := tt.wantIDs[variant.Dataset]; !ok || variant.FdcID != want || !variant.Found {
+					t.Errorf("variant %+v, want fdcId %d", variant, want)
+				}
+			}
+			// The default dataset's result is the top-level one
+			if len(md.Variants) > 0 && md.Macros != md.Variants[0].Macros {
+				t.Errorf("macros = %+v, want the %s variant's %+v", md.Macros, md.Variants[0].Dataset, md.Variants[0].Macros)
+			}
+			if tt.wantDiff && md.Variants[0].Per100g.Carbs*2 != md.Variants[1].Per100g.Carbs {
+				t.Errorf("per_100g carbs = %v and %v, want the second doubled", md.Variants[0].Per100g.Carbs, md.Variants[1].Per100g.Carbs)
+			}
+		})
+	}
+}
+
+func TestConfigValidateDatasets(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		wantDefault string
+		wantErr     bool
+	}{
+		{"implicit dataset named after the bucket", "couchdb:\n  bucket: fdc\n", "fdc", false},
+		{"implicit dataset without a bucket", "", "default", false},
+		{"single dataset is the default", "datasets:\n  sr: {}\n", "sr", false},
+		{"explicit default", twoDatasets, "fndds", false},
+		{"several datasets need a default", "datasets:\n  fndds: {}\n  sr: {}\n", "", true},
+		{"default must be configured", "datasets:\n  sr: {}\ndefault_dataset: fndds\n", "", true},
+		{"invalid collection name", "datasets:\n  sr:\n    collection: 'foods;drop'\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			if err := yaml.Unmarshal([]byte(tt.config), &config); err != nil {
+				t.Fatal(err)
+			}
+			err := config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && config.DefaultDataset != tt.wantDefault {
+				t.Errorf("default_dataset = %q, want %q", config.DefaultDataset, tt.wantDefault)
+			}
+		})
+	}
+}
+
+func TestDatasetNames(t *testing.T) {
+	testConfig(t, "datasets:\n  sr: {}\n  fndds: {}\n  branded: {}\ndefault_dataset: fndds\n")
+	got := datasetNames()
+	want := []string{"branded", "fndds", "sr"}
+	if !slices.Equal(got, want) {
+		t.Errorf("datasetNames() = %v, want %v", got, want)
+	}
+}
+
+func TestDatasetExpiry(t *testing.T) {
+	documents := []json.RawMessage{[]byte(`{"fdcId": 1}`), []byte(`{"fdcId": 2}`)}
+	tests := []struct {
+		name       string
+		config     string
+		expiry     time.Duration
+		wantExpiry time.Duration
+		wantErr    bool
+	}{
+		{"no expiry by default", "datasets:\n  tmp: {}\n", 0, 0, false},
+		{"configured on the dataset", "datasets:\n  tmp:\n    expiry: 48h\n", 0, 48 * time.Hour, false},
+		{"explicit expiry wins", "datasets:\n  tmp:\n    expiry: 48h\n", time.Hour, time.Hour, false},
+		{"negative expiry", "datasets:\n  tmp:\n    expiry: -1h\n", 0, 0, true},
+		{"expiry needs couchbase", "storage: sqlite\ndatasets:\n  tmp:\n    expiry: 1h\n", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			if err := yaml.Unmarshal([]byte(tt.config), &config); err != nil {
+				t.Fatal(err)
+			}
+			err := config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			ops, err := foodUpserts(documents, tt.expiry, config.Datasets["tmp"].Expiry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, op := range ops {
+				upsert := op.(*gocb.UpsertOp)
+				if want := fmt.Sprintf("fdc::%d", i+1); upsert.ID != want {
+					t.Errorf("ID = %s, want %s", upsert.ID, want)
+				}
+				if upsert.Expiry != tt.wantExpiry {
+					t.Errorf("Expiry = %v, want %v", upsert.Expiry, tt.wantExpiry)
+				}
+			}
+		})
+	}
+}
+
+func TestFoodDatasets(t *testing.T) {
+	config := twoDatasets + "food_datasets:\n  Banana: sr\n"
+	tests := []struct {
+		name        string
+		food        string
+		wantDataset string
+		wantCarbs   float64
+	}{
+		// The sr banana has twice the snapshot's 22.8 g carbs per 100 g
+		{"override routes to another dataset", "banana", "sr", 2 * 22.8 * 1.5},
+		{"override matches normalized names", " BANANA ", "sr", 2 * 22.8 * 1.5},
+		{"other foods use the default dataset", "egg", "fndds", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, config)
+			storeVariant(t, "sr", 5, 105, 2)
+
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: tt.food, VolumeCups: 1}))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if !item.Found || item.Dataset != tt.wantDataset {
+				t.Errorf("found = %v in %q, want %q", item.Found, item.Dataset, tt.wantDataset)
+			}
+			if tt.wantCarbs > 0 && math.Abs(item.Macros.Carbs-tt.wantCarbs) > 0.01 {
+				t.Errorf("carbs = %v, want %v from the %s food", item.Macros.Carbs, tt.wantCarbs, tt.wantDataset)
+			}
+		})
+	}
+}
+
+func TestConfigValidateFoodDatasets(t *testing.T) {
+	var config Config
+	if err := yaml.Unmarshal([]byte(twoDatasets+"food_datasets:\n  banana: branded\n"), &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.validate(); err == nil {
+		t.Error("validate() accepted a food routed to an unknown dataset")
+	}
+}
@@ -0,0 +1,135 @@
+// day.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DayRequest carries every meal a client logged for one day
+type DayRequest struct {
+	Data struct {
+		Date  string        `json:"date"`
+		Meals []MealRequest `json:"meals" binding:"dive"`
+	} `json:"data"`
+}
+
+type MealRequest struct {
+	FrameID string   `json:"frame_id" binding:"omitempty,frame_id"`
+	Volumes []Volume `json:"volumes" binding:"max=100,dive"`
+}
+
+type DayResponse struct {
+	Data DaySummary `json:"data"`
+}
+
+// DaySummary totals the resolved items of all meals; items that couldn't
+// be resolved are reported per meal and left out of the totals
+type DaySummary struct {
+	Date       string        `json:"date,omitempty"`
+	Status     string        `json:"status"`
+	EnergyUnit string        `json:"energy_unit"`
+	Totals     Macros        `json:"totals"`
+	Unresolved int           `json:"unresolved"`
+	Meals      []MealSummary `json:"meals"`
+}
+
+type MealSummary struct {
+	FrameID    string      `json:"frame_id,omitempty"`
+	Totals     Macros      `json:"totals"`
+	Unresolved int         `json:"unresolved"`
+	Items      []MacroData `json:"items"`
+}
+
+// calculateDay computes every meal of a day and aggregates them into daily
+// totals. Nothing is stored; the client sends the whole day.
+func calculateDay(c *gin.Context) {
+	var request DayRequest
+	if err := bindJSON(c, &request); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+
+	for i, meal := range request.Data.Meals {
+		if err := validateVolumes(fmt.Sprintf("data.meals[%d].volumes", i), meal.Volumes); err != nil {
+			respondInvalid(c, err)
+			return
+		}
+	}
+	format, err := parseOutputFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	micros, err := parseNutrientSelection(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if rejectWhileBreakerOpen(c) {
+		return
+	}
+	if !requireDataVersion(c) {
+		return
+	}
+
+	cc := newCalcContext(c)
+	cc.micros = micros
+	var volumes []Volume
+	for _, meal := range request.Data.Meals {
+		volumes = append(volumes, meal.Volumes...)
+	}
+	cc.prefetch(volumes)
+	day := DaySummary{
+		Date:       request.Data.Date,
+		EnergyUnit: format.energyUnit,
+		Meals:      make([]MealSummary, 0, len(request.Data.Meals)),
+	}
+	for _, meal := range request.Data.Meals {
+		summary := summarizeMeal(meal, cc)
+		day.Totals = day.Totals.add(summary.Totals)
+		day.Unresolved += summary.Unresolved
+
+		// Totals are summed unformatted, then everything is formatted
+		summary.Totals = format.macros(summary.Totals)
+		for i := range summary.Items {
+			format.item(&summary.Items[i])
+		}
+		day.Meals = append(day.Meals, summary)
+	}
+	if timedOut(c) {
+		return
+	}
+	day.Totals = format.macros(day.Totals)
+
+	var items []MacroData
+	for _, meal := range day.Meals {
+		items = append(items, meal.Items...)
+	}
+	day.Status = responseStatus(items)
+	status := http.StatusOK
+	if day.Status == responseStatusDegraded {
+		status = degradedStatus(c)
+	}
+	c.JSON(status, DayResponse{Data: day})
+}
+
+// summarizeMeal computes each item of a meal and totals the resolved ones
+func summarizeMeal(meal MealRequest, cc *calcContext) MealSummary {
+	summary := MealSummary{
+		FrameID: meal.FrameID,
+		Items:   make([]MacroData, 0, len(meal.Volumes)),
+	}
+	for _, volume := range meal.Volumes {
+		item := processFoodVolume(volume, cc)
+		if item.Found {
+			summary.Totals = summary.Totals.add(item.Macros)
+		} else {
+			summary.Unresolved++
+		}
+		summary.Items = append(summary.Items, item)
+	}
+	return summary
+}
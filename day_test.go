@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// day builds a POST /v1/day body with a meal per list of volumes
+func day(meals ...[]Volume) DayRequest {
+	var request DayRequest
+	request.Data.Date = "2026-10-15"
+	for _, volumes := range meals {
+		request.Data.Meals = append(request.Data.Meals, MealRequest{Volumes: volumes})
+	}
+	return request
+}
+
+func TestMacrosAdd(t *testing.T) {
+	a := Macros{Calories: 100, Carbs: 20, Fat: 5, Protein: 3}
+	b := Macros{Calories: 50.5, Carbs: 1, Fat: 0.5, Protein: 10}
+	want := Macros{Calories: 150.5, Carbs: 21, Fat: 5.5, Protein: 13}
+	if got := a.add(b); got != want {
+		t.Errorf("add() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCalculateDay(t *testing.T) {
+	breakfast := []Volume{{ObjectName: "egg", VolumeCups: 0.5}, {ObjectName: "banana", VolumeCups: 1}}
+	lunch := []Volume{{ObjectName: "rice", VolumeCups: 1.5}}
+	dinner := []Volume{{ObjectName: "rice", VolumeCups: 1}, {ObjectName: "dragonfruit", VolumeCups: 1}}
+	tests := []struct {
+		name           string
+		request        DayRequest
+		wantStatus     string
+		wantUnresolved []int
+	}{
+		{"single meal", day(lunch), responseStatusOK, []int{0}},
+		{"several meals", day(breakfast, lunch), responseStatusOK, []int{0, 0}},
+		{"unresolved items are left out of the totals", day(breakfast, lunch, dinner), responseStatusPartial, []int{0, 0, 1}},
+		{"no meals", day(), responseStatusOK, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, "")
+			w := doRequest(t, router, http.MethodPost, "/v1/day", tt.request)
+			summary := decode[DayResponse](t, w, http.StatusOK).Data
+			if summary.Status != tt.wantStatus || summary.Date != tt.request.Data.Date {
+				t.Errorf("status = %q, date = %q; want %q, %q", summary.Status, summary.Date, tt.wantStatus, tt.request.Data.Date)
+			}
+			if len(summary.Meals) != len(tt.wantUnresolved) {
+				t.Fatalf("meals = %d, want %d", len(summary.Meals), len(tt.wantUnresolved))
+			}
+
+			// Every meal totals what the same volumes calculate to on
+			// their own, and the day totals the meals
+			var want Macros
+			unresolved := 0
+			for i, meal := range summary.Meals {
+				if meal.Unresolved != tt.wantUnresolved[i] {
+					t.Errorf("meal %d: unresolved = %d, want %d", i, meal.Unresolved, tt.wantUnresolved[i])
+				}
+				w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(tt.request.Data.Meals[i].Volumes...))
+				var mealWant Macros
+				for _, item := range decode[MacroResponse](t, w, w.Code).Data {
+					if item.Found {
+						mealWant = mealWant.add(item.Macros)
+					}
+				}
+				if !macrosNear(meal.Totals, mealWant) {
+					t.Errorf("meal %d: totals = %+v, want %+v", i, meal.Totals, mealWant)
+				}
+				want = want.add(mealWant)
+				unresolved += meal.Unresolved
+			}
+			if !macrosNear(summary.Totals, want) || summary.Unresolved != unresolved {
+				t.Errorf("day totals = %+v with %d unresolved, want %+v with %d", summary.Totals, summary.Unresolved, want, unresolved)
+			}
+		})
+	}
+}
+
+func TestCalculateDayUnresolved(t *testing.T) {
+	// Foods the server doesn't know are resolved without a lookup, so
+	// they exercise the per-meal accounting on their own
+	breakfast := []Volume{{ObjectName: "dragonfruit", VolumeCups: 1}, {ObjectName: "quinoa", VolumeCups: 0.5}}
+	dinner := []Volume{{ObjectName: "durian", VolumeCups: 1}}
+	tests := []struct {
+		name           string
+		request        DayRequest
+		wantUnresolved []int
+	}{
+		{"single meal", day(dinner), []int{1}},
+		{"several meals", day(breakfast, dinner), []int{2, 1}},
+		{"empty meal", day(nil, dinner), []int{0, 1}},
+		{"no meals", day(), []int{}},
+	}
+	router := gin.New()
+	router.POST("/v1/day", calculateDay)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig(t, noFuzzy)
+			w := doRequest(t, router, http.MethodPost, "/v1/day", tt.request)
+			summary := decode[DayResponse](t, w, http.StatusOK).Data
+			if summary.Date != tt.request.Data.Date {
+				t.Errorf("date = %q, want %q", summary.Date, tt.request.Data.Date)
+			}
+			if len(summary.Meals) != len(tt.wantUnresolved) {
+				t.Fatalf("meals = %d, want %d", len(summary.Meals), len(tt.wantUnresolved))
+			}
+			total := 0
+			for i, meal := range summary.Meals {
+				if meal.Unresolved != tt.wantUnresolved[i] || len(meal.Items) != len(tt.request.Data.Meals[i].Volumes) {
+					t.Errorf("meal %d: unresolved = %d of %d items, want %d", i, meal.Unresolved, len(meal.Items), tt.wantUnresolved[i])
+				}
+				if meal.Totals != (Macros{}) {
+					t.Errorf("meal %d: totals = %+v, want none", i, meal.Totals)
+				}
+				total += meal.Unresolved
+			}
+			if summary.Unresolved != total || summary.Totals != (Macros{}) {
+				t.Errorf("day = %+v with %d unresolved, want no totals and %d unresolved", summary.Totals, summary.Unresolved, total)
+			}
+		})
+	}
+}
+
+func TestCalculateDayValidatesEveryMeal(t *testing.T) {
+	testConfig(t, "")
+	router := gin.New()
+	router.POST("/v1/day", calculateDay)
+	request := day([]Volume{{ObjectName: "rice", VolumeCups: 1}}, []Volume{{ObjectName: "egg", VolumeCups: 1, EggSize: "jumbo"}})
+	w := doRequest(t, router, http.MethodPost, "/v1/day", request)
+	body := decode[struct {
+		Fields []FieldError `json:"fields"`
+	}](t, w, http.StatusBadRequest)
+	if len(body.Fields) != 1 || body.Fields[0].Field != "data.meals[1].volumes[0].egg_size" || body.Fields[0].Code != fieldCodeInvalidValue {
+		t.Errorf("fields = %+v, want data.meals[1].volumes[0].egg_size", body.Fields)
+	}
+}
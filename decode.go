@@ -0,0 +1,128 @@
+// decode.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Number handling selectable with server.numbers
+const (
+	numbersStrict  = "strict"  // reject numbers float64 can't hold
+	numbersLenient = "lenient" // round them to the nearest float64
+)
+
+// limitBody caps request bodies at server.max_body_bytes, answering 413
+// up front when the declared length is already over it. Bodies that turn
+// out longer fail to read in bindJSON.
+func limitBody(c *gin.Context) {
+	limit := cfg.Server.MaxBodyBytes
+	if c.Request.ContentLength > limit {
+		respondTooLarge(c, limit)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	c.Next()
+}
+
+func respondTooLarge(c *gin.Context, limit int64) {
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request body exceeds the limit of %d bytes", limit)})
+}
+
+// bindJSON decodes the request body into v. Numbers are read as their
+// literal text first so that, in strict mode, values that would silently
+// lose digits converting to float64 (or overflow it) are rejected instead
+// of computed with. The decoded value is then checked against its binding
+// tags; decoding and validation failures are returned as a
+// *ValidationError.
+func bindJSON(c *gin.Context, v any) error {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Server.Numbers == numbersStrict {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var raw any
+		if err := decoder.Decode(&raw); err != nil {
+			return decodeError(err)
+		}
+		if err := checkNumbers(raw); err != nil {
+			return decodeError(err)
+		}
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return decodeError(err)
+	}
+	return validateRequest(v)
+}
+
+// checkNumbers walks a decoded document and validates every number
+func checkNumbers(value any) error {
+	switch value := value.(type) {
+	case map[string]any:
+		for _, v := range value {
+			if err := checkNumbers(v); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, v := range value {
+			if err := checkNumbers(v); err != nil {
+				return err
+			}
+		}
+	case json.Number:
+		return checkPrecision(value)
+	}
+	return nil
+}
+
+// errNumberPrecision marks a number float64 can't represent faithfully
+var errNumberPrecision = errors.New("number exceeds float64 precision")
+
+// The decimal exponents of the largest float64 and the smallest nonzero one
+const (
+	maxExponent = 308
+	minExponent = -324
+)
+
+// checkPrecision accepts a number when its float64 value, written back out
+// in shortest form, is the same decimal the client sent
+func checkPrecision(number json.Number) error {
+	f, err := strconv.ParseFloat(number.String(), 64)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errNumberPrecision, number)
+	}
+
+	// big.Rat computes the power of ten of any exponent, however large, so
+	// exponents no float64 could need for these digits are rejected first
+	// (0e-999999999 and underflows parse to 0 without an error)
+	if mantissa, exponent, ok := strings.Cut(strings.ToLower(number.String()), "e"); ok {
+		exp, err := strconv.Atoi(exponent)
+		if err != nil || exp > maxExponent+len(mantissa) || exp < minExponent-len(mantissa) {
+			return fmt.Errorf("%w: %s", errNumberPrecision, number)
+		}
+	}
+
+	sent, ok := new(big.Rat).SetString(number.String())
+	if !ok {
+		return fmt.Errorf("invalid number: %s", number)
+	}
+	parsed, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	if sent.Cmp(parsed) != 0 {
+		return fmt.Errorf("%w: %s", errNumberPrecision, number)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckPrecision(t *testing.T) {
+	tests := []struct {
+		number  string
+		wantErr bool
+	}{
+		{"1", false},
+		{"0.1", false},
+		{"1.5e2", false},
+		{"0.3333333333333333", false},
+		{"9007199254740993", true},
+		{"0.12345678901234567890", true},
+		{"1e400", true},
+		{"0.001e311", false},
+		{"1e-400", true},
+		{"0e-999999999", true},
+		{"1e-99999999999999999999", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			err := checkPrecision(json.Number(tt.number))
+			if (err != nil) != tt.wantErr || err != nil && !errors.Is(err, errNumberPrecision) {
+				t.Errorf("checkPrecision(%s) = %v, want error %v", tt.number, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHighPrecisionVolumes(t *testing.T) {
+	// One cup of cooked rice is 158 g
+	tests := []struct {
+		name       string
+		config     string
+		volume     string
+		wantStatus int
+		wantVolume float64
+	}{
+		{"precise volume is kept", "", "0.3333333333333333", http.StatusOK, 0.3333333333333333},
+		{"many significant digits", "", "1.234567890123456", http.StatusOK, 1.234567890123456},
+		{"too precise in strict mode", "", "0.12345678901234567890", http.StatusBadRequest, 0},
+		{"too precise in lenient mode", "server:\n  numbers: lenient\n", "0.12345678901234567890", http.StatusOK, 0.12345678901234568},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.config)
+			cacheTestFoods(t, config.DefaultDataset)
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+
+			body := `{"data": {"volumes": [{"object_name": "rice", "volume_cups": ` + tt.volume + `}]}}`
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if item.RequestedVolume != tt.wantVolume {
+				t.Errorf("requested_volume = %v, want %v", item.RequestedVolume, tt.wantVolume)
+			}
+			if want := 158 * tt.wantVolume; math.Abs(item.CalculatedWeight-want) > 1e-12 {
+				t.Errorf("calculated_weight = %v, want %v", item.CalculatedWeight, want)
+			}
+		})
+	}
+}
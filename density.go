@@ -0,0 +1,57 @@
+// density.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// DensityConfig converts volumes of foods without a cup portion to weight
+// by their food category. Many FNDDS foods only have portions such as
+// "1 slice" or "1 piece", which say nothing about a cup.
+type DensityConfig struct {
+	// Categories maps food categories, matched case-insensitively against
+	// the WWEIA or FDC category, to a density in g/mL
+	Categories map[string]float64 `yaml:"categories"`
+	// Default is the density in g/mL for foods whose category isn't listed;
+	// zero leaves them unresolved
+	Default float64 `yaml:"default"`
+}
+
+func (d *DensityConfig) validate() error {
+	categories := make(map[string]float64, len(d.Categories))
+	for category, density := range d.Categories {
+		if density <= 0 {
+			return fmt.Errorf("densities.categories[%q] must be positive", category)
+		}
+		categories[strings.ToLower(strings.TrimSpace(category))] = density
+	}
+	d.Categories = categories
+	if d.Default < 0 {
+		return fmt.Errorf("densities.default must not be negative")
+	}
+	return nil
+}
+
+// mlPerCup is the volume of a US cup
+const mlPerCup = 236.5882365
+
+// categoryDensity derives the weight of a cup of the food from the density
+// table: cups are converted to mL, which the category's density turns into
+// grams. The match has zero grams when the table has no density for the
+// food.
+func categoryDensity(ctx context.Context, foodData *FoodData) portionMatch {
+	category := foodData.category()
+	quality := matchCategoryDensity
+	density, ok := cfg.Densities.Categories[strings.ToLower(strings.TrimSpace(category))]
+	if !ok {
+		density, quality = cfg.Densities.Default, matchDefaultDensity
+	}
+	if density == 0 {
+		return portionMatch{}
+	}
+	slog.DebugContext(ctx, "using density table", "fdc_id", foodData.FdcID, "category", category, "grams_per_ml", density, "quality", quality)
+	return portionMatch{grams: density * mlPerCup, quality: quality, density: density}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+)
+
+// tofu has nutrients but no portions, so only the density table can
+// convert its volume
+const tofu = `{"fdcId": 50, "description": "Tofu, raw",
+	"wweiaFoodCategory": {"wweiaFoodCategoryDescription": "Soy products"},
+	"foodNutrients": [{"amount": 76, "nutrient": {"number": "208", "name": "Energy", "unitName": "KCAL"}}]}`
+
+func TestCategoryDensity(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		food        string
+		wantQuality string
+		wantDensity float64
+	}{
+		{"category in the table", "densities:\n  categories:\n    ' SOY products': 0.5\n  default: 2\n", "tofu", matchCategoryDensity, 0.5},
+		{"default for other categories", "densities:\n  categories:\n    Milk: 1.03\n  default: 2\n", "tofu", matchDefaultDensity, 2},
+		{"unresolved without a density", "densities:\n  categories:\n    Milk: 1.03\n", "tofu", "", 0},
+		{"cup portions take precedence", "densities:\n  default: 2\n", "rice", matchExactCup, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, tt.config)
+			if err := foodRepo.StoreFoods(context.Background(), cfg.DefaultDataset, []json.RawMessage{json.RawMessage(tofu)}, 0); err != nil {
+				t.Fatal(err)
+			}
+			useMappings(t, &fileMappings{path: writeConfig(t, "tofu: Tofu, raw\nrice: Rice, cooked, NFS\n")})
+
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: tt.food, VolumeCups: 2}))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if item.Found != (tt.wantQuality != "") || item.MatchQuality != tt.wantQuality || item.DensityGramsPerML != tt.wantDensity {
+				t.Fatalf("found = %v by %q at %v g/mL, want %q at %v", item.Found, item.MatchQuality, item.DensityGramsPerML, tt.wantQuality, tt.wantDensity)
+			}
+			if tt.wantDensity > 0 && math.Abs(item.CalculatedWeight-2*mlPerCup*tt.wantDensity) > 1e-9 {
+				t.Errorf("calculated_weight = %v, want 2 cups in mL times the density", item.CalculatedWeight)
+			}
+
+			// v2 reports the density with the rest of the match
+			w = doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: tt.food, VolumeCups: 2}), "Accept", mediaTypeV2)
+			if match := decode[MacroResponseV2](t, w, http.StatusOK).Items[0].Match; match != nil && match.DensityGramsPerML != tt.wantDensity {
+				t.Errorf("v2 density_g_per_ml = %v, want %v", match.DensityGramsPerML, tt.wantDensity)
+			}
+		})
+	}
+}
+
+func TestInvalidDensities(t *testing.T) {
+	tests := []struct {
+		name      string
+		densities DensityConfig
+	}{
+		{"zero category density", DensityConfig{Categories: map[string]float64{"Milk": 0}}},
+		{"negative category density", DensityConfig{Categories: map[string]float64{"Milk": -1}}},
+		{"negative default", DensityConfig{Default: -0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.densities.validate(); err == nil {
+				t.Error("validate() succeeded, want an error")
+			}
+		})
+	}
+}
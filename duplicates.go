@@ -0,0 +1,81 @@
+// duplicates.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// How a lookup resolves several foods sharing the exact description,
+// selected with duplicates.mode
+const (
+	duplicatesLowest = "lowest" // the lowest fdcId wins
+	duplicatesError  = "error"  // the lookup fails as ambiguous
+	duplicatesAll    = "all"    // lowest fdcId wins, ?candidates=true lists all
+)
+
+const errorCodeAmbiguousFood = "AMBIGUOUS_FOOD"
+
+// errAmbiguousFood is returned in error mode when a description matches
+// more than one food
+var errAmbiguousFood = errors.New("ambiguous food description")
+
+// FoodCandidate is one of the foods sharing the matched description
+type FoodCandidate struct {
+	FdcID            int     `json:"fdc_id"`
+	Description      string  `json:"description"`
+	Found            bool    `json:"found"`
+	Macros           Macros  `json:"macros"`
+	CalculatedWeight float64 `json:"calculated_weight"`
+}
+
+// candidateLimit is how many rows a lookup needs to apply the duplicates
+// mode: error mode has to see a second match to notice the ambiguity
+func candidateLimit() int {
+	switch cfg.Duplicates.Mode {
+	case duplicatesError:
+		return 2
+	case duplicatesAll:
+		return cfg.Limits.MaxCandidates
+	}
+	return 1
+}
+
+// getFoodData looks up the food for an object name and picks one of the
+// matches according to the duplicates mode
+func getFoodData(ctx context.Context, dataset, objectName, version string, stats *requestStats) (*FoodData, error) {
+	lookup, err := lookupFoods(ctx, dataset, objectName, version, stats)
+	if err != nil {
+		return nil, err
+	}
+	return pickFood(lookup.foods)
+}
+
+// pickFood selects from foods ordered by fdcId
+func pickFood(foods []FoodData) (*FoodData, error) {
+	if cfg.Duplicates.Mode == duplicatesError && len(foods) > 1 {
+		return nil, fmt.Errorf("%w: %q matches fdcIds %d and %d", errAmbiguousFood, foods[0].Description, foods[0].FdcID, foods[1].FdcID)
+	}
+	return &foods[0], nil
+}
+
+// foodCandidates computes the volume against every matched food, up to
+// limits.max_candidates
+func foodCandidates(ctx context.Context, volume Volume, foods []FoodData) []FoodCandidate {
+	if len(foods) > cfg.Limits.MaxCandidates {
+		foods = foods[:cfg.Limits.MaxCandidates]
+	}
+	candidates := make([]FoodCandidate, 0, len(foods))
+	for i := range foods {
+		result, ok := computeMacros(ctx, volume, &foods[i])
+		candidates = append(candidates, FoodCandidate{
+			FdcID:            foods[i].FdcID,
+			Description:      foods[i].Description,
+			Found:            ok,
+			Macros:           result.macros,
+			CalculatedWeight: result.grams,
+		})
+	}
+	return candidates
+}
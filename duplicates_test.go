@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDuplicateDescriptions(t *testing.T) {
+	// Food 105 is "Banana, raw" again with twice the nutrients; one cup,
+	// sliced, of food 5 has 34.2 g of carbs
+	const carbs = 34.2
+	tests := []struct {
+		name           string
+		config         string
+		query          string
+		wantFound      bool
+		wantErrorCode  string
+		wantCandidates []int
+	}{
+		{"lowest fdcId by default", "", "?candidates=true", true, "", nil},
+		{"lowest fdcId", "duplicates:\n  mode: lowest\n", "", true, "", nil},
+		{"ambiguous is an error", "duplicates:\n  mode: error\n", "", false, errorCodeAmbiguousFood, nil},
+		{"all lists the candidates", "duplicates:\n  mode: all\n", "?candidates=true", true, "", []int{5, 105}},
+		{"all without ?candidates", "duplicates:\n  mode: all\n", "", true, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.config)
+			banana := FoodData{
+				FdcID:         5,
+				Description:   "Banana, raw",
+				FoodNutrients: nutrients("205", 22.8, "203", 1.1, "204", 0.3, "208", 89.0),
+				FoodPortions:  portions("1 cup, sliced", 150),
+			}
+			doubled := banana
+			doubled.FdcID = 105
+			doubled.FoodNutrients = nutrients("205", 45.6, "203", 2.2, "204", 0.6, "208", 178.0)
+			cacheFood(t, config.DefaultDataset, "Banana, raw", banana, doubled)
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros"+tt.query, volumes(Volume{ObjectName: "banana", VolumeCups: 1}))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if item.Found != tt.wantFound || item.ErrorCode != tt.wantErrorCode {
+				t.Fatalf("found = %v, error_code = %q; want %v, %q", item.Found, item.ErrorCode, tt.wantFound, tt.wantErrorCode)
+			}
+			if tt.wantFound && math.Abs(item.Macros.Carbs-carbs) > 1e-9 {
+				t.Errorf("carbs = %v, want %v from the lowest fdcId", item.Macros.Carbs, carbs)
+			}
+
+			var ids []int
+			for _, candidate := range item.Candidates {
+				ids = append(ids, candidate.FdcID)
+				if candidate.Description != "Banana, raw" || !candidate.Found {
+					t.Errorf("candidate = %+v", candidate)
+				}
+			}
+			if !slices.Equal(ids, tt.wantCandidates) {
+				t.Errorf("candidates = %v, want %v", ids, tt.wantCandidates)
+			}
+			if len(item.Candidates) == 2 && math.Abs(item.Candidates[1].Macros.Carbs-2*carbs) > 1e-9 {
+				t.Errorf("second candidate carbs = %v, want %v", item.Candidates[1].Macros.Carbs, 2*carbs)
+			}
+		})
+	}
+}
+
+func TestListLimits(t *testing.T) {
+	// Five foods share "Banana, raw"
+	tests := []struct {
+		name           string
+		config         string
+		wantCandidates int
+	}{
+		{"default candidate cap above the matches", "duplicates:\n  mode: all\n", 5},
+		{"candidates capped", "duplicates:\n  mode: all\nlimits:\n  max_candidates: 2\n", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.config)
+			var bananas []FoodData
+			for _, id := range []int{5, 105, 106, 107, 108} {
+				bananas = append(bananas, FoodData{FdcID: id, Description: "Banana, raw", FoodPortions: portions("1 cup, sliced", 150)})
+			}
+			cacheFood(t, config.DefaultDataset, "Banana, raw", bananas...)
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros?candidates=true", volumes(Volume{ObjectName: "banana", VolumeCups: 1}))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if len(item.Candidates) != tt.wantCandidates {
+				t.Errorf("candidates = %d, want %d", len(item.Candidates), tt.wantCandidates)
+			}
+		})
+	}
+}
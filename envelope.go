@@ -0,0 +1,184 @@
+// envelope.go
+package main
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Media types selecting the response schema. Plain application/json (or no
+// Accept header) gets v1, so existing clients are unaffected.
+const (
+	mediaTypeV1 = "application/vnd.bytemi.v1+json"
+	mediaTypeV2 = "application/vnd.bytemi.v2+json"
+)
+
+// responseVersion picks the schema version from the Accept header
+func responseVersion(c *gin.Context) int {
+	for _, accepted := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && mediaType == mediaTypeV2 {
+			return 2
+		}
+	}
+	return 1
+}
+
+// MacroResponseV2 groups each item's fields by concern and adds totals
+// across the resolved items
+type MacroResponseV2 struct {
+	CalcVersion string          `json:"calc_version"`
+	Status      string          `json:"status"`
+	FrameID     string          `json:"frame_id,omitempty"`
+	EnergyUnit  string          `json:"energy_unit"`
+	Scale       float64         `json:"scale,omitempty"`
+	Totals      Macros          `json:"totals"`
+	Unresolved  int             `json:"unresolved"`
+	Items       []MacroItemV2   `json:"items"`
+	ResultHash  string          `json:"result_hash,omitempty"`
+	Meta        *ProcessingMeta `json:"meta,omitempty"`
+}
+
+type MacroItemV2 struct {
+	// Request echoes the volume as sent: amount is in unit, which is cups
+	// unless the volume named another
+	Request struct {
+		ObjectName string  `json:"object_name"`
+		Amount     float64 `json:"amount"`
+		Unit       string  `json:"unit"`
+		State      string  `json:"state,omitempty"`
+	} `json:"request"`
+	ResolvedName string   `json:"resolved_name,omitempty"`
+	Found        bool     `json:"found"`
+	ErrorCode    string   `json:"error_code,omitempty"`
+	ErrorMessage string   `json:"error_message,omitempty"`
+	Suggestions  []string `json:"suggestions,omitempty"`
+
+	Food *FoodV2 `json:"food,omitempty"`
+
+	Macros         Macros                    `json:"macros"`
+	PerGram        *Macros                   `json:"per_gram,omitempty"`
+	Per100g        *Macros                   `json:"per_100g,omitempty"`
+	PerServing     *ServingMacros            `json:"per_serving,omitempty"`
+	WeightGrams    float64                   `json:"weight_grams"`
+	DRIStatus      map[string]string         `json:"dri_status,omitempty"`
+	NutrientStatus map[string]string         `json:"nutrient_status,omitempty"`
+	Nutrients      map[string]NutrientAmount `json:"nutrients,omitempty"`
+
+	Match       *MatchV2      `json:"match,omitempty"`
+	Uncertainty UncertaintyV2 `json:"uncertainty"`
+
+	Consensus  *ConsensusMacros `json:"consensus,omitempty"`
+	Variants   []DatasetVariant `json:"variants,omitempty"`
+	Candidates []FoodCandidate  `json:"candidates,omitempty"`
+	Portions   []Portion        `json:"portions,omitempty"`
+	Queries    []ExecutedQuery  `json:"queries,omitempty"`
+}
+
+type FoodV2 struct {
+	Description string `json:"description"`
+	Category    string `json:"category,omitempty"`
+	Dataset     string `json:"dataset"`
+	DataVersion string `json:"data_version,omitempty"`
+}
+
+// MatchV2 explains where the item's grams per cup came from
+type MatchV2 struct {
+	Quality    string  `json:"quality"`
+	Confidence float64 `json:"confidence"`
+	Portion    string  `json:"portion,omitempty"`
+	// DensityGramsPerML is the density the volume was converted with when
+	// quality is category-density or default-density
+	DensityGramsPerML float64 `json:"density_g_per_ml,omitempty"`
+	DensityOverride   bool    `json:"density_override"`
+	CaloriesComputed  bool    `json:"calories_computed"`
+	YieldFactor       float64 `json:"yield_factor,omitempty"`
+}
+
+type UncertaintyV2 struct {
+	PercentError float64     `json:"percent_error"`
+	Clamped      bool        `json:"clamped"`
+	Range        *MacroRange `json:"range,omitempty"`
+}
+
+// toV2 converts a v1 response, moving its frame summary to the top level
+func (r MacroResponse) toV2() MacroResponseV2 {
+	v2 := MacroResponseV2{
+		CalcVersion: r.CalcVersion,
+		Status:      r.Status,
+		FrameID:     r.Summary.FrameID,
+		EnergyUnit:  r.EnergyUnit,
+		Scale:       r.Scale,
+		Totals:      r.Summary.Totals,
+		Unresolved:  r.Summary.Unresolved,
+		Items:       make([]MacroItemV2, 0, len(r.Data)),
+		ResultHash:  r.ResultHash,
+		Meta:        r.Meta,
+	}
+
+	for _, md := range r.Data {
+		item := MacroItemV2{
+			Found:          md.Found,
+			ErrorCode:      md.ErrorCode,
+			ErrorMessage:   md.ErrorMessage,
+			ResolvedName:   md.ResolvedName,
+			Suggestions:    md.Suggestions,
+			Macros:         md.Macros,
+			PerGram:        md.PerGram,
+			Per100g:        md.Per100g,
+			PerServing:     md.PerServing,
+			WeightGrams:    md.CalculatedWeight,
+			DRIStatus:      md.DRIStatus,
+			NutrientStatus: md.NutrientStatus,
+			Nutrients:      md.Nutrients,
+			Uncertainty:    UncertaintyV2{PercentError: md.PercentError, Clamped: md.UncertaintyClamped, Range: md.Range},
+			Consensus:      md.Consensus,
+			Variants:       md.Variants,
+			Candidates:     md.Candidates,
+			Portions:       md.Portions,
+			Queries:        md.Queries,
+		}
+		item.Request.ObjectName = md.RequestedFood
+		item.Request.Amount = md.RequestedVolume
+		item.Request.Unit = md.RequestedUnit
+		if item.Request.Unit == "" {
+			item.Request.Unit = unitCups
+		}
+		item.Request.State = md.State
+
+		if md.Found {
+			item.Food = &FoodV2{
+				Description: md.Description,
+				Category:    md.Category,
+				Dataset:     md.Dataset,
+				DataVersion: md.DataVersion,
+			}
+			item.Match = &MatchV2{
+				Quality:           md.MatchQuality,
+				Confidence:        md.Confidence,
+				Portion:           md.PortionUsed,
+				DensityGramsPerML: md.DensityGramsPerML,
+				DensityOverride:   md.DensityOverride,
+				CaloriesComputed:  md.CaloriesComputed,
+				YieldFactor:       md.YieldFactor,
+			}
+		}
+		v2.Items = append(v2.Items, item)
+	}
+	return v2
+}
+
+// respondVersioned writes the v1 response, or its v2 form when the client
+// asked for it
+func respondVersioned(c *gin.Context, status int, response MacroResponse) {
+	c.Writer.Header().Add("Vary", "Accept")
+	if responseVersion(c) != 2 {
+		c.JSON(status, response)
+		return
+	}
+	// c.JSON keeps a Content-Type that is already set
+	c.Header("Content-Type", mediaTypeV2+"; charset=utf-8")
+	c.JSON(status, response.toV2())
+}
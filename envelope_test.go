@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResponseVersionNegotiation(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		wantVersion int
+	}{
+		{"no Accept header", "", 1},
+		{"plain JSON", "application/json", 1},
+		{"anything", "*/*", 1},
+		{"v1 media type", mediaTypeV1, 1},
+		{"v2 media type", mediaTypeV2, 2},
+		{"v2 among others", "text/html, " + mediaTypeV2 + "; q=0.9", 2},
+	}
+	config := testConfig(t, noFuzzy)
+	cacheTestFoods(t, config.DefaultDataset)
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+	request := volumes(Volume{ObjectName: "rice", VolumeCups: 1}, Volume{ObjectName: "banana", VolumeCups: 0.5}, Volume{ObjectName: "dragonfruit", VolumeCups: 1})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.accept != "" {
+				headers = []string{"Accept", tt.accept}
+			}
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", request, headers...)
+			if vary := w.Header().Get("Vary"); !strings.Contains(vary, "Accept") {
+				t.Errorf("Vary = %q, want Accept", vary)
+			}
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantVersion == 1 {
+				response := decode[MacroResponse](t, w, http.StatusOK)
+				if len(response.Data) != 3 || fields["items"] != nil {
+					t.Errorf("response = %s, want the v1 schema", w.Body)
+				}
+				return
+			}
+
+			if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, mediaTypeV2) {
+				t.Errorf("Content-Type = %q, want %s", contentType, mediaTypeV2)
+			}
+			response := decode[MacroResponseV2](t, w, http.StatusOK)
+			if len(response.Items) != 3 || fields["data"] != nil {
+				t.Fatalf("response = %s, want the v2 schema", w.Body)
+			}
+			var totals Macros
+			for _, item := range response.Items {
+				if item.Found {
+					totals = totals.add(item.Macros)
+				}
+			}
+			if !macrosNear(response.Totals, totals) || response.Unresolved != 1 {
+				t.Errorf("totals = %+v, unresolved = %d; want %+v, 1", response.Totals, response.Unresolved, totals)
+			}
+			if rice := response.Items[0]; rice.Request.ObjectName != "rice" || rice.Food == nil || rice.Match == nil || rice.WeightGrams != 158 {
+				t.Errorf("rice item = %+v, want its request, food and match grouped", rice)
+			}
+		})
+	}
+}
+
+func TestV2RequestUnit(t *testing.T) {
+	config := testConfig(t, noFuzzy)
+	cacheTestFoods(t, config.DefaultDataset)
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+
+	request := volumes(Volume{ObjectName: "rice", VolumeCups: 250, Unit: unitML}, Volume{ObjectName: "banana", VolumeCups: 0.5})
+	response := decode[MacroResponseV2](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", request, "Accept", mediaTypeV2), http.StatusOK)
+	// The amount is echoed in the unit it was sent in, cups by default
+	for i, want := range []struct {
+		amount float64
+		unit   string
+	}{{250, unitML}, {0.5, unitCups}} {
+		if got := response.Items[i].Request; got.Amount != want.amount || got.Unit != want.unit {
+			t.Errorf("items[%d].request = %+v, want %v %s", i, got, want.amount, want.unit)
+		}
+	}
+}
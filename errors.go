@@ -0,0 +1,43 @@
+// errors.go
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	modeProduction  = "production"
+	modeDevelopment = "development"
+)
+
+// devMode reports whether internal error detail may be returned to clients
+func devMode() bool {
+	return cfg.Server.Mode == modeDevelopment
+}
+
+// respondError aborts the request with an error response carrying a
+// correlation id. The full error is always logged under that id, but it is
+// only returned to the client in development mode; in production the client
+// gets the generic message instead.
+func respondError(c *gin.Context, status int, message string, err error) {
+	id := newCorrelationID()
+	slog.ErrorContext(c.Request.Context(), "request error", "correlation_id", id, "method", c.Request.Method, "path", c.Request.URL.Path, "message", message, "error", err)
+
+	detail := message
+	if devMode() && err != nil {
+		detail = err.Error()
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": detail, "correlation_id": id})
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
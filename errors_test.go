@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRespondError(t *testing.T) {
+	internal := errors.New("dial tcp 10.0.0.7:8093: connection refused")
+	tests := []struct {
+		name       string
+		mode       string
+		err        error
+		wantDetail string
+	}{
+		{"production hides the detail", modeProduction, internal, "failed to query foods"},
+		{"development returns the detail", modeDevelopment, internal, internal.Error()},
+		{"development without an error", modeDevelopment, nil, "failed to query foods"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig(t, "server:\n  mode: "+tt.mode+"\n")
+			logs := captureLogs(t)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/v1/foods/1", nil)
+
+			respondError(c, http.StatusBadGateway, "failed to query foods", tt.err)
+
+			body := decode[map[string]string](t, w, http.StatusBadGateway)
+			if body["error"] != tt.wantDetail {
+				t.Errorf("error = %q, want %q", body["error"], tt.wantDetail)
+			}
+			id := body["correlation_id"]
+			if id == "" {
+				t.Fatal("correlation_id missing")
+			}
+			// The server side always has the full error, under the same id
+			entries := logEntries(t, logs)
+			if len(entries) != 1 || entries[0]["correlation_id"] != id {
+				t.Fatalf("logs = %v, want one entry with correlation_id %s", entries, id)
+			}
+			if tt.err != nil && !strings.Contains(logs.String(), tt.err.Error()) {
+				t.Errorf("logs don't contain the error: %s", logs)
+			}
+		})
+	}
+}
+
+func TestConfigValidateServerMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    string
+		wantErr bool
+	}{
+		{"", modeProduction, false},
+		{modeProduction, modeProduction, false},
+		{modeDevelopment, modeDevelopment, false},
+		{"debug", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			var config Config
+			config.Server.Mode = tt.mode
+			err := config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && config.Server.Mode != tt.want {
+				t.Errorf("server.mode = %q, want %q", config.Server.Mode, tt.want)
+			}
+		})
+	}
+}
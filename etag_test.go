@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestETags(t *testing.T) {
+	router := setupServer(t, "")
+	for _, path := range []string{"/v1/foods/1", "/v1/foods/search?q=raw&limit=2"} {
+		t.Run(path, func(t *testing.T) {
+			first := doRequest(t, router, http.MethodGet, path, nil)
+			etag := first.Header().Get("ETag")
+			if first.Code != http.StatusOK || etag == "" {
+				t.Fatalf("status = %d, ETag = %q; want 200 with an ETag", first.Code, etag)
+			}
+			if again := doRequest(t, router, http.MethodGet, path, nil); again.Header().Get("ETag") != etag {
+				t.Errorf("ETag = %q on a second request, want %q", again.Header().Get("ETag"), etag)
+			}
+
+			tests := []struct {
+				name        string
+				ifNoneMatch string
+				wantStatus  int
+			}{
+				{"current tag", etag, http.StatusNotModified},
+				{"strong form of the tag", etag[len("W/"):], http.StatusNotModified},
+				{"one of several tags", `W/"stale", ` + etag, http.StatusNotModified},
+				{"any tag", "*", http.StatusNotModified},
+				{"stale tag", `W/"stale"`, http.StatusOK},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					w := doRequest(t, router, http.MethodGet, path, nil, "If-None-Match", tt.ifNoneMatch)
+					if w.Code != tt.wantStatus {
+						t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+					}
+					if tt.wantStatus == http.StatusNotModified && w.Body.Len() != 0 {
+						t.Errorf("304 with a body: %s", w.Body)
+					}
+				})
+			}
+		})
+	}
+
+	// Another page of results is tagged differently
+	first := doRequest(t, router, http.MethodGet, "/v1/foods/search?q=raw&limit=2", nil).Header().Get("ETag")
+	if other := doRequest(t, router, http.MethodGet, "/v1/foods/search?q=raw&limit=3", nil).Header().Get("ETag"); other == first {
+		t.Errorf("ETag %q for both pages, want them to differ", other)
+	}
+}
@@ -0,0 +1,160 @@
+// fdcapi.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FDCAPIConfig enables falling back to the USDA FoodData Central API for
+// search terms that aren't in the local data. Foods found there are stored
+// in the looked-up dataset, so the database fills itself over time. It is
+// disabled while no API key is configured.
+type FDCAPIConfig struct {
+	APIKey string `yaml:"api_key"`
+	// URL is the API base; defaults to https://api.nal.usda.gov/fdc/v1
+	URL string `yaml:"url"`
+	// DataTypes restricts the search, e.g. "Survey (FNDDS)" (default)
+	DataTypes []string      `yaml:"data_types"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+func (f *FDCAPIConfig) enabled() bool {
+	return f.APIKey != ""
+}
+
+func (f *FDCAPIConfig) validate() error {
+	if !f.enabled() {
+		return nil
+	}
+	if f.URL == "" {
+		f.URL = "https://api.nal.usda.gov/fdc/v1"
+	}
+	if u, err := url.Parse(f.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid fdc_api.url %q: expected an https URL", f.URL)
+	}
+	f.URL = strings.TrimSuffix(f.URL, "/")
+	if len(f.DataTypes) == 0 {
+		f.DataTypes = []string{"Survey (FNDDS)"}
+	}
+	if f.Timeout == 0 {
+		f.Timeout = 10 * time.Second
+	}
+	if f.Timeout < 0 {
+		return errors.New("fdc_api.timeout must be positive")
+	}
+	return nil
+}
+
+var fdcClient = &http.Client{}
+
+// fetchFromFDC looks up foods whose description equals a search term in the
+// FDC API, stores them in the dataset and returns them. Only exact
+// description matches are taken, the same as for local lookups. Failures
+// are logged and reported as no match, so the API being down never fails
+// a lookup that would have failed anyway.
+func fetchFromFDC(ctx context.Context, dataset, term string) []FoodData {
+	ctx, cancel := context.WithTimeout(ctx, cfg.FDCAPI.Timeout)
+	defer cancel()
+
+	ids, err := searchFDC(ctx, term)
+	if err != nil {
+		slog.WarnContext(ctx, "FDC API search failed", "term", term, "error", err)
+		return nil
+	}
+
+	var foods []FoodData
+	for _, id := range ids {
+		document, err := fdcRequest(ctx, http.MethodGet, "/food/"+strconv.Itoa(id), nil)
+		if err != nil {
+			slog.WarnContext(ctx, "FDC API food request failed", "fdc_id", id, "error", err)
+			continue
+		}
+		var food FoodData
+		if err := json.Unmarshal(document, &food); err != nil {
+			slog.WarnContext(ctx, "FDC API returned an undecodable food", "fdc_id", id, "error", err)
+			continue
+		}
+		if err := foodRepo.StoreFoods(ctx, dataset, []json.RawMessage{document}, 0); err != nil {
+			slog.WarnContext(ctx, "failed to store food from the FDC API", "fdc_id", id, "dataset", dataset, "error", err)
+		}
+		slog.InfoContext(ctx, "fetched food from the FDC API", "term", term, "fdc_id", id, "dataset", dataset)
+		foods = append(foods, food)
+	}
+	return foods
+}
+
+// searchFDC returns the IDs of the foods whose description equals the term,
+// lowest first
+func searchFDC(ctx context.Context, term string) ([]int, error) {
+	body, err := json.Marshal(map[string]any{
+		"query":           term,
+		"dataType":        cfg.FDCAPI.DataTypes,
+		"requireAllWords": true,
+		"pageSize":        candidateLimit() * 5,
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw, err := fdcRequest(ctx, http.MethodPost, "/foods/search", body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Foods []struct {
+			FdcID       int    `json:"fdcId"`
+			Description string `json:"description"`
+		} `json:"foods"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, food := range result.Foods {
+		if strings.EqualFold(food.Description, term) && len(ids) < candidateLimit() {
+			ids = append(ids, food.FdcID)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// fdcRequest calls an FDC API endpoint and returns the response body
+func fdcRequest(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, cfg.FDCAPI.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// The key goes in a header rather than the query string so it doesn't
+	// end up in logged URLs
+	req.Header.Set("X-Api-Key", cfg.FDCAPI.APIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := fdcClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return data, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// quinoa is a food the test snapshot doesn't have
+var quinoa = FoodData{
+	FdcID:         100,
+	Description:   "Quinoa, cooked",
+	FoodNutrients: nutrients("205", 21.3, "203", 4.4, "204", 1.9, "208", 120.0),
+	FoodPortions:  portions("1 cup", 185),
+}
+
+// fdcServer is an FDC API serving foods, whose search also returns a
+// near-miss description for each. It fails every request with status
+// when that is not zero, and counts the requests it gets.
+func fdcServer(t *testing.T, status int, foods ...FoodData) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("X-Api-Key") != "test-key" || r.URL.Query().Has("api_key") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		if r.URL.Path == "/foods/search" {
+			var hits []map[string]any
+			for _, food := range foods {
+				hits = append(hits,
+					map[string]any{"fdcId": food.FdcID, "description": food.Description},
+					map[string]any{"fdcId": food.FdcID + 1, "description": food.Description + ", with salt"})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"foods": hits})
+			return
+		}
+		for _, food := range foods {
+			if r.URL.Path == "/food/"+strconv.Itoa(food.FdcID) {
+				json.NewEncoder(w).Encode(food)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+
+	previous := fdcClient
+	fdcClient = server.Client()
+	t.Cleanup(func() { fdcClient = previous })
+	return server, &requests
+}
+
+func TestFDCAPIFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		apiKey       string
+		status       int
+		query        string
+		wantFound    bool
+		wantRequests int32
+	}{
+		{"found and stored", "test-key", 0, "", true, 2},
+		{"disabled without an API key", "", 0, "", false, 0},
+		{"API failure", "test-key", http.StatusServiceUnavailable, "", false, 1},
+		{"pinned data version", "test-key", 0, "?data_version=2023-10", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := fdcServer(t, tt.status, quinoa)
+			config := "fdc_api:\n  url: " + server.URL + "\n"
+			if tt.apiKey != "" {
+				config += "  api_key: " + tt.apiKey + "\n"
+			}
+			router := setupServer(t, config)
+			useMappings(t, &fileMappings{path: writeConfig(t, "quinoa: Quinoa, cooked\n")})
+
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros"+tt.query, volumes(Volume{ObjectName: "quinoa", VolumeCups: 1}))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if item.Found != tt.wantFound || (tt.wantFound && item.Description != quinoa.Description) {
+				t.Errorf("found = %v as %q, want %v", item.Found, item.Description, tt.wantFound)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("FDC API requests = %d, want %d", got, tt.wantRequests)
+			}
+			_, stored, err := foodRepo.GetByFDCID(context.Background(), cfg.DefaultDataset, quinoa.FdcID)
+			if err != nil || stored != tt.wantFound {
+				t.Errorf("stored = %v, %v; want %v", stored, err, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestInvalidFDCAPIConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config FDCAPIConfig
+	}{
+		{"plain http URL", FDCAPIConfig{APIKey: "key", URL: "http://api.nal.usda.gov/fdc/v1"}},
+		{"relative URL", FDCAPIConfig{APIKey: "key", URL: "/fdc/v1"}},
+		{"negative timeout", FDCAPIConfig{APIKey: "key", Timeout: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); err == nil {
+				t.Error("validate() succeeded, want an error")
+			}
+		})
+	}
+	config := FDCAPIConfig{APIKey: "key"}
+	if err := config.validate(); err != nil || !strings.HasPrefix(config.URL, "https://") || config.Timeout <= 0 {
+		t.Errorf("validate() = %v with %+v, want the defaults filled in", err, config)
+	}
+}
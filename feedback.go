@@ -0,0 +1,274 @@
+// feedback.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// FeedbackConfig controls learning densities from measured weights. It is
+// disabled unless a collection to store the feedback in is configured.
+type FeedbackConfig struct {
+	Scope      string `yaml:"scope"`
+	Collection string `yaml:"collection"`
+	// MinSamples is how many measurements a food needs before its learned
+	// density is used
+	MinSamples int `yaml:"min_samples"`
+	// Window is how many of the most recent measurements are aggregated
+	Window int `yaml:"window"`
+	// TrimFraction drops this share of the lowest and of the highest
+	// densities before averaging, to keep outliers out
+	TrimFraction float64 `yaml:"trim_fraction"`
+	// MaxAdjustment bounds the learned density to this fraction above or
+	// below the dataset's own density
+	MaxAdjustment float64 `yaml:"max_adjustment"`
+	// Refresh is how long an aggregated density is reused before it is
+	// read again from Couchbase
+	Refresh time.Duration `yaml:"refresh"`
+}
+
+func (f *FeedbackConfig) enabled() bool {
+	return f.Collection != ""
+}
+
+func (f *FeedbackConfig) validate() error {
+	if !f.enabled() {
+		return nil
+	}
+	if f.Scope == "" {
+		f.Scope = defaultKeyspaceName
+	}
+	for _, name := range []string{f.Scope, f.Collection} {
+		if name != defaultKeyspaceName && !keyspaceNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid keyspace name %q in feedback config", name)
+		}
+	}
+
+	if f.MinSamples == 0 {
+		f.MinSamples = 5
+	}
+	if f.Window == 0 {
+		f.Window = 100
+	}
+	if f.TrimFraction == 0 {
+		f.TrimFraction = 0.1
+	}
+	if f.MaxAdjustment == 0 {
+		f.MaxAdjustment = 0.25
+	}
+	if f.Refresh == 0 {
+		f.Refresh = 5 * time.Minute
+	}
+
+	switch {
+	case f.MinSamples < 0, f.Window < 0, f.Refresh < 0, f.MaxAdjustment < 0:
+		return errors.New("feedback min_samples, window, refresh and max_adjustment must be positive")
+	case f.TrimFraction < 0 || f.TrimFraction >= 0.5:
+		return errors.New("feedback.trim_fraction must be between 0 and 0.5")
+	}
+	return nil
+}
+
+// FeedbackRequest reports the weight actually measured for a volume
+type FeedbackRequest struct {
+	ObjectName    string  `json:"object_name" binding:"required"`
+	VolumeCups    float64 `json:"volume_cups" binding:"gt=0"`
+	MeasuredGrams float64 `json:"measured_grams" binding:"gt=0"`
+}
+
+type FeedbackResponse struct {
+	ObjectName     string  `json:"object_name"`
+	Samples        int     `json:"samples"`
+	LearnedDensity float64 `json:"learned_density_grams_per_cup,omitempty"`
+}
+
+// FeedbackSample is one measurement as stored in Couchbase
+type FeedbackSample struct {
+	VolumeCups    float64   `json:"volume_cups"`
+	MeasuredGrams float64   `json:"measured_grams"`
+	At            time.Time `json:"at"`
+}
+
+type feedbackDoc struct {
+	ObjectName string           `json:"object_name"`
+	Samples    []FeedbackSample `json:"samples"`
+}
+
+func feedbackKey(objectName string) string {
+	return "density_feedback::" + objectName
+}
+
+// feedbackStore keeps the measurements submitted for each food
+type feedbackStore interface {
+	add(ctx context.Context, name string, sample FeedbackSample) error
+	samples(ctx context.Context, name string) ([]FeedbackSample, error)
+}
+
+// couchbaseFeedback keeps one document per food, appending each sample
+type couchbaseFeedback struct {
+	collection *gocb.Collection
+}
+
+func (s *couchbaseFeedback) add(ctx context.Context, name string, sample FeedbackSample) error {
+	_, err := s.collection.MutateIn(feedbackKey(name), []gocb.MutateInSpec{
+		gocb.UpsertSpec("object_name", name, nil),
+		gocb.ArrayAppendSpec("samples", sample, &gocb.ArrayAppendSpecOptions{CreatePath: true}),
+	}, &gocb.MutateInOptions{
+		StoreSemantic: gocb.StoreSemanticsUpsert,
+		Context:       ctx,
+	})
+	return err
+}
+
+func (s *couchbaseFeedback) samples(ctx context.Context, name string) ([]FeedbackSample, error) {
+	result, err := s.collection.Get(feedbackKey(name), &gocb.GetOptions{Context: ctx})
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc feedbackDoc
+	if err := result.Content(&doc); err != nil {
+		return nil, fmt.Errorf("invalid feedback document: %w", err)
+	}
+	return doc.Samples, nil
+}
+
+// learnedDensity is a food's density aggregated from feedback
+type learnedDensity struct {
+	density  float64
+	samples  int
+	loadedAt time.Time
+}
+
+// learnedDensities caches aggregated feedback so lookups don't read the
+// feedback document every time
+var learnedDensities = struct {
+	sync.Mutex
+	entries map[string]learnedDensity
+}{entries: make(map[string]learnedDensity)}
+
+// submitFeedback records a measured weight for a food and volume
+func submitFeedback(c *gin.Context) {
+	if db.feedback == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "density feedback is not enabled"})
+		return
+	}
+
+	var request FeedbackRequest
+	if err := bindJSON(c, &request); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+
+	name := normalizeFoodName(request.ObjectName)
+	_, ok, err := foodMappings.searchTerm(c.Request.Context(), name)
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to load food mappings", err)
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown food: %s", request.ObjectName)})
+		return
+	}
+
+	sample := FeedbackSample{VolumeCups: request.VolumeCups, MeasuredGrams: request.MeasuredGrams, At: time.Now().UTC()}
+	if err := db.feedback.add(c.Request.Context(), name, sample); err != nil {
+		if timedOut(c) {
+			return
+		}
+		respondError(c, http.StatusBadGateway, "failed to store feedback", err)
+		return
+	}
+
+	learnedDensities.Lock()
+	delete(learnedDensities.entries, name)
+	learnedDensities.Unlock()
+
+	learned := loadLearnedDensity(c.Request.Context(), name)
+	c.JSON(http.StatusCreated, FeedbackResponse{
+		ObjectName:     name,
+		Samples:        learned.samples,
+		LearnedDensity: learned.density,
+	})
+}
+
+// applyLearnedDensity replaces a portion-derived density with the one
+// learned from feedback, once enough samples exist. The learned value is
+// kept within max_adjustment of the dataset's density.
+func applyLearnedDensity(ctx context.Context, objectName string, match portionMatch) portionMatch {
+	if db == nil || db.feedback == nil || match.grams <= 0 {
+		return match
+	}
+
+	learned := loadLearnedDensity(ctx, normalizeFoodName(objectName))
+	if learned.samples < cfg.Feedback.MinSamples {
+		return match
+	}
+
+	bound := match.grams * cfg.Feedback.MaxAdjustment
+	density := min(max(learned.density, match.grams-bound), match.grams+bound)
+	slog.DebugContext(ctx, "using learned density", "food", objectName, "grams_per_cup", density, "samples", learned.samples, "dataset_grams_per_cup", match.grams)
+	return portionMatch{grams: density, portion: match.portion, quality: matchLearnedDensity}
+}
+
+// loadLearnedDensity returns the cached aggregate for a food, reading the
+// feedback document again once the cached one is older than refresh
+func loadLearnedDensity(ctx context.Context, name string) learnedDensity {
+	learnedDensities.Lock()
+	entry, ok := learnedDensities.entries[name]
+	learnedDensities.Unlock()
+	if ok && time.Since(entry.loadedAt) < cfg.Feedback.Refresh {
+		return entry
+	}
+
+	entry = learnedDensity{loadedAt: time.Now()}
+	if samples, err := db.feedback.samples(ctx, name); err != nil {
+		slog.ErrorContext(ctx, "failed to load density feedback", "food", name, "error", err)
+	} else {
+		entry.density, entry.samples = aggregateFeedback(samples)
+	}
+
+	learnedDensities.Lock()
+	learnedDensities.entries[name] = entry
+	learnedDensities.Unlock()
+	return entry
+}
+
+// aggregateFeedback computes the trimmed mean density of the most recent
+// samples
+func aggregateFeedback(samples []FeedbackSample) (float64, int) {
+	if len(samples) > cfg.Feedback.Window {
+		samples = samples[len(samples)-cfg.Feedback.Window:]
+	}
+
+	densities := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		if s.VolumeCups > 0 && s.MeasuredGrams > 0 {
+			densities = append(densities, s.MeasuredGrams/s.VolumeCups)
+		}
+	}
+	if len(densities) == 0 {
+		return 0, 0
+	}
+
+	sort.Float64s(densities)
+	trim := int(float64(len(densities)) * cfg.Feedback.TrimFraction)
+	kept := densities[trim : len(densities)-trim]
+
+	total := 0.0
+	for _, d := range kept {
+		total += d
+	}
+	return total / float64(len(kept)), len(densities)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// memoryFeedback is a feedback store kept in memory
+type memoryFeedback struct {
+	mu   sync.Mutex
+	docs map[string][]FeedbackSample
+}
+
+func (s *memoryFeedback) add(_ context.Context, name string, sample FeedbackSample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.docs == nil {
+		s.docs = make(map[string][]FeedbackSample)
+	}
+	s.docs[name] = append(s.docs[name], sample)
+	return nil
+}
+
+func (s *memoryFeedback) samples(_ context.Context, name string) ([]FeedbackSample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.docs[name], nil
+}
+
+// feedbackRouter serves feedback and calculate-macros with the test foods
+// cached. With a feedback section, feedback is kept in memory; without
+// one it is disabled.
+func feedbackRouter(t *testing.T, enabled bool, content string) *gin.Engine {
+	t.Helper()
+	config := testConfig(t, "")
+	cacheTestFoods(t, config.DefaultDataset)
+	previous := db
+	db = &Database{}
+	t.Cleanup(func() { db = previous })
+	if enabled {
+		if err := yaml.Unmarshal([]byte("collection: feedback\n"+content), &config.Feedback); err != nil {
+			t.Fatal(err)
+		}
+		if err := config.Feedback.validate(); err != nil {
+			t.Fatal(err)
+		}
+		db.feedback = &memoryFeedback{}
+	}
+	t.Cleanup(func() {
+		learnedDensities.Lock()
+		clear(learnedDensities.entries)
+		learnedDensities.Unlock()
+	})
+
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+	router.POST("/v1/feedback", submitFeedback)
+	return router
+}
+
+func TestFeedbackShiftsDensity(t *testing.T) {
+	// One cup of cooked rice is 158 g in the dataset; max_adjustment 0.25
+	// keeps the learned density within 118.5 and 197.5 g
+	tests := []struct {
+		name        string
+		config      string
+		grams       []float64
+		wantGrams   float64
+		wantLearned bool
+	}{
+		{"too few samples keep the dataset density", "min_samples: 3\n", []float64{170, 170}, 158, false},
+		{"enough samples shift the density", "min_samples: 3\n", []float64{168, 170, 172}, 170, true},
+		{"shifted no further than max_adjustment", "min_samples: 3\n", []float64{300, 300, 300}, 197.5, true},
+		{"shifted no lower than max_adjustment", "min_samples: 3\n", []float64{50, 50, 50}, 118.5, true},
+		{"outliers are trimmed", "min_samples: 3\ntrim_fraction: 0.2\n", []float64{1000, 165, 170, 175, 10}, 170, true},
+		{"only the window is aggregated", "min_samples: 2\nwindow: 2\n", []float64{300, 160, 164}, 162, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := feedbackRouter(t, true, tt.config)
+			for _, grams := range tt.grams {
+				w := doRequest(t, router, http.MethodPost, "/v1/feedback", FeedbackRequest{ObjectName: "Rice", VolumeCups: 1, MeasuredGrams: grams})
+				decode[FeedbackResponse](t, w, http.StatusCreated)
+			}
+
+			item := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: "rice", VolumeCups: 2})), http.StatusOK).Data[0]
+			if math.Abs(item.CalculatedWeight-2*tt.wantGrams) > 1e-6 {
+				t.Errorf("calculated_weight = %v, want %v", item.CalculatedWeight, 2*tt.wantGrams)
+			}
+			if learned := item.MatchQuality == matchLearnedDensity; learned != tt.wantLearned {
+				t.Errorf("match_quality = %s, want learned %v", item.MatchQuality, tt.wantLearned)
+			}
+		})
+	}
+}
+
+func TestFeedbackRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		request    FeedbackRequest
+		wantStatus int
+	}{
+		{"disabled", false, FeedbackRequest{ObjectName: "rice", VolumeCups: 1, MeasuredGrams: 160}, http.StatusNotFound},
+		{"unknown food", true, FeedbackRequest{ObjectName: "dragonfruit", VolumeCups: 1, MeasuredGrams: 160}, http.StatusBadRequest},
+		{"no volume", true, FeedbackRequest{ObjectName: "rice", MeasuredGrams: 160}, http.StatusBadRequest},
+		{"no weight", true, FeedbackRequest{ObjectName: "rice", VolumeCups: 1}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := feedbackRouter(t, tt.enabled, "")
+			w := doRequest(t, router, http.MethodPost, "/v1/feedback", tt.request)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
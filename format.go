@@ -0,0 +1,122 @@
+// format.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Energy units selectable with ?energy_unit=
+const (
+	energyKcal = "kcal"
+	energyKJ   = "kJ"
+
+	kJPerKcal = 4.184
+)
+
+// outputFormat controls how computed macros are presented. All computation
+// (totals, DRI status, ...) happens on unformatted kcal values; formatting
+// is the last step and always converts units before rounding, so rounding
+// applies to the numbers the client actually sees.
+type outputFormat struct {
+	energyUnit string
+	// decimals rounds every value when non-negative
+	decimals int
+}
+
+// parseOutputFormat reads ?energy_unit=kcal|kJ and ?precision=0-6
+func parseOutputFormat(c *gin.Context) (outputFormat, error) {
+	return newOutputFormat(c.Query("energy_unit"), c.Query("precision"))
+}
+
+// newOutputFormat validates an energy unit and precision, either of which
+// may be empty for the default
+func newOutputFormat(energyUnit, precision string) (outputFormat, error) {
+	format := outputFormat{energyUnit: energyKcal, decimals: -1}
+
+	switch energyUnit {
+	case "", energyKcal:
+	case energyKJ, "kj":
+		format.energyUnit = energyKJ
+	default:
+		return format, fmt.Errorf("energy_unit must be kcal or kJ")
+	}
+
+	if precision != "" {
+		decimals, err := strconv.Atoi(precision)
+		if err != nil || decimals < 0 || decimals > 6 {
+			return format, fmt.Errorf("precision must be an integer between 0 and 6")
+		}
+		format.decimals = decimals
+	}
+	return format, nil
+}
+
+// macros converts then rounds a set of macros
+func (f outputFormat) macros(m Macros) Macros {
+	if f.energyUnit == energyKJ {
+		m.Calories *= kJPerKcal
+	}
+	return Macros{
+		Calories: f.round(m.Calories),
+		Carbs:    f.round(m.Carbs),
+		Fat:      f.round(m.Fat),
+		Protein:  f.round(m.Protein),
+	}
+}
+
+func (f outputFormat) round(v float64) float64 {
+	if f.decimals < 0 {
+		return v
+	}
+	scale := math.Pow(10, float64(f.decimals))
+	return math.Round(v*scale) / scale
+}
+
+// item formats every macro value of a result item
+func (f outputFormat) item(md *MacroData) {
+	md.Macros = f.macros(md.Macros)
+	md.CalculatedWeight = f.round(md.CalculatedWeight)
+	md.PercentError = f.round(md.PercentError)
+	for number, amount := range md.Nutrients {
+		amount.Amount = f.round(amount.Amount)
+		md.Nutrients[number] = amount
+	}
+	if md.Range != nil {
+		md.Range = &MacroRange{Min: f.macros(md.Range.Min), Max: f.macros(md.Range.Max)}
+	}
+	if md.PerGram != nil {
+		perGram := f.macros(*md.PerGram)
+		md.PerGram = &perGram
+	}
+	if md.Per100g != nil {
+		per100g := f.macros(*md.Per100g)
+		md.Per100g = &per100g
+	}
+	if md.PerServing != nil {
+		md.PerServing = &ServingMacros{
+			Portion: md.PerServing.Portion,
+			Grams:   f.round(md.PerServing.Grams),
+			Macros:  f.macros(md.PerServing.Macros),
+		}
+	}
+	for i := range md.Variants {
+		md.Variants[i].Macros = f.macros(md.Variants[i].Macros)
+		md.Variants[i].CalculatedWeight = f.round(md.Variants[i].CalculatedWeight)
+		md.Variants[i].Per100g = f.macros(md.Variants[i].Per100g)
+	}
+	if md.Consensus != nil {
+		md.Consensus.Macros = f.macros(md.Consensus.Macros)
+		md.Consensus.Per100g = f.macros(md.Consensus.Per100g)
+		for i := range md.Consensus.Contributors {
+			md.Consensus.Contributors[i].Per100g = f.macros(md.Consensus.Contributors[i].Per100g)
+		}
+	}
+	for i := range md.Candidates {
+		md.Candidates[i].Macros = f.macros(md.Candidates[i].Macros)
+		md.Candidates[i].CalculatedWeight = f.round(md.Candidates[i].CalculatedWeight)
+	}
+}
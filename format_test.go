@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// formatRouter serves calculate-macros with the test foods cached
+func formatRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	config := testConfig(t, "")
+	cacheTestFoods(t, config.DefaultDataset)
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+	return router
+}
+
+func TestOutputFormat(t *testing.T) {
+	router := formatRouter(t)
+	request := volumes(Volume{ObjectName: "banana", VolumeCups: 1})
+	base := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros?per_serving=true", request), http.StatusOK).Data[0]
+	if !base.Found || base.PerServing == nil {
+		t.Fatalf("banana = %+v, want it found with per_serving", base)
+	}
+	// Formatting is deterministic, so results match to float precision
+	same := func(a, b Macros) bool {
+		near := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+		return near(a.Calories, b.Calories) && near(a.Carbs, b.Carbs) && near(a.Fat, b.Fat) && near(a.Protein, b.Protein)
+	}
+	round1 := func(v float64) float64 { return math.Round(v*10) / 10 }
+	kJ := func(m Macros) Macros { m.Calories *= kJPerKcal; return m }
+	rounded := func(m Macros) Macros {
+		return Macros{Calories: round1(m.Calories), Carbs: round1(m.Carbs), Fat: round1(m.Fat), Protein: round1(m.Protein)}
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		wantUnit    string
+		wantMacros  Macros
+		wantWeight  float64
+		wantServing Macros
+	}{
+		{"kcal by default", "", energyKcal, base.Macros, base.CalculatedWeight, base.PerServing.Macros},
+		{"kJ", "&energy_unit=kJ", energyKJ, kJ(base.Macros), base.CalculatedWeight, kJ(base.PerServing.Macros)},
+		{"one decimal", "&precision=1", energyKcal, rounded(base.Macros), round1(base.CalculatedWeight), rounded(base.PerServing.Macros)},
+		// Converted first, then rounded
+		{"kJ with one decimal", "&energy_unit=kJ&precision=1", energyKJ, rounded(kJ(base.Macros)), round1(base.CalculatedWeight), rounded(kJ(base.PerServing.Macros))},
+		{"lowercase kj", "&energy_unit=kj&precision=1", energyKJ, rounded(kJ(base.Macros)), round1(base.CalculatedWeight), rounded(kJ(base.PerServing.Macros))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros?per_serving=true"+tt.query, request), http.StatusOK)
+			if response.EnergyUnit != tt.wantUnit {
+				t.Errorf("energy_unit = %q, want %q", response.EnergyUnit, tt.wantUnit)
+			}
+			item := response.Data[0]
+			if !same(item.Macros, tt.wantMacros) || item.CalculatedWeight != tt.wantWeight {
+				t.Errorf("macros = %+v at %v g, want %+v at %v g", item.Macros, item.CalculatedWeight, tt.wantMacros, tt.wantWeight)
+			}
+			if item.PerServing == nil || !same(item.PerServing.Macros, tt.wantServing) {
+				t.Errorf("per_serving = %+v, want %+v", item.PerServing, tt.wantServing)
+			}
+		})
+	}
+}
+
+func TestOutputFormatRejectsInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"unknown energy unit", "?energy_unit=cal"},
+		{"negative precision", "?precision=-1"},
+		{"precision too large", "?precision=7"},
+		{"non-numeric precision", "?precision=two"},
+	}
+	router := formatRouter(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros"+tt.query, volumes(Volume{ObjectName: "banana", VolumeCups: 1}))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// frames.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// FramesConfig controls storing calculation results requested with
+// persist: true. It is disabled unless a collection is configured.
+type FramesConfig struct {
+	Scope      string `yaml:"scope"`
+	Collection string `yaml:"collection"`
+	// Expiry removes stored frames after this long; zero keeps them
+	Expiry time.Duration `yaml:"expiry"`
+}
+
+func (f *FramesConfig) enabled() bool {
+	return f.Collection != ""
+}
+
+func (f *FramesConfig) validate() error {
+	if !f.enabled() {
+		return nil
+	}
+	if f.Scope == "" {
+		f.Scope = defaultKeyspaceName
+	}
+	for _, name := range []string{f.Scope, f.Collection} {
+		if name != defaultKeyspaceName && !keyspaceNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid keyspace name %q in frames config", name)
+		}
+	}
+	if f.Expiry < 0 {
+		return errors.New("frames.expiry must not be negative")
+	}
+	return nil
+}
+
+// FrameRecord is a calculation result as stored in Couchbase
+type FrameRecord struct {
+	FrameID  string        `json:"frame_id"`
+	UserID   string        `json:"user_id,omitempty"`
+	StoredAt time.Time     `json:"stored_at"`
+	Response MacroResponse `json:"response"`
+}
+
+// frameKeyEscaper keeps users from containing the "::" frameKey separates
+// them from frame IDs with, which may contain colons themselves
+var frameKeyEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+
+// frameKey scopes frame IDs to their user
+func frameKey(user, frameID string) string {
+	return "frame::" + frameKeyEscaper.Replace(user) + "::" + frameID
+}
+
+// frameStore keeps persisted results by user and frame ID
+type frameStore interface {
+	put(ctx context.Context, record FrameRecord) error
+	// get returns false when the user has no result for the frame
+	get(ctx context.Context, user, frameID string) (FrameRecord, bool, error)
+}
+
+// couchbaseFrames keeps one document per user and frame
+type couchbaseFrames struct {
+	collection *gocb.Collection
+	expiry     time.Duration
+}
+
+func (s *couchbaseFrames) put(ctx context.Context, record FrameRecord) error {
+	_, err := s.collection.Upsert(frameKey(record.UserID, record.FrameID), record, &gocb.UpsertOptions{
+		Expiry:  s.expiry,
+		Context: ctx,
+	})
+	return err
+}
+
+func (s *couchbaseFrames) get(ctx context.Context, user, frameID string) (FrameRecord, bool, error) {
+	var record FrameRecord
+	result, err := s.collection.Get(frameKey(user, frameID), &gocb.GetOptions{Context: ctx})
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return record, false, nil
+	}
+	if err != nil {
+		return record, false, err
+	}
+	if err := result.Content(&record); err != nil {
+		return record, false, fmt.Errorf("invalid frame document: %w", err)
+	}
+	return record, true, nil
+}
+
+// validatePersist checks that a request asking to persist its result can be
+// stored
+func validatePersist(frameID string) error {
+	if db.frames == nil {
+		return errors.New("persisting results is not enabled")
+	}
+	if frameID == "" {
+		return errors.New("frame_id is required with persist")
+	}
+	return nil
+}
+
+// storeFrame saves a response under the user's frame ID, replacing an
+// earlier result for the same frame
+func storeFrame(c *gin.Context, user, frameID string, response MacroResponse) error {
+	record := FrameRecord{FrameID: frameID, UserID: user, StoredAt: time.Now().UTC(), Response: response}
+	return db.frames.put(c.Request.Context(), record)
+}
+
+// getFrame returns a previously persisted result
+func getFrame(c *gin.Context) {
+	if db.frames == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "persisting results is not enabled"})
+		return
+	}
+
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	frameID := c.Param("frame_id")
+	record, found, err := db.frames.get(c.Request.Context(), user, frameID)
+	if err != nil {
+		if timedOut(c) {
+			return
+		}
+		respondError(c, http.StatusBadGateway, "failed to load frame", err)
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no stored result for frame %s", frameID)})
+		return
+	}
+	c.JSON(http.StatusOK, record)
+}
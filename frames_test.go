@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memoryFrames is a frame store kept in memory
+type memoryFrames struct {
+	mu      sync.Mutex
+	records map[string]FrameRecord
+}
+
+func (s *memoryFrames) put(_ context.Context, record FrameRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		s.records = make(map[string]FrameRecord)
+	}
+	s.records[frameKey(record.UserID, record.FrameID)] = record
+	return nil
+}
+
+func (s *memoryFrames) get(_ context.Context, user, frameID string) (FrameRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[frameKey(user, frameID)]
+	return record, ok, nil
+}
+
+// trustUserHeader takes users from X-User-ID
+const trustUserHeader = "auth:\n  trust_user_header: true\n"
+
+// framesRouter serves calculate-macros and the stored frames with the test
+// foods cached, persisting to memory when enabled. Users are taken from
+// X-User-ID.
+func framesRouter(t *testing.T, enabled bool) *gin.Engine {
+	t.Helper()
+	config := testConfig(t, trustUserHeader)
+	cacheTestFoods(t, config.DefaultDataset)
+	previous := db
+	db = &Database{}
+	t.Cleanup(func() { db = previous })
+	if enabled {
+		db.frames = &memoryFrames{}
+	}
+
+	router := gin.New()
+	router.POST("/v1/calculate-macros", authenticate, calculateMacros)
+	router.GET("/v1/frames/:frame_id", authenticate, getFrame)
+	return router
+}
+
+func TestPersistFrame(t *testing.T) {
+	router := framesRouter(t, true)
+	user := []string{userHeader, "alice"}
+	body := volumes(Volume{ObjectName: "rice", VolumeCups: 1})
+	body.Data.FrameID = "frame-1"
+	body.Data.Persist = true
+	computed := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", body, user...), http.StatusOK)
+
+	record := decode[FrameRecord](t, doRequest(t, router, http.MethodGet, "/v1/frames/frame-1", nil, user...), http.StatusOK)
+	if record.FrameID != "frame-1" || record.UserID != "alice" || record.StoredAt.IsZero() {
+		t.Errorf("record = %+v, want alice's frame-1 with its storage time", record)
+	}
+	if record.Response.ResultHash != computed.ResultHash || record.Response.Summary.Totals != computed.Summary.Totals {
+		t.Errorf("stored response = %+v, want %+v", record.Response, computed)
+	}
+
+	// Without persist nothing is stored
+	body.Data.FrameID = "frame-2"
+	body.Data.Persist = false
+	doRequest(t, router, http.MethodPost, "/v1/calculate-macros", body, user...)
+	if w := doRequest(t, router, http.MethodGet, "/v1/frames/frame-2", nil, user...); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d for an unpersisted frame, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestFramesOfOtherUsers(t *testing.T) {
+	router := framesRouter(t, true)
+	// Both frames would be frame::a::x::y unless users are escaped
+	persist := func(user, frameID string) MacroResponse {
+		body := volumes(Volume{ObjectName: "rice", VolumeCups: 1})
+		body.Data.FrameID = frameID
+		body.Data.Persist = true
+		return decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", body, userHeader, user), http.StatusOK)
+	}
+	persist("a::x", "y")
+	if w := doRequest(t, router, http.MethodGet, "/v1/frames/x::y", nil, userHeader, "a"); w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d for another user's frame, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+	persist("a", "x::y")
+	record := decode[FrameRecord](t, doRequest(t, router, http.MethodGet, "/v1/frames/y", nil, userHeader, "a::x"), http.StatusOK)
+	if record.UserID != "a::x" || record.FrameID != "y" {
+		t.Errorf("record = %s's %s, want a::x's y", record.UserID, record.FrameID)
+	}
+}
+
+func TestPersistFrameRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		frameID string
+	}{
+		{"persisting disabled", false, "frame-1"},
+		{"missing frame_id", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := framesRouter(t, tt.enabled)
+			body := volumes(Volume{ObjectName: "rice", VolumeCups: 1})
+			body.Data.FrameID = tt.frameID
+			body.Data.Persist = true
+			if w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", body, userHeader, "alice"); w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+		})
+	}
+}
+
+func TestGetFrameDisabled(t *testing.T) {
+	router := framesRouter(t, false)
+	if w := doRequest(t, router, http.MethodGet, "/v1/frames/frame-1", nil, userHeader, "alice"); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestInvalidFramesConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames FramesConfig
+	}{
+		{"invalid collection name", FramesConfig{Collection: "stored frames"}},
+		{"negative expiry", FramesConfig{Collection: "frames", Expiry: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.frames.validate(); err == nil {
+				t.Error("validate() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+// fuzzy.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// FuzzyConfig tunes how object names without a mapped search term are
+// resolved against the food descriptions
+type FuzzyConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// MinConfidence is the score (0-1] a description needs to be used;
+	// defaults to 0.5
+	MinConfidence float64 `yaml:"min_confidence"`
+	// Scan bounds how many descriptions sharing a token are scored;
+	// defaults to 200
+	Scan int `yaml:"scan"`
+}
+
+func (f *FuzzyConfig) validate() error {
+	if f.MinConfidence == 0 {
+		f.MinConfidence = 0.5
+	}
+	if f.Scan == 0 {
+		f.Scan = 200
+	}
+	if f.MinConfidence < 0 || f.MinConfidence > 1 {
+		return errors.New("fuzzy.min_confidence must be between 0 and 1")
+	}
+	if f.Scan < 0 {
+		return errors.New("fuzzy.scan must be positive")
+	}
+	return nil
+}
+
+// errUnknownFood is returned when an object name resolves to no food
+var errUnknownFood = errors.New("unknown food")
+
+// unmatchedError is an unknown food along with the closest descriptions,
+// none of which scored high enough to be used
+type unmatchedError struct {
+	objectName  string
+	suggestions []string
+}
+
+func (e *unmatchedError) Error() string {
+	return fmt.Sprintf("%v: %s", errUnknownFood, e.objectName)
+}
+
+func (e *unmatchedError) Unwrap() error {
+	return errUnknownFood
+}
+
+// suggestionsFor returns the suggestions carried by a lookup error
+func suggestionsFor(err error) []string {
+	var unmatched *unmatchedError
+	if errors.As(err, &unmatched) {
+		return unmatched.suggestions
+	}
+	return nil
+}
+
+// scoredDescription is a food description with its similarity to the name
+type scoredDescription struct {
+	description string
+	score       float64
+}
+
+// fuzzyMatch finds the description closest to an object name. Candidates
+// share at least one token with the name and are scored by token overlap
+// (Dice coefficient), so "boiled egg" scores "Egg, whole, boiled or
+// poached" above "Egg salad". The best description is returned with its
+// score; when it scores below min_confidence an unmatchedError carries the
+// best ones as suggestions.
+func fuzzyMatch(ctx context.Context, dataset, objectName, version string, stats *requestStats) (string, float64, error) {
+	nameTokens := tokenize(objectName)
+	if len(nameTokens) == 0 {
+		return "", 0, fmt.Errorf("%w: %s has no searchable words", errInvalidFood, objectName)
+	}
+
+	descriptions, err := foodRepo.MatchDescriptions(ctx, dataset, nameTokens, version, cfg.Fuzzy.Scan, stats)
+	if err != nil {
+		return "", 0, err
+	}
+	return bestDescription(objectName, nameTokens, descriptions)
+}
+
+// bestDescription scores the descriptions against the name's tokens and
+// returns the closest one, or an unmatchedError when none reaches
+// min_confidence
+func bestDescription(objectName string, nameTokens, descriptions []string) (string, float64, error) {
+	var scored []scoredDescription
+	for _, description := range descriptions {
+		if score := similarity(nameTokens, tokenize(description)); score > 0 {
+			scored = append(scored, scoredDescription{description: description, score: score})
+		}
+	}
+
+	// Ties go to the shorter, more generic description
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].score != scored[j].score {
+			return scored[i].score > scored[j].score
+		}
+		return len(scored[i].description) < len(scored[j].description)
+	})
+
+	if len(scored) == 0 || scored[0].score < cfg.Fuzzy.MinConfidence {
+		unmatched := &unmatchedError{objectName: objectName}
+		for _, s := range scored[:min(len(scored), cfg.Limits.MaxSuggestions)] {
+			unmatched.suggestions = append(unmatched.suggestions, s.description)
+		}
+		return "", 0, unmatched
+	}
+	return scored[0].description, scored[0].score, nil
+}
+
+// tokenize lowercases text and splits it into words of two letters or more
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := words[:0]
+	seen := make(map[string]bool, len(words))
+	for _, word := range words {
+		if len(word) >= 2 && !seen[word] {
+			seen[word] = true
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// similarity is the Dice coefficient of two token sets, counting a plural
+// and its singular ("bananas", "banana") as the same word
+func similarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for _, x := range a {
+		for _, y := range b {
+			if sameWord(x, y) {
+				common++
+				break
+			}
+		}
+	}
+	return 2 * float64(common) / float64(len(a)+len(b))
+}
+
+func sameWord(a, b string) bool {
+	return a == b || a+"s" == b || b+"s" == a || a+"es" == b || b+"es" == a
+}
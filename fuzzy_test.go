@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Egg, whole, boiled or poached", []string{"egg", "whole", "boiled", "or", "poached"}},
+		{"  Rice (cooked) rice ", []string{"rice", "cooked"}},
+		{"a 1% milk", []string{"milk"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := tokenize(tt.text); !slices.Equal(got, tt.want) {
+				t.Errorf("tokenize(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBestDescription(t *testing.T) {
+	raw := []string{"Banana, raw", "Cucumber, raw", "Apple, raw", "Egg, whole, raw", "Cantaloupe, raw", "Spinach, raw"}
+	tests := []struct {
+		name            string
+		config          string
+		objectName      string
+		descriptions    []string
+		want            string
+		wantConfidence  float64
+		wantSuggestions int
+	}{
+		{"more shared words win", "", "boiled egg", []string{"Egg salad", "Egg, whole, boiled or poached"}, "Egg, whole, boiled or poached", 4.0 / 7, 0},
+		{"plurals match their singular", "", "bananas", []string{"Banana, raw"}, "Banana, raw", 2.0 / 3, 0},
+		{"ties go to the shorter description", "", "rice", []string{"Rice, white, cooked", "Rice, cooked, NFS"}, "Rice, cooked, NFS", 0.5, 0},
+		{"below min_confidence", "fuzzy:\n  min_confidence: 0.9\n", "bananas", []string{"Banana, raw"}, "", 0, 1},
+		{"no shared words", "", "durian", raw, "", 0, 0},
+		{"default suggestion cap", "", "raw purple thing", raw, "", 0, 5},
+		{"suggestions capped", "limits:\n  max_suggestions: 2\n", "raw purple thing", raw, "", 0, 2},
+		{"suggestion cap above the matches", "limits:\n  max_suggestions: 10\n", "raw purple thing", raw, "", 0, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig(t, tt.config)
+			got, confidence, err := bestDescription(tt.objectName, tokenize(tt.objectName), tt.descriptions)
+			if tt.want == "" {
+				if !errors.Is(err, errUnknownFood) || len(suggestionsFor(err)) != tt.wantSuggestions {
+					t.Errorf("bestDescription() = %q, %v; want unknown with %d suggestions", got, err, tt.wantSuggestions)
+				}
+				return
+			}
+			if err != nil || got != tt.want || math.Abs(confidence-tt.wantConfidence) > 1e-9 {
+				t.Errorf("bestDescription() = %q, %v, %v; want %q, %v", got, confidence, err, tt.want, tt.wantConfidence)
+			}
+		})
+	}
+}
+
+func TestFuzzyDisabled(t *testing.T) {
+	testConfig(t, noFuzzy)
+	lookup, err := lookupFoods(context.Background(), cfg.DefaultDataset, "raw purple thing", "", &requestStats{})
+	if !errors.Is(err, errUnknownFood) || len(lookup.foods) != 0 || suggestionsFor(err) != nil {
+		t.Errorf("lookupFoods() = %+v, %v; want unknown without suggestions", lookup, err)
+	}
+}
@@ -0,0 +1,445 @@
+// graphql.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+)
+
+// GraphQLRequest is the body of POST /v1/graphql
+type GraphQLRequest struct {
+	Query         string         `json:"query" binding:"required"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// gqlField resolves a field from the source object, which has type T
+func gqlField[T any](typ graphql.Output, get func(T) any) *graphql.Field {
+	return &graphql.Field{
+		Type: typ,
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			return get(p.Source.(T)), nil
+		},
+	}
+}
+
+// gqlEntry is one entry of a map, which GraphQL has no type for
+type gqlEntry struct {
+	key   string
+	value string
+}
+
+func gqlEntries(m map[string]string) []gqlEntry {
+	entries := make([]gqlEntry, 0, len(m))
+	for k, v := range m {
+		entries = append(entries, gqlEntry{key: k, value: v})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries
+}
+
+// graphqlSchema is built once; it only depends on the code
+var graphqlSchema = sync.OnceValues(newGraphQLSchema)
+
+func newGraphQLSchema() (graphql.Schema, error) {
+	entryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Entry",
+		Fields: graphql.Fields{
+			"key":   gqlField(graphql.String, func(e gqlEntry) any { return e.key }),
+			"value": gqlField(graphql.String, func(e gqlEntry) any { return e.value }),
+		},
+	})
+
+	nutrientType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Nutrient",
+		Description: "A nutrient of a food, per 100 g",
+		Fields: graphql.Fields{
+			"number":   gqlField(graphql.String, func(n Nutrient) any { return n.Nutrient.Number }),
+			"name":     gqlField(graphql.String, func(n Nutrient) any { return n.Nutrient.Name }),
+			"unitName": gqlField(graphql.String, func(n Nutrient) any { return n.Nutrient.UnitName }),
+			"amount":   gqlField(graphql.Float, func(n Nutrient) any { return n.Amount }),
+		},
+	})
+
+	portionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Portion",
+		Fields: graphql.Fields{
+			"id":             gqlField(graphql.Int, func(p Portion) any { return p.ID }),
+			"description":    gqlField(graphql.String, func(p Portion) any { return portionName(p) }),
+			"modifier":       gqlField(graphql.String, func(p Portion) any { return p.Modifier }),
+			"measureUnit":    gqlField(graphql.String, func(p Portion) any { return p.MeasureUnit.Name }),
+			"gramWeight":     gqlField(graphql.Float, func(p Portion) any { return p.GramWeight }),
+			"sequenceNumber": gqlField(graphql.Int, func(p Portion) any { return p.SequenceNumber }),
+		},
+	})
+
+	foodType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Food",
+		Fields: graphql.Fields{
+			"fdcId":       gqlField(graphql.Int, func(f FoodData) any { return f.FdcID }),
+			"description": gqlField(graphql.String, func(f FoodData) any { return f.Description }),
+			"category":    gqlField(graphql.String, func(f FoodData) any { return f.category() }),
+			"dataVersion": gqlField(graphql.String, func(f FoodData) any { return f.DataVersion }),
+			"portions":    gqlField(graphql.NewList(portionType), func(f FoodData) any { return f.FoodPortions }),
+			"nutrients": &graphql.Field{
+				Type:        graphql.NewList(nutrientType),
+				Description: "The food's nutrients, optionally only those with the given numbers",
+				Args: graphql.FieldConfigArgument{
+					"numbers": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					food := p.Source.(FoodData)
+					numbers, ok := p.Args["numbers"].([]any)
+					if !ok {
+						return food.FoodNutrients, nil
+					}
+					wanted := make(map[string]bool, len(numbers))
+					for _, number := range numbers {
+						wanted[number.(string)] = true
+					}
+					var nutrients []Nutrient
+					for _, n := range food.FoodNutrients {
+						if wanted[n.Nutrient.Number] {
+							nutrients = append(nutrients, n)
+						}
+					}
+					return nutrients, nil
+				},
+			},
+		},
+	})
+
+	macrosType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Macros",
+		Fields: graphql.Fields{
+			"calories": gqlField(graphql.Float, func(m Macros) any { return m.Calories }),
+			"carbs":    gqlField(graphql.Float, func(m Macros) any { return m.Carbs }),
+			"fat":      gqlField(graphql.Float, func(m Macros) any { return m.Fat }),
+			"protein":  gqlField(graphql.Float, func(m Macros) any { return m.Protein }),
+		},
+	})
+
+	nutrientAmountType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "NutrientAmount",
+		Fields: graphql.Fields{
+			"number": gqlField(graphql.String, func(n gqlNutrientAmount) any { return n.number }),
+			"name":   gqlField(graphql.String, func(n gqlNutrientAmount) any { return n.Name }),
+			"amount": gqlField(graphql.Float, func(n gqlNutrientAmount) any { return n.Amount }),
+			"unit":   gqlField(graphql.String, func(n gqlNutrientAmount) any { return n.Unit }),
+		},
+	})
+
+	servingType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ServingMacros",
+		Fields: graphql.Fields{
+			"portion": gqlField(graphql.String, func(s ServingMacros) any { return s.Portion }),
+			"grams":   gqlField(graphql.Float, func(s ServingMacros) any { return s.Grams }),
+			"macros":  gqlField(macrosType, func(s ServingMacros) any { return s.Macros }),
+		},
+	})
+
+	macroDataType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "MacroData",
+		Fields: graphql.Fields{
+			"found":              gqlField(graphql.Boolean, func(d MacroData) any { return d.Found }),
+			"dataset":            gqlField(graphql.String, func(d MacroData) any { return d.Dataset }),
+			"description":        gqlField(graphql.String, func(d MacroData) any { return d.Description }),
+			"category":           gqlField(graphql.String, func(d MacroData) any { return d.Category }),
+			"dataVersion":        gqlField(graphql.String, func(d MacroData) any { return d.DataVersion }),
+			"macros":             gqlField(macrosType, func(d MacroData) any { return d.Macros }),
+			"perGram":            gqlField(macrosType, func(d MacroData) any { return derefOrNil(d.PerGram) }),
+			"per100g":            gqlField(macrosType, func(d MacroData) any { return derefOrNil(d.Per100g) }),
+			"perServing":         gqlField(servingType, func(d MacroData) any { return derefOrNil(d.PerServing) }),
+			"requestedFood":      gqlField(graphql.String, func(d MacroData) any { return d.RequestedFood }),
+			"requestedVolume":    gqlField(graphql.Float, func(d MacroData) any { return d.RequestedVolume }),
+			"requestedUnit":      gqlField(graphql.String, func(d MacroData) any { return d.RequestedUnit }),
+			"calculatedWeight":   gqlField(graphql.Float, func(d MacroData) any { return d.CalculatedWeight }),
+			"densityOverride":    gqlField(graphql.Boolean, func(d MacroData) any { return d.DensityOverride }),
+			"caloriesComputed":   gqlField(graphql.Boolean, func(d MacroData) any { return d.CaloriesComputed }),
+			"portionUsed":        gqlField(graphql.String, func(d MacroData) any { return d.PortionUsed }),
+			"matchQuality":       gqlField(graphql.String, func(d MacroData) any { return d.MatchQuality }),
+			"densityGPerMl":      gqlField(graphql.Float, func(d MacroData) any { return d.DensityGramsPerML }),
+			"errorCode":          gqlField(graphql.String, func(d MacroData) any { return d.ErrorCode }),
+			"errorMessage":       gqlField(graphql.String, func(d MacroData) any { return d.ErrorMessage }),
+			"confidence":         gqlField(graphql.Float, func(d MacroData) any { return d.Confidence }),
+			"uncertaintyClamped": gqlField(graphql.Boolean, func(d MacroData) any { return d.UncertaintyClamped }),
+			"percentError":       gqlField(graphql.Float, func(d MacroData) any { return d.PercentError }),
+			"nutrients":          gqlField(graphql.NewList(nutrientAmountType), func(d MacroData) any { return gqlNutrientAmounts(d.Nutrients) }),
+			"driStatus":          gqlField(graphql.NewList(entryType), func(d MacroData) any { return gqlEntries(d.DRIStatus) }),
+			"nutrientStatus":     gqlField(graphql.NewList(entryType), func(d MacroData) any { return gqlEntries(d.NutrientStatus) }),
+			"suggestions":        gqlField(graphql.NewList(graphql.String), func(d MacroData) any { return d.Suggestions }),
+			"resolvedName":       gqlField(graphql.String, func(d MacroData) any { return d.ResolvedName }),
+			"state":              gqlField(graphql.String, func(d MacroData) any { return d.State }),
+			"yieldFactor":        gqlField(graphql.Float, func(d MacroData) any { return d.YieldFactor }),
+		},
+	})
+
+	summaryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "FrameSummary",
+		Fields: graphql.Fields{
+			"frameId":    gqlField(graphql.String, func(s FrameSummary) any { return s.FrameID }),
+			"totals":     gqlField(macrosType, func(s FrameSummary) any { return s.Totals }),
+			"unresolved": gqlField(graphql.Int, func(s FrameSummary) any { return s.Unresolved }),
+		},
+	})
+
+	responseType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "MacroResponse",
+		Fields: graphql.Fields{
+			"calcVersion": gqlField(graphql.String, func(r MacroResponse) any { return r.CalcVersion }),
+			"status":      gqlField(graphql.String, func(r MacroResponse) any { return r.Status }),
+			"data":        gqlField(graphql.NewList(macroDataType), func(r MacroResponse) any { return r.Data }),
+			"summary":     gqlField(summaryType, func(r MacroResponse) any { return r.Summary }),
+			"energyUnit":  gqlField(graphql.String, func(r MacroResponse) any { return r.EnergyUnit }),
+			"scale":       gqlField(graphql.Float, func(r MacroResponse) any { return r.Scale }),
+			"resultHash":  gqlField(graphql.String, func(r MacroResponse) any { return r.ResultHash }),
+		},
+	})
+
+	volumeInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "VolumeInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"objectName":         &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"volumeCups":         &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Float)},
+			"uncertaintyCups":    &graphql.InputObjectFieldConfig{Type: graphql.Float},
+			"densityGramsPerCup": &graphql.InputObjectFieldConfig{Type: graphql.Float},
+			"portionDescription": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"eggSize":            &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"unit":               &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"state":              &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"food": &graphql.Field{
+				Type:        foodType,
+				Description: "A food by FDC ID; null when the dataset has none",
+				Args: graphql.FieldConfigArgument{
+					"fdcId":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"dataset": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: resolveFood,
+			},
+			"searchFoods": &graphql.Field{
+				Type:        graphql.NewList(foodType),
+				Description: "Foods whose description contains every word of q, ordered by FDC ID. Nutrients are not loaded.",
+				Args: graphql.FieldConfigArgument{
+					"q":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"dataset": &graphql.ArgumentConfig{Type: graphql.String},
+					"limit":   &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageSize},
+					"cursor":  &graphql.ArgumentConfig{Type: graphql.String, Description: "next_cursor of a GET /v1/foods/search page"},
+				},
+				Resolve: resolveSearchFoods,
+			},
+			"calculateMacros": &graphql.Field{
+				Type:        responseType,
+				Description: "The same calculation as POST /v1/calculate-macros",
+				Args: graphql.FieldConfigArgument{
+					"frameId":     &graphql.ArgumentConfig{Type: graphql.String},
+					"volumes":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(volumeInput)))},
+					"scale":       &graphql.ArgumentConfig{Type: graphql.Float},
+					"dataVersion": &graphql.ArgumentConfig{Type: graphql.String},
+					"perGram":     &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"perServing":  &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"nutrients":   &graphql.ArgumentConfig{Type: graphql.String},
+					"energyUnit":  &graphql.ArgumentConfig{Type: graphql.String},
+					"precision":   &graphql.ArgumentConfig{Type: graphql.Int},
+					"languages":   &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+				},
+				Resolve: resolveCalculateMacros,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+func derefOrNil[T any](m *T) any {
+	if m == nil {
+		return nil
+	}
+	return *m
+}
+
+// gqlNutrientAmount is a NutrientAmount with the number it is keyed by
+type gqlNutrientAmount struct {
+	NutrientAmount
+	number string
+}
+
+func gqlNutrientAmounts(m map[string]NutrientAmount) []gqlNutrientAmount {
+	amounts := make([]gqlNutrientAmount, 0, len(m))
+	for number, amount := range m {
+		amounts = append(amounts, gqlNutrientAmount{NutrientAmount: amount, number: number})
+	}
+	sort.Slice(amounts, func(i, j int) bool { return amounts[i].number < amounts[j].number })
+	return amounts
+}
+
+// gqlDataset returns the dataset argument, defaulting to default_dataset
+func gqlDataset(args map[string]any) (string, error) {
+	dataset, _ := args["dataset"].(string)
+	if dataset == "" {
+		dataset = cfg.DefaultDataset
+	}
+	if _, ok := cfg.Datasets[dataset]; !ok {
+		return "", fmt.Errorf("unknown dataset: %s", dataset)
+	}
+	return dataset, nil
+}
+
+func resolveFood(p graphql.ResolveParams) (any, error) {
+	dataset, err := gqlDataset(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	document, found, err := foodRepo.GetByFDCID(p.Context, dataset, p.Args["fdcId"].(int))
+	if err != nil {
+		return nil, gqlError(p.Context, "food lookup failed", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	var food FoodData
+	if err := json.Unmarshal(document.Data, &food); err != nil {
+		return nil, gqlError(p.Context, "failed to decode food data", err)
+	}
+	return food, nil
+}
+
+func resolveSearchFoods(p graphql.ResolveParams) (any, error) {
+	words := tokenize(p.Args["q"].(string))
+	if len(words) == 0 {
+		return nil, errors.New("q must contain at least one word")
+	}
+	dataset, err := gqlDataset(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	limit := p.Args["limit"].(int)
+	if limit <= 0 || limit > maxPageSize {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	after := -1
+	if raw, ok := p.Args["cursor"].(string); ok {
+		cursor, err := decodePageCursor(raw)
+		if err != nil || cursor.Sort != searchFields.defaultSort {
+			return nil, errInvalidCursor
+		}
+		after, err = searchAfter(&cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	foods, err := foodRepo.Search(p.Context, dataset, words, after, limit)
+	if err != nil {
+		return nil, gqlError(p.Context, "search failed", err)
+	}
+	return foods, nil
+}
+
+func resolveCalculateMacros(p graphql.ResolveParams) (any, error) {
+	var request VolumeRequest
+	request.Data.FrameID, _ = p.Args["frameId"].(string)
+	if scale, ok := p.Args["scale"].(float64); ok {
+		request.Data.Scale = &scale
+	}
+	for _, raw := range p.Args["volumes"].([]any) {
+		input := raw.(map[string]any)
+		volume := Volume{
+			ObjectName: input["objectName"].(string),
+			VolumeCups: input["volumeCups"].(float64),
+		}
+		volume.UncertaintyCups, _ = input["uncertaintyCups"].(float64)
+		if density, ok := input["densityGramsPerCup"].(float64); ok {
+			volume.DensityGramsPerCup = &density
+		}
+		volume.PortionDescription, _ = input["portionDescription"].(string)
+		volume.EggSize, _ = input["eggSize"].(string)
+		volume.Unit, _ = input["unit"].(string)
+		volume.State, _ = input["state"].(string)
+		request.Data.Volumes = append(request.Data.Volumes, volume)
+	}
+
+	options := frameOptions{}
+	options.dataVersion, _ = p.Args["dataVersion"].(string)
+	options.perGram, _ = p.Args["perGram"].(bool)
+	options.perServing, _ = p.Args["perServing"].(bool)
+	options.nutrients, _ = p.Args["nutrients"].(string)
+	options.energyUnit, _ = p.Args["energyUnit"].(string)
+	if precision, ok := p.Args["precision"].(int); ok {
+		options.precision = strconv.Itoa(precision)
+	}
+	if languages, ok := p.Args["languages"].([]any); ok {
+		for _, lang := range languages {
+			options.languages = append(options.languages, lang.(string))
+		}
+	}
+
+	cc, format, err := prepareFrame(p.Context, request, options)
+	if err != nil {
+		if errors.Is(err, errInvalidRequest) || errors.Is(err, errUnknownDataVersion) || errors.Is(err, errFoodsUnavailable) {
+			return nil, err
+		}
+		return nil, gqlError(p.Context, "failed to look up data version", err)
+	}
+	response := computeFrame(cc, request, format)
+	// As over REST and gRPC, a degraded result isn't returned as if it
+	// were complete
+	if response.Status == responseStatusDegraded {
+		return nil, errors.New("food database failed some lookups, retry later")
+	}
+	return response, nil
+}
+
+// gqlError logs a failure under a correlation id, like respondError, and
+// returns the message for the client
+func gqlError(ctx context.Context, message string, err error) error {
+	id := newCorrelationID()
+	slog.ErrorContext(ctx, "graphql error", "correlation_id", id, "message", message, "error", err)
+	detail := message
+	if devMode() {
+		detail = err.Error()
+	}
+	return fmt.Errorf("%s (correlation_id %s)", detail, id)
+}
+
+// serveGraphQL executes a GraphQL query. As is usual for GraphQL, failures
+// of single fields are reported in the errors of a 200 response.
+func serveGraphQL(c *gin.Context) {
+	var request GraphQLRequest
+	if err := bindJSON(c, &request); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	schema, err := graphqlSchema()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "GraphQL schema is invalid", err)
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  request.Query,
+		OperationName:  request.OperationName,
+		VariableValues: request.Variables,
+		Context:        c.Request.Context(),
+	})
+	if timedOut(c) {
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"testing"
+)
+
+// graphQLResult is the body of a GraphQL response
+type graphQLResult struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func TestGraphQLQueries(t *testing.T) {
+	router := setupServer(t, "")
+	useMappings(t, &fileMappings{path: writeConfig(t, "rice: Rice, cooked, NFS\n")})
+	query := func(t *testing.T, q string) graphQLResult {
+		t.Helper()
+		w := doRequest(t, router, http.MethodPost, "/v1/graphql", GraphQLRequest{Query: q})
+		return decode[graphQLResult](t, w, http.StatusOK)
+	}
+
+	t.Run("food with selected nutrients", func(t *testing.T) {
+		result := query(t, `{ food(fdcId: 5) { description nutrients(numbers: ["291"]) { number amount } } }`)
+		var data struct {
+			Food struct {
+				Description string     `json:"description"`
+				Nutrients   []Nutrient `json:"nutrients"`
+			} `json:"food"`
+		}
+		if len(result.Errors) > 0 || json.Unmarshal(result.Data, &data) != nil {
+			t.Fatalf("result = %s, %+v", result.Data, result.Errors)
+		}
+		if data.Food.Description != "Banana, raw" || len(data.Food.Nutrients) != 1 {
+			t.Errorf("food = %+v, want the banana with only fiber", data.Food)
+		}
+	})
+
+	t.Run("missing food is null", func(t *testing.T) {
+		result := query(t, `{ food(fdcId: 99) { description } }`)
+		if len(result.Errors) > 0 || string(result.Data) != `{"food":null}` {
+			t.Errorf("result = %s, %+v; want a null food", result.Data, result.Errors)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		result := query(t, `{ searchFoods(q: "rice") { fdcId } }`)
+		var data struct {
+			SearchFoods []FoodData `json:"searchFoods"`
+		}
+		if len(result.Errors) > 0 || json.Unmarshal(result.Data, &data) != nil {
+			t.Fatalf("result = %s, %+v", result.Data, result.Errors)
+		}
+		if len(data.SearchFoods) != 2 || data.SearchFoods[0].FdcID != 1 {
+			t.Errorf("searchFoods = %v, want both rices by fdcId", fdcIDs(data.SearchFoods))
+		}
+	})
+
+	t.Run("calculate macros", func(t *testing.T) {
+		result := query(t, `{ calculateMacros(volumes: [{objectName: "rice", volumeCups: 1}]) { calcVersion data { found macros { calories } } } }`)
+		var data struct {
+			CalculateMacros struct {
+				CalcVersion string `json:"calcVersion"`
+				Data        []struct {
+					Found  bool   `json:"found"`
+					Macros Macros `json:"macros"`
+				} `json:"data"`
+			} `json:"calculateMacros"`
+		}
+		if len(result.Errors) > 0 || json.Unmarshal(result.Data, &data) != nil {
+			t.Fatalf("result = %s, %+v", result.Data, result.Errors)
+		}
+		response := data.CalculateMacros
+		if response.CalcVersion != CalcVersion || len(response.Data) != 1 || !response.Data[0].Found {
+			t.Fatalf("calculateMacros = %+v, want the rice found", response)
+		}
+		// 158 g of rice at 130 kcal per 100 g
+		if calories := response.Data[0].Macros.Calories; math.Abs(calories-205.4) > 1e-9 {
+			t.Errorf("calories = %v, want 205.4", calories)
+		}
+	})
+
+	invalid := []struct {
+		name  string
+		query string
+	}{
+		{"unknown dataset", `{ food(fdcId: 1, dataset: "nope") { description } }`},
+		{"empty search", `{ searchFoods(q: " ") { fdcId } }`},
+		{"search limit out of range", `{ searchFoods(q: "rice", limit: 0) { fdcId } }`},
+		{"invalid unit", `{ calculateMacros(volumes: [{objectName: "rice", volumeCups: 1, unit: "pint"}]) { calcVersion } }`},
+		{"invalid precision", `{ calculateMacros(volumes: [{objectName: "rice", volumeCups: 1}], precision: 9) { calcVersion } }`},
+		{"unknown field", `{ food(fdcId: 1) { calories } }`},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := query(t, tt.query); len(result.Errors) == 0 {
+				t.Errorf("result = %s without errors, want an error", result.Data)
+			}
+		})
+	}
+}
+
+func TestGraphQLDegraded(t *testing.T) {
+	router := setupServer(t, "breaker:\n  disabled: true\n")
+	foodRepo = &mockRepo{err: errors.New("cluster unreachable")}
+	w := doRequest(t, router, http.MethodPost, "/v1/graphql", GraphQLRequest{Query: `{ calculateMacros(volumes: [{objectName: "rice", volumeCups: 1}]) { status } }`})
+	result := decode[graphQLResult](t, w, http.StatusOK)
+	if len(result.Errors) == 0 || string(result.Data) != `{"calculateMacros":null}` {
+		t.Errorf("result = %s, %+v; want an error instead of the degraded response", result.Data, result.Errors)
+	}
+}
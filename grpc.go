@@ -0,0 +1,241 @@
+// grpc.go
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/prlorence/bytemi-api/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// macroService serves CalculateMacros over gRPC for clients that prefer
+// protobuf, with the same computation as POST /v1/calculate-macros.
+// Persisting frames and callbacks are only offered by the REST API.
+type macroService struct {
+	pb.UnimplementedMacroServiceServer
+}
+
+// newGRPCServer returns the gRPC server, which applies the same rate limit,
+// authentication and route timeout as the REST endpoint, over TLS when
+// tlsConfig is set
+func newGRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	options := []grpc.ServerOption{grpc.ChainUnaryInterceptor(grpcRateLimit, grpcAuthenticate, grpcTimeout)}
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(options...)
+	pb.RegisterMacroServiceServer(server, macroService{})
+	return server
+}
+
+// grpcRateLimit counts calls against the same buckets as REST requests,
+// identified by the x-api-key metadata and the peer's IP. Calls beyond the
+// rate fail with ResourceExhausted and a retry-after header.
+func grpcRateLimit(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	limiter := clientLimiter.Load()
+	if limiter == nil {
+		return handler(ctx, req)
+	}
+	var id string
+	if key := firstMetadata(ctx, apiKeyHeader); key != "" {
+		id = apiKeyID(key)
+	}
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+	if ok, wait := limiter.take(limiter.client(id, ip)); !ok {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(wait.Seconds())))))
+		return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return handler(ctx, req)
+}
+
+// grpcAuthenticate verifies a bearer token in the authorization metadata,
+// like authenticate. Calls without one go through anonymously.
+func grpcAuthenticate(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !cfg.Auth.enabled() {
+		return handler(ctx, req)
+	}
+	header := firstMetadata(ctx, "authorization")
+	if header == "" {
+		return handler(ctx, req)
+	}
+	raw, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "expected a bearer token")
+	}
+	if _, err := verifyToken(ctx, raw); err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token: "+err.Error())
+	}
+	return handler(ctx, req)
+}
+
+// firstMetadata returns the first value of an incoming metadata key
+func firstMetadata(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func grpcTimeout(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if timeout := cfg.Server.Timeouts.forRoute("/v1/calculate-macros"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return handler(ctx, req)
+}
+
+func (macroService) CalculateMacros(ctx context.Context, req *pb.CalculateMacrosRequest) (*pb.MacroResponse, error) {
+	var request VolumeRequest
+	request.Data.FrameID = req.GetFrameId()
+	request.Data.Scale = req.Scale
+	for _, v := range req.GetVolumes() {
+		request.Data.Volumes = append(request.Data.Volumes, Volume{
+			ObjectName:         v.GetObjectName(),
+			VolumeCups:         v.GetVolumeCups(),
+			UncertaintyCups:    v.GetUncertaintyCups(),
+			DensityGramsPerCup: v.DensityGramsPerCup,
+			PortionDescription: v.GetPortionDescription(),
+			EggSize:            v.GetEggSize(),
+			Unit:               v.GetUnit(),
+			State:              v.GetState(),
+		})
+	}
+
+	precision := ""
+	if req.Precision != nil {
+		precision = strconv.Itoa(int(req.GetPrecision()))
+	}
+	cc, format, err := prepareFrame(ctx, request, frameOptions{
+		dataVersion: req.GetDataVersion(),
+		perGram:     req.GetPerGram(),
+		perServing:  req.GetPerServing(),
+		nutrients:   req.GetNutrients(),
+		energyUnit:  req.GetEnergyUnit(),
+		precision:   precision,
+		languages:   req.GetLanguages(),
+	})
+	switch {
+	case errors.Is(err, errInvalidRequest):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errUnknownDataVersion):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errFoodsUnavailable):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	case err != nil:
+		return nil, grpcError(ctx, "failed to look up data version", err)
+	}
+
+	response := computeFrame(cc, request, format)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, status.Error(codes.DeadlineExceeded, "request timed out")
+	}
+	// As over REST, a degraded result isn't returned as if it were complete
+	if response.Status == responseStatusDegraded {
+		return nil, status.Error(codes.Unavailable, "food database failed some lookups, retry later")
+	}
+	return macroResponseToProto(response), nil
+}
+
+// grpcError logs a failure under a correlation id, like respondError, and
+// returns the message for the client
+func grpcError(ctx context.Context, message string, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return status.Error(codes.DeadlineExceeded, "request timed out")
+	}
+	id := newCorrelationID()
+	slog.ErrorContext(ctx, "rpc error", "correlation_id", id, "message", message, "error", err)
+	detail := message
+	if devMode() {
+		detail = err.Error()
+	}
+	return status.Errorf(codes.Unavailable, "%s (correlation_id %s)", detail, id)
+}
+
+func macroResponseToProto(r MacroResponse) *pb.MacroResponse {
+	response := &pb.MacroResponse{
+		CalcVersion: r.CalcVersion,
+		Status:      r.Status,
+		Data:        make([]*pb.MacroData, 0, len(r.Data)),
+		Summary: &pb.FrameSummary{
+			FrameId:    r.Summary.FrameID,
+			Totals:     macrosToProto(r.Summary.Totals),
+			Unresolved: int32(r.Summary.Unresolved),
+		},
+		EnergyUnit: r.EnergyUnit,
+		Scale:      r.Scale,
+		ResultHash: r.ResultHash,
+	}
+	for _, d := range r.Data {
+		item := &pb.MacroData{
+			Found:              d.Found,
+			Dataset:            d.Dataset,
+			Description:        d.Description,
+			Category:           d.Category,
+			DataVersion:        d.DataVersion,
+			Macros:             macrosToProto(d.Macros),
+			RequestedFood:      d.RequestedFood,
+			RequestedVolume:    d.RequestedVolume,
+			RequestedUnit:      d.RequestedUnit,
+			CalculatedWeight:   d.CalculatedWeight,
+			DensityOverride:    d.DensityOverride,
+			CaloriesComputed:   d.CaloriesComputed,
+			PortionUsed:        d.PortionUsed,
+			MatchQuality:       d.MatchQuality,
+			DensityGPerMl:      d.DensityGramsPerML,
+			ErrorCode:          d.ErrorCode,
+			ErrorMessage:       d.ErrorMessage,
+			Confidence:         d.Confidence,
+			UncertaintyClamped: d.UncertaintyClamped,
+			PercentError:       d.PercentError,
+			DriStatus:          d.DRIStatus,
+			NutrientStatus:     d.NutrientStatus,
+			Suggestions:        d.Suggestions,
+			ResolvedName:       d.ResolvedName,
+			State:              d.State,
+			YieldFactor:        d.YieldFactor,
+		}
+		if d.PerGram != nil {
+			item.PerGram = macrosToProto(*d.PerGram)
+		}
+		if d.Per100g != nil {
+			item.Per100G = macrosToProto(*d.Per100g)
+		}
+		if d.PerServing != nil {
+			item.PerServing = &pb.ServingMacros{Portion: d.PerServing.Portion, Grams: d.PerServing.Grams, Macros: macrosToProto(d.PerServing.Macros)}
+		}
+		if d.Range != nil {
+			item.Range = &pb.MacroRange{Min: macrosToProto(d.Range.Min), Max: macrosToProto(d.Range.Max)}
+		}
+		if len(d.Nutrients) > 0 {
+			item.Nutrients = make(map[string]*pb.NutrientAmount, len(d.Nutrients))
+			for number, n := range d.Nutrients {
+				item.Nutrients[number] = &pb.NutrientAmount{Name: n.Name, Amount: n.Amount, Unit: n.Unit, Grams: n.Grams}
+			}
+		}
+		response.Data = append(response.Data, item)
+	}
+	return response
+}
+
+func macrosToProto(m Macros) *pb.Macros {
+	return &pb.Macros{Calories: m.Calories, Carbs: m.Carbs, Fat: m.Fat, Protein: m.Protein}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prlorence/bytemi-api/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialGRPC serves the gRPC server in memory and returns a client for it
+func dialGRPC(t *testing.T) pb.MacroServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer(nil)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMacroServiceClient(conn)
+}
+
+// riceRequest calculates a cup of rice
+func riceRequest() *pb.CalculateMacrosRequest {
+	return &pb.CalculateMacrosRequest{Volumes: []*pb.Volume{{ObjectName: "rice", VolumeCups: 1}}}
+}
+
+func TestGRPCUnavailable(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		setup    func()
+		wantCode codes.Code
+	}{
+		{"store serving", "", func() {}, codes.OK},
+		{"store not connected", "", func() { storageReady.Store(false) }, codes.Unavailable},
+		{"breaker open", "", func() {
+			for range 10 {
+				foodBreaker.record(false)
+			}
+		}, codes.Unavailable},
+		{"lookups failed", "breaker:\n  disabled: true\n", func() {
+			foodRepo = &mockRepo{err: errors.New("cluster unreachable")}
+		}, codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupServer(t, tt.config)
+			tt.setup()
+			client := dialGRPC(t)
+
+			response, err := client.CalculateMacros(context.Background(), riceRequest())
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("code = %s, want %s: %v", code, tt.wantCode, err)
+			}
+			if err == nil && response.GetStatus() == responseStatusDegraded {
+				t.Error("degraded response returned as OK")
+			}
+		})
+	}
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      []string
+		wantCodes []codes.Code
+	}{
+		{"limited by peer", []string{"", "", ""}, []codes.Code{codes.OK, codes.OK, codes.ResourceExhausted}},
+		{"rotating unknown keys", []string{"made-up-1", "made-up-2", "made-up-3"}, []codes.Code{codes.OK, codes.OK, codes.ResourceExhausted}},
+		{"known key has its own bucket", []string{"", "", "known-1"}, []codes.Code{codes.OK, codes.OK, codes.OK}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupServer(t, "rate_limit:\n  rps: 0.001\n  burst: 2\n  api_keys: ["+apiKeyID("known-1")+"]\n")
+			client := dialGRPC(t)
+			for i, key := range tt.keys {
+				ctx := context.Background()
+				if key != "" {
+					ctx = metadata.AppendToOutgoingContext(ctx, apiKeyHeader, key)
+				}
+				var header metadata.MD
+				_, err := client.CalculateMacros(ctx, riceRequest(), grpc.Header(&header))
+				if code := status.Code(err); code != tt.wantCodes[i] {
+					t.Fatalf("call %d with key %q: code = %s, want %s", i, key, code, tt.wantCodes[i])
+				}
+				if status.Code(err) == codes.ResourceExhausted && len(header.Get("retry-after")) == 0 {
+					t.Errorf("call %d: ResourceExhausted without retry-after", i)
+				}
+			}
+		})
+	}
+}
+
+func TestGRPCAuthenticate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := jwksServer(t, key)
+	token := func(expires time.Duration) string {
+		return signToken(t, key, jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(expires).Unix()})
+	}
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      codes.Code
+	}{
+		{"anonymous", "", codes.OK},
+		{"valid token", "Bearer " + token(time.Hour), codes.OK},
+		{"expired token", "Bearer " + token(-time.Hour), codes.Unauthenticated},
+		{"malformed token", "Bearer not-a-token", codes.Unauthenticated},
+		{"not a bearer token", "Basic YWxpY2U6c2VjcmV0", codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupServer(t, "auth:\n  jwks_url: "+server.URL+"\n")
+			client := dialGRPC(t)
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", tt.authorization)
+			}
+			_, err := client.CalculateMacros(ctx, riceRequest())
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("code = %s, want %s: %v", code, tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestMacroResponseToProtoNutrients(t *testing.T) {
+	grams := 0.716
+	response := MacroResponse{Data: []MacroData{{Found: true, Nutrients: map[string]NutrientAmount{
+		"306": {Name: "Potassium, K", Amount: 716, Unit: "mg", Grams: &grams},
+		"320": {Name: "Vitamin A, RAE", Amount: 200, Unit: "iu"},
+	}}}}
+	nutrients := macroResponseToProto(response).Data[0].Nutrients
+	if got := nutrients["306"]; got.Grams == nil || *got.Grams != grams {
+		t.Errorf("306 = %v, want %v grams", got, grams)
+	}
+	// Units without a gram conversion leave grams unset, as in JSON
+	if got := nutrients["320"]; got.Grams != nil {
+		t.Errorf("320 = %v, want no grams", got)
+	}
+}
@@ -0,0 +1,42 @@
+// hash.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// resultHash fingerprints a calculation: the request inputs and the computed
+// items. Identical requests against unchanged data hash the same, so clients
+// can use it for caching and change detection. Processing metadata is left
+// out since it varies between otherwise identical runs.
+func resultHash(request VolumeRequest, response MacroResponse) (string, error) {
+	canonical, err := canonicalJSON(map[string]any{
+		"request": request.Data,
+		"data":    response.Data,
+		"scale":   response.Scale,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalJSON encodes v with every object's keys sorted. Struct fields
+// marshal in declaration order, so v is round-tripped through generic maps,
+// which encoding/json always writes sorted.
+func canonicalJSON(v any) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic any
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
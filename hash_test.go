@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResultHash(t *testing.T) {
+	base := volumes(Volume{ObjectName: "rice", VolumeCups: 1}, Volume{ObjectName: "banana", VolumeCups: 0.5})
+	changedVolume := volumes(Volume{ObjectName: "rice", VolumeCups: 1.25}, Volume{ObjectName: "banana", VolumeCups: 0.5})
+	reordered := volumes(Volume{ObjectName: "banana", VolumeCups: 0.5}, Volume{ObjectName: "rice", VolumeCups: 1})
+	tests := []struct {
+		name     string
+		path     string
+		request  VolumeRequest
+		wantSame bool
+	}{
+		{"identical request", "/v1/calculate-macros", base, true},
+		{"processing metadata is left out", "/v1/calculate-macros?meta=true", base, true},
+		{"changed volume", "/v1/calculate-macros", changedVolume, false},
+		{"reordered items", "/v1/calculate-macros", reordered, false},
+	}
+	config := testConfig(t, "")
+	cacheTestFoods(t, config.DefaultDataset)
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+	first := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", base), http.StatusOK).ResultHash
+	if len(first) != 64 {
+		t.Fatalf("result_hash = %q, want a hex SHA-256", first)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, tt.path, tt.request), http.StatusOK).ResultHash
+			if (hash == first) != tt.wantSame {
+				t.Errorf("result_hash = %s, first = %s, want same %v", hash, first, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"map keys are sorted", map[string]any{"b": 1, "a": 2}, `{"a":2,"b":1}`},
+		{"struct fields are sorted", struct {
+			Z int `json:"z"`
+			A int `json:"a"`
+		}{1, 2}, `{"a":2,"z":1}`},
+		{"nested objects are sorted", map[string]any{"x": map[string]int{"d": 1, "c": 2}}, `{"x":{"c":2,"d":1}}`},
+		{"arrays keep their order", []int{3, 1, 2}, `[3,1,2]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := canonicalJSON(tt.v)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("canonicalJSON() = %s, %v; want %s", got, err, tt.want)
+			}
+		})
+	}
+}
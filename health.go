@@ -0,0 +1,32 @@
+// health.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds the checks behind /readyz so a hung cluster fails
+// the probe instead of stalling it
+const readinessTimeout = 5 * time.Second
+
+// healthz reports that the process is up and serving
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyz reports whether the food store answers, so traffic is only routed to
+// instances that can serve lookups
+func readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	if err := foodRepo.Ping(ctx); err != nil {
+		respondError(c, http.StatusServiceUnavailable, "not ready", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
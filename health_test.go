@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// unreachableRepo is a food store whose ping fails
+type unreachableRepo struct {
+	FoodRepository
+}
+
+func (unreachableRepo) Ping(ctx context.Context) error {
+	return errors.New("cluster unreachable")
+}
+
+func TestHealthProbes(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		store      string
+		wantStatus int
+	}{
+		{"process up", "/healthz", "ok", http.StatusOK},
+		{"process up while the store is down", "/healthz", "down", http.StatusOK},
+		{"process up before the store connects", "/healthz", "connecting", http.StatusOK},
+		{"ready", "/readyz", "ok", http.StatusOK},
+		{"store down", "/readyz", "down", http.StatusServiceUnavailable},
+		{"store not connected yet", "/readyz", "connecting", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, "")
+			switch tt.store {
+			case "down":
+				foodRepo = unreachableRepo{foodRepo}
+			case "connecting":
+				storageReady.Store(false)
+			}
+
+			w := doRequest(t, router, http.MethodGet, tt.path, nil)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// noFuzzy turns fuzzy matching off, so foods without a mapping are
+// resolved as unknown without reaching the database
+const noFuzzy = "fuzzy:\n  disabled: true\n"
+
+// testFoods is the FDC JSON snapshot the test stores are loaded from
+const testFoods = "testdata/foods.json"
+
+// testConfig parses and validates a config for the sqlite storage, as
+// loadAppConfig would, and makes it the current config for the test
+func testConfig(t *testing.T, content string) *Config {
+	t.Helper()
+	var config Config
+	if err := yaml.Unmarshal([]byte(content), &config); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+	if config.Storage == "" {
+		config.Storage = storageSQLite
+	}
+	if config.SQLite.Path == "" {
+		config.SQLite.Path = filepath.Join(t.TempDir(), "foods.db")
+	}
+	if err := config.validate(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+
+	previous := cfg
+	cfg = &config
+	t.Cleanup(func() { cfg = previous })
+	return &config
+}
+
+// setupServer sets up the globals main would for a config, with the
+// sqlite store loaded from testFoods, and returns the REST router
+func setupServer(t *testing.T, content string) *gin.Engine {
+	t.Helper()
+	config := testConfig(t, content)
+	if config.SQLite.Snapshot == "" {
+		config.SQLite.Snapshot = testFoods
+	}
+
+	previousRepo, previousDB, previousMappings := foodRepo, db, foodMappings
+	previousBreaker, previousCache, previousKeys := foodBreaker, foodDataCache, authKeys
+	previousLimiter, previousReady := clientLimiter.Load(), storageReady.Load()
+	t.Cleanup(func() {
+		foodRepo, db, foodMappings = previousRepo, previousDB, previousMappings
+		foodBreaker, foodDataCache, authKeys = previousBreaker, previousCache, previousKeys
+		clientLimiter.Store(previousLimiter)
+		storageReady.Store(previousReady)
+	})
+
+	foodBreaker = newCircuitBreaker(config.Breaker)
+	foodDataCache = newFoodCache(config.Cache)
+	authKeys = newJWKS(config.Auth)
+	clientLimiter.Store(newRateLimiter(config.RateLimit))
+
+	repo, err := openSQLite(config.SQLite, config.DefaultDataset)
+	if err != nil {
+		t.Fatalf("failed to open test store: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	foodRepo = repo
+	db = &Database{}
+	foodMappings = newMappingCache(newMappingStore(config, db))
+	storageReady.Store(true)
+
+	return newRouter(slog.Default())
+}
+
+// decode reads a JSON response into a T, failing the test on a status
+// other than want
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder, want int) T {
+	t.Helper()
+	var v T
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d: %s", w.Code, want, w.Body)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("invalid response %s: %v", w.Body, err)
+	}
+	return v
+}
+
+// doRequest sends a request through the router. A body that isn't a
+// string or nil is sent as JSON; headers are name, value pairs.
+func doRequest(t *testing.T, router http.Handler, method, path string, body any, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// ptr returns a pointer to v, for optional request fields
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// volumes builds a calculate-macros request body
+func volumes(items ...Volume) VolumeRequest {
+	var request VolumeRequest
+	request.Data.Volumes = items
+	return request
+}
+
+// cacheFood makes lookups of searchTerm in dataset find foods in the food
+// cache, so handlers can be tested without a database
+func cacheFood(t *testing.T, dataset, searchTerm string, foods ...FoodData) {
+	t.Helper()
+	if foodDataCache.config.Disabled {
+		previous := foodDataCache
+		foodDataCache = newFoodCache(CacheConfig{Size: 100, TTL: time.Hour})
+		t.Cleanup(func() { foodDataCache = previous })
+	}
+	foodDataCache.put(foodCacheKey(dataset, "", strings.ToLower(searchTerm)), foods)
+}
+
+// cacheTestFoods caches rice and banana records, so lookups of both
+// succeed without a database
+func cacheTestFoods(t *testing.T, dataset string) {
+	t.Helper()
+	cacheFood(t, dataset, defaultFoodMappings["rice"], FoodData{
+		FdcID:         1,
+		Description:   defaultFoodMappings["rice"],
+		FoodNutrients: nutrients("205", 28.0, "203", 2.7, "204", 0.3, "208", 130.0),
+		FoodPortions:  portions("1 cup", 158),
+	})
+	cacheFood(t, dataset, defaultFoodMappings["banana"], FoodData{
+		FdcID:         2,
+		Description:   defaultFoodMappings["banana"],
+		FoodNutrients: nutrients("205", 22.8, "203", 1.1, "204", 0.3, "208", 89.0),
+		FoodPortions:  portions("1 cup, sliced", 150),
+	})
+}
+
+// macrosNear compares macros up to the response rounding
+func macrosNear(a, b Macros) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 0.05 }
+	return near(a.Calories, b.Calories) && near(a.Carbs, b.Carbs) && near(a.Fat, b.Fat) && near(a.Protein, b.Protein)
+}
@@ -0,0 +1,267 @@
+// idempotency.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyConfig lets clients retry the POST endpoints that persist data
+// without persisting twice: a request sent with an Idempotency-Key header
+// is answered once, and retries with the same key get the stored response.
+// Keys are kept for TTL (default 24h). Without a collection the header is
+// ignored.
+type IdempotencyConfig struct {
+	Scope      string        `yaml:"scope"`
+	Collection string        `yaml:"collection"`
+	TTL        time.Duration `yaml:"ttl"`
+}
+
+func (i *IdempotencyConfig) enabled() bool {
+	return i.Collection != ""
+}
+
+func (i *IdempotencyConfig) validate() error {
+	if !i.enabled() {
+		return nil
+	}
+	if i.Scope == "" {
+		i.Scope = defaultKeyspaceName
+	}
+	for _, name := range []string{i.Scope, i.Collection} {
+		if name != defaultKeyspaceName && !keyspaceNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid keyspace name %q in idempotency config", name)
+		}
+	}
+	if i.TTL == 0 {
+		i.TTL = 24 * time.Hour
+	}
+	if i.TTL < 0 {
+		return errors.New("idempotency.ttl must be positive")
+	}
+	return nil
+}
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayHeader marks a response replayed for a retry
+	idempotentReplayHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotencyRecord is a request claimed by its key, and its response once
+// it completed
+type IdempotencyRecord struct {
+	RequestHash string    `json:"request_hash"`
+	CreatedAt   time.Time `json:"created_at"`
+	Completed   bool      `json:"completed"`
+	Status      int       `json:"status,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+}
+
+// idempotencyStore keeps idempotency records by document key
+type idempotencyStore interface {
+	// claim stores a new record, returning false when the key is already
+	// claimed
+	claim(ctx context.Context, docKey string, record IdempotencyRecord) (bool, error)
+	// get returns false when no record is stored under the key
+	get(ctx context.Context, docKey string) (IdempotencyRecord, bool, error)
+	complete(ctx context.Context, docKey string, record IdempotencyRecord) error
+	release(ctx context.Context, docKey string) error
+}
+
+// couchbaseIdempotency keeps one document per key, expiring after ttl
+type couchbaseIdempotency struct {
+	collection *gocb.Collection
+	ttl        time.Duration
+}
+
+func (s *couchbaseIdempotency) claim(ctx context.Context, docKey string, record IdempotencyRecord) (bool, error) {
+	_, err := s.collection.Insert(docKey, record, &gocb.InsertOptions{Context: ctx, Expiry: s.ttl})
+	if errors.Is(err, gocb.ErrDocumentExists) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *couchbaseIdempotency) get(ctx context.Context, docKey string) (IdempotencyRecord, bool, error) {
+	var record IdempotencyRecord
+	result, err := s.collection.Get(docKey, &gocb.GetOptions{Context: ctx})
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return record, false, nil
+	}
+	if err != nil {
+		return record, false, err
+	}
+	if err := result.Content(&record); err != nil {
+		return record, false, err
+	}
+	return record, true, nil
+}
+
+func (s *couchbaseIdempotency) complete(ctx context.Context, docKey string, record IdempotencyRecord) error {
+	_, err := s.collection.Replace(docKey, record, &gocb.ReplaceOptions{Context: ctx, Expiry: s.ttl})
+	return err
+}
+
+func (s *couchbaseIdempotency) release(ctx context.Context, docKey string) error {
+	_, err := s.collection.Remove(docKey, &gocb.RemoveOptions{Context: ctx})
+	return err
+}
+
+// idempotencyDocKey scopes a key to the client and route that sent it, so
+// clients can't replay each other's responses. The client is the current
+// user, else the API key, else the IP, which X-Forwarded-For only sets
+// when sent by one of server.trusted_proxies.
+func idempotencyDocKey(c *gin.Context, key string) string {
+	client := "ip:" + c.ClientIP()
+	if user := currentUser(c); user != "" {
+		client = "user:" + user
+	} else if id := c.GetString(ctxKeyAPIKeyID); id != "" {
+		client = "key:" + id
+	}
+	sum := sha256.Sum256([]byte(client + "\x00" + c.Request.Method + "\x00" + c.FullPath() + "\x00" + key))
+	return "idempotency::" + hex.EncodeToString(sum[:])
+}
+
+// idempotencyRequestHash identifies the request a key was used for: its
+// body, query options and response version, all of which change the answer
+func idempotencyRequestHash(c *gin.Context, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "v%d\x00%s\x00", responseVersion(c), c.Request.URL.RawQuery)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// recordingWriter keeps a copy of the response it writes
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent answers a request carrying an Idempotency-Key once. The key
+// is claimed before the handler runs, so a retry arriving while the first
+// attempt is still running gets 409 instead of running it again. Reusing a
+// key for a different body, query or response version gets 422. Server
+// errors release the key, leaving the request free to be retried.
+func idempotent(c *gin.Context) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" || db == nil || db.idempotency == nil {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondTooLarge(c, tooLarge.Limit)
+			c.Abort()
+			return
+		}
+		respondError(c, http.StatusBadRequest, "failed to read request body", err)
+		c.Abort()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	requestHash := idempotencyRequestHash(c, body)
+
+	ctx := c.Request.Context()
+	docKey := idempotencyDocKey(c, key)
+	claim := IdempotencyRecord{RequestHash: requestHash, CreatedAt: time.Now().UTC()}
+	claimed, err := db.idempotency.claim(ctx, docKey, claim)
+	if err == nil && !claimed {
+		replayIdempotent(c, docKey, requestHash)
+		return
+	}
+	if err != nil {
+		if !timedOut(c) {
+			respondError(c, http.StatusBadGateway, "failed to claim idempotency key", err)
+		}
+		c.Abort()
+		return
+	}
+
+	writer := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	c.Next()
+	c.Writer = writer.ResponseWriter
+
+	// The request's own deadline may have passed; storing the outcome
+	// mustn't be cut short by it
+	storeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readinessTimeout)
+	defer cancel()
+	status := writer.Status()
+	if status >= http.StatusInternalServerError {
+		if err := db.idempotency.release(storeCtx, docKey); err != nil {
+			slog.WarnContext(ctx, "failed to release idempotency key", "error", err)
+		}
+		return
+	}
+	record := claim
+	record.Completed = true
+	record.Status = status
+	record.ContentType = writer.Header().Get("Content-Type")
+	record.Body = writer.body.Bytes()
+	if err := db.idempotency.complete(storeCtx, docKey, record); err != nil {
+		slog.WarnContext(ctx, "failed to store idempotent response", "error", err)
+	}
+}
+
+// replayIdempotent answers a retry from the record its key claimed
+func replayIdempotent(c *gin.Context, docKey, requestHash string) {
+	defer c.Abort()
+	record, found, err := db.idempotency.get(c.Request.Context(), docKey)
+	if err != nil {
+		if !timedOut(c) {
+			respondError(c, http.StatusBadGateway, "failed to load idempotent response", err)
+		}
+		return
+	}
+	if !found {
+		// Released by a failed attempt, or expired, since the claim
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusConflict, gin.H{"error": "the request with this Idempotency-Key is being retried; try again"})
+		return
+	}
+
+	switch {
+	case record.RequestHash != requestHash:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+	case !record.Completed:
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is still in progress"})
+	default:
+		c.Header(idempotentReplayHeader, "true")
+		c.Data(record.Status, record.ContentType, record.Body)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// memoryIdempotency is an idempotency store kept in memory
+type memoryIdempotency struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+func (s *memoryIdempotency) claim(ctx context.Context, docKey string, record IdempotencyRecord) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[docKey]; ok {
+		return false, nil
+	}
+	if s.records == nil {
+		s.records = make(map[string]IdempotencyRecord)
+	}
+	s.records[docKey] = record
+	return true, nil
+}
+
+func (s *memoryIdempotency) get(ctx context.Context, docKey string) (IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[docKey]
+	return record, ok, nil
+}
+
+func (s *memoryIdempotency) complete(ctx context.Context, docKey string, record IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[docKey] = record
+	return nil
+}
+
+func (s *memoryIdempotency) release(ctx context.Context, docKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, docKey)
+	return nil
+}
+
+// idempotentServer sets up a server keeping idempotency records and meals
+// in memory, with users taken from X-User-ID
+func idempotentServer(t *testing.T) http.Handler {
+	t.Helper()
+	router := setupServer(t, trustUserHeader)
+	useMappings(t, &fileMappings{path: writeConfig(t, "rice: Rice, cooked, NFS\n")})
+	cfg.Meals = MealsConfig{Collection: "meals"}
+	cfg.Idempotency = IdempotencyConfig{Collection: "idempotency"}
+	if err := cfg.Meals.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Idempotency.validate(); err != nil {
+		t.Fatal(err)
+	}
+	db.meals = &memoryMeals{}
+	db.idempotency = &memoryIdempotency{}
+	return router
+}
+
+func TestIdempotencyKeysAreScopedToUsers(t *testing.T) {
+	apple := LogMealRequest{Entries: []ManualEntry{{Name: "apple", Macros: Macros{Calories: 95}}}}
+	pear := LogMealRequest{Entries: []ManualEntry{{Name: "pear", Macros: Macros{Calories: 100}}}}
+	// Steps run in order against one server, all with the same key
+	tests := []struct {
+		name         string
+		user         string
+		meal         LogMealRequest
+		wantStatus   int
+		wantReplayed bool
+	}{
+		{"first request", "alice", apple, http.StatusCreated, false},
+		{"retry is replayed", "alice", apple, http.StatusCreated, true},
+		{"other user's request runs", "bob", apple, http.StatusCreated, false},
+		{"other user's retry is replayed", "bob", apple, http.StatusCreated, true},
+		{"other user may send a different body", "carol", pear, http.StatusCreated, false},
+		{"reused key for a different body", "alice", pear, http.StatusUnprocessableEntity, false},
+	}
+	router := idempotentServer(t)
+
+	first := make(map[string]Meal)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodPost, "/v1/meals", tt.meal, idempotencyKeyHeader, "meal-1", userHeader, tt.user)
+			if replayed := w.Header().Get(idempotentReplayHeader) == "true"; replayed != tt.wantReplayed {
+				t.Errorf("replayed = %v, want %v", replayed, tt.wantReplayed)
+			}
+			if tt.wantStatus != http.StatusCreated {
+				if w.Code != tt.wantStatus {
+					t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+				}
+				return
+			}
+			meal := decode[Meal](t, w, http.StatusCreated)
+			if meal.UserID != tt.user {
+				t.Errorf("meal of %s answered to %s", meal.UserID, tt.user)
+			}
+			if previous, ok := first[tt.user]; ok && previous.ID != meal.ID {
+				t.Errorf("retry logged meal %s, want the replayed %s", meal.ID, previous.ID)
+			}
+			first[tt.user] = meal
+		})
+	}
+
+	meals := db.meals.(*memoryMeals)
+	if len(meals.meals) != 3 {
+		t.Errorf("stored %d meals, want one per user", len(meals.meals))
+	}
+}
+
+func TestIdempotencyKeysAreScopedToRequests(t *testing.T) {
+	// Steps run in order against one server, all with the same key and body
+	tests := []struct {
+		name         string
+		path         string
+		accept       string
+		wantStatus   int
+		wantReplayed bool
+	}{
+		{"first request", "/v1/calculate-macros", "", http.StatusOK, false},
+		{"retry is replayed", "/v1/calculate-macros", "", http.StatusOK, true},
+		{"other query", "/v1/calculate-macros?per_gram=true", "", http.StatusUnprocessableEntity, false},
+		{"other response version", "/v1/calculate-macros", mediaTypeV2, http.StatusUnprocessableEntity, false},
+	}
+	router := idempotentServer(t)
+	body := volumes(Volume{ObjectName: "rice", VolumeCups: 1})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := []string{idempotencyKeyHeader, "calc-1", userHeader, "alice"}
+			if tt.accept != "" {
+				headers = append(headers, "Accept", tt.accept)
+			}
+			w := doRequest(t, router, http.MethodPost, tt.path, body, headers...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if replayed := w.Header().Get(idempotentReplayHeader) == "true"; replayed != tt.wantReplayed {
+				t.Errorf("replayed = %v, want %v", replayed, tt.wantReplayed)
+			}
+		})
+	}
+}
+
+func TestIdempotencyIgnoresForwardedFor(t *testing.T) {
+	router := idempotentServer(t)
+	send := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		data, err := json.Marshal(volumes(Volume{ObjectName: "rice", VolumeCups: 1}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/v1/calculate-macros", bytes.NewReader(data))
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotencyKeyHeader, "calc-1")
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	// Anonymous clients are scoped by IP, which a client other than a
+	// trusted proxy can't claim with X-Forwarded-For
+	if w := send("198.51.100.7:1234", ""); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if w := send("192.0.2.1:1234", "198.51.100.7"); w.Header().Get(idempotentReplayHeader) == "true" {
+		t.Error("response of 198.51.100.7 replayed to a client claiming its IP")
+	}
+	if w := send("198.51.100.7:1234", ""); w.Header().Get(idempotentReplayHeader) != "true" {
+		t.Error("retry from 198.51.100.7 not replayed")
+	}
+}
@@ -0,0 +1,481 @@
+// import.go
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// importOptions control how a download is stored
+type importOptions struct {
+	dataset     string
+	dataVersion string
+	expiry      time.Duration
+	batchSize   int
+	// checkpoint is the file progress is recorded in; empty disables
+	// resuming
+	checkpoint string
+}
+
+// importCheckpoint records how far an import got. Documents are imported
+// in a fixed order, so a restarted import skips the ones already stored.
+type importCheckpoint struct {
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	Imported int    `json:"imported"`
+}
+
+// runImport implements the import command:
+//
+//	bytemi-fdc-api import [flags] <FoodData_Central_survey_food_json_*.zip>
+//
+// It ingests an FDC download (JSON, or CSV in a zip) into a dataset of the
+// configured storage.
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dataset := flags.String("dataset", "", "dataset to import into (default: default_dataset)")
+	dataVersion := flags.String("data-version", "", "data version stamped on every document, e.g. 2023-10")
+	expiry := flags.Duration("expiry", 0, "remove imported documents after this long (couchbase only; default: the dataset's expiry)")
+	batchSize := flags.Int("batch", 500, "documents stored per batch")
+	resume := flags.Bool("resume", true, "continue an interrupted import of the same file")
+	offline := flags.Bool("offline", false, "import into the embedded SQLite database")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bytemi-fdc-api import [flags] <file.json|file.zip>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *batchSize <= 0 || *expiry < 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	file := flags.Arg(0)
+
+	storage := ""
+	if *offline {
+		storage = storageSQLite
+	}
+	var err error
+	cfg, err = loadAppConfig(storage)
+	if err != nil {
+		fatal("failed to load config", err)
+	}
+	setupLogging(cfg.Logging.Format)
+	// The snapshot is for serving; it would be loaded before the import
+	cfg.SQLite.Snapshot = ""
+
+	options := importOptions{
+		dataset:     *dataset,
+		dataVersion: *dataVersion,
+		expiry:      *expiry,
+		batchSize:   *batchSize,
+	}
+	if options.dataset == "" {
+		options.dataset = cfg.DefaultDataset
+	}
+	if _, ok := cfg.Datasets[options.dataset]; !ok {
+		fatal("failed to import", fmt.Errorf("unknown dataset: %s", options.dataset))
+	}
+	if *resume {
+		options.checkpoint = file + ".import-progress"
+	}
+
+	if err := connectStorage(); err != nil {
+		fatal("failed to initialize database", err)
+	}
+	defer foodRepo.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	imported, err := importFoods(ctx, foodRepo, file, options)
+	if err != nil {
+		foodRepo.Close()
+		fatal("import failed", err)
+	}
+	slog.Info("import complete", "file", file, "dataset", options.dataset, "foods", imported)
+}
+
+// importFoods stores every food of a download and returns how many were
+// stored, including ones stored by an earlier run that was resumed
+func importFoods(ctx context.Context, repo FoodRepository, file string, options importOptions) (int, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+
+	done := importCheckpoint{File: file, Size: info.Size()}
+	if options.checkpoint != "" {
+		if previous, ok := readCheckpoint(options.checkpoint); ok && previous.File == done.File && previous.Size == done.Size {
+			done.Imported = previous.Imported
+			slog.Info("resuming import", "file", file, "already_imported", done.Imported)
+		}
+	}
+
+	start := time.Now()
+	seen := 0
+	batch := make([]json.RawMessage, 0, options.batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := repo.StoreFoods(ctx, options.dataset, batch, options.expiry); err != nil {
+			return err
+		}
+		done.Imported += len(batch)
+		batch = batch[:0]
+		if options.checkpoint != "" {
+			if err := writeCheckpoint(options.checkpoint, done); err != nil {
+				return err
+			}
+		}
+		slog.Info("import progress", "imported", done.Imported, "foods_per_second", int(float64(done.Imported)/time.Since(start).Seconds()))
+		return nil
+	}
+
+	err = readDownload(file, func(document json.RawMessage) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		seen++
+		if seen <= done.Imported {
+			return nil
+		}
+		if options.dataVersion != "" {
+			stamped, err := stampDataVersion(document, options.dataVersion)
+			if err != nil {
+				return err
+			}
+			document = stamped
+		}
+		batch = append(batch, document)
+		if len(batch) < options.batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return done.Imported, err
+	}
+	if options.checkpoint != "" {
+		os.Remove(options.checkpoint)
+	}
+	return done.Imported, nil
+}
+
+func readCheckpoint(file string) (importCheckpoint, bool) {
+	var checkpoint importCheckpoint
+	data, err := os.ReadFile(file)
+	if err != nil || json.Unmarshal(data, &checkpoint) != nil {
+		return checkpoint, false
+	}
+	return checkpoint, true
+}
+
+// writeCheckpoint replaces the checkpoint atomically, so an interrupted
+// write can't lose the progress recorded before
+func writeCheckpoint(file string, checkpoint importCheckpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(file+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// stampDataVersion sets a document's dataVersion, keeping every other field
+// as it is
+func stampDataVersion(document json.RawMessage, version string) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(document, &fields); err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+	fields["dataVersion"] = encoded
+	return json.Marshal(fields)
+}
+
+// readDownload calls fn with every food of an FDC download in FDC JSON
+// form. Plain .json files and zips holding either the JSON or the CSV
+// download are accepted.
+func readDownload(file string, fn func(json.RawMessage) error) error {
+	if !strings.EqualFold(path.Ext(file), ".zip") {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return readFoodDocuments(f, fn)
+	}
+
+	archive, err := zip.OpenReader(file)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		// Downloads nest their files in a dated directory
+		files[strings.ToLower(path.Base(f.Name))] = f
+	}
+	if _, ok := files["food.csv"]; ok {
+		return readCSVDownload(files, fn)
+	}
+	for name, f := range files {
+		if strings.HasSuffix(name, ".json") {
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			return readFoodDocuments(r, fn)
+		}
+	}
+	return errors.New("zip holds neither food.csv nor a .json download")
+}
+
+// readCSVDownload assembles FDC JSON documents from the tables of a CSV
+// download. Foods are emitted in fdc_id order so a resumed import skips
+// the same ones.
+func readCSVDownload(files map[string]*zip.File, fn func(json.RawMessage) error) error {
+	foods := make(map[int]*FoodData)
+	err := readCSV(files, "food.csv", true, func(row csvRow) error {
+		id, err := row.int("fdc_id")
+		if err != nil {
+			return err
+		}
+		foods[id] = &FoodData{FdcID: id, Description: row.get("description")}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	type nutrientInfo struct{ name, number, unit string }
+	nutrients := make(map[string]nutrientInfo)
+	err = readCSV(files, "nutrient.csv", true, func(row csvRow) error {
+		// Numbers are sometimes written as decimals, e.g. "208.0"
+		number := strings.TrimSuffix(row.get("nutrient_nbr"), ".0")
+		nutrients[row.get("id")] = nutrientInfo{name: row.get("name"), number: number, unit: row.get("unit_name")}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = readCSV(files, "food_nutrient.csv", true, func(row csvRow) error {
+		id, err := row.int("fdc_id")
+		if err != nil {
+			return err
+		}
+		food, ok := foods[id]
+		info, known := nutrients[row.get("nutrient_id")]
+		if !ok || !known || info.number == "" {
+			return nil
+		}
+		amount, err := row.float("amount")
+		if err != nil {
+			return err
+		}
+		var n Nutrient
+		n.Amount = amount
+		n.Nutrient.Name, n.Nutrient.Number, n.Nutrient.UnitName = info.name, info.number, info.unit
+		food.FoodNutrients = append(food.FoodNutrients, n)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	units := make(map[string]string)
+	err = readCSV(files, "measure_unit.csv", false, func(row csvRow) error {
+		units[row.get("id")] = row.get("name")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = readCSV(files, "food_portion.csv", false, func(row csvRow) error {
+		id, err := row.int("fdc_id")
+		if err != nil {
+			return err
+		}
+		food, ok := foods[id]
+		if !ok {
+			return nil
+		}
+		var p Portion
+		if p.ID, err = row.int("id"); err != nil {
+			return err
+		}
+		if p.GramWeight, err = row.float("gram_weight"); err != nil {
+			return err
+		}
+		p.SequenceNumber, _ = row.int("seq_num")
+		p.PortionDescription = row.get("portion_description")
+		p.Amount, _ = strconv.ParseFloat(row.get("amount"), 64)
+		p.Modifier = row.get("modifier")
+		p.MeasureUnit.ID, _ = row.int("measure_unit_id")
+		p.MeasureUnit.Name = units[row.get("measure_unit_id")]
+		food.FoodPortions = append(food.FoodPortions, p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// FNDDS foods are categorized by WWEIA, the other datasets by food
+	// category
+	wweia := make(map[string]string)
+	err = readCSV(files, "wweia_food_category.csv", false, func(row csvRow) error {
+		wweia[row.get("wweia_food_category")] = row.get("wweia_food_category_description")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = readCSV(files, "survey_fndds_food.csv", false, func(row csvRow) error {
+		id, err := row.int("fdc_id")
+		if err != nil {
+			return err
+		}
+		if food, ok := foods[id]; ok {
+			food.WWEIAFoodCategory.Description = wweia[row.get("wweia_category_code")]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	categories := make(map[string]string)
+	err = readCSV(files, "food_category.csv", false, func(row csvRow) error {
+		categories[row.get("id")] = row.get("description")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(categories) > 0 {
+		err = readCSV(files, "food.csv", true, func(row csvRow) error {
+			id, _ := row.int("fdc_id")
+			if food, ok := foods[id]; ok {
+				food.FoodCategory.Description = categories[row.get("food_category_id")]
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	ids := make([]int, 0, len(foods))
+	for id := range foods {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		food := foods[id]
+		sort.SliceStable(food.FoodPortions, func(i, j int) bool {
+			return food.FoodPortions[i].SequenceNumber < food.FoodPortions[j].SequenceNumber
+		})
+		document, err := json.Marshal(food)
+		if err != nil {
+			return err
+		}
+		if err := fn(document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvRow reads the fields of a CSV record by column name
+type csvRow struct {
+	columns map[string]int
+	record  []string
+}
+
+func (r csvRow) get(column string) string {
+	if i, ok := r.columns[column]; ok && i < len(r.record) {
+		return r.record[i]
+	}
+	return ""
+}
+
+func (r csvRow) int(column string) (int, error) {
+	value, err := strconv.Atoi(r.get(column))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", column, r.get(column))
+	}
+	return value, nil
+}
+
+func (r csvRow) float(column string) (float64, error) {
+	value, err := strconv.ParseFloat(r.get(column), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", column, r.get(column))
+	}
+	return value, nil
+}
+
+// readCSV calls fn for every record of a table in the download. A table
+// that isn't required may be missing.
+func readCSV(files map[string]*zip.File, name string, required bool, fn func(csvRow) error) error {
+	f, ok := files[name]
+	if !ok {
+		if required {
+			return fmt.Errorf("download has no %s", name)
+		}
+		return nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[strings.TrimPrefix(column, "\ufeff")] = i
+	}
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if err := fn(csvRow{columns: columns, record: record}); err != nil {
+			return fmt.Errorf("%s line %d: %v", name, line, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recordingRepo records the fdcIds of the foods stored through it, failing
+// every batch after the first failAfter when that is not zero
+type recordingRepo struct {
+	FoodRepository
+	failAfter int
+	batches   int
+	stored    []int
+	versions  []string
+}
+
+func (r *recordingRepo) StoreFoods(_ context.Context, _ string, documents []json.RawMessage, _ time.Duration) error {
+	r.batches++
+	if r.failAfter > 0 && r.batches > r.failAfter {
+		return errors.New("store unavailable")
+	}
+	for _, document := range documents {
+		var food FoodData
+		if err := json.Unmarshal(document, &food); err != nil {
+			return err
+		}
+		r.stored = append(r.stored, food.FdcID)
+		r.versions = append(r.versions, food.DataVersion)
+	}
+	return nil
+}
+
+func TestImportFoodsResumes(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "foods.json")
+	if err := os.WriteFile(file, []byte(snapshot), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	options := importOptions{dataset: "fndds", dataVersion: "2024-04", batchSize: 1, checkpoint: file + ".import-progress"}
+
+	interrupted := &recordingRepo{failAfter: 2}
+	if imported, err := importFoods(context.Background(), interrupted, file, options); err == nil || imported != 2 {
+		t.Fatalf("importFoods() = %d, %v; want 2 imported and an error", imported, err)
+	}
+	resumed := &recordingRepo{}
+	imported, err := importFoods(context.Background(), resumed, file, options)
+	if err != nil || imported != 3 {
+		t.Fatalf("resumed importFoods() = %d, %v; want 3", imported, err)
+	}
+	// The snapshot lists fdcIds 3, 1, 2
+	if !slices.Equal(interrupted.stored, []int{3, 1}) || !slices.Equal(resumed.stored, []int{2}) {
+		t.Errorf("stored %v, then %v; want [3 1], then [2]", interrupted.stored, resumed.stored)
+	}
+	if !slices.Equal(resumed.versions, []string{"2024-04"}) {
+		t.Errorf("data versions = %v, want every document stamped 2024-04", resumed.versions)
+	}
+	if _, err := os.Stat(options.checkpoint); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("checkpoint left behind after a complete import: %v", err)
+	}
+
+	// Without a checkpoint the whole file is imported again
+	again := &recordingRepo{}
+	if imported, err := importFoods(context.Background(), again, file, options); err != nil || imported != 3 || len(again.stored) != 3 {
+		t.Errorf("second importFoods() = %d, %v, storing %v; want all 3", imported, err, again.stored)
+	}
+}
+
+// writeZip writes a zip holding the named files under a dated directory,
+// as FDC downloads do
+func writeZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "download.zip")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := archive.Create("FoodData_Central_csv_2024-04-18/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReadCSVDownload(t *testing.T) {
+	file := writeZip(t, map[string]string{
+		"food.csv":                    "\ufefffdc_id,data_type,description\n20,survey_fndds_food,\"Rice, cooked, NFS\"\n10,survey_fndds_food,\"Banana, raw\"\n",
+		"nutrient.csv":                "id,name,unit_name,nutrient_nbr\n1005,\"Carbohydrate, by difference\",G,205.0\n1008,Energy,KCAL,208\n2000,Unnumbered,G,\n",
+		"food_nutrient.csv":           "id,fdc_id,nutrient_id,amount\n1,20,1005,28\n2,20,1008,130\n3,20,2000,5\n4,99,1005,1\n",
+		"measure_unit.csv":            "id,name\n1000,cup\n",
+		"food_portion.csv":            "id,fdc_id,seq_num,amount,measure_unit_id,portion_description,modifier,gram_weight\n7,20,2,1,9999,1 spoonful,,40\n6,20,1,1,1000,1 cup,,158\n",
+		"wweia_food_category.csv":     "wweia_food_category,wweia_food_category_description\n4002,Rice\n",
+		"survey_fndds_food.csv":       "fdc_id,food_code,wweia_category_code\n20,56205000,4002\n",
+		"unrelated_table_ignored.csv": "a,b\n1,2\n",
+	})
+
+	var foods []FoodData
+	err := readDownload(file, func(document json.RawMessage) error {
+		var food FoodData
+		if err := json.Unmarshal(document, &food); err != nil {
+			return err
+		}
+		foods = append(foods, food)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("readDownload() error = %v", err)
+	}
+	if !slices.Equal(fdcIDs(foods), []int{10, 20}) {
+		t.Fatalf("foods = %v, want [10 20] in fdc_id order", fdcIDs(foods))
+	}
+	rice := foods[1]
+	if rice.Description != "Rice, cooked, NFS" || rice.WWEIAFoodCategory.Description != "Rice" {
+		t.Errorf("rice = %q in %q, want its description and WWEIA category", rice.Description, rice.WWEIAFoodCategory.Description)
+	}
+	// Nutrients without a number are left out; decimal numbers are trimmed
+	if len(rice.FoodNutrients) != 2 || rice.FoodNutrients[0].Nutrient.Number != "205" || rice.FoodNutrients[1].Amount != 130 {
+		t.Errorf("nutrients = %+v, want carbs 205 and energy 130", rice.FoodNutrients)
+	}
+	if len(rice.FoodPortions) != 2 || rice.FoodPortions[0].PortionDescription != "1 cup" || rice.FoodPortions[0].MeasureUnit.Name != "cup" || rice.FoodPortions[1].GramWeight != 40 {
+		t.Errorf("portions = %+v, want the cup, then the spoonful", rice.FoodPortions)
+	}
+}
+
+func TestReadDownloadRejectsOtherZips(t *testing.T) {
+	file := writeZip(t, map[string]string{"readme.txt": "not a download"})
+	if err := readDownload(file, func(json.RawMessage) error { return nil }); err == nil {
+		t.Error("readDownload() succeeded, want an error")
+	}
+	file = writeZip(t, map[string]string{"food.csv": "fdc_id,description\nabc,Rice\n", "nutrient.csv": "id\n", "food_nutrient.csv": "id\n"})
+	if err := readDownload(file, func(json.RawMessage) error { return nil }); err == nil {
+		t.Error("readDownload() accepted an invalid fdc_id")
+	}
+}
@@ -0,0 +1,142 @@
+// indexes.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// IndexWaitConfig makes startup wait until the listed indexes are online,
+// so the first lookups don't fail while indexes are still building
+type IndexWaitConfig struct {
+	Names []string `yaml:"names"`
+	// Timeout is how long to wait before giving up; defaults to 5m
+	Timeout time.Duration `yaml:"timeout"`
+	// Interval is the delay between polls; defaults to 5s
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (w *IndexWaitConfig) validate() error {
+	if w.Timeout == 0 {
+		w.Timeout = 5 * time.Minute
+	}
+	if w.Interval == 0 {
+		w.Interval = 5 * time.Second
+	}
+	if w.Timeout < 0 || w.Interval < 0 {
+		return errors.New("couchdb.wait_for_indexes timeout and interval must be positive")
+	}
+	return nil
+}
+
+// errIndexesNotOnline is returned when the indexes didn't come online before
+// the deadline
+var errIndexesNotOnline = errors.New("indexes not online")
+
+// waitForIndexes polls system:indexes until every configured index of the
+// bucket is online
+func waitForIndexes(reader indexStateReader, bucket string, config IndexWaitConfig) error {
+	if len(config.Names) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	for {
+		pending, err := pendingIndexes(ctx, reader, bucket, config.Names)
+		switch {
+		case err == nil && len(pending) == 0:
+			slog.Info("required indexes are online", "indexes", len(config.Names))
+			return nil
+		case err != nil:
+			slog.Warn("failed to read index states", "error", err)
+		default:
+			slog.Info("waiting for indexes to come online", "pending", pending)
+		}
+
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return fmt.Errorf("%w after %s: %v", errIndexesNotOnline, config.Timeout, err)
+			}
+			return fmt.Errorf("%w after %s: %v", errIndexesNotOnline, config.Timeout, pending)
+		case <-time.After(config.Interval):
+		}
+	}
+}
+
+// indexStateReader reads the state of the named indexes of a bucket, keyed
+// by index name; indexes that don't exist are left out
+type indexStateReader interface {
+	indexStates(ctx context.Context, bucket string, names []string) (map[string]string, error)
+}
+
+// clusterIndexes reads index states from system:indexes
+type clusterIndexes struct {
+	cluster *gocb.Cluster
+}
+
+func (c clusterIndexes) indexStates(ctx context.Context, bucket string, names []string) (map[string]string, error) {
+	// Indexes on the default collection are keyed by bucket, the others
+	// carry the bucket in bucket_id
+	result, err := c.cluster.Query(
+		"SELECT i.name, i.state FROM system:indexes i WHERE i.name IN $1 AND (i.bucket_id = $2 OR (i.bucket_id IS MISSING AND i.keyspace_id = $2))",
+		&gocb.QueryOptions{
+			PositionalParameters: []interface{}{names, bucket},
+			Context:              ctx,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	states := make(map[string]string, len(names))
+	for result.Next() {
+		var index struct {
+			Name  string `json:"name"`
+			State string `json:"state"`
+		}
+		if err := result.Row(&index); err != nil {
+			return nil, err
+		}
+		// An index name can repeat across collections; any copy not
+		// online keeps it pending
+		if states[index.Name] == "" || index.State != "online" {
+			states[index.Name] = index.State
+		}
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// pendingIndexes returns the named indexes that aren't online yet, with
+// their state ("missing" when the index doesn't exist)
+func pendingIndexes(ctx context.Context, reader indexStateReader, bucket string, names []string) ([]string, error) {
+	states, err := reader.indexStates(ctx, bucket, names)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for _, name := range names {
+		state, ok := states[name]
+		if !ok {
+			state = "missing"
+		}
+		if state != "online" {
+			pending = append(pending, fmt.Sprintf("%s (%s)", name, state))
+		}
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeIndexes reports the indexes building until onlineAfter polls, then
+// online; a negative onlineAfter never brings them online
+type fakeIndexes struct {
+	onlineAfter int32
+	err         error
+	polls       atomic.Int32
+}
+
+func (f *fakeIndexes) indexStates(ctx context.Context, bucket string, names []string) (map[string]string, error) {
+	poll := f.polls.Add(1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	states := make(map[string]string, len(names))
+	for _, name := range names {
+		states[name] = "building"
+		if f.onlineAfter >= 0 && poll > f.onlineAfter {
+			states[name] = "online"
+		}
+	}
+	return states, nil
+}
+
+func TestWaitForIndexes(t *testing.T) {
+	config := IndexWaitConfig{
+		Names:    []string{"ix_food_description", "ix_food_data_version"},
+		Timeout:  100 * time.Millisecond,
+		Interval: 5 * time.Millisecond,
+	}
+	tests := []struct {
+		name      string
+		indexes   *fakeIndexes
+		config    IndexWaitConfig
+		wantErr   string
+		wantPolls int32
+	}{
+		{"already online", &fakeIndexes{}, config, "", 1},
+		{"online after a delay", &fakeIndexes{onlineAfter: 3}, config, "", 4},
+		{"never online", &fakeIndexes{onlineAfter: -1}, config, "ix_food_data_version (building)", 0},
+		{"states unreadable", &fakeIndexes{err: errors.New("query service unavailable")}, config, "query service unavailable", 0},
+		{"nothing to wait for", &fakeIndexes{onlineAfter: -1}, IndexWaitConfig{Timeout: time.Millisecond}, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			err := waitForIndexes(tt.indexes, "fndds", tt.config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("waitForIndexes() = %v", err)
+				}
+				if polls := tt.indexes.polls.Load(); polls != tt.wantPolls {
+					t.Errorf("polled %d times, want %d", polls, tt.wantPolls)
+				}
+				return
+			}
+			if !errors.Is(err, errIndexesNotOnline) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("waitForIndexes() = %v, want %v mentioning %q", err, errIndexesNotOnline, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed < tt.config.Timeout {
+				t.Errorf("gave up after %s, before the %s deadline", elapsed, tt.config.Timeout)
+			}
+		})
+	}
+}
+
+func TestPendingIndexes(t *testing.T) {
+	tests := []struct {
+		name   string
+		states staticIndexes
+		want   string
+	}{
+		{"all online", staticIndexes{"ix_a": "online", "ix_b": "online"}, ""},
+		{"building", staticIndexes{"ix_a": "online", "ix_b": "building"}, "ix_b (building)"},
+		{"missing", staticIndexes{"ix_b": "deferred"}, "ix_a (missing), ix_b (deferred)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pending, err := pendingIndexes(context.Background(), tt.states, "fndds", []string{"ix_b", "ix_a"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(pending, ", "); got != tt.want {
+				t.Errorf("pending = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// staticIndexes reports fixed index states
+type staticIndexes map[string]string
+
+func (s staticIndexes) indexStates(ctx context.Context, bucket string, names []string) (map[string]string, error) {
+	return s, nil
+}
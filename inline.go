@@ -0,0 +1,73 @@
+// inline.go
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InlineRequest carries nutrient data supplied by the client, together with
+// either a weight or a volume and density to scale it to
+type InlineRequest struct {
+	Nutrients          []Nutrient `json:"nutrients"`
+	WeightGrams        *float64   `json:"weight_grams,omitempty"`
+	VolumeCups         *float64   `json:"volume_cups,omitempty"`
+	DensityGramsPerCup *float64   `json:"density_grams_per_cup,omitempty"`
+}
+
+type InlineResponse struct {
+	Macros           Macros  `json:"macros"`
+	CalculatedWeight float64 `json:"calculated_weight"`
+	CaloriesComputed bool    `json:"calories_computed,omitempty"`
+
+	NutrientStatus map[string]string `json:"nutrient_status"`
+}
+
+// grams resolves the weight the nutrients are scaled to
+func (r InlineRequest) grams() (float64, error) {
+	switch {
+	case r.WeightGrams != nil && (r.VolumeCups != nil || r.DensityGramsPerCup != nil):
+		return 0, errors.New("weight_grams can't be combined with volume_cups and density_grams_per_cup")
+	case r.WeightGrams != nil:
+		if *r.WeightGrams < 0 {
+			return 0, errors.New("weight_grams must not be negative")
+		}
+		return *r.WeightGrams, nil
+	case r.VolumeCups != nil && r.DensityGramsPerCup != nil:
+		if *r.VolumeCups < 0 {
+			return 0, errors.New("volume_cups must not be negative")
+		}
+		if *r.DensityGramsPerCup <= 0 {
+			return 0, errors.New("density_grams_per_cup must be positive")
+		}
+		return *r.VolumeCups * *r.DensityGramsPerCup, nil
+	default:
+		return 0, errors.New("either weight_grams or volume_cups with density_grams_per_cup is required")
+	}
+}
+
+// calculateMacrosInline runs the macro scaling on client-supplied nutrients
+// without touching the database
+func calculateMacrosInline(c *gin.Context) {
+	var request InlineRequest
+	if err := bindJSON(c, &request); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+
+	grams, err := request.grams()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	macros, caloriesComputed, nutrients := calculateMacrosForGrams(request.Nutrients, grams, grams)
+	c.JSON(http.StatusOK, InlineResponse{
+		Macros:           macros,
+		CalculatedWeight: grams,
+		CaloriesComputed: caloriesComputed,
+		NutrientStatus:   nutrients,
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func inlineRouter() *gin.Engine {
+	router := gin.New()
+	router.POST("/v1/calculate-macros/inline", calculateMacrosInline)
+	return router
+}
+
+func TestInlineMatchesDatabase(t *testing.T) {
+	// Nutrients and portions as stored for the food
+	food := &FoodData{
+		FoodNutrients: nutrients("208", 89.0, "203", 1.09, "204", 0.33, "205", 22.84),
+		FoodPortions:  []Portion{{PortionDescription: "1 cup, sliced", GramWeight: 150}},
+	}
+	tests := []struct {
+		name   string
+		volume Volume
+		inline InlineRequest
+	}{
+		{
+			name:   "cup portion by volume and density",
+			volume: Volume{ObjectName: "banana", VolumeCups: 2},
+			inline: InlineRequest{VolumeCups: ptr(2.0), DensityGramsPerCup: ptr(150.0)},
+		},
+		{
+			name:   "cup portion by weight",
+			volume: Volume{ObjectName: "banana", VolumeCups: 0.5},
+			inline: InlineRequest{WeightGrams: ptr(75.0)},
+		},
+		{
+			name:   "density override",
+			volume: Volume{ObjectName: "banana", VolumeCups: 1.5, DensityGramsPerCup: ptr(100.0)},
+			inline: InlineRequest{VolumeCups: ptr(1.5), DensityGramsPerCup: ptr(100.0)},
+		},
+	}
+	testConfig(t, "")
+	router := inlineRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := computeMacros(context.Background(), tt.volume, food)
+			if !ok {
+				t.Fatal("computeMacros() found no weight per cup")
+			}
+			tt.inline.Nutrients = food.FoodNutrients
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros/inline", tt.inline)
+			inline := decode[InlineResponse](t, w, http.StatusOK)
+
+			if inline.Macros != result.macros {
+				t.Errorf("inline macros = %+v, want %+v", inline.Macros, result.macros)
+			}
+			if inline.CalculatedWeight != result.grams {
+				t.Errorf("inline calculated_weight = %v, want %v", inline.CalculatedWeight, result.grams)
+			}
+		})
+	}
+}
+
+func TestInlineRejectsInvalidAmounts(t *testing.T) {
+	tests := []struct {
+		name    string
+		request InlineRequest
+	}{
+		{"no amount", InlineRequest{}},
+		{"volume without density", InlineRequest{VolumeCups: ptr(1.0)}},
+		{"weight and volume", InlineRequest{WeightGrams: ptr(100.0), VolumeCups: ptr(1.0), DensityGramsPerCup: ptr(100.0)}},
+		{"negative weight", InlineRequest{WeightGrams: ptr(-1.0)}},
+		{"negative volume", InlineRequest{VolumeCups: ptr(-1.0), DensityGramsPerCup: ptr(100.0)}},
+		{"zero density", InlineRequest{VolumeCups: ptr(1.0), DensityGramsPerCup: ptr(0.0)}},
+	}
+	testConfig(t, "")
+	router := inlineRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros/inline", tt.request)
+			if body := decode[map[string]any](t, w, http.StatusBadRequest); body["error"] == nil {
+				t.Errorf("no error in %v", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+// jobs.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobsConfig controls batch calculation jobs, which compute many frames in
+// the background, e.g. when re-processing a day of photos. Jobs are kept in
+// memory: they are lost on restart and only visible to the instance that
+// accepted them.
+type JobsConfig struct {
+	// Workers is how many frames are computed at once; defaults to 4
+	Workers int `yaml:"workers"`
+	// MaxFrames limits the frames of one job; defaults to 1000
+	MaxFrames int `yaml:"max_frames"`
+	// QueueSize limits the frames waiting across all jobs, beyond which
+	// new jobs get 503; defaults to 10000
+	QueueSize int `yaml:"queue_size"`
+	// Retention is how long a finished job's results can be read;
+	// defaults to 1h
+	Retention time.Duration `yaml:"retention"`
+	// Attempts is how often a frame is computed while the food database
+	// is unavailable before it fails; defaults to 3
+	Attempts int `yaml:"attempts"`
+}
+
+func (j *JobsConfig) validate() error {
+	if j.Workers == 0 {
+		j.Workers = 4
+	}
+	if j.MaxFrames == 0 {
+		j.MaxFrames = 1000
+	}
+	if j.QueueSize == 0 {
+		j.QueueSize = 10000
+	}
+	if j.Retention == 0 {
+		j.Retention = time.Hour
+	}
+	if j.Attempts == 0 {
+		j.Attempts = 3
+	}
+	if j.Workers < 0 || j.MaxFrames < 0 || j.QueueSize < 0 || j.Retention < 0 || j.Attempts < 0 {
+		return errors.New("jobs.workers, jobs.max_frames, jobs.queue_size, jobs.retention and jobs.attempts must be positive")
+	}
+	if j.MaxFrames > j.QueueSize {
+		return fmt.Errorf("jobs.max_frames (%d) must not exceed jobs.queue_size (%d)", j.MaxFrames, j.QueueSize)
+	}
+	return nil
+}
+
+// Job states
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+)
+
+// JobRequest is the body of POST /v1/jobs/calculate-macros: frames shaped
+// like the data of a calculate-macros request. The query options of
+// calculate-macros apply to every frame.
+type JobRequest struct {
+	Data struct {
+		Frames []JobFrame `json:"frames" binding:"required,min=1,dive"`
+	} `json:"data"`
+}
+
+type JobFrame struct {
+	FrameID string   `json:"frame_id" binding:"omitempty,frame_id"`
+	Volumes []Volume `json:"volumes" binding:"max=100,dive"`
+	Scale   *float64 `json:"scale,omitempty" binding:"omitempty,gt=0"`
+}
+
+// JobResult is the outcome of one frame: its response, or why it failed
+type JobResult struct {
+	FrameID  string         `json:"frame_id,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Response *MacroResponse `json:"response,omitempty"`
+}
+
+// JobStatus is a job's progress. Results are in the order of the frames,
+// null for frames not computed yet.
+type JobStatus struct {
+	ID          string       `json:"id"`
+	Status      string       `json:"status"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CompletedAt *time.Time   `json:"completed_at,omitempty"`
+	Total       int          `json:"total"`
+	Processed   int          `json:"processed"`
+	Failed      int          `json:"failed"`
+	Results     []*JobResult `json:"results"`
+}
+
+// job is a batch being computed; status is guarded by mu
+type job struct {
+	user     string
+	template calcContext
+	format   outputFormat
+	frames   []JobFrame
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+// jobTask is one frame of a job
+type jobTask struct {
+	job   *job
+	index int
+}
+
+// jobQueue holds the jobs and the frames waiting for a worker
+type jobQueue struct {
+	mu    sync.Mutex
+	jobs  map[string]*job
+	tasks chan jobTask
+}
+
+var jobs *jobQueue
+
+// startJobWorkers starts the workers that compute queued frames
+func startJobWorkers(config JobsConfig) {
+	jobs = &jobQueue{
+		jobs:  make(map[string]*job),
+		tasks: make(chan jobTask, config.QueueSize),
+	}
+	for range config.Workers {
+		go jobs.work()
+	}
+	go jobs.expire(config.Retention)
+}
+
+// enqueue adds a job and its frames, failing when the queue can't take all
+// of them
+func (q *jobQueue) enqueue(j *job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if cap(q.tasks)-len(q.tasks) < len(j.frames) {
+		return false
+	}
+	q.jobs[j.status.ID] = j
+	for i := range j.frames {
+		q.tasks <- jobTask{job: j, index: i}
+	}
+	return true
+}
+
+// get returns a user's job
+func (q *jobQueue) get(user, id string) (*job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok || j.user != user {
+		return nil, false
+	}
+	return j, true
+}
+
+func (q *jobQueue) work() {
+	for task := range q.tasks {
+		task.job.start()
+		task.job.finish(task.index, task.job.compute(task.index))
+	}
+}
+
+// expire drops finished jobs once their retention passed
+func (q *jobQueue) expire(retention time.Duration) {
+	for range time.Tick(time.Minute) {
+		cutoff := time.Now().Add(-retention)
+		q.mu.Lock()
+		for id, j := range q.jobs {
+			j.mu.Lock()
+			done := j.status.CompletedAt
+			j.mu.Unlock()
+			if done != nil && done.Before(cutoff) {
+				delete(q.jobs, id)
+			}
+		}
+		q.mu.Unlock()
+	}
+}
+
+func (j *job) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.Status == jobQueued {
+		j.status.Status = jobRunning
+	}
+}
+
+// compute computes one frame, retrying while the food database is
+// unavailable, under the deadline calculate-macros requests get
+func (j *job) compute(index int) *JobResult {
+	frame := j.frames[index]
+	result := &JobResult{FrameID: frame.FrameID}
+	var request VolumeRequest
+	request.Data.FrameID = frame.FrameID
+	request.Data.Volumes = frame.Volumes
+	request.Data.Scale = frame.Scale
+
+	for attempt := 1; ; attempt++ {
+		if !storageReady.Load() || foodBreaker.retryAfter() > 0 {
+			result.Error = errFoodsUnavailable.Error()
+		} else {
+			response, err := j.computeOnce(request)
+			if err == nil && response.Status != responseStatusDegraded {
+				result.Error = ""
+				result.Response = &response
+				return result
+			}
+			result.Error = "some foods couldn't be looked up"
+			if err != nil {
+				result.Error = err.Error()
+			}
+		}
+		if attempt >= cfg.Jobs.Attempts {
+			return result
+		}
+		time.Sleep(max(foodBreaker.retryAfter(), time.Second))
+	}
+}
+
+func (j *job) computeOnce(request VolumeRequest) (MacroResponse, error) {
+	ctx := context.Background()
+	if timeout := cfg.Server.Timeouts.forRoute("/v1/calculate-macros"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cc := j.template
+	cc.ctx = ctx
+	response := computeFrame(&cc, request, j.format)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return response, errors.New("timed out")
+	}
+	return response, nil
+}
+
+func (j *job) finish(index int, result *JobResult) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Results[index] = result
+	j.status.Processed++
+	if result.Error != "" {
+		j.status.Failed++
+		slog.Warn("job frame failed", "job_id", j.status.ID, "frame_id", result.FrameID, "error", result.Error)
+	}
+	if j.status.Processed == j.status.Total {
+		now := time.Now().UTC()
+		j.status.Status = jobCompleted
+		j.status.CompletedAt = &now
+		slog.Info("job completed", "job_id", j.status.ID, "frames", j.status.Total, "failed", j.status.Failed)
+	}
+}
+
+// snapshot copies the job's status for a response
+func (j *job) snapshot() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	status.Results = append([]*JobResult(nil), j.status.Results...)
+	return status
+}
+
+// createCalculationJob queues frames to be computed in the background,
+// answering 202 with the job's location
+func createCalculationJob(c *gin.Context) {
+	var request JobRequest
+	if err := bindJSON(c, &request); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	frames := request.Data.Frames
+	if len(frames) > cfg.Jobs.MaxFrames {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("a job takes at most %d frames", cfg.Jobs.MaxFrames)})
+		return
+	}
+	for i, frame := range frames {
+		if err := validateVolumes(fmt.Sprintf("data.frames[%d].volumes", i), frame.Volumes); err != nil {
+			respondInvalid(c, err)
+			return
+		}
+	}
+	format, err := parseOutputFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	micros, err := parseNutrientSelection(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !requireDataVersion(c) {
+		return
+	}
+
+	// Statements aren't recorded, since job results have no debug output
+	template := *newCalcContext(c)
+	template.ctx = nil
+	template.micros = micros
+	template.stats = requestStats{}
+
+	j := &job{
+		user:     currentUser(c),
+		template: template,
+		format:   format,
+		frames:   frames,
+		status: JobStatus{
+			ID:        newCorrelationID(),
+			Status:    jobQueued,
+			CreatedAt: time.Now().UTC(),
+			Total:     len(frames),
+			Results:   make([]*JobResult, len(frames)),
+		},
+	}
+	if !jobs.enqueue(j) {
+		c.Header("Retry-After", "60")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "too many frames are queued; try again later"})
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "job queued", "job_id", j.status.ID, "frames", len(frames))
+	c.Header("Location", "/v1/jobs/"+j.status.ID)
+	c.JSON(http.StatusAccepted, j.snapshot())
+}
+
+// getCalculationJob returns a job's progress and the results so far. Jobs
+// are only visible to the user who created them.
+func getCalculationJob(c *gin.Context) {
+	id := c.Param("id")
+	j, ok := jobs.get(currentUser(c), id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("job %s not found", id)})
+		return
+	}
+	c.JSON(http.StatusOK, j.snapshot())
+}
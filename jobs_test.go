@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// useJobs replaces the job queue with one of the configured size, worked by
+// the given number of workers
+func useJobs(t *testing.T, workers int) {
+	t.Helper()
+	previous := jobs
+	jobs = &jobQueue{
+		jobs:  make(map[string]*job),
+		tasks: make(chan jobTask, cfg.Jobs.QueueSize),
+	}
+	for range workers {
+		go jobs.work()
+	}
+	queue := jobs
+	t.Cleanup(func() {
+		close(queue.tasks)
+		jobs = previous
+	})
+}
+
+func jobOf(frames ...JobFrame) JobRequest {
+	var request JobRequest
+	request.Data.Frames = frames
+	return request
+}
+
+func TestCalculationJob(t *testing.T) {
+	router := setupServer(t, trustUserHeader)
+	useMappings(t, &fileMappings{path: writeConfig(t, "rice: Rice, cooked, NFS\n")})
+	useJobs(t, 2)
+
+	request := jobOf(
+		JobFrame{FrameID: "frame-1", Volumes: []Volume{{ObjectName: "rice", VolumeCups: 1}}},
+		JobFrame{FrameID: "frame-2", Volumes: []Volume{{ObjectName: "dragonfruit", VolumeCups: 1}}},
+	)
+	w := doRequest(t, router, http.MethodPost, "/v1/jobs/calculate-macros", request, userHeader, "alice")
+	created := decode[JobStatus](t, w, http.StatusAccepted)
+	if location := w.Header().Get("Location"); location != "/v1/jobs/"+created.ID {
+		t.Errorf("Location = %q, want the job", location)
+	}
+
+	var status JobStatus
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		status = decode[JobStatus](t, doRequest(t, router, http.MethodGet, "/v1/jobs/"+created.ID, nil, userHeader, "alice"), http.StatusOK)
+		if status.Status == jobCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s after 5s", status.Status)
+		}
+	}
+	if status.Processed != 2 || status.Failed != 0 || status.CompletedAt == nil {
+		t.Errorf("status = %+v, want both frames processed", status)
+	}
+	// Results keep the order of the frames
+	for i, result := range status.Results {
+		if result == nil || result.FrameID != request.Data.Frames[i].FrameID || result.Response == nil {
+			t.Fatalf("results[%d] = %+v, want the response of %s", i, result, request.Data.Frames[i].FrameID)
+		}
+	}
+	if item := status.Results[0].Response.Data[0]; !item.Found {
+		t.Errorf("rice = %+v, want it found", item)
+	}
+
+	// Jobs are only visible to their user
+	if w := doRequest(t, router, http.MethodGet, "/v1/jobs/"+created.ID, nil, userHeader, "bob"); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d for another user's job, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestCalculationJobLimits(t *testing.T) {
+	router := setupServer(t, trustUserHeader+"jobs:\n  max_frames: 2\n  queue_size: 3\n")
+	// Without workers, queued frames stay queued
+	useJobs(t, 0)
+
+	frame := JobFrame{Volumes: []Volume{{ObjectName: "rice", VolumeCups: 1}}}
+	if w := doRequest(t, router, http.MethodPost, "/v1/jobs/calculate-macros", jobOf(frame, frame, frame), userHeader, "alice"); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d over max_frames, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequest(t, router, http.MethodPost, "/v1/jobs/calculate-macros", jobOf(frame, frame), userHeader, "alice"); w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	w := doRequest(t, router, http.MethodPost, "/v1/jobs/calculate-macros", jobOf(frame, frame), userHeader, "alice")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d with Retry-After %q once the queue is full, want %d with Retry-After", w.Code, w.Header().Get("Retry-After"), http.StatusServiceUnavailable)
+	}
+}
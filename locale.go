@@ -0,0 +1,83 @@
+// locale.go
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestLanguages returns the client's preferred languages, most preferred
+// first. An explicit ?lang= wins over the Accept-Language header.
+func requestLanguages(c *gin.Context) []string {
+	if lang := strings.TrimSpace(c.Query("lang")); lang != "" {
+		return []string{lang}
+	}
+	return parseAcceptLanguage(c.GetHeader("Accept-Language"))
+}
+
+// parseAcceptLanguage orders the tags of an Accept-Language header by
+// quality, dropping wildcards and tags with q=0
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag: tag, q: q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	languages := make([]string, len(tags))
+	for i, t := range tags {
+		languages[i] = t.tag
+	}
+	return languages
+}
+
+// localizedDescription picks the food description for the first language
+// that has a translation, matching the full tag before the base language
+// (es-MX, then es). It falls back to the dataset's default description.
+func localizedDescription(food *FoodData, languages []string) string {
+	if len(food.Descriptions) == 0 {
+		return food.Description
+	}
+
+	descriptions := make(map[string]string, len(food.Descriptions))
+	for locale, description := range food.Descriptions {
+		descriptions[strings.ToLower(locale)] = description
+	}
+
+	for _, lang := range languages {
+		lang = strings.ToLower(lang)
+		if description, ok := descriptions[lang]; ok {
+			return description
+		}
+		if base, _, found := strings.Cut(lang, "-"); found {
+			if description, ok := descriptions[base]; ok {
+				return description
+			}
+		}
+	}
+	return food.Description
+}
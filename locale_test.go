@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"es", []string{"es"}},
+		{"fr;q=0.5, es-MX, es;q=0.8", []string{"es-MX", "es", "fr"}},
+		{"de;q=0, *;q=0.1, it", []string{"it"}},
+		{"en;q=oops, pt", []string{"pt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := parseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAcceptLanguage(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalizedDescription(t *testing.T) {
+	banana := &FoodData{
+		Description:  "Banana, raw",
+		Descriptions: map[string]string{"es": "Plátano, crudo", "PT-br": "Banana, crua"},
+	}
+	tests := []struct {
+		name           string
+		query          string
+		acceptLanguage string
+		want           string
+	}{
+		{"default without a language", "", "", "Banana, raw"},
+		{"translated language", "", "es", "Plátano, crudo"},
+		{"regional tag falls back to its base", "", "es-MX", "Plátano, crudo"},
+		{"regional translation wins", "", "pt-BR", "Banana, crua"},
+		{"first translated preference", "", "ja, es;q=0.5", "Plátano, crudo"},
+		{"untranslated language falls back", "", "de", "Banana, raw"},
+		{"lang parameter beats the header", "?lang=pt-BR", "es", "Banana, crua"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/calculate-macros"+tt.query, nil)
+			if tt.acceptLanguage != "" {
+				c.Request.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+
+			if got := localizedDescription(banana, requestLanguages(c)); got != tt.want {
+				t.Errorf("description = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalizedDescriptionWithoutTranslations(t *testing.T) {
+	food := &FoodData{Description: "Rice, cooked, NFS"}
+	if got := localizedDescription(food, []string{"es"}); got != food.Description {
+		t.Errorf("description = %q, want %q", got, food.Description)
+	}
+}
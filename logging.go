@@ -0,0 +1,82 @@
+// logging.go
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Log formats selectable with logging.format
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+)
+
+// logLevel is the minimum level logged, set from logging.level and changed
+// by config reloads
+var logLevel = new(slog.LevelVar)
+
+// setupLogging makes slog's default logger (which the log package also
+// writes through) emit in the configured format, tagged with the request
+// ID of the context it is given
+func setupLogging(format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stdout, options)
+	if format == logFormatText {
+		handler = slog.NewTextHandler(os.Stdout, options)
+	}
+	logger := slog.New(requestIDHandler{handler})
+	slog.SetDefault(logger)
+	return logger
+}
+
+// fatal logs a startup error and exits
+func fatal(message string, err error) {
+	slog.Error(message, "error", err)
+	os.Exit(1)
+}
+
+type requestIDKey struct{}
+
+// requestIDHandler adds the request ID carried by a record's context
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// requestIDPattern limits client-supplied IDs to what is safe to echo into
+// headers and logs
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestID gives every request an ID, reusing the client's X-Request-ID
+// when it is well formed. The ID is returned in the response header and
+// attached to every log line written with the request's context.
+func requestID(c *gin.Context) {
+	id := c.GetHeader("X-Request-ID")
+	if !requestIDPattern.MatchString(id) {
+		id = newCorrelationID()
+	}
+
+	c.Set(ctxKeyRequestID, id)
+	c.Header("X-Request-ID", id)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, id))
+	c.Next()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		wantReuse bool
+	}{
+		{"client ID is reused", "req-42.a:b_c", true},
+		{"missing ID is generated", "", false},
+		{"ID with unsafe characters is replaced", "req 1\r\nX-Evil: 1", false},
+		{"overlong ID is replaced", strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			previous := slog.Default()
+			slog.SetDefault(slog.New(requestIDHandler{slog.NewJSONHandler(&buf, nil)}))
+			t.Cleanup(func() { slog.SetDefault(previous) })
+
+			router := gin.New()
+			router.Use(requestID)
+			router.GET("/ok", func(c *gin.Context) {
+				slog.InfoContext(c.Request.Context(), "handled")
+				c.Status(http.StatusNoContent)
+			})
+			var headers []string
+			if tt.header != "" {
+				headers = []string{"X-Request-ID", tt.header}
+			}
+			w := doRequest(t, router, http.MethodGet, "/ok", nil, headers...)
+
+			id := w.Header().Get("X-Request-ID")
+			if id == "" || !requestIDPattern.MatchString(id) {
+				t.Fatalf("X-Request-ID = %q, want a well-formed ID", id)
+			}
+			if (id == tt.header) != tt.wantReuse {
+				t.Errorf("X-Request-ID = %q, want the client's ID reused: %v", id, tt.wantReuse)
+			}
+			entries := logEntries(t, &buf)
+			if len(entries) != 1 || entries[0]["request_id"] != id {
+				t.Errorf("logs = %v, want one entry with request_id %s", entries, id)
+			}
+		})
+	}
+}
+
+func TestConfigValidateLogFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{"", logFormatJSON, false},
+		{"json", logFormatJSON, false},
+		{"text", logFormatText, false},
+		{"xml", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			config := Config{}
+			config.Logging.Format = tt.format
+			err := config.validate()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("validate() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validate() error = %v", err)
+			}
+			if config.Logging.Format != tt.want {
+				t.Errorf("logging.format = %q, want %q", config.Logging.Format, tt.want)
+			}
+		})
+	}
+}
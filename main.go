@@ -3,53 +3,540 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/couchbase/gocb/v2"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds database configuration
 type Config struct {
+	// Storage selects where foods are read from: "couchbase" (default),
+	// "postgres" or "sqlite". Features that store their own documents
+	// (feedback, frames, meals, conversions, recipes, synonyms, unresolved
+	// foods, stored food mappings) need couchbase.
+	Storage  string         `yaml:"storage"`
+	Postgres PostgresConfig `yaml:"postgres"`
+	SQLite   SQLiteConfig   `yaml:"sqlite"`
+
 	CouchDB struct {
 		URL    string `yaml:"url"`
 		Bucket string `yaml:"bucket"`
 		User   string `yaml:"user"`
 		Pwd    string `yaml:"pwd"`
+
+		// Scope and Collection name where the food documents live; both
+		// default to _default
+		Scope      string `yaml:"scope"`
+		Collection string `yaml:"collection"`
+
+		// Probe selects how connectivity is verified at startup: "query"
+		// (default), "kv" or "none"
+		Probe string `yaml:"probe"`
+
+		// WaitForIndexes lists indexes startup waits on before serving
+		WaitForIndexes IndexWaitConfig `yaml:"wait_for_indexes"`
+
+		// SkipMigrations stops startup from creating the collections and
+		// indexes the configuration needs; see the migrate command
+		SkipMigrations bool `yaml:"skip_migrations"`
+
+		// AdhocQueries sends every N1QL statement ad hoc instead of as a
+		// prepared statement whose plan is reused
+		AdhocQueries bool `yaml:"adhoc_queries"`
+
+		// Pool tunes the SDK's connection pools; zero leaves the SDK default
+		Pool struct {
+			KVPoolSize              int `yaml:"kv_pool_size"`
+			MaxQueueSize            int `yaml:"max_queue_size"`
+			ConnectionBufferSize    int `yaml:"connection_buffer_size"`
+			MaxHTTPConnsPerHost     int `yaml:"max_perhost_http_connections"`
+			MaxIdleHTTPConns        int `yaml:"max_idle_http_connections"`
+			MaxIdleHTTPConnsPerHost int `yaml:"max_perhost_idle_http_connections"`
+		} `yaml:"pool"`
 	} `yaml:"couchdb"`
+
+	// Datasets maps dataset names to the collections holding their food
+	// documents. Without any, the couchdb scope and collection form a
+	// single dataset named after the bucket.
+	Datasets map[string]Dataset `yaml:"datasets"`
+	// DefaultDataset is the dataset used for lookups
+	DefaultDataset string `yaml:"default_dataset"`
+	// FoodDatasets routes specific object names to another dataset than
+	// the default one
+	FoodDatasets map[string]string `yaml:"food_datasets"`
+
+	// DRI holds per-nutrient reference ranges keyed by macro name
+	// (calories, carbs, fat, protein); only nutrients listed here get a
+	// status label in the response
+	DRI map[string]DRIRange `yaml:"dri"`
+
+	Calories struct {
+		// Source selects the authoritative calorie value: "reported"
+		// (default, nutrient 208), "computed" (always Atwater from the
+		// macros) or "prefer_reported" (208, Atwater when it is missing)
+		Source string `yaml:"source"`
+	} `yaml:"calories"`
+
+	Eggs struct {
+		// PerCup is how many large eggs make up one cup, used when an egg
+		// volume has to be derived from per-egg portions because the data
+		// has no cup portion. Other sizes scale from it. Defaults to 4.5.
+		PerCup float64 `yaml:"per_cup"`
+	} `yaml:"eggs"`
+
+	Duplicates struct {
+		// Mode decides what happens when several foods share the exact
+		// description: "lowest" (default) picks the lowest fdcId, "error"
+		// fails the item as ambiguous, "all" picks the lowest fdcId and
+		// lists every match with ?candidates=true
+		Mode string `yaml:"mode"`
+	} `yaml:"duplicates"`
+
+	// Limits caps the lists returned per food
+	Limits struct {
+		// MaxCandidates bounds ?candidates=true; defaults to 10
+		MaxCandidates int `yaml:"max_candidates"`
+		// MaxSuggestions bounds the alternatives offered for foods that
+		// weren't matched; defaults to 5
+		MaxSuggestions int `yaml:"max_suggestions"`
+	} `yaml:"limits"`
+
+	Nutrients struct {
+		// Missing decides what happens when a food has no entry for a
+		// macro: "zero" (default) counts it as 0, "reject" fails the item
+		// with MISSING_NUTRIENTS. Either way nutrient_status tells missing
+		// and explicitly zero entries apart.
+		Missing string `yaml:"missing"`
+	} `yaml:"nutrients"`
+
+	Uncertainty struct {
+		// MaxRatio caps uncertainty_cups as a fraction of volume_cups;
+		// defaults to 1 (the volume itself)
+		MaxRatio float64 `yaml:"max_ratio"`
+		// Mode is "clamp" (default) to cap larger values, or "reject" to
+		// fail the request
+		Mode string `yaml:"mode"`
+	} `yaml:"uncertainty"`
+
+	Breaker BreakerConfig `yaml:"breaker"`
+
+	Connect ConnectConfig `yaml:"connect"`
+
+	Webhooks WebhookConfig `yaml:"webhooks"`
+
+	Feedback FeedbackConfig `yaml:"feedback"`
+
+	Frames FramesConfig `yaml:"frames"`
+
+	Meals MealsConfig `yaml:"meals"`
+
+	Fuzzy FuzzyConfig `yaml:"fuzzy"`
+
+	Cache CacheConfig `yaml:"cache"`
+
+	FoodMappings MappingsConfig `yaml:"food_mappings"`
+
+	Auth AuthConfig `yaml:"auth"`
+
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	CORS CORSConfig `yaml:"cors"`
+
+	FDCAPI FDCAPIConfig `yaml:"fdc_api"`
+
+	Densities DensityConfig `yaml:"densities"`
+
+	States StatesConfig `yaml:"states"`
+
+	Conversions ConversionsConfig `yaml:"conversions"`
+
+	Recipes RecipesConfig `yaml:"recipes"`
+
+	Synonyms SynonymsConfig `yaml:"synonyms"`
+
+	UnresolvedFoods UnresolvedFoodsConfig `yaml:"unresolved_foods"`
+
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+
+	Jobs JobsConfig `yaml:"jobs"`
+
+	Reload ReloadConfig `yaml:"reload"`
+
+	Admin struct {
+		// Token protects the admin endpoints, which are disabled while it
+		// is empty
+		Token string `yaml:"token"`
+	} `yaml:"admin"`
+
+	Server struct {
+		// Mode is "production" (default), where clients only see generic
+		// error messages, or "development", where full errors are returned
+		Mode string `yaml:"mode"`
+
+		// Numbers is "strict" (default) to reject request numbers that
+		// float64 can't represent without losing digits, or "lenient" to
+		// round them
+		Numbers string `yaml:"numbers"`
+
+		Timeouts TimeoutConfig `yaml:"timeouts"`
+
+		// ShutdownTimeout is how long in-flight requests may take to
+		// finish after SIGTERM; defaults to 15s
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+		// Docs serves Swagger UI for the OpenAPI spec at /docs
+		Docs bool `yaml:"docs"`
+
+		// GRPCPort serves the gRPC API (pb/macros.proto) on this port;
+		// disabled when empty
+		GRPCPort string `yaml:"grpc_port"`
+
+		TLS TLSConfig `yaml:"tls"`
+
+		// MaxBodyBytes rejects larger request bodies with 413; defaults to
+		// 1 MiB
+		MaxBodyBytes int64 `yaml:"max_body_bytes"`
+
+		Compression CompressionConfig `yaml:"compression"`
+
+		// TrustedProxies lists the IPs and CIDRs of the proxies whose
+		// X-Forwarded-For is believed. Clients are otherwise identified by
+		// the peer address, in the rate limit and the access log alike,
+		// since anyone can send the header. Trusts none by default.
+		TrustedProxies []string `yaml:"trusted_proxies"`
+	} `yaml:"server"`
+
+	Logging struct {
+		// Format is "json" (default) or "text" for the application log
+		Format string `yaml:"format"`
+		// AccessLog selects the access log format: "text" (gin's default
+		// logger) or "json" (one structured slog entry per request)
+		AccessLog string `yaml:"access_log"`
+		// Level is the minimum level of the application log: debug, info
+		// (default), warn or error
+		Level string `yaml:"level"`
+	} `yaml:"logging"`
+
+	// StrictEnv turns an environment variable overriding a value set in
+	// the config file into a startup error instead of a logged warning
+	StrictEnv bool `yaml:"strict_env"`
+}
+
+// DRIRange is an inclusive reference intake range for a single nutrient
+type DRIRange struct {
+	Min float64 `yaml:"min"`
+	Max float64 `yaml:"max"`
+}
+
+// errStrictEnv is returned when strict_env is set and an environment variable
+// tries to override a value from the config file
+var errStrictEnv = errors.New("environment override rejected by strict_env")
+
+// envOverride ties an environment variable to the config field it replaces
+type envOverride struct {
+	env    string
+	field  string
+	value  *string
+	secret bool
+}
+
+func (c *Config) envOverrides() []envOverride {
+	return []envOverride{
+		{env: "COUCHDB_URL", field: "couchdb.url", value: &c.CouchDB.URL},
+		{env: "COUCHDB_BUCKET", field: "couchdb.bucket", value: &c.CouchDB.Bucket},
+		{env: "COUCHDB_USER", field: "couchdb.user", value: &c.CouchDB.User},
+		{env: "COUCHDB_PWD", field: "couchdb.pwd", value: &c.CouchDB.Pwd, secret: true},
+		{env: "COUCHDB_SCOPE", field: "couchdb.scope", value: &c.CouchDB.Scope},
+		{env: "COUCHDB_COLLECTION", field: "couchdb.collection", value: &c.CouchDB.Collection},
+		{env: "POSTGRES_DSN", field: "postgres.dsn", value: &c.Postgres.DSN, secret: true},
+		{env: "FDC_API_KEY", field: "fdc_api.api_key", value: &c.FDCAPI.APIKey, secret: true},
+		{env: "ADMIN_TOKEN", field: "admin.token", value: &c.Admin.Token, secret: true},
+		{env: "GRPC_PORT", field: "server.grpc_port", value: &c.Server.GRPCPort},
+	}
 }
 
 // Request models
 type VolumeRequest struct {
 	Data struct {
-		FrameID string   `json:"frame_id"`
-		Volumes []Volume `json:"volumes"`
+		FrameID string   `json:"frame_id" binding:"omitempty,frame_id"`
+		Volumes []Volume `json:"volumes" binding:"max=100,dive"`
+
+		// CallbackURL, when set, also receives the response as a POST
+		CallbackURL string `json:"callback_url,omitempty"`
+
+		// Scale calibrates every volume in the frame, e.g. from a reference
+		// object of known size in the photo
+		Scale *float64 `json:"scale,omitempty" binding:"omitempty,gt=0"`
+
+		// Persist stores the response under frame_id, to be read back from
+		// /v1/frames/:frame_id
+		Persist bool `json:"persist,omitempty"`
 	} `json:"data"`
 }
 
 type Volume struct {
-	ObjectName      string  `json:"object_name"`
-	UncertaintyCups float64 `json:"uncertainty_cups"`
-	VolumeCups      float64 `json:"volume_cups"`
+	ObjectName      string  `json:"object_name" binding:"required"`
+	UncertaintyCups float64 `json:"uncertainty_cups" binding:"gte=0"`
+	VolumeCups      float64 `json:"volume_cups" binding:"gt=0"`
+
+	// DensityGramsPerCup, when set, replaces the dataset's portion-derived
+	// density for this item
+	DensityGramsPerCup *float64 `json:"density_grams_per_cup,omitempty" binding:"omitempty,gt=0"`
+
+	// PortionDescription names a food portion to weigh the item by, such as
+	// "1 slice" or "2 tbsp", volume_cups then being read as a count of that
+	// portion. It is matched by quantity, unit and modifier, so it needn't
+	// equal a portion's description. The cup heuristic is used when no
+	// portion matches.
+	PortionDescription string `json:"portion_description,omitempty"`
+
+	// EggSize selects the egg size (small, medium, large, xl) used when an
+	// egg volume has to be derived from per-egg portions; defaults to large
+	EggSize string `json:"egg_size,omitempty" binding:"omitempty,oneof=small medium large xl"`
+
+	// Unit is the unit volume_cups and uncertainty_cups are given in:
+	// cups (default), ml, tbsp, tsp, fl_oz, or g for a weight that needs
+	// no density
+	Unit string `json:"unit,omitempty" binding:"omitempty,oneof=cups ml g tbsp tsp fl_oz"`
+
+	// State is the preparation state of the food (raw, cooked, boiled,
+	// fried). It steers which description the food is looked up by, and
+	// when the matched food is in another state its nutrients are converted
+	// with the food's yield factor; see states.go.
+	State string `json:"state,omitempty" binding:"omitempty,oneof=raw cooked boiled fried"`
+
+	// byWeight is set once a gram unit has been applied
+	byWeight bool
 }
 
 // Response models
+
+// CalcVersion identifies the computation logic behind a result, so a stored
+// result can be traced back to the rules that produced it. Bump it in the
+// same change that alters computed values for an unchanged request and
+// dataset:
+//   - major: a result field changes meaning or unit
+//   - minor: values change (density heuristics, portion matching, Atwater
+//     or calorie source handling, nutrient mapping)
+//   - patch: fixes that change values only in edge cases
+//
+// Changes that only add optional output leave it as is. TestCalcVersion pins
+// the version together with reference results, so a change that moves them
+// fails until both are updated.
+//
+// History:
+//   - 1.1.0: foods without a cup portion convert by their category's density
+//   - 1.2.0: portions are picked by parsed quantity and unit
+//   - 1.3.0: state-aware lookups and yield factors
+const CalcVersion = "1.3.0"
+
 type MacroResponse struct {
-	Data []MacroData `json:"data"`
+	CalcVersion string          `json:"calc_version"`
+	Status      string          `json:"status"`
+	Data        []MacroData     `json:"data"`
+	Summary     FrameSummary    `json:"summary"`
+	EnergyUnit  string          `json:"energy_unit"`
+	Scale       float64         `json:"scale,omitempty"`
+	ResultHash  string          `json:"result_hash,omitempty"`
+	Meta        *ProcessingMeta `json:"meta,omitempty"`
+}
+
+// FrameSummary totals the resolved items of the request's frame, so clients
+// don't have to sum the items themselves
+type FrameSummary struct {
+	FrameID    string `json:"frame_id,omitempty"`
+	Totals     Macros `json:"totals"`
+	Unresolved int    `json:"unresolved"`
+}
+
+// ProcessingMeta describes how a response was produced; only included when
+// the request asks for it with ?meta=true
+type ProcessingMeta struct {
+	ProcessingTimeMs float64 `json:"processing_time_ms"`
+	QueriesExecuted  int     `json:"queries_executed"`
+	CacheHits        int     `json:"cache_hits"`
+	CacheMisses      int     `json:"cache_misses"`
+}
+
+// Error codes reported per item when a food can't be computed
+const (
+	errorCodeInvalidFood      = "INVALID_FOOD"
+	errorCodeUnknownFood      = "UNKNOWN_FOOD"
+	errorCodeNoPortionData    = "NO_PORTION_DATA"
+	errorCodeMissingNutrients = "MISSING_NUTRIENTS"
+	errorCodeDatabase         = "DB_ERROR"
+)
+
+// Overall status of a MacroResponse. Only degraded responses are worth
+// retrying; partial ones lack data that a retry won't find either.
+const (
+	responseStatusOK       = "ok"       // every item resolved
+	responseStatusPartial  = "partial"  // some items are unknown or lack data
+	responseStatusDegraded = "degraded" // the database failed some items
+)
+
+// requestStats collects the per-request counters reported in ProcessingMeta
+type requestStats struct {
+	queries     int
+	cacheHits   int
+	cacheMisses int
+
+	// executed keeps every statement when debug_query is on
+	recordQueries bool
+	executed      []ExecutedQuery
+}
+
+// ExecutedQuery is a N1QL statement as sent to Couchbase, reported in
+// development mode with ?debug_query=true
+type ExecutedQuery struct {
+	Statement string `json:"statement"`
+	Params    []any  `json:"params"`
+}
+
+func (s *requestStats) recordQuery(statement string, params ...any) {
+	s.queries++
+	if s.recordQueries {
+		s.executed = append(s.executed, ExecutedQuery{Statement: statement, Params: params})
+	}
+}
+
+// calcContext carries the per-request options and counters through the
+// lookup pipeline
+type calcContext struct {
+	// ctx carries the request's deadline into the queries
+	ctx        context.Context
+	variants   bool
+	portions   bool
+	candidates bool
+	perGram    bool
+	perServing bool
+	consensus  bool
+	// micros are the nutrients requested besides the macros
+	micros []microNutrient
+	// resolved holds the lookups done up front by prefetch
+	resolved map[string]lookupResult
+	// dataVersion pins lookups to one ingest version; empty matches any
+	dataVersion string
+	languages   []string
+	// scale multiplies every volume before it is computed
+	scale float64
+	stats requestStats
 }
 
 type MacroData struct {
-	Found            bool    `json:"found"`
-	Macros           Macros  `json:"macros"` // Contains calories
+	Found       bool   `json:"found"`
+	Dataset     string `json:"dataset,omitempty"`
+	Description string `json:"description,omitempty"`
+	Category    string `json:"category,omitempty"`
+	DataVersion string `json:"data_version,omitempty"`
+	Macros      Macros `json:"macros"` // Contains calories
+	// PerGram is Macros divided by CalculatedWeight; only with
+	// ?per_gram=true and a non-zero weight
+	PerGram          *Macros `json:"per_gram,omitempty"`
 	RequestedFood    string  `json:"requested_food"`
 	RequestedVolume  float64 `json:"requested_volume"`
+	RequestedUnit    string  `json:"requested_unit,omitempty"`
 	CalculatedWeight float64 `json:"calculated_weight"`
+	DensityOverride  bool    `json:"density_override,omitempty"`
+	CaloriesComputed bool    `json:"calories_computed,omitempty"`
+	PortionUsed      string  `json:"portion_used,omitempty"`
+	MatchQuality     string  `json:"match_quality,omitempty"`
+	// DensityGramsPerML is the density the volume was converted with when
+	// match_quality is category-density or default-density
+	DensityGramsPerML float64 `json:"density_g_per_ml,omitempty"`
+	ErrorCode         string  `json:"error_code,omitempty"`
+	ErrorMessage      string  `json:"error_message,omitempty"`
+
+	// Confidence scores how well the description matches the requested
+	// food: 1 for mapped names, the fuzzy match score otherwise
+	Confidence float64 `json:"confidence,omitempty"`
+
+	// UncertaintyClamped is set when uncertainty_cups exceeded the
+	// configured maximum and was reduced to it
+	UncertaintyClamped bool `json:"uncertainty_clamped,omitempty"`
+
+	// PercentError summarizes the uncertainty as a ± percentage of the
+	// macros, capped at maxPercentError
+	PercentError float64 `json:"percent_error"`
+	// Range bounds the macros over volume_cups ± uncertainty_cups
+	Range *MacroRange `json:"range,omitempty"`
+
+	// Per100g is the food's macros per 100 g, the baseline the macros are
+	// scaled from, so clients can re-scale without another request
+	Per100g *Macros `json:"per_100g,omitempty"`
+	// PerServing is one serving of the food; only with ?per_serving=true
+	PerServing *ServingMacros `json:"per_serving,omitempty"`
+
+	// ResolvedName is the object name requested_food was translated to by
+	// the synonym dictionary
+	ResolvedName string `json:"resolved_name,omitempty"`
+
+	// State is the requested preparation state; YieldFactor is what the
+	// matched food's nutrients were scaled by when it is described in
+	// another state
+	State       string  `json:"state,omitempty"`
+	YieldFactor float64 `json:"yield_factor,omitempty"`
+
+	// Nutrients holds the nutrients requested with ?nutrients=, keyed by
+	// FDC nutrient number
+	Nutrients map[string]NutrientAmount `json:"nutrients,omitempty"`
+
+	DRIStatus map[string]string `json:"dri_status,omitempty"`
+
+	// NutrientStatus tells, per macro, whether the food reports it
+	// ("present"), reports it as 0 ("zero") or lacks it ("missing")
+	NutrientStatus map[string]string `json:"nutrient_status,omitempty"`
+
+	// Variants lists the food as found in every configured dataset; only
+	// filled in when requested with ?variants=true
+	Variants []DatasetVariant `json:"variants,omitempty"`
+
+	// Suggestions are the closest descriptions to an unresolved food, none
+	// of which matched confidently enough to be used
+	Suggestions []string `json:"suggestions,omitempty"`
+
+	// Consensus averages the food across datasets; only with
+	// ?consensus=true
+	Consensus *ConsensusMacros `json:"consensus,omitempty"`
+
+	// Candidates lists every food sharing the matched description; only
+	// with duplicates.mode "all" and ?candidates=true
+	Candidates []FoodCandidate `json:"candidates,omitempty"`
+
+	// Portions is every portion of the matched food, so clients can see
+	// why a density was chosen; only with ?include_portions=true
+	Portions []Portion `json:"portions,omitempty"`
+
+	// Queries lists the statements run for this item (development mode
+	// with ?debug_query=true only)
+	Queries []ExecutedQuery `json:"queries,omitempty"`
+}
+
+// ServingMacros are the macros of one serving of a food
+type ServingMacros struct {
+	// Portion is the serving: the item's portion_description, else the
+	// food's first listed portion, or for a recipe 1/servings of it
+	Portion string  `json:"portion"`
+	Grams   float64 `json:"grams"`
+	Macros  Macros  `json:"macros"`
 }
 
 type Macros struct {
@@ -59,12 +546,85 @@ type Macros struct {
 	Protein  float64 `json:"protein"`
 }
 
+// add returns the sum of two sets of macros
+func (m Macros) add(o Macros) Macros {
+	return Macros{
+		Calories: m.Calories + o.Calories,
+		Carbs:    m.Carbs + o.Carbs,
+		Fat:      m.Fat + o.Fat,
+		Protein:  m.Protein + o.Protein,
+	}
+}
+
+// times multiplies every macro by factor
+func (m Macros) times(factor float64) Macros {
+	return Macros{
+		Calories: m.Calories * factor,
+		Carbs:    m.Carbs * factor,
+		Fat:      m.Fat * factor,
+		Protein:  m.Protein * factor,
+	}
+}
+
+// perGram divides the macros by a weight; false when there is no weight to
+// divide by
+func (m Macros) perGram(grams float64) (Macros, bool) {
+	if grams <= 0 {
+		return Macros{}, false
+	}
+	return Macros{
+		Calories: m.Calories / grams,
+		Carbs:    m.Carbs / grams,
+		Fat:      m.Fat / grams,
+		Protein:  m.Protein / grams,
+	}, true
+}
+
+// per100g scales the macros of a weight to 100 g; false when there is no
+// weight to scale from
+func (m Macros) per100g(grams float64) (Macros, bool) {
+	perGram, ok := m.perGram(grams)
+	return perGram.times(100), ok
+}
+
+// byName returns the macros keyed by the names used in config and responses
+func (m Macros) byName() map[string]float64 {
+	return map[string]float64{
+		"calories": m.Calories,
+		"carbs":    m.Carbs,
+		"fat":      m.Fat,
+		"protein":  m.Protein,
+	}
+}
+
 // Food data models
 type FoodData struct {
-	Description   string     `json:"description"`
-	FdcID         int        `json:"fdcId"` // Changed from string to int
-	FoodNutrients []Nutrient `json:"foodNutrients"`
-	FoodPortions  []Portion  `json:"foodPortions"`
+	Description string `json:"description"`
+	FdcID       int    `json:"fdcId"` // Changed from string to int
+	// Descriptions holds translated descriptions keyed by locale, when the
+	// dataset provides them
+	Descriptions  map[string]string `json:"descriptions,omitempty"`
+	FoodNutrients []Nutrient        `json:"foodNutrients"`
+	FoodPortions  []Portion         `json:"foodPortions"`
+	// DataVersion is stamped on documents at ingest, e.g. "2023-10"
+	DataVersion string `json:"dataVersion,omitempty"`
+
+	// FNDDS documents are categorized by WWEIA, the other FDC datasets
+	// carry a foodCategory
+	WWEIAFoodCategory struct {
+		Description string `json:"wweiaFoodCategoryDescription"`
+	} `json:"wweiaFoodCategory"`
+	FoodCategory struct {
+		Description string `json:"description"`
+	} `json:"foodCategory"`
+}
+
+// category returns the food's category description, if the document has one
+func (f *FoodData) category() string {
+	if f.WWEIAFoodCategory.Description != "" {
+		return f.WWEIAFoodCategory.Description
+	}
+	return f.FoodCategory.Description
 }
 
 type Nutrient struct {
@@ -72,6 +632,8 @@ type Nutrient struct {
 	Nutrient struct {
 		Name   string `json:"name"`
 		Number string `json:"number"`
+		// UnitName is the unit Amount is given in, e.g. "G", "MG", "UG"
+		UnitName string `json:"unitName"`
 	} `json:"nutrient"`
 }
 
@@ -85,7 +647,10 @@ type Portion struct {
 	} `json:"measureUnit"`
 	Modifier           string `json:"modifier"`
 	PortionDescription string `json:"portionDescription"`
-	SequenceNumber     int    `json:"sequenceNumber"`
+	// Amount is how many measure units the portion is; only used for
+	// portions without a description
+	Amount         float64 `json:"amount,omitempty"`
+	SequenceNumber int     `json:"sequenceNumber"`
 }
 
 // Database represents our CouchDB connection
@@ -94,9 +659,63 @@ type Database struct {
 	bucket     *gocb.Bucket
 	scope      *gocb.Scope
 	collection *gocb.Collection
+
+	// keyspaces holds the escaped N1QL path of every dataset
+	keyspaces map[string]string
+
+	// feedback stores measured densities; nil when feedback is disabled
+	feedback feedbackStore
+
+	// frames stores persisted results; nil when persisting is disabled
+	frames frameStore
+
+	// meals stores the meal log; nil when meal logging is disabled
+	meals mealStore
+
+	// conversions stores per-food conversion overrides, queried at
+	// conversionsKeyspace; nil when overrides are disabled
+	conversions         *gocb.Collection
+	conversionsKeyspace string
+
+	// recipes stores saved recipes, queried at recipesKeyspace; nil when
+	// recipes are disabled
+	recipes         *gocb.Collection
+	recipesKeyspace string
+
+	// synonyms stores the synonym dictionary, queried at synonymsKeyspace;
+	// nil when only the built-in synonyms are used
+	synonyms         *gocb.Collection
+	synonymsKeyspace string
+
+	// unresolved counts the object names that failed to resolve, queried
+	// at unresolvedKeyspace; nil when they are only tracked in memory
+	unresolved         *gocb.Collection
+	unresolvedKeyspace string
+
+	// idempotency stores the responses to requests sent with an
+	// Idempotency-Key; nil when the header is ignored
+	idempotency idempotencyStore
+}
+
+const defaultKeyspaceName = "_default"
+
+// keyspaceNamePattern matches valid Couchbase scope and collection names;
+// only the built-in _default may start with an underscore
+var keyspaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_%-]{0,250}$`)
+
+// keyspaceFor returns the N1QL keyspace of a collection, using the short
+// bucket form for the default collection
+func keyspaceFor(bucket, scope, collection string) string {
+	if scope == defaultKeyspaceName && collection == defaultKeyspaceName {
+		return fmt.Sprintf("`%s`", bucket)
+	}
+	return fmt.Sprintf("`%s`.`%s`.`%s`", bucket, scope, collection)
 }
 
-var db *Database
+var (
+	db  *Database
+	cfg *Config
+)
 
 func loadConfig(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
@@ -108,289 +727,1547 @@ func loadConfig(filename string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("error parsing config file: %v", err)
 	}
+	// The file's log level already applies to the override logs
+	logLevel.Set(parseLogLevel(config.Logging.Level))
 
 	// Override with environment variables if they exist
-	if url := os.Getenv("COUCHDB_URL"); url != "" {
-		config.CouchDB.URL = url
-	}
-	if bucket := os.Getenv("COUCHDB_BUCKET"); bucket != "" {
-		config.CouchDB.Bucket = bucket
+	if err := applyEnvOverrides(&config); err != nil {
+		return nil, err
 	}
-	if user := os.Getenv("COUCHDB_USER"); user != "" {
-		config.CouchDB.User = user
-	}
-	if pwd := os.Getenv("COUCHDB_PWD"); pwd != "" {
-		config.CouchDB.Pwd = pwd
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := resolveSecrets(ctx, &config); err != nil {
+		return nil, err
 	}
 
 	return &config, nil
 }
 
-func main() {
-	// Initialize database connection
-	var err error
-	db, err = initDB()
-	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+// validate rejects option values the server can't act on
+func (c *Config) validate() error {
+	switch c.Server.Mode {
+	case "":
+		c.Server.Mode = modeProduction
+	case modeProduction, modeDevelopment:
+	default:
+		return fmt.Errorf("invalid server.mode %q: expected production or development", c.Server.Mode)
 	}
-
-	router := gin.Default()
-	router.POST("/v1/calculate-macros", calculateMacros)
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	switch c.Server.Numbers {
+	case "":
+		c.Server.Numbers = numbersStrict
+	case numbersStrict, numbersLenient:
+	default:
+		return fmt.Errorf("invalid server.numbers %q: expected strict or lenient", c.Server.Numbers)
 	}
-	log.Printf("Starting server on port %s", port)
-	router.Run(":" + port)
-}
-
-func initDB() (*Database, error) {
-	config, err := loadConfig("config.yaml")
-	if err != nil {
-		log.Printf("Warning: Failed to load config file: %v", err)
-		config = &Config{}
-		config.CouchDB.URL = os.Getenv("COUCHBASE_URL")
-		config.CouchDB.Bucket = os.Getenv("COUCHBASE_BUCKET")
-		config.CouchDB.User = os.Getenv("COUCHBASE_USER")
-		config.CouchDB.Pwd = os.Getenv("COUCHBASE_PWD")
+	if err := c.Server.Timeouts.validate(); err != nil {
+		return err
 	}
-
-	log.Printf("Attempting to connect to Couchbase with URL: %s, Bucket: %s", config.CouchDB.URL, config.CouchDB.Bucket)
-
-	// Configure cluster options for cloud connectivity
-	clusterOpts := gocb.ClusterOptions{
-		Authenticator: gocb.PasswordAuthenticator{
-			Username: config.CouchDB.User,
-			Password: config.CouchDB.Pwd,
-		},
-		SecurityConfig: gocb.SecurityConfig{
-			TLSSkipVerify: false,
-		},
-		TimeoutsConfig: gocb.TimeoutsConfig{
-			ConnectTimeout: time.Second * 30,
-			KVTimeout:      time.Second * 30,
-			QueryTimeout:   time.Second * 30,
-		},
+	if c.Server.ShutdownTimeout == 0 {
+		c.Server.ShutdownTimeout = 15 * time.Second
 	}
-
-	// Connect to cluster
-	cluster, err := gocb.Connect(fmt.Sprintf("couchbases://%s", config.CouchDB.URL), clusterOpts)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to cluster: %v", err)
+	if c.Server.ShutdownTimeout < 0 {
+		return errors.New("server.shutdown_timeout must not be negative")
 	}
 
-	log.Printf("Successfully connected to cluster, attempting to get bucket: %s", config.CouchDB.Bucket)
-
-	// Try a simple query to verify connectivity
-	result, err := cluster.Query(
-		"SELECT RAW 1",
-		&gocb.QueryOptions{},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute test query: %v", err)
+	switch c.Storage {
+	case "":
+		c.Storage = storageCouchbase
+	case storageCouchbase:
+	case storagePostgres, storageSQLite:
+		validate := c.Postgres.validate
+		if c.Storage == storageSQLite {
+			validate = c.SQLite.validate
+		}
+		if err := validate(); err != nil {
+			return err
+		}
+		for feature, enabled := range map[string]bool{
+			"feedback":                 c.Feedback.enabled(),
+			"frames":                   c.Frames.enabled(),
+			"meals":                    c.Meals.enabled(),
+			"conversions":              c.Conversions.enabled(),
+			"recipes":                  c.Recipes.enabled(),
+			"synonyms":                 c.Synonyms.enabled(),
+			"unresolved_foods":         c.UnresolvedFoods.enabled(),
+			"idempotency":              c.Idempotency.enabled(),
+			"food_mappings.collection": c.FoodMappings.Collection != "",
+		} {
+			if enabled {
+				return fmt.Errorf("%s needs storage couchbase", feature)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid storage %q: expected couchbase, postgres or sqlite", c.Storage)
 	}
-	result.Close()
 
-	// Get bucket with longer timeout
-	bucket := cluster.Bucket(config.CouchDB.Bucket)
-
-	// Increase the wait time for bucket readiness
-	err = bucket.WaitUntilReady(30*time.Second, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to bucket: %v", err)
+	if c.CouchDB.Scope == "" {
+		c.CouchDB.Scope = defaultKeyspaceName
 	}
-
-	collection := bucket.DefaultCollection()
-
-	database := &Database{
-		cluster:    cluster,
-		bucket:     bucket,
-		collection: collection,
+	if c.CouchDB.Collection == "" {
+		c.CouchDB.Collection = defaultKeyspaceName
 	}
-
-	log.Printf("Successfully connected to Couchbase and bucket '%s'", config.CouchDB.Bucket)
-	return database, nil
-}
-
-func calculateMacros(c *gin.Context) {
-	var request VolumeRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	for field, name := range map[string]string{"scope": c.CouchDB.Scope, "collection": c.CouchDB.Collection} {
+		if name != defaultKeyspaceName && !keyspaceNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid couchdb.%s %q", field, name)
+		}
 	}
-
-	response := MacroResponse{
-		Data: make([]MacroData, 0),
+	switch c.CouchDB.Probe {
+	case "":
+		c.CouchDB.Probe = probeQuery
+	case probeQuery, probeKV, probeNone:
+	default:
+		return fmt.Errorf("invalid couchdb.probe %q: expected query, kv or none", c.CouchDB.Probe)
 	}
-
-	for _, volume := range request.Data.Volumes {
-		macroData := processFoodVolume(volume)
-		response.Data = append(response.Data, macroData)
+	if err := c.CouchDB.WaitForIndexes.validate(); err != nil {
+		return err
 	}
-
-	c.JSON(http.StatusOK, response)
-}
-
-// Update the struct to match exactly what's in Couchbase
-func processFoodVolume(volume Volume) MacroData {
-	// Get food data based on object name
-	foodData, err := getFoodData(volume.ObjectName)
-	if err != nil || foodData == nil {
-		log.Printf("Error getting food data: %v", err)
-		return MacroData{
-			Found:           false,
-			RequestedFood:   volume.ObjectName,
-			RequestedVolume: volume.VolumeCups,
-		}
-	}
-
-	// Debug log to see what portions we have
-	log.Printf("Available portions for %s:", volume.ObjectName)
-	for _, p := range foodData.FoodPortions {
-		log.Printf("- Description: %s, Weight: %f", p.PortionDescription, p.GramWeight)
-	}
-
-	// Find cup portion measurement with exact matching
-	var cupGrams float64
-	for _, portion := range foodData.FoodPortions {
-		if strings.Contains(portion.PortionDescription, "1 cup") {
-			log.Printf("Found cup measurement: %s = %fg", portion.PortionDescription, portion.GramWeight)
-			cupGrams = portion.GramWeight
-			break
-		}
-	}
-
-	if cupGrams == 0 {
-		log.Printf("No cup measurement found for %s", volume.ObjectName)
-		// For eggs specifically, we might need to convert from individual egg weight
-		if volume.ObjectName == "egg" {
-			// Find "1 egg" portion
-			for _, portion := range foodData.FoodPortions {
-				if portion.PortionDescription == "1 egg" {
-					// Approximate 1 cup as 4-5 large eggs
-					cupGrams = portion.GramWeight * 4.5
-					break
-				}
-			}
-		}
-		if cupGrams == 0 {
-			return MacroData{
-				Found:           false,
-				RequestedFood:   volume.ObjectName,
-				RequestedVolume: volume.VolumeCups,
-			}
-		}
+	if err := c.validateDatasets(); err != nil {
+		return err
 	}
-
-	// Calculate total grams based on requested cups
-	calculatedGrams := volume.VolumeCups * cupGrams
-
-	// Get nutrient values
-	macros := calculateMacrosForGrams(foodData.FoodNutrients, calculatedGrams, cupGrams)
-
-	return MacroData{
-		Found:            true,
-		Macros:           macros,
-		RequestedFood:    volume.ObjectName,
-		RequestedVolume:  volume.VolumeCups,
-		CalculatedWeight: calculatedGrams,
+	switch c.Calories.Source {
+	case "":
+		c.Calories.Source = calorieSourceReported
+	case calorieSourceReported, calorieSourceComputed, calorieSourcePreferReported:
+	default:
+		return fmt.Errorf("invalid calories.source %q: expected reported, computed or prefer_reported", c.Calories.Source)
 	}
-}
 
-func calculateMacrosForGrams(nutrients []Nutrient, calculatedGrams, baseGrams float64) Macros {
-	var macros Macros
-	ratio := calculatedGrams / 100.0 // nutrients are per 100g
-
-	for _, nutrient := range nutrients {
-		switch nutrient.Nutrient.Number {
-		case "208": // Energy (kcal)
-			macros.Calories = nutrient.Amount * ratio
-		case "203": // Protein
-			macros.Protein = nutrient.Amount * ratio
-		case "204": // Total fat
-			macros.Fat = nutrient.Amount * ratio
-		case "205": // Carbohydrates
-			macros.Carbs = nutrient.Amount * ratio
-		}
+	if c.Eggs.PerCup < 0 {
+		return fmt.Errorf("eggs.per_cup must be positive")
+	}
+	if c.Eggs.PerCup == 0 {
+		c.Eggs.PerCup = defaultEggsPerCup
 	}
 
-	return macros
-}
-
-func getFoodData(objectName string) (*FoodData, error) {
-	var searchTerm string
-	switch objectName {
-	case "egg":
-		searchTerm = "Egg, whole, boiled or poached"
-	case "rice":
-		searchTerm = "Rice, cooked, NFS"
-	case "banana":
-		searchTerm = "Banana, raw"
-	case "cucumber":
-		searchTerm = "Cucumber, raw"
-	case "apple":
-		searchTerm = "Apple, dried"
-	case "sugar-melon":
-		searchTerm = "Cantaloupe, raw"
+	if c.Uncertainty.MaxRatio < 0 {
+		return fmt.Errorf("uncertainty.max_ratio must not be negative")
+	}
+	if c.Uncertainty.MaxRatio == 0 {
+		c.Uncertainty.MaxRatio = 1
+	}
+	switch c.Duplicates.Mode {
+	case "":
+		c.Duplicates.Mode = duplicatesLowest
+	case duplicatesLowest, duplicatesError, duplicatesAll:
 	default:
-		return nil, fmt.Errorf("unknown food: %s", objectName)
+		return fmt.Errorf("invalid duplicates.mode %q: expected lowest, error or all", c.Duplicates.Mode)
 	}
 
-	// Create N1QL query with raw result inspection
-	query := "SELECT RAW r FROM fndds r WHERE LOWER(r.description) = LOWER($1) LIMIT 1"
+	if c.Limits.MaxCandidates == 0 {
+		c.Limits.MaxCandidates = 10
+	}
+	if c.Limits.MaxSuggestions == 0 {
+		c.Limits.MaxSuggestions = 5
+	}
+	if c.Limits.MaxCandidates < 0 || c.Limits.MaxSuggestions < 0 {
+		return errors.New("limits.max_candidates and limits.max_suggestions must be positive")
+	}
 
-	log.Printf("Executing query: %s with params: [%s]", query, searchTerm)
+	switch c.Nutrients.Missing {
+	case "":
+		c.Nutrients.Missing = missingNutrientsZero
+	case missingNutrientsZero, missingNutrientsReject:
+	default:
+		return fmt.Errorf("invalid nutrients.missing %q: expected zero or reject", c.Nutrients.Missing)
+	}
 
-	// Execute the query
-	result, err := db.cluster.Query(
-		query,
-		&gocb.QueryOptions{
-			PositionalParameters: []interface{}{
-				searchTerm,
-			},
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("query failed: %v", err)
+	switch c.Uncertainty.Mode {
+	case "":
+		c.Uncertainty.Mode = "clamp"
+	case "clamp", "reject":
+	default:
+		return fmt.Errorf("invalid uncertainty.mode %q: expected clamp or reject", c.Uncertainty.Mode)
 	}
-	defer result.Close()
 
-	// Print each raw result for debugging
-	var rawResult interface{}
-	for result.Next() {
-		err = result.Row(&rawResult)
-		if err != nil {
-			log.Printf("Error scanning row: %v", err)
+	if err := c.Connect.validate(); err != nil {
+		return err
+	}
+	if err := c.Breaker.validate(); err != nil {
+		return err
+	}
+	if err := c.Webhooks.validate(); err != nil {
+		return err
+	}
+	if err := c.Jobs.validate(); err != nil {
+		return err
+	}
+	if err := c.Feedback.validate(); err != nil {
+		return err
+	}
+	if err := c.Frames.validate(); err != nil {
+		return err
+	}
+	if err := c.Meals.validate(); err != nil {
+		return err
+	}
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+	if err := c.RateLimit.validate(); err != nil {
+		return err
+	}
+	if err := c.Reload.validate(); err != nil {
+		return err
+	}
+	if err := c.CORS.validate(); err != nil {
+		return err
+	}
+	if err := c.Server.TLS.validate(); err != nil {
+		return err
+	}
+	if err := c.Server.Compression.validate(); err != nil {
+		return err
+	}
+	if c.Server.MaxBodyBytes == 0 {
+		c.Server.MaxBodyBytes = 1 << 20
+	}
+	if c.Server.MaxBodyBytes < 0 {
+		return errors.New("server.max_body_bytes must be positive")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("invalid server.trusted_proxies entry %q: expected an IP or CIDR", proxy)
+		}
+	}
+	if err := c.FDCAPI.validate(); err != nil {
+		return err
+	}
+	if err := c.Densities.validate(); err != nil {
+		return err
+	}
+	if err := c.States.validate(); err != nil {
+		return err
+	}
+	if err := c.Conversions.validate(); err != nil {
+		return err
+	}
+	if err := c.Recipes.validate(); err != nil {
+		return err
+	}
+	if err := c.Synonyms.validate(); err != nil {
+		return err
+	}
+	if err := c.UnresolvedFoods.validate(); err != nil {
+		return err
+	}
+	if err := c.Idempotency.validate(); err != nil {
+		return err
+	}
+	if err := c.Fuzzy.validate(); err != nil {
+		return err
+	}
+	if err := c.Cache.validate(); err != nil {
+		return err
+	}
+	if err := c.FoodMappings.validate(); err != nil {
+		return err
+	}
+
+	for option, value := range c.poolOptions() {
+		if value < 0 {
+			return fmt.Errorf("couchdb.pool.%s must not be negative, got %d", option, value)
+		}
+	}
+
+	switch c.Logging.AccessLog {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("invalid logging.access_log %q: expected text or json", c.Logging.AccessLog)
+	}
+	switch c.Logging.Format {
+	case "":
+		c.Logging.Format = logFormatJSON
+	case logFormatJSON, logFormatText:
+	default:
+		return fmt.Errorf("invalid logging.format %q: expected json or text", c.Logging.Format)
+	}
+	switch c.Logging.Level {
+	case "":
+		c.Logging.Level = "info"
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("invalid logging.level %q: expected debug, info, warn or error", c.Logging.Level)
+	}
+
+	for name, r := range c.DRI {
+		if _, ok := (Macros{}).byName()[name]; !ok {
+			return fmt.Errorf("unknown nutrient in dri config: %s", name)
+		}
+		if r.Min < 0 || r.Max < r.Min {
+			return fmt.Errorf("invalid dri range for %s: min %v, max %v", name, r.Min, r.Max)
+		}
+	}
+
+	return nil
+}
+
+// applyEnvOverrides replaces config values with their environment variable
+// counterparts, or the contents of the file named by <ENV>_FILE. Every
+// value that differs from the one in the config file is logged at debug
+// level (secrets redacted), so a stray env var can be traced, and is
+// rejected outright when strict_env is set.
+func applyEnvOverrides(config *Config) error {
+	for _, o := range config.envOverrides() {
+		value := os.Getenv(o.env)
+		if fromFile, ok, err := secretFromFile(o.env); err != nil {
+			return err
+		} else if ok {
+			value = fromFile
+		}
+		if value == "" || value == *o.value {
 			continue
 		}
-		// Print the raw result to see what we're getting
-		// log.Printf("Raw result: %+v", rawResult)
+
+		if *o.value != "" {
+			from, to := *o.value, value
+			if o.secret {
+				from, to = "[REDACTED]", "[REDACTED]"
+			}
+			if config.StrictEnv {
+				return fmt.Errorf("%w: %s is set in the config file and by %s", errStrictEnv, o.field, o.env)
+			}
+			slog.Debug("config value overridden by environment", "field", o.field, "env", o.env, "from", from, "to", to)
+		}
+		*o.value = value
+	}
+	return nil
+}
+
+// loadAppConfig reads config.yaml, falling back to the COUCHBASE_* environment
+// variables when the file is unavailable. A non-empty storage replaces the
+// configured one.
+func loadAppConfig(storage string) (*Config, error) {
+	config, err := loadConfig(configFile)
+	if errors.Is(err, errStrictEnv) || errors.Is(err, errSecret) {
+		return nil, err
+	}
+	if err != nil {
+		slog.Warn("failed to load config file, using COUCHBASE_* environment", "error", err)
+		config = &Config{}
+		config.CouchDB.URL = os.Getenv("COUCHBASE_URL")
+		config.CouchDB.Bucket = os.Getenv("COUCHBASE_BUCKET")
+		config.CouchDB.User = os.Getenv("COUCHBASE_USER")
+		config.CouchDB.Pwd = os.Getenv("COUCHBASE_PWD")
+	}
+	if storage != "" {
+		config.Storage = storage
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// connectStorage opens the configured storage, setting db and foodRepo
+func connectStorage() error {
+	var err error
+	switch cfg.Storage {
+	case storagePostgres, storageSQLite:
+		if cfg.Storage == storagePostgres {
+			foodRepo, err = openPostgres(cfg.Postgres)
+		} else {
+			foodRepo, err = openSQLite(cfg.SQLite, cfg.DefaultDataset)
+		}
+		if err != nil {
+			return err
+		}
+		// Without Couchbase every document-backed feature is disabled
+		db = &Database{}
+	default:
+		if db, err = initDB(cfg); err != nil {
+			return err
+		}
+		foodRepo = db
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		setupLogging(logFormatJSON)
+		runImport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		setupLogging(logFormatJSON)
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	offline := flag.Bool("offline", false, "serve foods from the embedded SQLite database (see sqlite in config.yaml) instead of Couchbase")
+	skipMigrations := flag.Bool("skip-migrations", false, "don't create missing Couchbase collections and indexes at startup")
+	flag.Parse()
+	setupLogging(logFormatJSON)
+
+	storage := ""
+	if *offline {
+		storage = storageSQLite
+	}
+	var err error
+	cfg, err = loadAppConfig(storage)
+	if err != nil {
+		fatal("failed to load config", err)
+	}
+	if *skipMigrations {
+		cfg.CouchDB.SkipMigrations = true
+	}
+	logger := setupLogging(cfg.Logging.Format)
+	logLevel.Set(parseLogLevel(cfg.Logging.Level))
+
+	foodBreaker = newCircuitBreaker(cfg.Breaker)
+	foodDataCache = newFoodCache(cfg.Cache)
+	authKeys = newJWKS(cfg.Auth)
+	clientLimiter.Store(newRateLimiter(cfg.RateLimit))
+	startJobWorkers(cfg.Jobs)
+
+	// Initialize database connection
+	if err := startStorage(); err != nil {
+		fatal("failed to initialize database", err)
+	}
+
+	router := newRouter(logger)
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	if cfg.Server.TLS.enabled() {
+		certs, err := newCertReloader(cfg.Server.TLS)
+		if err != nil {
+			fatal("failed to load TLS certificates", err)
+		}
+		server.TLSConfig = certs.tlsConfig()
+	}
+	var rpc *grpc.Server
+	if cfg.Server.GRPCPort != "" {
+		rpc = newGRPCServer(server.TLSConfig)
+	}
+	go watchConfig()
+	if err := serve(server, rpc, cfg.Server.ShutdownTimeout); err != nil {
+		fatal("server failed", err)
+	}
+}
+
+// newRouter sets up the middleware and routes of the REST API
+func newRouter(logger *slog.Logger) *gin.Engine {
+	router := gin.New()
+	// The entries were checked by validate
+	router.SetTrustedProxies(cfg.Server.TrustedProxies)
+	router.Use(requestID)
+	if cfg.Logging.AccessLog == "json" {
+		router.Use(jsonAccessLogger(logger))
+	} else {
+		router.Use(gin.Logger())
+	}
+	router.Use(gin.Recovery())
+	router.Use(compress)
+	router.Use(cors)
+	router.Use(rateLimit)
+	router.Use(limitBody)
+	router.Use(routeTimeout)
+	router.Use(requireStorage)
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz)
+	router.GET("/v1/openapi.json", serveOpenAPI)
+	if cfg.Server.Docs {
+		router.GET("/docs", serveDocs)
+	}
+	router.POST("/v1/calculate-macros", authenticate, idempotent, calculateMacros)
+	router.POST("/v1/calculate-macros/inline", calculateMacrosInline)
+	router.POST("/v1/jobs/calculate-macros", authenticate, idempotent, createCalculationJob)
+	router.GET("/v1/jobs/:id", authenticate, getCalculationJob)
+	router.POST("/v1/graphql", authenticate, serveGraphQL)
+	router.POST("/v1/day", calculateDay)
+	router.POST("/v1/feedback", idempotent, submitFeedback)
+	router.GET("/v1/frames/:frame_id", authenticate, getFrame)
+	router.POST("/v1/meals", authenticate, idempotent, logMeal)
+	router.GET("/v1/meals", authenticate, listMeals)
+	router.GET("/v1/daily-summary", authenticate, dailySummary)
+	router.POST("/v1/recipes", authenticate, idempotent, createRecipe)
+	router.GET("/v1/recipes/:name", authenticate, getRecipe)
+	router.GET("/v1/stats", getStats)
+	router.GET("/v1/foods/search", searchFoods)
+	router.GET("/v1/foods/:fdcId", getFood)
+
+	admin := router.Group("/admin", requireAdmin)
+	admin.GET("/foods/check", checkFoods)
+	admin.GET("/datasets/:dataset/coverage", nutrientCoverage)
+
+	v1Admin := router.Group("/v1/admin", requireAdmin)
+	v1Admin.GET("/food-mappings", listFoodMappings)
+	v1Admin.GET("/food-mappings/:name", getFoodMapping)
+	v1Admin.PUT("/food-mappings/:name", putFoodMapping)
+	v1Admin.DELETE("/food-mappings/:name", deleteFoodMapping)
+	v1Admin.GET("/conversions", listConversions)
+	v1Admin.GET("/conversions/:name", getConversion)
+	v1Admin.PUT("/conversions/:name", putConversion)
+	v1Admin.DELETE("/conversions/:name", deleteConversion)
+	v1Admin.DELETE("/recipes/:name", deleteRecipe)
+	v1Admin.GET("/synonyms", listSynonyms)
+	v1Admin.GET("/synonyms/:lang/:term", getSynonym)
+	v1Admin.PUT("/synonyms/:lang/:term", putSynonym)
+	v1Admin.DELETE("/synonyms/:lang/:term", deleteSynonym)
+	v1Admin.GET("/aliases/:term", getSynonym)
+	v1Admin.PUT("/aliases/:term", putSynonym)
+	v1Admin.DELETE("/aliases/:term", deleteSynonym)
+	v1Admin.GET("/unresolved-foods", listUnresolvedFoods)
+	v1Admin.POST("/cache/flush", flushCache)
+	return router
+}
+
+// serve runs the server, and the gRPC server when not nil, until SIGINT or
+// SIGTERM, then stops accepting connections, lets in-flight requests finish
+// within timeout and closes the Couchbase connections
+func serve(server *http.Server, rpc *grpc.Server, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 2)
+	go func() {
+		if server.TLSConfig != nil {
+			slog.Info("starting server", "addr", server.Addr, "tls", true, "client_auth", cfg.Server.TLS.ClientAuth)
+			errs <- server.ListenAndServeTLS("", "")
+			return
+		}
+		slog.Info("starting server", "addr", server.Addr)
+		errs <- server.ListenAndServe()
+	}()
+	if rpc != nil {
+		listener, err := net.Listen("tcp", ":"+cfg.Server.GRPCPort)
+		if err != nil {
+			return err
+		}
+		go func() {
+			slog.Info("starting gRPC server", "addr", listener.Addr().String())
+			errs <- rpc.Serve(listener)
+		}()
 	}
 
-	// Reset the query for actual processing
-	result, err = db.cluster.Query(
-		query,
-		&gocb.QueryOptions{
-			PositionalParameters: []interface{}{
-				searchTerm,
-			},
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("shutting down, draining requests", "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rpcStopped := make(chan struct{})
+	if rpc != nil {
+		go func() {
+			rpc.GracefulStop()
+			close(rpcStopped)
+		}()
+	}
+	shutdownErr := server.Shutdown(shutdownCtx)
+	if shutdownErr != nil {
+		slog.Warn("requests still in flight after shutdown timeout", "timeout", timeout, "error", shutdownErr)
+	}
+	// In-flight calls past the timeout are cut off like the HTTP requests
+	if rpc != nil {
+		select {
+		case <-rpcStopped:
+		case <-shutdownCtx.Done():
+			rpc.Stop()
+		}
+	}
+
+	if storageReady.Load() {
+		if db.unresolved != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+			flushUnresolved(ctx)
+			cancel()
+		}
+		if err := foodRepo.Close(); err != nil {
+			slog.Error("failed to close database connections", "error", err)
+		}
+	}
+	slog.Info("shutdown complete")
+	return nil
+}
+
+func initDB(config *Config) (*Database, error) {
+	slog.Info("connecting to Couchbase", "url", config.CouchDB.URL, "bucket", config.CouchDB.Bucket)
+
+	// Connect to cluster
+	cluster, err := gocb.Connect(connectionString(config), clusterOptions(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to cluster: %v", err)
+	}
+	// A failed attempt is retried with a new cluster, so this one is closed
+	connected := false
+	defer func() {
+		if !connected {
+			cluster.Close(nil)
+		}
+	}()
+
+	slog.Info("connected to cluster, opening bucket", "bucket", config.CouchDB.Bucket)
+
+	// Get bucket with longer timeout
+	bucket := cluster.Bucket(config.CouchDB.Bucket)
+
+	// Increase the wait time for bucket readiness
+	err = bucket.WaitUntilReady(30*time.Second, nil)
+	if err != nil {
+		if missing := missingBucketError(cluster.Buckets(), config.CouchDB.Bucket, err); missing != nil {
+			return nil, missing
+		}
+		return nil, fmt.Errorf("failed to connect to bucket: %v", err)
+	}
+
+	if err := probeConnectivity(config.CouchDB.Probe, cluster, bucket); err != nil {
+		return nil, err
+	}
+	if !config.CouchDB.SkipMigrations {
+		if err := migrateCouchbase(context.Background(), config, cluster, bucket); err != nil {
+			return nil, fmt.Errorf("%w (start with --skip-migrations if the collections and indexes are managed elsewhere)", err)
+		}
+	}
+	if err := waitForIndexes(clusterIndexes{cluster: cluster}, config.CouchDB.Bucket, config.CouchDB.WaitForIndexes); err != nil {
+		return nil, err
+	}
+
+	database := newDatabase(config, cluster, bucket)
+	slog.Info("connected to Couchbase", "bucket", config.CouchDB.Bucket, "default_dataset", config.DefaultDataset, "keyspace", database.keyspaces[config.DefaultDataset])
+	connected = true
+	return database, nil
+}
+
+// newDatabase opens the collection handles and keyspaces the config names
+// in a connected bucket
+func newDatabase(config *Config, cluster *gocb.Cluster, bucket *gocb.Bucket) *Database {
+	defaultDataset := config.Datasets[config.DefaultDataset]
+	scope := bucket.Scope(defaultDataset.Scope)
+	collection := scope.Collection(defaultDataset.Collection)
+
+	database := &Database{
+		cluster:    cluster,
+		bucket:     bucket,
+		scope:      scope,
+		collection: collection,
+		keyspaces:  make(map[string]string, len(config.Datasets)),
+	}
+	for name, dataset := range config.Datasets {
+		database.keyspaces[name] = keyspaceFor(config.CouchDB.Bucket, dataset.Scope, dataset.Collection)
+	}
+	if config.Feedback.enabled() {
+		database.feedback = &couchbaseFeedback{collection: bucket.Scope(config.Feedback.Scope).Collection(config.Feedback.Collection)}
+	}
+	if config.Frames.enabled() {
+		database.frames = &couchbaseFrames{
+			collection: bucket.Scope(config.Frames.Scope).Collection(config.Frames.Collection),
+			expiry:     config.Frames.Expiry,
+		}
+	}
+	if config.Meals.enabled() {
+		database.meals = &couchbaseMeals{
+			cluster:    cluster,
+			collection: bucket.Scope(config.Meals.Scope).Collection(config.Meals.Collection),
+			keyspace:   keyspaceFor(config.CouchDB.Bucket, config.Meals.Scope, config.Meals.Collection),
+		}
+	}
+	if config.Conversions.enabled() {
+		database.conversions = bucket.Scope(config.Conversions.Scope).Collection(config.Conversions.Collection)
+		database.conversionsKeyspace = keyspaceFor(config.CouchDB.Bucket, config.Conversions.Scope, config.Conversions.Collection)
+	}
+	if config.Recipes.enabled() {
+		database.recipes = bucket.Scope(config.Recipes.Scope).Collection(config.Recipes.Collection)
+		database.recipesKeyspace = keyspaceFor(config.CouchDB.Bucket, config.Recipes.Scope, config.Recipes.Collection)
+	}
+	if config.Synonyms.enabled() {
+		database.synonyms = bucket.Scope(config.Synonyms.Scope).Collection(config.Synonyms.Collection)
+		database.synonymsKeyspace = keyspaceFor(config.CouchDB.Bucket, config.Synonyms.Scope, config.Synonyms.Collection)
+	}
+	if config.UnresolvedFoods.enabled() {
+		database.unresolved = bucket.Scope(config.UnresolvedFoods.Scope).Collection(config.UnresolvedFoods.Collection)
+		database.unresolvedKeyspace = keyspaceFor(config.CouchDB.Bucket, config.UnresolvedFoods.Scope, config.UnresolvedFoods.Collection)
+	}
+	if config.Idempotency.enabled() {
+		database.idempotency = &couchbaseIdempotency{
+			collection: bucket.Scope(config.Idempotency.Scope).Collection(config.Idempotency.Collection),
+			ttl:        config.Idempotency.TTL,
+		}
+	}
+	return database
+}
+
+// clusterOptions configures the cluster for cloud connectivity
+func clusterOptions(config *Config) gocb.ClusterOptions {
+	clusterOpts := gocb.ClusterOptions{
+		Authenticator: gocb.PasswordAuthenticator{
+			Username: config.CouchDB.User,
+			Password: config.CouchDB.Pwd,
+		},
+		SecurityConfig: gocb.SecurityConfig{
+			TLSSkipVerify: false,
 		},
-	)
+		TimeoutsConfig: gocb.TimeoutsConfig{
+			ConnectTimeout: time.Second * 30,
+			KVTimeout:      time.Second * 30,
+			QueryTimeout:   time.Second * 30,
+		},
+	}
+
+	if size := config.CouchDB.Pool.ConnectionBufferSize; size > 0 {
+		clusterOpts.InternalConfig.ConnectionBufferSize = uint(size)
+	}
+	return clusterOpts
+}
+
+// bucketLister is the part of the cluster's bucket manager used to tell a
+// missing bucket from a slow one
+type bucketLister interface {
+	GetAllBuckets(opts *gocb.GetAllBucketsOptions) (map[string]gocb.BucketSettings, error)
+}
+
+// missingBucketError explains a failed bucket wait caused by the bucket not
+// existing. A missing bucket often only surfaces as a timeout, so the
+// cluster's buckets are listed to tell the two apart; listing needs bucket
+// management rights and is skipped when denied. It returns nil when the
+// bucket isn't known to be missing.
+func missingBucketError(manager bucketLister, name string, err error) error {
+	notFound := errors.Is(err, gocb.ErrBucketNotFound)
+	if !notFound && !errors.Is(err, gocb.ErrTimeout) {
+		return nil
+	}
+
+	buckets, listErr := manager.GetAllBuckets(&gocb.GetAllBucketsOptions{Timeout: 10 * time.Second})
+	if listErr != nil {
+		slog.Warn("could not list buckets", "error", listErr)
+		if notFound {
+			return fmt.Errorf("bucket %q does not exist; check couchdb.bucket", name)
+		}
+		return nil
+	}
+	if _, ok := buckets[name]; ok {
+		return nil
+	}
+
+	names := make([]string, 0, len(buckets))
+	for bucketName := range buckets {
+		names = append(names, bucketName)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("bucket %q does not exist; check couchdb.bucket (available buckets: %s)", name, strings.Join(names, ", "))
+}
+
+// Startup probes selectable with couchdb.probe
+const (
+	probeQuery = "query"
+	probeKV    = "kv"
+	probeNone  = "none"
+)
+
+// clusterQuerier and bucketPinger are the parts of the cluster and bucket
+// the startup probes use
+type clusterQuerier interface {
+	Query(statement string, opts *gocb.QueryOptions) (*gocb.QueryResult, error)
+}
+
+type bucketPinger interface {
+	Ping(opts *gocb.PingOptions) (*gocb.PingResult, error)
+}
+
+// probeConnectivity verifies the cluster is usable before serving. The query
+// probe needs the query service to accept ad-hoc statements, which some
+// locked-down clusters refuse; those can ping the KV service instead.
+func probeConnectivity(mode string, cluster clusterQuerier, bucket bucketPinger) error {
+	switch mode {
+	case probeQuery:
+		// Try a simple query to verify connectivity
+		result, err := cluster.Query(
+			"SELECT RAW 1",
+			&gocb.QueryOptions{},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to execute test query: %v", err)
+		}
+		result.Close()
+	case probeKV:
+		report, err := bucket.Ping(&gocb.PingOptions{
+			ServiceTypes: []gocb.ServiceType{gocb.ServiceTypeKeyValue},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to ping key-value service: %v", err)
+		}
+		endpoints := report.Services[gocb.ServiceTypeKeyValue]
+		if len(endpoints) == 0 {
+			return fmt.Errorf("no key-value endpoints answered the ping")
+		}
+		for _, endpoint := range endpoints {
+			if endpoint.State != gocb.PingStateOk {
+				return fmt.Errorf("key-value endpoint %s is not ok: %s", endpoint.Remote, endpoint.Error)
+			}
+		}
+	case probeNone:
+		slog.Info("skipping startup connectivity probe")
+	}
+	return nil
+}
+
+// poolOptions maps the connection string options the SDK reads its pool
+// sizes from to their configured values
+func (c *Config) poolOptions() map[string]int {
+	pool := c.CouchDB.Pool
+	return map[string]int{
+		"kv_pool_size":                      pool.KVPoolSize,
+		"max_queue_size":                    pool.MaxQueueSize,
+		"connection_buffer_size":            pool.ConnectionBufferSize,
+		"max_perhost_http_connections":      pool.MaxHTTPConnsPerHost,
+		"max_idle_http_connections":         pool.MaxIdleHTTPConns,
+		"max_perhost_idle_http_connections": pool.MaxIdleHTTPConnsPerHost,
+	}
+}
+
+// connectionString builds the cluster connection string. Pool sizes are
+// only tunable through connection string options, so the configured ones
+// are appended there; the buffer size is set on ClusterOptions instead.
+func connectionString(config *Config) string {
+	params := url.Values{}
+	for option, value := range config.poolOptions() {
+		if value > 0 && option != "connection_buffer_size" {
+			params.Set(option, strconv.Itoa(value))
+		}
+	}
+
+	connStr := fmt.Sprintf("couchbases://%s", config.CouchDB.URL)
+	if len(params) > 0 {
+		connStr += "?" + params.Encode()
+	}
+	return connStr
+}
+
+func calculateMacros(c *gin.Context) {
+	start := time.Now()
+
+	var request VolumeRequest
+	if err := bindJSON(c, &request); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+
+	if err := validateVolumes("data.volumes", request.Data.Volumes); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	format, err := parseOutputFormat(c)
 	if err != nil {
-		return nil, fmt.Errorf("query failed: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	micros, err := parseNutrientSelection(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Data.CallbackURL != "" {
+		if err := validateCallbackURL(request.Data.CallbackURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var user string
+	if request.Data.Persist {
+		if err := validatePersist(request.Data.FrameID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ok bool
+		if user, ok = requireUser(c); !ok {
+			return
+		}
+	}
+	if rejectWhileBreakerOpen(c) {
+		return
+	}
+	if !requireDataVersion(c) {
+		return
+	}
+
+	cc := newCalcContext(c)
+	cc.micros = micros
+	response := computeFrame(cc, request, format)
+	if timedOut(c) {
+		return
 	}
-	defer result.Close()
 
-	var food FoodData
-	if result.Next() {
-		err := result.Row(&food)
+	if c.Query("meta") == "true" {
+		response.Meta = &ProcessingMeta{
+			ProcessingTimeMs: float64(time.Since(start).Microseconds()) / 1000,
+			QueriesExecuted:  cc.stats.queries,
+			CacheHits:        cc.stats.cacheHits,
+			CacheMisses:      cc.stats.cacheMisses,
+		}
+	}
+
+	// A degraded result isn't stored or sent on, so it can't replace a
+	// complete one; the client is told to retry instead
+	if response.Status == responseStatusDegraded {
+		respondVersioned(c, degradedStatus(c), response)
+		return
+	}
+
+	if request.Data.Persist {
+		if err := storeFrame(c, user, request.Data.FrameID, response); err != nil {
+			if timedOut(c) {
+				return
+			}
+			respondError(c, http.StatusBadGateway, "failed to store result", err)
+			return
+		}
+	}
+
+	if request.Data.CallbackURL != "" {
+		sendWebhook(c.Request.Context(), request.Data.CallbackURL, request.Data.FrameID, response)
+	}
+
+	respondVersioned(c, http.StatusOK, response)
+}
+
+// computeFrame computes every volume of a request. It is shared by the REST
+// and gRPC APIs, which validate the request and set up cc beforehand.
+func computeFrame(cc *calcContext, request VolumeRequest, format outputFormat) MacroResponse {
+	response := MacroResponse{
+		CalcVersion: CalcVersion,
+		Data:        make([]MacroData, 0),
+		Summary:     FrameSummary{FrameID: request.Data.FrameID},
+		EnergyUnit:  format.energyUnit,
+	}
+
+	if request.Data.Scale != nil {
+		cc.scale = *request.Data.Scale
+		response.Scale = cc.scale
+	}
+	cc.prefetch(request.Data.Volumes)
+	var totals Macros
+	for _, volume := range request.Data.Volumes {
+		macroData := processFoodVolume(volume, cc)
+		if macroData.Found {
+			totals = totals.add(macroData.Macros)
+		} else {
+			response.Summary.Unresolved++
+		}
+		format.item(&macroData)
+		response.Data = append(response.Data, macroData)
+	}
+	// Totals are summed unformatted so they aren't off by the items' rounding
+	response.Summary.Totals = format.macros(totals)
+	response.Status = responseStatus(response.Data)
+
+	hash, err := resultHash(request, response)
+	if err != nil {
+		slog.ErrorContext(cc.ctx, "failed to hash result", "error", err)
+	}
+	response.ResultHash = hash
+	return response
+}
+
+// frameOptions are the REST query options of a calculation, for the APIs
+// that take them in the request instead
+type frameOptions struct {
+	dataVersion string
+	perGram     bool
+	perServing  bool
+	nutrients   string
+	energyUnit  string
+	precision   string
+	languages   []string
+}
+
+// Errors of prepareFrame, for the APIs to map to their status codes
+var (
+	errInvalidRequest     = errors.New("invalid request")
+	errUnknownDataVersion = errors.New("unknown data_version")
+	errFoodsUnavailable   = errors.New("food database is temporarily unavailable")
+)
+
+// prepareFrame validates a request and its options the way calculateMacros
+// does and sets up the calculation for computeFrame
+func prepareFrame(ctx context.Context, request VolumeRequest, options frameOptions) (*calcContext, outputFormat, error) {
+	if err := validateRequest(&request); err != nil {
+		return nil, outputFormat{}, fmt.Errorf("%w: %v", errInvalidRequest, err)
+	}
+	if err := validateVolumes("data.volumes", request.Data.Volumes); err != nil {
+		return nil, outputFormat{}, fmt.Errorf("%w: %v", errInvalidRequest, err)
+	}
+	format, err := newOutputFormat(options.energyUnit, options.precision)
+	if err != nil {
+		return nil, format, fmt.Errorf("%w: %v", errInvalidRequest, err)
+	}
+	micros, err := selectNutrients(options.nutrients)
+	if err != nil {
+		return nil, format, fmt.Errorf("%w: %v", errInvalidRequest, err)
+	}
+	if !storageReady.Load() || foodBreaker.retryAfter() > 0 {
+		return nil, format, errFoodsUnavailable
+	}
+	if options.dataVersion != "" {
+		exists, err := foodRepo.HasDataVersion(ctx, options.dataVersion)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode food data: %v", err)
+			return nil, format, fmt.Errorf("failed to look up data version: %w", err)
+		}
+		if !exists {
+			return nil, format, fmt.Errorf("%w: %s", errUnknownDataVersion, options.dataVersion)
 		}
-		log.Printf("Found food: %s with %d portions", food.Description, len(food.FoodPortions))
-		return &food, nil
 	}
 
-	return nil, fmt.Errorf("no matching food found for: %s", searchTerm)
+	return &calcContext{
+		ctx:         ctx,
+		perGram:     options.perGram,
+		perServing:  options.perServing,
+		micros:      micros,
+		dataVersion: options.dataVersion,
+		languages:   options.languages,
+		scale:       1,
+	}, format, nil
+}
+
+// newCalcContext reads the lookup options from the request's query string
+// and headers
+func newCalcContext(c *gin.Context) *calcContext {
+	return &calcContext{
+		ctx:         c.Request.Context(),
+		variants:    c.Query("variants") == "true",
+		portions:    c.Query("include_portions") == "true",
+		candidates:  c.Query("candidates") == "true",
+		perGram:     c.Query("per_gram") == "true",
+		perServing:  c.Query("per_serving") == "true",
+		consensus:   c.Query("consensus") == "true",
+		dataVersion: c.Query("data_version"),
+		languages:   requestLanguages(c),
+		scale:       1,
+		stats: requestStats{
+			// Statements reveal the data layout, so never outside dev mode
+			recordQueries: devMode() && c.Query("debug_query") == "true",
+		},
+	}
+}
+
+// validateVolumes checks the client-supplied fields of each volume; path
+// prefixes the field names in the returned error
+func validateVolumes(path string, volumes []Volume) error {
+	for i, volume := range volumes {
+		if limit := maxUncertainty(volume); cfg.Uncertainty.Mode == "reject" && volume.UncertaintyCups > limit {
+			return invalidField(fmt.Sprintf("%s[%d].uncertainty_cups", path, i), fieldCodeOutOfRange, "exceeds the maximum of %g cups", limit)
+		}
+	}
+	return nil
+}
+
+// Update the struct to match exactly what's in Couchbase
+func processFoodVolume(volume Volume, cc *calcContext) (macroData MacroData) {
+	macroData = MacroData{
+		Found:           false,
+		RequestedFood:   volume.ObjectName,
+		RequestedVolume: volume.VolumeCups,
+		RequestedUnit:   volume.Unit,
+		State:           volume.State,
+	}
+	defer func() { recordUnresolved(macroData) }()
+	if translated := cc.translate(volume); translated.ObjectName != volume.ObjectName {
+		volume = translated
+		macroData.ResolvedName = volume.ObjectName
+	}
+	volume.applyUnit()
+	volume.VolumeCups *= cc.scale
+	volume.UncertaintyCups *= cc.scale
+	if limit := maxUncertainty(volume); volume.UncertaintyCups > limit {
+		slog.InfoContext(cc.ctx, "clamping uncertainty", "food", volume.ObjectName, "from_cups", volume.UncertaintyCups, "to_cups", limit)
+		volume.UncertaintyCups = limit
+		macroData.UncertaintyClamped = true
+	}
+	if cc.stats.recordQueries {
+		executed := len(cc.stats.executed)
+		defer func() { macroData.Queries = cc.stats.executed[executed:] }()
+	}
+	// Saved recipes take precedence over foods of the same name
+	if recipe, ok := findRecipe(cc.ctx, volume.ObjectName); ok {
+		return recipeMacros(cc, volume, recipe, macroData)
+	}
+	var variants []DatasetVariant
+	if cc.variants || cc.consensus {
+		variants = datasetVariants(cc.ctx, volume, cc.dataVersion, &cc.stats)
+	}
+	if cc.variants {
+		macroData.Variants = variants
+	}
+
+	// Get food data based on object name and state
+	dataset := datasetFor(volume.ObjectName)
+	mappings, _ := foodMappings.all(cc.ctx)
+	lookup, err := cc.lookupFoods(dataset, lookupName(volume, mappings))
+	var foodData *FoodData
+	if err == nil {
+		foodData, err = pickFood(lookup.foods)
+	}
+	if err != nil {
+		// An open breaker fails every lookup; it logged when it tripped
+		if errors.Is(err, errBreakerOpen) {
+			slog.DebugContext(cc.ctx, "food lookup skipped", "food", volume.ObjectName, "error", err)
+		} else {
+			slog.WarnContext(cc.ctx, "food lookup failed", "food", volume.ObjectName, "error", err)
+		}
+		macroData.ErrorCode, macroData.ErrorMessage = lookupErrorCode(err)
+		macroData.Suggestions = suggestionsFor(err)
+		return macroData
+	}
+	if cc.candidates && cfg.Duplicates.Mode == duplicatesAll {
+		macroData.Candidates = foodCandidates(cc.ctx, volume, lookup.foods)
+	}
+	if cc.portions {
+		macroData.Portions = foodData.FoodPortions
+	}
+
+	result, ok := computeMacros(cc.ctx, volume, foodData)
+	if !ok {
+		macroData.ErrorCode = errorCodeNoPortionData
+		macroData.ErrorMessage = fmt.Sprintf("%s has no portion or density to convert cups to grams", foodData.Description)
+		return macroData
+	}
+	if missing := missingNutrients(result.nutrients, result.caloriesComputed); cfg.Nutrients.Missing == missingNutrientsReject && len(missing) > 0 {
+		slog.InfoContext(cc.ctx, "rejecting food with missing nutrients", "food", volume.ObjectName, "missing", missing)
+		macroData.ErrorCode = errorCodeMissingNutrients
+		macroData.ErrorMessage = fmt.Sprintf("%s has no entry for %s", foodData.Description, strings.Join(missing, ", "))
+		return macroData
+	}
+	factor := stateYieldFactor(normalizeFoodName(volume.ObjectName), volume.State, foodData.Description)
+	if factor != 1 {
+		result.macros = result.macros.times(factor)
+		macroData.YieldFactor = factor
+	}
+
+	macroData.Found = true
+	macroData.Dataset = dataset
+	macroData.Description = localizedDescription(foodData, cc.languages)
+	macroData.Category = foodData.category()
+	macroData.Confidence = lookup.confidence
+	macroData.DataVersion = foodData.DataVersion
+	macroData.Macros = result.macros
+	macroData.CalculatedWeight = result.grams
+	macroData.CaloriesComputed = result.caloriesComputed
+	macroData.PortionUsed = result.match.portion
+	macroData.MatchQuality = result.match.quality
+	macroData.DensityGramsPerML = result.match.density
+	macroData.DensityOverride = volume.DensityGramsPerCup != nil
+	macroData.PercentError = percentError(volume)
+	macroData.Range = macroRange(result.macros, volume)
+	macroData.Nutrients = microNutrientAmounts(foodData.FoodNutrients, result.grams*factor, cc.micros)
+	if perGram, ok := result.macros.perGram(result.grams); cc.perGram && ok {
+		macroData.PerGram = &perGram
+	}
+	if per100g, ok := result.macros.per100g(result.grams); ok {
+		macroData.Per100g = &per100g
+	}
+	if cc.perServing {
+		macroData.PerServing = foodServing(cc.ctx, volume, foodData, result)
+	}
+	macroData.DRIStatus = driStatus(result.macros, cfg.DRI)
+	macroData.NutrientStatus = result.nutrients
+	if cc.consensus {
+		macroData.Consensus = consensusMacros(variants, result.grams)
+	}
+	return macroData
+}
+
+// lookupErrorCode classifies a failed food lookup for the item's error_code
+// and error_message. Database failures aren't detailed outside development
+// mode; they are logged instead.
+func lookupErrorCode(err error) (code, message string) {
+	switch {
+	case errors.Is(err, errInvalidFood):
+		return errorCodeInvalidFood, err.Error()
+	case errors.Is(err, errAmbiguousFood):
+		return errorCodeAmbiguousFood, err.Error()
+	case errors.Is(err, errUnknownFood):
+		return errorCodeUnknownFood, err.Error()
+	case errors.Is(err, errBreakerOpen):
+		return errorCodeDatabase, err.Error()
+	}
+	if devMode() {
+		return errorCodeDatabase, err.Error()
+	}
+	return errorCodeDatabase, "food lookup failed"
+}
+
+// responseStatus summarizes the items of a response
+func responseStatus(data []MacroData) string {
+	status := responseStatusOK
+	for _, item := range data {
+		if item.ErrorCode == errorCodeDatabase {
+			return responseStatusDegraded
+		}
+		if !item.Found {
+			status = responseStatusPartial
+		}
+	}
+	return status
+}
+
+// maxUncertainty returns the largest uncertainty accepted for a volume
+func maxUncertainty(volume Volume) float64 {
+	return volume.VolumeCups * cfg.Uncertainty.MaxRatio
+}
+
+// MacroRange holds the macros at the low and high end of the uncertainty
+type MacroRange struct {
+	Min Macros `json:"min"`
+	Max Macros `json:"max"`
+}
+
+// macroRange scales the macros to the volume minus and plus its
+// uncertainty. Macros are linear in volume, so this is exact for the cup
+// density used; the low end stops at zero.
+func macroRange(macros Macros, volume Volume) *MacroRange {
+	if volume.VolumeCups <= 0 || volume.UncertaintyCups <= 0 {
+		return nil
+	}
+	low := max(volume.VolumeCups-volume.UncertaintyCups, 0) / volume.VolumeCups
+	high := (volume.VolumeCups + volume.UncertaintyCups) / volume.VolumeCups
+	return &MacroRange{Min: macros.times(low), Max: macros.times(high)}
+}
+
+// maxPercentError caps PercentError; beyond it the figure stops being useful
+const maxPercentError = 100.0
+
+// percentError is the volume uncertainty relative to the volume. Macros scale
+// linearly with volume, so it is also their relative error.
+func percentError(volume Volume) float64 {
+	if volume.VolumeCups <= 0 {
+		return 0
+	}
+	return min(100*volume.UncertaintyCups/volume.VolumeCups, maxPercentError)
+}
+
+// computation is the outcome of scaling a food to a requested volume
+type computation struct {
+	macros           Macros
+	grams            float64
+	caloriesComputed bool
+	// nutrients is the presence of each macro's nutrient entry
+	nutrients map[string]string
+	match     portionMatch
+}
+
+// computeMacros scales a food's nutrients to the requested volume. It reports
+// false when no weight per cup can be derived for the food.
+func computeMacros(ctx context.Context, volume Volume, foodData *FoodData) (computation, bool) {
+	if volume.byWeight {
+		macros, caloriesComputed, nutrients := calculateMacrosForGrams(foodData.FoodNutrients, volume.VolumeCups, volume.VolumeCups)
+		return computation{
+			macros:           macros,
+			grams:            volume.VolumeCups,
+			caloriesComputed: caloriesComputed,
+			nutrients:        nutrients,
+			match:            portionMatch{grams: volume.VolumeCups, quality: matchWeight},
+		}, true
+	}
+
+	var match portionMatch
+	if volume.DensityGramsPerCup != nil {
+		match = portionMatch{grams: *volume.DensityGramsPerCup, quality: matchOverride}
+		slog.DebugContext(ctx, "using client density override", "food", volume.ObjectName, "grams_per_cup", match.grams)
+	} else if named, ok := findPortionByDescription(ctx, volume.PortionDescription, foodData.FoodPortions); ok {
+		match = named
+		slog.DebugContext(ctx, "using requested portion", "food", volume.ObjectName, "requested", volume.PortionDescription, "portion", named.portion, "grams", named.grams)
+	} else {
+		if volume.PortionDescription != "" {
+			slog.InfoContext(ctx, "requested portion not found, falling back to cups", "food", volume.ObjectName, "portion", volume.PortionDescription)
+		}
+		match = findCupGrams(ctx, volume, foodData)
+		if match.grams == 0 {
+			match = categoryDensity(ctx, foodData)
+		}
+		match = applyLearnedDensity(ctx, volume.ObjectName, match)
+	}
+
+	if match.grams == 0 {
+		return computation{}, false
+	}
+
+	// Calculate total grams based on requested cups
+	calculatedGrams := volume.VolumeCups * match.grams
+
+	// Get nutrient values
+	macros, caloriesComputed, nutrients := calculateMacrosForGrams(foodData.FoodNutrients, calculatedGrams, match.grams)
+	return computation{
+		macros:           macros,
+		grams:            calculatedGrams,
+		caloriesComputed: caloriesComputed,
+		nutrients:        nutrients,
+		match:            match,
+	}, true
+}
+
+// driStatus labels each nutrient with a configured range as below, within or
+// above that range. Nutrients without a range are left out.
+func driStatus(macros Macros, ranges map[string]DRIRange) map[string]string {
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	values := macros.byName()
+	status := make(map[string]string, len(ranges))
+	for name, r := range ranges {
+		switch value := values[name]; {
+		case value < r.Min:
+			status[name] = "below"
+		case value > r.Max:
+			status[name] = "above"
+		default:
+			status[name] = "within"
+		}
+	}
+	return status
+}
+
+func calculateMacrosForGrams(nutrients []Nutrient, calculatedGrams, baseGrams float64) (Macros, bool, map[string]string) {
+	var macros Macros
+	ratio := calculatedGrams / 100.0 // nutrients are per 100g
+
+	// A missing entry and an entry of 0 both leave the macro at zero, so
+	// record which one it was
+	status := make(map[string]string, len(macroNutrients))
+	for _, macro := range macroNutrients {
+		status[macro.Name] = nutrientMissing
+	}
+
+	reported := false
+	for _, nutrient := range nutrients {
+		if name, ok := macroNutrientName(nutrient.Nutrient.Number); ok {
+			status[name] = nutrientPresent
+			if nutrient.Amount == 0 {
+				status[name] = nutrientZero
+			}
+		}
+		switch nutrient.Nutrient.Number {
+		case "208": // Energy (kcal)
+			macros.Calories = nutrient.Amount * ratio
+			reported = true
+		case "203": // Protein
+			macros.Protein = nutrient.Amount * ratio
+		case "204": // Total fat
+			macros.Fat = nutrient.Amount * ratio
+		case "205": // Carbohydrates
+			macros.Carbs = nutrient.Amount * ratio
+		}
+	}
+
+	// Some records report calories that disagree with their macros, so the
+	// configured source decides which one is authoritative
+	computed := false
+	switch cfg.Calories.Source {
+	case calorieSourceComputed:
+		computed = true
+	case calorieSourcePreferReported:
+		computed = !reported
+	}
+	if computed {
+		macros.Calories = atwaterCalories(macros)
+	}
+
+	return macros, computed, status
+}
+
+// macroNutrients lists the FDC nutrient numbers the macros are read from
+var macroNutrients = []struct {
+	Number string
+	Name   string
+}{
+	{"208", "calories"},
+	{"203", "protein"},
+	{"204", "fat"},
+	{"205", "carbs"},
+}
+
+func macroNutrientName(number string) (string, bool) {
+	for _, macro := range macroNutrients {
+		if macro.Number == number {
+			return macro.Name, true
+		}
+	}
+	return "", false
+}
+
+// Handling of missing nutrients selectable with nutrients.missing
+const (
+	missingNutrientsZero   = "zero"
+	missingNutrientsReject = "reject"
+)
+
+// Per-macro nutrient presence reported in nutrient_status
+const (
+	nutrientPresent = "present"
+	nutrientZero    = "zero" // the food has an entry with amount 0
+	nutrientMissing = "missing"
+)
+
+// missingNutrients lists the macros the food has no entry for; calories
+// don't count when they were computed from the other macros
+func missingNutrients(status map[string]string, caloriesComputed bool) []string {
+	var missing []string
+	for _, macro := range macroNutrients {
+		if status[macro.Name] != nutrientMissing || (macro.Name == "calories" && caloriesComputed) {
+			continue
+		}
+		missing = append(missing, macro.Name)
+	}
+	return missing
+}
+
+// Calorie sources selectable with calories.source
+const (
+	calorieSourceReported       = "reported"
+	calorieSourceComputed       = "computed"
+	calorieSourcePreferReported = "prefer_reported"
+)
+
+// atwaterCalories derives energy from the macros using the general Atwater
+// factors of 4 kcal/g for protein and carbohydrate and 9 kcal/g for fat
+func atwaterCalories(m Macros) float64 {
+	return 4*m.Protein + 4*m.Carbs + 9*m.Fat
+}
+
+// errInvalidFood marks object names that can't be turned into a search term
+var errInvalidFood = errors.New("invalid food name")
+
+// errQueryFailed marks lookups where Couchbase rejected or failed the query
+var errQueryFailed = errors.New("query failed")
+
+// errResultStream marks a query whose result stream failed part way, so a
+// truncated result isn't mistaken for "no match"
+var errResultStream = errors.New("query result stream failed")
+
+// defaultFoodMappings maps the object names the vision pipeline detects to
+// the dataset description they are looked up by, until food_mappings
+// holds mappings of its own
+var defaultFoodMappings = map[string]string{
+	"egg":         "Egg, whole, boiled or poached",
+	"egg:raw":     "Egg, whole, raw",
+	"rice":        "Rice, cooked, NFS",
+	"banana":      "Banana, raw",
+	"cucumber":    "Cucumber, raw",
+	"apple":       "Apple, dried",
+	"sugar-melon": "Cantaloupe, raw",
+}
+
+// normalizeFoodName canonicalizes an object name from the vision pipeline
+func normalizeFoodName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// foodLookup is the outcome of resolving an object name
+type foodLookup struct {
+	// foods share the matched description, ordered by fdcId
+	foods []FoodData
+	// confidence is 1 for mapped names and the fuzzy match score otherwise
+	confidence float64
+}
+
+// lookupFoods resolves an object name to foods: mapped names query their
+// search term, any other name is matched fuzzily against the descriptions.
+// A non-empty version only matches documents ingested as that data version.
+func lookupFoods(ctx context.Context, dataset, objectName, version string, stats *requestStats) (foodLookup, error) {
+	objectName = normalizeFoodName(objectName)
+	if objectName == "" {
+		return foodLookup{}, fmt.Errorf("%w: empty object name", errInvalidFood)
+	}
+
+	searchTerm, ok, err := foodMappings.searchTerm(ctx, objectName)
+	if err != nil {
+		return foodLookup{}, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	if !ok && cfg.Fuzzy.Disabled {
+		return foodLookup{}, &unmatchedError{objectName: objectName}
+	}
+
+	// Never query with a blank term, it could match unintended rows
+	if ok && strings.TrimSpace(searchTerm) == "" {
+		return foodLookup{}, fmt.Errorf("%w: %s resolves to an empty search term", errInvalidFood, objectName)
+	}
+
+	if !foodBreaker.allow() {
+		return foodLookup{}, errBreakerOpen
+	}
+	lookup := foodLookup{confidence: 1}
+	if !ok {
+		searchTerm, lookup.confidence, err = fuzzyMatch(ctx, dataset, objectName, version, stats)
+	}
+	if err == nil {
+		lookup.foods, err = queryFoodByDescription(ctx, dataset, searchTerm, version, candidateLimit(), stats)
+	}
+	// A lookup cut short by the request's own deadline says nothing about
+	// the cluster's health
+	if ctx.Err() != nil {
+		foodBreaker.release()
+	} else {
+		foodBreaker.record(!isDatabaseError(err))
+	}
+	return lookup, err
+}
+
+// isDatabaseError reports whether a lookup failed because of Couchbase
+// rather than because the food doesn't exist
+func isDatabaseError(err error) bool {
+	return errors.Is(err, errQueryFailed) || errors.Is(err, errResultStream)
+}
+
+func queryFoodByDescription(ctx context.Context, dataset, searchTerm, version string, limit int, stats *requestStats) ([]FoodData, error) {
+	matches, err := queryFoodsByDescriptions(ctx, dataset, []string{searchTerm}, version, stats)
+	if err != nil {
+		return nil, err
+	}
+	foods := matches[strings.ToLower(searchTerm)]
+	if len(foods) == 0 {
+		return nil, fmt.Errorf("%w: no matching food found for %s", errUnknownFood, searchTerm)
+	}
+	return foods[:min(len(foods), limit)], nil
+}
+
+// queryFoodsByDescriptions fetches the foods for several search terms in a
+// single query, grouped by lowercased description. Ordering by fdcId keeps
+// the pick stable when several foods share a description.
+func queryFoodsByDescriptions(ctx context.Context, dataset string, searchTerms []string, version string, stats *requestStats) (map[string][]FoodData, error) {
+	foods := make(map[string][]FoodData, len(searchTerms))
+	var lowered []string
+	for _, term := range searchTerms {
+		term = strings.ToLower(term)
+		if cached, ok := foodDataCache.get(foodCacheKey(dataset, version, term)); ok {
+			stats.cacheHits++
+			foods[term] = cached
+			continue
+		}
+		stats.cacheMisses++
+		lowered = append(lowered, term)
+	}
+	if len(lowered) == 0 {
+		return foods, nil
+	}
+
+	fetched, err := foodRepo.GetByDescription(ctx, dataset, lowered, version, stats)
+	if err != nil {
+		return nil, err
+	}
+	// Foods from the FDC API carry no data version, so they can't satisfy
+	// a pinned one
+	if cfg.FDCAPI.enabled() && version == "" {
+		for _, term := range lowered {
+			if _, ok := fetched[term]; !ok {
+				if matches := fetchFromFDC(ctx, dataset, term); len(matches) > 0 {
+					fetched[term] = matches
+				}
+			}
+		}
+	}
+
+	// Only found foods are cached, so a newly ingested food shows up on the
+	// next lookup
+	for key, matches := range fetched {
+		foodDataCache.put(foodCacheKey(dataset, version, key), matches)
+		foods[key] = matches
+	}
+	return foods, nil
 }
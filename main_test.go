@@ -0,0 +1,1240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	// Tests that check logs capture them; the rest would only be noise
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	// Handlers tested on their own run with the breaker and cache out of
+	// the way
+	foodBreaker = newCircuitBreaker(BreakerConfig{Disabled: true})
+	foodDataCache = newFoodCache(CacheConfig{Disabled: true})
+	foodMappings = newMappingCache(&fileMappings{})
+	os.Exit(m.Run())
+}
+
+// captureLogs sends slog's default logger to a buffer of JSON lines for
+// the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: logLevel})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+// logEntries decodes the captured log lines
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// writeConfig writes a config file for loadConfig to read
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		env      map[string]string
+		wantErr  error
+		wantURL  string
+		wantLogs []map[string]any
+	}{
+		{
+			name:    "env fills unset value without a log",
+			config:  "logging:\n  level: debug\n",
+			env:     map[string]string{"COUCHDB_URL": "couchbase://env"},
+			wantURL: "couchbase://env",
+		},
+		{
+			name:    "env equal to the file is not an override",
+			config:  "logging:\n  level: debug\ncouchdb:\n  url: couchbase://file\n",
+			env:     map[string]string{"COUCHDB_URL": "couchbase://file"},
+			wantURL: "couchbase://file",
+		},
+		{
+			name:    "override is logged at debug level",
+			config:  "logging:\n  level: debug\ncouchdb:\n  url: couchbase://file\n",
+			env:     map[string]string{"COUCHDB_URL": "couchbase://env"},
+			wantURL: "couchbase://env",
+			wantLogs: []map[string]any{
+				{"level": "DEBUG", "field": "couchdb.url", "env": "COUCHDB_URL", "from": "couchbase://file", "to": "couchbase://env"},
+			},
+		},
+		{
+			name:    "secrets are redacted",
+			config:  "logging:\n  level: debug\ncouchdb:\n  pwd: from-file\n",
+			env:     map[string]string{"COUCHDB_PWD": "from-env"},
+			wantURL: "",
+			wantLogs: []map[string]any{
+				{"level": "DEBUG", "field": "couchdb.pwd", "from": "[REDACTED]", "to": "[REDACTED]"},
+			},
+		},
+		{
+			name:    "override is not logged at info level",
+			config:  "couchdb:\n  url: couchbase://file\n",
+			env:     map[string]string{"COUCHDB_URL": "couchbase://env"},
+			wantURL: "couchbase://env",
+		},
+		{
+			name:    "strict_env rejects an override",
+			config:  "strict_env: true\ncouchdb:\n  url: couchbase://file\n",
+			env:     map[string]string{"COUCHDB_URL": "couchbase://env"},
+			wantErr: errStrictEnv,
+		},
+		{
+			name:    "strict_env allows filling unset values",
+			config:  "strict_env: true\n",
+			env:     map[string]string{"COUCHDB_URL": "couchbase://env"},
+			wantURL: "couchbase://env",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer logLevel.Set(logLevel.Level())
+			logs := captureLogs(t)
+			for env, value := range tt.env {
+				t.Setenv(env, value)
+			}
+
+			config, err := loadConfig(writeConfig(t, tt.config))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("loadConfig() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if config.CouchDB.URL != tt.wantURL {
+				t.Errorf("couchdb.url = %q, want %q", config.CouchDB.URL, tt.wantURL)
+			}
+
+			entries := logEntries(t, logs)
+			if len(entries) != len(tt.wantLogs) {
+				t.Fatalf("got %d log entries, want %d: %s", len(entries), len(tt.wantLogs), logs)
+			}
+			for i, want := range tt.wantLogs {
+				for key, value := range want {
+					if entries[i][key] != value {
+						t.Errorf("log entry %d: %s = %v, want %v", i, key, entries[i][key], value)
+					}
+				}
+			}
+			if strings.Contains(logs.String(), "from-env") || strings.Contains(logs.String(), "from-file") {
+				t.Errorf("secret leaked into logs: %s", logs)
+			}
+		})
+	}
+}
+
+func TestDRIStatus(t *testing.T) {
+	ranges := map[string]DRIRange{
+		"protein": {Min: 10, Max: 20},
+		"fat":     {Min: 5, Max: 8},
+	}
+	tests := []struct {
+		name   string
+		macros Macros
+		ranges map[string]DRIRange
+		want   map[string]string
+	}{
+		{"below", Macros{Protein: 9.9, Fat: 6}, ranges, map[string]string{"protein": "below", "fat": "within"}},
+		{"within at the bounds", Macros{Protein: 10, Fat: 8}, ranges, map[string]string{"protein": "within", "fat": "within"}},
+		{"above", Macros{Protein: 20.1, Fat: 4}, ranges, map[string]string{"protein": "above", "fat": "below"}},
+		{"no ranges configured", Macros{Protein: 50}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := driStatus(tt.macros, tt.ranges)
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("driStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateMacrosDRIStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   map[string]string
+	}{
+		// 1 cup of cooked rice weighs 158g: 4.27g protein, 44.24g carbs
+		{"only configured nutrients", "dri:\n  protein: {min: 5, max: 10}\n", map[string]string{"protein": "below"}},
+		{"within and above", "dri:\n  protein: {min: 4, max: 5}\n  carbs: {min: 10, max: 40}\n", map[string]string{"protein": "within", "carbs": "above"}},
+		{"omitted without ranges", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, tt.config)
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: "rice", VolumeCups: 1}))
+			response := decode[MacroResponse](t, w, http.StatusOK)
+			if got := response.Data[0].DRIStatus; !maps.Equal(got, tt.want) {
+				t.Errorf("dri_status = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidateDRI(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"valid range", "dri:\n  protein: {min: 5, max: 10}\n", false},
+		{"unknown nutrient", "dri:\n  sugar: {min: 5, max: 10}\n", true},
+		{"max below min", "dri:\n  fat: {min: 10, max: 5}\n", true},
+		{"negative min", "dri:\n  carbs: {min: -1, max: 5}\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := loadConfig(writeConfig(t, tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// nutrients builds per-100g FDC nutrients from number, amount pairs
+func nutrients(pairs ...any) []Nutrient {
+	var result []Nutrient
+	for i := 0; i+1 < len(pairs); i += 2 {
+		var n Nutrient
+		n.Nutrient.Number = pairs[i].(string)
+		n.Amount = pairs[i+1].(float64)
+		result = append(result, n)
+	}
+	return result
+}
+
+func TestDensityOverride(t *testing.T) {
+	testConfig(t, "")
+	density := func(v float64) *float64 { return &v }
+	rice := &FoodData{
+		FoodNutrients: nutrients("205", 28.0),
+		FoodPortions:  []Portion{{PortionDescription: "1 cup", GramWeight: 158}},
+	}
+	tests := []struct {
+		name      string
+		volume    Volume
+		wantGrams float64
+	}{
+		{"dataset cup portion", Volume{ObjectName: "rice", VolumeCups: 1.5}, 237},
+		{"override bypasses the portion", Volume{ObjectName: "rice", VolumeCups: 1.5, DensityGramsPerCup: density(200)}, 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := computeMacros(context.Background(), tt.volume, rice)
+			if !ok {
+				t.Fatal("computeMacros() found no weight per cup")
+			}
+			if result.grams != tt.wantGrams {
+				t.Errorf("calculated grams = %v, want %v", result.grams, tt.wantGrams)
+			}
+			if want := 28 * tt.wantGrams / 100; result.macros.Carbs != want {
+				t.Errorf("carbs = %v, want %v", result.macros.Carbs, want)
+			}
+		})
+	}
+}
+
+func TestDensityOverrideRejectsNonPositive(t *testing.T) {
+	testConfig(t, "")
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+	for _, density := range []string{"0", "-5"} {
+		t.Run(density, func(t *testing.T) {
+			body := `{"data":{"volumes":[{"object_name":"rice","volume_cups":1,"density_grams_per_cup":` + density + `}]}}`
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/calculate-macros", strings.NewReader(body)))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if !strings.Contains(w.Body.String(), "volumes[0].density_grams_per_cup") {
+				t.Errorf("error doesn't name the field: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestNamedScopeAndCollection(t *testing.T) {
+	tests := []struct {
+		name           string
+		couchdb        string
+		wantScope      string
+		wantCollection string
+		wantKeyspace   string
+		wantErr        bool
+	}{
+		{"defaults", "", "_default", "_default", "`fndds`", false},
+		{"named scope and collection", "  scope: nutrition\n  collection: foods\n", "nutrition", "foods", "`fndds`.`nutrition`.`foods`", false},
+		{"named collection in the default scope", "  collection: foods\n", "_default", "foods", "`fndds`.`_default`.`foods`", false},
+		{"invalid scope", "  scope: _system\n", "", "", "", true},
+		{"invalid collection", "  collection: \"foods; DROP\"\n", "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			content := "couchdb:\n  url: couchbase://127.0.0.1\n  bucket: fndds\n" + tt.couchdb
+			if err := yaml.Unmarshal([]byte(content), &config); err != nil {
+				t.Fatal(err)
+			}
+			err := config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			// Handles are only bound to the bucket, so no cluster is needed
+			cluster, err := gocb.Connect("couchbase://127.0.0.1", gocb.ClusterOptions{
+				Authenticator: gocb.PasswordAuthenticator{Username: "test", Password: "test"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cluster.Close(nil)
+			database := newDatabase(&config, cluster, cluster.Bucket("fndds"))
+
+			if got := database.collection.ScopeName(); got != tt.wantScope {
+				t.Errorf("scope = %q, want %q", got, tt.wantScope)
+			}
+			if got := database.collection.Name(); got != tt.wantCollection {
+				t.Errorf("collection = %q, want %q", got, tt.wantCollection)
+			}
+			if got := database.collection.Bucket().Name(); got != "fndds" {
+				t.Errorf("bucket = %q, want fndds", got)
+			}
+			if got := database.keyspaces[config.DefaultDataset]; got != tt.wantKeyspace {
+				t.Errorf("keyspace = %s, want %s", got, tt.wantKeyspace)
+			}
+		})
+	}
+}
+
+func TestProcessingMeta(t *testing.T) {
+	router := setupServer(t, "")
+	// Warm the cache with rice only
+	doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: "rice", VolumeCups: 1}))
+
+	tests := []struct {
+		name        string
+		path        string
+		wantMeta    bool
+		wantHits    int
+		wantMisses  int
+		wantQueries bool
+	}{
+		{"one cached and one uncached food", "/v1/calculate-macros?meta=true", true, 1, 1, true},
+		{"both cached now", "/v1/calculate-macros?meta=true", true, 2, 0, false},
+		{"omitted unless asked for", "/v1/calculate-macros", false, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := volumes(Volume{ObjectName: "rice", VolumeCups: 1}, Volume{ObjectName: "banana", VolumeCups: 1})
+			response := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, tt.path, body), http.StatusOK)
+			if !tt.wantMeta {
+				if response.Meta != nil {
+					t.Errorf("meta = %+v, want none", response.Meta)
+				}
+				return
+			}
+			meta := response.Meta
+			if meta == nil {
+				t.Fatal("meta missing")
+			}
+			if meta.CacheHits != tt.wantHits || meta.CacheMisses != tt.wantMisses {
+				t.Errorf("cache hits, misses = %d, %d; want %d, %d", meta.CacheHits, meta.CacheMisses, tt.wantHits, tt.wantMisses)
+			}
+			if (meta.QueriesExecuted > 0) != tt.wantQueries {
+				t.Errorf("queries_executed = %d, want queries %v", meta.QueriesExecuted, tt.wantQueries)
+			}
+			if meta.ProcessingTimeMs <= 0 {
+				t.Errorf("processing_time_ms = %v, want > 0", meta.ProcessingTimeMs)
+			}
+		})
+	}
+}
+
+func TestConnectionPoolOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		wantQuery  url.Values
+		wantBuffer uint
+		wantErr    bool
+	}{
+		{"SDK defaults", "", url.Values{}, 0, false},
+		{
+			name:      "pool sizes go on the connection string",
+			config:    "kv_pool_size: 4\n    max_queue_size: 4096\n    max_perhost_http_connections: 64\n    max_idle_http_connections: 128\n    max_perhost_idle_http_connections: 32\n",
+			wantQuery: url.Values{"kv_pool_size": {"4"}, "max_queue_size": {"4096"}, "max_perhost_http_connections": {"64"}, "max_idle_http_connections": {"128"}, "max_perhost_idle_http_connections": {"32"}},
+		},
+		{"buffer size goes on ClusterOptions", "connection_buffer_size: 2048\n", url.Values{}, 2048, false},
+		{"negative sizes are rejected", "kv_pool_size: -1\n", nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			content := "couchdb:\n  url: cb.example.com\n  user: app\n  pwd: secret\n  pool:\n    " + tt.config
+			if err := yaml.Unmarshal([]byte(content), &config); err != nil {
+				t.Fatal(err)
+			}
+			err := config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			connStr, err := url.Parse(connectionString(&config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if connStr.Scheme != "couchbases" || connStr.Host != "cb.example.com" {
+				t.Errorf("connection string = %s", connStr)
+			}
+			if got := connStr.Query(); !maps.EqualFunc(got, tt.wantQuery, slices.Equal) {
+				t.Errorf("connection string options = %v, want %v", got, tt.wantQuery)
+			}
+
+			opts := clusterOptions(&config)
+			if opts.InternalConfig.ConnectionBufferSize != tt.wantBuffer {
+				t.Errorf("ConnectionBufferSize = %d, want %d", opts.InternalConfig.ConnectionBufferSize, tt.wantBuffer)
+			}
+			if auth, ok := opts.Authenticator.(gocb.PasswordAuthenticator); !ok || auth.Username != "app" || auth.Password != "secret" {
+				t.Errorf("Authenticator = %#v", opts.Authenticator)
+			}
+		})
+	}
+}
+
+func TestEmptySearchTerms(t *testing.T) {
+	testConfig(t, "")
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+	for _, objectName := range []string{"  \t ", "\n"} {
+		t.Run(fmt.Sprintf("%q", objectName), func(t *testing.T) {
+			var stats requestStats
+			if _, err := getFoodData(context.Background(), cfg.DefaultDataset, objectName, "", &stats); !errors.Is(err, errInvalidFood) {
+				t.Errorf("getFoodData() error = %v, want %v", err, errInvalidFood)
+			}
+			if stats.queries != 0 {
+				t.Errorf("queries = %d, want none", stats.queries)
+			}
+
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros?meta=true", volumes(Volume{ObjectName: objectName, VolumeCups: 1}))
+			response := decode[MacroResponse](t, w, http.StatusOK)
+			if item := response.Data[0]; item.Found || item.ErrorCode != errorCodeInvalidFood {
+				t.Errorf("found = %v, error_code = %q; want %s", item.Found, item.ErrorCode, errorCodeInvalidFood)
+			}
+			if response.Meta.QueriesExecuted != 0 {
+				t.Errorf("queries_executed = %d, want none", response.Meta.QueriesExecuted)
+			}
+		})
+	}
+}
+
+func TestUncertaintyLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		uncertainty float64
+		wantStatus  int
+		wantClamped bool
+	}{
+		{"normal uncertainty passes through", "", 0.5, http.StatusOK, false},
+		{"equal to the volume passes through", "", 2, http.StatusOK, false},
+		{"over-large uncertainty is clamped to the volume", "", 6, http.StatusOK, true},
+		{"clamped to the configured ratio", "uncertainty:\n  max_ratio: 0.5\n", 1.5, http.StatusOK, true},
+		{"rejected when configured", "uncertainty:\n  mode: reject\n", 6, http.StatusBadRequest, false},
+		{"allowed under the rejection limit", "uncertainty:\n  mode: reject\n", 1, http.StatusOK, false},
+		{"negative uncertainty", "", -1, http.StatusBadRequest, false},
+	}
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig(t, noFuzzy+tt.config)
+			// The clamp applies before the lookup, so a food the server
+			// doesn't know still reports it without reaching the database
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: "quinoa", VolumeCups: 2, UncertaintyCups: tt.uncertainty}))
+			if tt.wantStatus != http.StatusOK {
+				body := decode[map[string]any](t, w, tt.wantStatus)
+				if !strings.Contains(fmt.Sprint(body), "uncertainty_cups") {
+					t.Errorf("error doesn't name the field: %v", body)
+				}
+				return
+			}
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if item.UncertaintyClamped != tt.wantClamped {
+				t.Errorf("uncertainty_clamped = %v, want %v", item.UncertaintyClamped, tt.wantClamped)
+			}
+		})
+	}
+}
+
+func TestCalorieSource(t *testing.T) {
+	// Reports 100 kcal, but its macros add up to 4*10 + 4*20 + 9*5 = 165
+	divergent := nutrients("208", 100.0, "203", 10.0, "205", 20.0, "204", 5.0)
+	unreported := nutrients("203", 10.0, "205", 20.0, "204", 5.0)
+	tests := []struct {
+		source       string
+		nutrients    []Nutrient
+		wantCalories float64
+		wantComputed bool
+	}{
+		{calorieSourceReported, divergent, 200, false},
+		{calorieSourceReported, unreported, 0, false},
+		{calorieSourceComputed, divergent, 330, true},
+		{calorieSourceComputed, unreported, 330, true},
+		{calorieSourcePreferReported, divergent, 200, false},
+		{calorieSourcePreferReported, unreported, 330, true},
+	}
+	for _, tt := range tests {
+		name := tt.source + " with 208"
+		if len(tt.nutrients) == len(unreported) {
+			name = tt.source + " without 208"
+		}
+		t.Run(name, func(t *testing.T) {
+			testConfig(t, "calories:\n  source: "+tt.source+"\n")
+			macros, computed, _ := calculateMacrosForGrams(tt.nutrients, 200, 200)
+			if math.Abs(macros.Calories-tt.wantCalories) > 1e-9 || computed != tt.wantComputed {
+				t.Errorf("calories = %v, computed = %v; want %v, %v", macros.Calories, computed, tt.wantCalories, tt.wantComputed)
+			}
+			if macros.Protein != 20 || macros.Carbs != 40 || macros.Fat != 10 {
+				t.Errorf("macros = %+v, want them scaled to 200 g", macros)
+			}
+		})
+	}
+}
+
+func TestFrameScale(t *testing.T) {
+	items := []Volume{{ObjectName: "rice", VolumeCups: 1, UncertaintyCups: 0.2}, {ObjectName: "banana", VolumeCups: 0.5}}
+	tests := []struct {
+		name       string
+		scale      *float64
+		wantStatus int
+	}{
+		{"no scale", nil, http.StatusOK},
+		{"enlarges every volume", ptr(1.5), http.StatusOK},
+		{"shrinks every volume", ptr(0.5), http.StatusOK},
+		{"zero is rejected", ptr(0.0), http.StatusBadRequest},
+		{"negative is rejected", ptr(-1.0), http.StatusBadRequest},
+	}
+	config := testConfig(t, "")
+	cacheFood(t, config.DefaultDataset, "Rice, cooked, NFS", FoodData{
+		Description:   "Rice, cooked, NFS",
+		FoodNutrients: nutrients("205", 28.0, "203", 2.7, "204", 0.3, "208", 130.0),
+		FoodPortions:  portions("1 cup", 158),
+	})
+	cacheFood(t, config.DefaultDataset, "Banana, raw", FoodData{
+		Description:   "Banana, raw",
+		FoodNutrients: nutrients("205", 22.8, "203", 1.1, "204", 0.3, "208", 89.0),
+		FoodPortions:  portions("1 cup, sliced", 150),
+	})
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := volumes(items...)
+			body.Data.Scale = tt.scale
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", body)
+			if tt.wantStatus != http.StatusOK {
+				decode[map[string]any](t, w, tt.wantStatus)
+				return
+			}
+			scaled := decode[MacroResponse](t, w, http.StatusOK)
+
+			// A scaled frame computes as the same frame with every volume
+			// multiplied by the scale
+			scale := 1.0
+			if tt.scale != nil {
+				scale = *tt.scale
+			}
+			var manual []Volume
+			for _, item := range items {
+				item.VolumeCups *= scale
+				item.UncertaintyCups *= scale
+				manual = append(manual, item)
+			}
+			want := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(manual...)), http.StatusOK)
+
+			if got := scaled.Data[0].CalculatedWeight; got != 158*scale {
+				t.Errorf("rice weight = %v g, want %v g", got, 158*scale)
+			}
+			if tt.scale != nil && scaled.Scale != scale {
+				t.Errorf("scale = %v, want %v recorded", scaled.Scale, scale)
+			}
+			for i, item := range scaled.Data {
+				if !item.Found {
+					t.Fatalf("item %d not found: %+v", i, item)
+				}
+				if item.Macros != want.Data[i].Macros || item.CalculatedWeight != want.Data[i].CalculatedWeight {
+					t.Errorf("item %d = %+v at %v g, want %+v at %v g", i, item.Macros, item.CalculatedWeight, want.Data[i].Macros, want.Data[i].CalculatedWeight)
+				}
+				// The requested volume is reported as sent
+				if item.RequestedVolume != items[i].VolumeCups {
+					t.Errorf("item %d requested volume = %v, want %v", i, item.RequestedVolume, items[i].VolumeCups)
+				}
+			}
+			if !macrosNear(scaled.Summary.Totals, want.Summary.Totals) {
+				t.Errorf("totals = %+v, want %+v", scaled.Summary.Totals, want.Summary.Totals)
+			}
+		})
+	}
+}
+
+func TestFrameSummary(t *testing.T) {
+	config := testConfig(t, noFuzzy)
+	cacheTestFoods(t, config.DefaultDataset)
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+
+	body := volumes(
+		Volume{ObjectName: "rice", VolumeCups: 1},
+		Volume{ObjectName: "banana", VolumeCups: 1},
+		Volume{ObjectName: "dragonfruit", VolumeCups: 1},
+	)
+	body.Data.FrameID = "frame-7"
+	response := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", body), http.StatusOK)
+
+	// 158 g of rice and 150 g of banana; the unknown food adds nothing
+	want := Macros{
+		Calories: 1.58*130 + 1.5*89,
+		Carbs:    1.58*28 + 1.5*22.8,
+		Fat:      1.58*0.3 + 1.5*0.3,
+		Protein:  1.58*2.7 + 1.5*1.1,
+	}
+	if response.Summary.FrameID != "frame-7" {
+		t.Errorf("frame_id = %q, want frame-7", response.Summary.FrameID)
+	}
+	if !macrosNear(response.Summary.Totals, want) {
+		t.Errorf("totals = %+v, want %+v", response.Summary.Totals, want)
+	}
+	if response.Summary.Unresolved != 1 {
+		t.Errorf("unresolved = %d, want 1", response.Summary.Unresolved)
+	}
+}
+
+func TestFoodCategory(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     string
+	}{
+		{"FNDDS WWEIA category", `{"wweiaFoodCategory": {"wweiaFoodCategoryDescription": "Bananas"}}`, "Bananas"},
+		{"SR food category", `{"foodCategory": {"description": "Fruits and Fruit Juices"}}`, "Fruits and Fruit Juices"},
+		{"WWEIA wins over the food category", `{"wweiaFoodCategory": {"wweiaFoodCategoryDescription": "Bananas"}, "foodCategory": {"description": "Fruits"}}`, "Bananas"},
+		{"no category", `{}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var food FoodData
+			if err := json.Unmarshal([]byte(tt.document), &food); err != nil {
+				t.Fatal(err)
+			}
+			if got := food.category(); got != tt.want {
+				t.Errorf("category() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateMacrosCategory(t *testing.T) {
+	config := testConfig(t, "")
+	var banana FoodData
+	if err := json.Unmarshal([]byte(`{"description": "Banana, raw", "wweiaFoodCategory": {"wweiaFoodCategoryDescription": "Bananas"}}`), &banana); err != nil {
+		t.Fatal(err)
+	}
+	banana.FoodPortions = portions("1 cup, sliced", 150)
+	cacheFood(t, config.DefaultDataset, "Banana, raw", banana)
+	cacheFood(t, config.DefaultDataset, "Rice, cooked, NFS", FoodData{Description: "Rice, cooked, NFS", FoodPortions: portions("1 cup", 158)})
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+
+	tests := []struct {
+		food string
+		want string
+	}{
+		{"banana", "Bananas"},
+		{"rice", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.food, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: tt.food, VolumeCups: 1}))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if !item.Found || item.Category != tt.want {
+				t.Errorf("found = %v, category = %q; want %q", item.Found, item.Category, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		query       string
+		wantQueries bool
+	}{
+		{"development with debug_query", modeDevelopment, "?debug_query=true", true},
+		{"development without debug_query", modeDevelopment, "", false},
+		{"production ignores debug_query", modeProduction, "?debug_query=true", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, "server:\n  mode: "+tt.mode+"\n")
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros"+tt.query, volumes(Volume{ObjectName: "rice", VolumeCups: 1}))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if !tt.wantQueries {
+				if len(item.Queries) > 0 || strings.Contains(w.Body.String(), "statement") {
+					t.Errorf("queries exposed: %+v", item.Queries)
+				}
+				return
+			}
+			if len(item.Queries) == 0 {
+				t.Fatal("queries missing")
+			}
+			q := item.Queries[0]
+			if !strings.Contains(q.Statement, "SELECT") || len(q.Params) == 0 || !strings.Contains(fmt.Sprint(q.Params...), "rice, cooked, nfs") {
+				t.Errorf("query = %+v, want the lookup statement and its parameters", q)
+			}
+		})
+	}
+}
+
+// fakeProbeTarget records which startup probe ran; its query is refused
+// and its ping answers with the given endpoints
+type fakeProbeTarget struct {
+	queried, pinged bool
+	endpoints       []gocb.EndpointPingReport
+}
+
+func (f *fakeProbeTarget) Query(statement string, opts *gocb.QueryOptions) (*gocb.QueryResult, error) {
+	f.queried = true
+	return nil, errors.New("query service refused")
+}
+
+func (f *fakeProbeTarget) Ping(opts *gocb.PingOptions) (*gocb.PingResult, error) {
+	f.pinged = true
+	return &gocb.PingResult{Services: map[gocb.ServiceType][]gocb.EndpointPingReport{
+		gocb.ServiceTypeKeyValue: f.endpoints,
+	}}, nil
+}
+
+func TestStartupProbe(t *testing.T) {
+	ok := []gocb.EndpointPingReport{{Remote: "kv1", State: gocb.PingStateOk}}
+	down := []gocb.EndpointPingReport{{Remote: "kv1", State: gocb.PingStateOk}, {Remote: "kv2", State: gocb.PingStateError, Error: "timeout"}}
+	tests := []struct {
+		name        string
+		config      string
+		endpoints   []gocb.EndpointPingReport
+		wantQueried bool
+		wantPinged  bool
+		wantErr     string
+	}{
+		{"query probe by default", "", ok, true, false, "failed to execute test query"},
+		{"query probe", "couchdb:\n  probe: query\n", ok, true, false, "failed to execute test query"},
+		{"kv probe", "couchdb:\n  probe: kv\n", ok, false, true, ""},
+		{"kv probe with an endpoint down", "couchdb:\n  probe: kv\n", down, false, true, "kv2 is not ok"},
+		{"kv probe without endpoints", "couchdb:\n  probe: kv\n", nil, false, true, "no key-value endpoints"},
+		{"no probe", "couchdb:\n  probe: none\n", ok, false, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.config)
+			target := &fakeProbeTarget{endpoints: tt.endpoints}
+			err := probeConnectivity(config.CouchDB.Probe, target, target)
+			if target.queried != tt.wantQueried || target.pinged != tt.wantPinged {
+				t.Errorf("queried = %v, pinged = %v; want %v, %v", target.queried, target.pinged, tt.wantQueried, tt.wantPinged)
+			}
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("probeConnectivity() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInvalidStartupProbe(t *testing.T) {
+	var config Config
+	config.CouchDB.Probe = "http"
+	if err := config.validate(); err == nil || !strings.Contains(err.Error(), "couchdb.probe") {
+		t.Errorf("validate() = %v, want an invalid couchdb.probe error", err)
+	}
+}
+
+func TestIncludePortions(t *testing.T) {
+	config := testConfig(t, "")
+	// The boiled egg has large, medium and chopped cup portions
+	cacheFood(t, config.DefaultDataset, defaultFoodMappings["egg"], FoodData{
+		Description:   defaultFoodMappings["egg"],
+		FoodNutrients: nutrients("205", 1.1, "203", 12.6, "204", 10.6, "208", 155.0),
+		FoodPortions:  portions("1 large", 50, "1 medium", 44, "1 cup, chopped", 136),
+	})
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+
+	tests := []struct {
+		name         string
+		query        string
+		wantPortions []float64
+	}{
+		{"off by default", "", nil},
+		{"explicitly off", "?include_portions=false", nil},
+		{"requested", "?include_portions=true", []float64{50, 44, 136}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros"+tt.query, volumes(Volume{ObjectName: "egg", VolumeCups: 1}))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			var grams []float64
+			for _, p := range item.Portions {
+				grams = append(grams, p.GramWeight)
+			}
+			slices.Sort(grams)
+			want := slices.Clone(tt.wantPortions)
+			slices.Sort(want)
+			if !slices.Equal(grams, want) {
+				t.Errorf("portion grams = %v, want %v", grams, want)
+			}
+			if tt.wantPortions == nil && strings.Contains(w.Body.String(), `"portions"`) {
+				t.Errorf("response has portions: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestPercentError(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		volume      float64
+		uncertainty float64
+		want        float64
+	}{
+		{"no uncertainty", "", 1, 0, 0},
+		{"quarter of the volume", "", 2, 0.5, 25},
+		{"scales with uncertainty", "", 2, 1, 50},
+		{"relative to the volume", "", 4, 1, 25},
+		{"capped", "uncertainty:\n  max_ratio: 3\n", 1, 2.5, maxPercentError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.config)
+			cacheTestFoods(t, config.DefaultDataset)
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+
+			volume := Volume{ObjectName: "rice", VolumeCups: tt.volume, UncertaintyCups: tt.uncertainty}
+			if got := percentError(volume); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("percentError() = %v, want %v", got, tt.want)
+			}
+			item := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(volume)), http.StatusOK).Data[0]
+			if math.Abs(item.PercentError-tt.want) > 1e-9 {
+				t.Errorf("percent_error = %v, want %v", item.PercentError, tt.want)
+			}
+		})
+	}
+}
+
+func TestMacroRange(t *testing.T) {
+	tests := []struct {
+		name        string
+		volume      float64
+		uncertainty float64
+		wantRange   bool
+		wantMin     float64
+		wantMax     float64
+	}{
+		{"no uncertainty", 1, 0, false, 0, 0},
+		{"symmetric", 1, 0.25, true, 0.75, 1.25},
+		{"low end stops at zero", 1, 1.5, true, 0, 2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, "uncertainty:\n  max_ratio: 2\n")
+			cacheTestFoods(t, config.DefaultDataset)
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+
+			volume := Volume{ObjectName: "rice", VolumeCups: tt.volume, UncertaintyCups: tt.uncertainty}
+			item := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(volume)), http.StatusOK).Data[0]
+			if (item.Range != nil) != tt.wantRange {
+				t.Fatalf("range = %+v, want one: %v", item.Range, tt.wantRange)
+			}
+			if !tt.wantRange {
+				return
+			}
+			// 1 cup of rice is 158 g at 28 g carbs per 100 g
+			wantMin, wantMax := tt.wantMin*158*0.28, tt.wantMax*158*0.28
+			if math.Abs(item.Range.Min.Carbs-wantMin) > 0.05 || math.Abs(item.Range.Max.Carbs-wantMax) > 0.05 {
+				t.Errorf("carbs range = %v..%v, want %v..%v", item.Range.Min.Carbs, item.Range.Max.Carbs, wantMin, wantMax)
+			}
+			if item.Range.Min.Carbs > item.Macros.Carbs || item.Range.Max.Carbs < item.Macros.Carbs {
+				t.Errorf("range %v..%v doesn't contain %v", item.Range.Min.Carbs, item.Range.Max.Carbs, item.Macros.Carbs)
+			}
+		})
+	}
+}
+
+// fakeBuckets lists the given buckets, or fails with err
+type fakeBuckets struct {
+	names []string
+	err   error
+}
+
+func (f fakeBuckets) GetAllBuckets(opts *gocb.GetAllBucketsOptions) (map[string]gocb.BucketSettings, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	buckets := make(map[string]gocb.BucketSettings, len(f.names))
+	for _, name := range f.names {
+		buckets[name] = gocb.BucketSettings{Name: name}
+	}
+	return buckets, nil
+}
+
+func TestMissingBucketError(t *testing.T) {
+	denied := errors.New("permission denied")
+	tests := []struct {
+		name    string
+		buckets fakeBuckets
+		err     error
+		// wantMessage is empty when the bucket isn't reported missing
+		wantMessage string
+	}{
+		{"not found lists the available buckets", fakeBuckets{names: []string{"travel", "beer"}}, gocb.ErrBucketNotFound, `bucket "fndds" does not exist; check couchdb.bucket (available buckets: beer, travel)`},
+		{"timeout on a missing bucket", fakeBuckets{names: []string{"beer"}}, gocb.ErrTimeout, `bucket "fndds" does not exist; check couchdb.bucket (available buckets: beer)`},
+		{"not found without listing rights", fakeBuckets{err: denied}, gocb.ErrBucketNotFound, `bucket "fndds" does not exist; check couchdb.bucket`},
+		{"timeout on an existing bucket", fakeBuckets{names: []string{"fndds"}}, gocb.ErrTimeout, ""},
+		{"timeout without listing rights", fakeBuckets{err: denied}, gocb.ErrTimeout, ""},
+		{"other errors", fakeBuckets{names: []string{"beer"}}, gocb.ErrAuthenticationFailure, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := missingBucketError(tt.buckets, "fndds", fmt.Errorf("wait until ready: %w", tt.err))
+			if tt.wantMessage == "" {
+				if err != nil {
+					t.Errorf("missingBucketError() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantMessage {
+				t.Errorf("missingBucketError() = %v, want %q", err, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestPerGram(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		food        string
+		volume      float64
+		wantPerGram bool
+	}{
+		{"off by default", "", "banana", 1, false},
+		{"banana", "?per_gram=true", "banana", 1, true},
+		{"rice", "?per_gram=true", "rice", 2, true},
+		{"half a cup of rice", "?per_gram=true", "rice", 0.5, true},
+		{"unresolved food", "?per_gram=true", "dragonfruit", 1, false},
+	}
+	config := testConfig(t, noFuzzy)
+	cacheTestFoods(t, config.DefaultDataset)
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros"+tt.query, volumes(Volume{ObjectName: tt.food, VolumeCups: tt.volume}))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if (item.PerGram != nil) != tt.wantPerGram {
+				t.Fatalf("per_gram = %+v, want present %v", item.PerGram, tt.wantPerGram)
+			}
+			if !tt.wantPerGram {
+				return
+			}
+			weight := item.CalculatedWeight
+			scaled := Macros{Calories: item.PerGram.Calories * weight, Carbs: item.PerGram.Carbs * weight, Fat: item.PerGram.Fat * weight, Protein: item.PerGram.Protein * weight}
+			if !macrosNear(scaled, item.Macros) {
+				t.Errorf("per_gram %+v × %v g = %+v, want %+v", *item.PerGram, weight, scaled, item.Macros)
+			}
+		})
+	}
+}
+
+func TestMacrosPerGram(t *testing.T) {
+	macros := Macros{Calories: 200, Carbs: 40, Fat: 4, Protein: 8}
+	tests := []struct {
+		name   string
+		grams  float64
+		want   Macros
+		wantOK bool
+	}{
+		{"divides every macro", 100, Macros{Calories: 2, Carbs: 0.4, Fat: 0.04, Protein: 0.08}, true},
+		{"zero weight", 0, Macros{}, false},
+		{"negative weight", -10, Macros{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := macros.perGram(tt.grams)
+			if ok != tt.wantOK || !macrosNear(got, tt.want) {
+				t.Errorf("perGram(%v) = %+v, %v; want %+v, %v", tt.grams, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNutrientStatus(t *testing.T) {
+	mappings := writeConfig(t, "rice: Rice, cooked, NFS\nzero protein rice: Rice, zero protein\nno protein rice: Rice, no protein\n")
+	// rice stores cooked rice under another description, with its protein
+	// entry changed
+	rice := func(description string, protein func([]Nutrient) []Nutrient) FoodData {
+		return FoodData{
+			Description:   description,
+			FoodNutrients: protein(nutrients("205", 28.0, "203", 2.7, "204", 0.3, "208", 130.0)),
+			FoodPortions:  portions("1 cup", 158),
+		}
+	}
+	zeroProtein := func(nutrients []Nutrient) []Nutrient {
+		for i := range nutrients {
+			if nutrients[i].Nutrient.Number == "203" {
+				nutrients[i].Amount = 0
+			}
+		}
+		return nutrients
+	}
+	noProtein := func(nutrients []Nutrient) []Nutrient {
+		return slices.DeleteFunc(nutrients, func(n Nutrient) bool { return n.Nutrient.Number == "203" })
+	}
+
+	tests := []struct {
+		name          string
+		missing       string
+		food          string
+		wantFound     bool
+		wantErrorCode string
+		wantStatus    string
+	}{
+		{"reported protein", "", "rice", true, "", nutrientPresent},
+		{"explicit zero protein", "", "zero protein rice", true, "", nutrientZero},
+		{"no protein entry counts as zero", "", "no protein rice", true, "", nutrientMissing},
+		{"explicit zero protein is kept when rejecting", "reject", "zero protein rice", true, "", nutrientZero},
+		{"no protein entry is rejected", "reject", "no protein rice", false, errorCodeMissingNutrients, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := ""
+			if tt.missing != "" {
+				content = "nutrients:\n  missing: " + tt.missing + "\n"
+			}
+			config := testConfig(t, content)
+			useMappings(t, &fileMappings{path: mappings})
+			cacheTestFoods(t, config.DefaultDataset)
+			cacheFood(t, config.DefaultDataset, "Rice, zero protein", rice("Rice, zero protein", zeroProtein))
+			cacheFood(t, config.DefaultDataset, "Rice, no protein", rice("Rice, no protein", noProtein))
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: tt.food, VolumeCups: 1}))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if item.Found != tt.wantFound || item.ErrorCode != tt.wantErrorCode {
+				t.Fatalf("found = %v, error_code = %q; want %v, %q", item.Found, item.ErrorCode, tt.wantFound, tt.wantErrorCode)
+			}
+			if got := item.NutrientStatus["protein"]; got != tt.wantStatus {
+				t.Errorf("protein status = %q, want %q", got, tt.wantStatus)
+			}
+			if tt.wantFound && tt.wantStatus != nutrientPresent && item.Macros.Protein != 0 {
+				t.Errorf("protein = %v, want 0", item.Macros.Protein)
+			}
+		})
+	}
+}
+
+func TestCalcVersion(t *testing.T) {
+	// Reference results of the pinned version. When a change moves any of
+	// them, bump CalcVersion as its doc comment describes and update both
+	// together; bumping the version alone fails too.
+	const pinnedVersion = "1.3.0"
+	tests := []struct {
+		volume     Volume
+		wantWeight float64
+		wantMacros Macros
+	}{
+		{Volume{ObjectName: "rice", VolumeCups: 1}, 158, Macros{Calories: 205.4, Carbs: 44.24, Fat: 0.474, Protein: 4.266}},
+		{Volume{ObjectName: "banana", VolumeCups: 0.5}, 75, Macros{Calories: 66.75, Carbs: 17.1, Fat: 0.225, Protein: 0.825}},
+		{Volume{ObjectName: "egg", VolumeCups: 1, EggSize: "large"}, 136, Macros{Calories: 210.8, Carbs: 1.496, Fat: 14.416, Protein: 17.136}},
+		{Volume{ObjectName: "cucumber", VolumeCups: 2, UncertaintyCups: 0.25}, 208, Macros{Calories: 31.2, Carbs: 7.5504, Fat: 0.2288, Protein: 1.352}},
+	}
+	if CalcVersion != pinnedVersion {
+		t.Fatalf("CalcVersion = %s, pinned %s: update the pin and the reference results together", CalcVersion, pinnedVersion)
+	}
+	config := testConfig(t, "")
+	cacheTestFoods(t, config.DefaultDataset)
+	cacheFood(t, config.DefaultDataset, defaultFoodMappings["egg"], FoodData{
+		FdcID:         3,
+		Description:   defaultFoodMappings["egg"],
+		FoodNutrients: nutrients("205", 1.1, "203", 12.6, "204", 10.6, "208", 155.0),
+		FoodPortions:  portions("1 large", 50, "1 medium", 44, "1 cup, chopped", 136),
+	})
+	cacheFood(t, config.DefaultDataset, defaultFoodMappings["cucumber"], FoodData{
+		FdcID:         4,
+		Description:   defaultFoodMappings["cucumber"],
+		FoodNutrients: nutrients("205", 3.63, "203", 0.65, "204", 0.11, "208", 15.0),
+		FoodPortions:  portions("1 cup, sliced", 104),
+	})
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+	for _, tt := range tests {
+		t.Run(tt.volume.ObjectName, func(t *testing.T) {
+			item := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(tt.volume)), http.StatusOK).Data[0]
+			near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+			if !near(item.CalculatedWeight, tt.wantWeight) || !near(item.Macros.Calories, tt.wantMacros.Calories) || !near(item.Macros.Carbs, tt.wantMacros.Carbs) ||
+				!near(item.Macros.Fat, tt.wantMacros.Fat) || !near(item.Macros.Protein, tt.wantMacros.Protein) {
+				t.Errorf("%v g, %+v; want %v g, %+v as computed by %s. Bump CalcVersion if the change is intended.", item.CalculatedWeight, item.Macros, tt.wantWeight, tt.wantMacros, pinnedVersion)
+			}
+		})
+	}
+}
+
+func TestCalcVersionInResponses(t *testing.T) {
+	request := volumes(Volume{ObjectName: "rice", VolumeCups: 1})
+	tests := []struct {
+		name string
+		get  func(t *testing.T, router http.Handler) string
+	}{
+		{"v1", func(t *testing.T, router http.Handler) string {
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", request)
+			return decode[MacroResponse](t, w, http.StatusOK).CalcVersion
+		}},
+		{"v2", func(t *testing.T, router http.Handler) string {
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", request, "Accept", mediaTypeV2)
+			return decode[MacroResponseV2](t, w, http.StatusOK).CalcVersion
+		}},
+		{"gRPC", func(t *testing.T, _ http.Handler) string {
+			response, err := dialGRPC(t).CalculateMacros(context.Background(), riceRequest())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return response.GetCalcVersion()
+		}},
+	}
+	router := setupServer(t, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.get(t, router); got != CalcVersion {
+				t.Errorf("calc_version = %q, want %q", got, CalcVersion)
+			}
+		})
+	}
+}
+
+func TestResponseStatus(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         string
+		repoErr        error
+		volumes        []Volume
+		wantHTTP       int
+		wantStatus     string
+		wantErrorCodes []string
+	}{
+		{"every item resolved", "", nil, []Volume{{ObjectName: "rice", VolumeCups: 1}}, http.StatusOK, responseStatusOK, []string{""}},
+		{"unknown food", "", nil, []Volume{{ObjectName: "rice", VolumeCups: 1}, {ObjectName: "dragonfruit", VolumeCups: 1}}, http.StatusOK, responseStatusPartial, []string{"", errorCodeUnknownFood}},
+		{"no portion data", "", nil, []Volume{{ObjectName: "cucumber", VolumeCups: 1}}, http.StatusOK, responseStatusPartial, []string{errorCodeNoPortionData}},
+		{"database failure", "breaker:\n  disabled: true\n", errors.New("cluster unreachable"), []Volume{{ObjectName: "rice", VolumeCups: 1}}, http.StatusBadGateway, responseStatusDegraded, []string{errorCodeDatabase}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, tt.config)
+			useMappings(t, &fileMappings{path: writeConfig(t, "rice: Rice, cooked, NFS\ncucumber: Cucumber, raw\n")})
+			if tt.repoErr != nil {
+				foodRepo = &mockRepo{err: tt.repoErr}
+			}
+
+			response := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(tt.volumes...)), tt.wantHTTP)
+			if response.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", response.Status, tt.wantStatus)
+			}
+			for i, item := range response.Data {
+				if item.ErrorCode != tt.wantErrorCodes[i] || (item.ErrorCode != "") != (item.ErrorMessage != "") {
+					t.Errorf("item %d: error_code = %q (%q), want %q with a message", i, item.ErrorCode, item.ErrorMessage, tt.wantErrorCodes[i])
+				}
+				// Database errors aren't detailed outside development mode
+				if strings.Contains(item.ErrorMessage, "cluster") {
+					t.Errorf("item %d: error_message = %q leaks the database error", i, item.ErrorMessage)
+				}
+			}
+		})
+	}
+}
+
+func TestShutdownTimeoutConfig(t *testing.T) {
+	config := testConfig(t, "")
+	if config.Server.ShutdownTimeout != 15*time.Second {
+		t.Errorf("shutdown_timeout = %v, want the 15s default", config.Server.ShutdownTimeout)
+	}
+	config = testConfig(t, "server:\n  shutdown_timeout: 2s\n")
+	if config.Server.ShutdownTimeout != 2*time.Second {
+		t.Errorf("shutdown_timeout = %v, want 2s", config.Server.ShutdownTimeout)
+	}
+
+	invalid := &Config{}
+	if err := yaml.Unmarshal([]byte("server:\n  shutdown_timeout: -1s\n"), invalid); err != nil {
+		t.Fatal(err)
+	}
+	if err := invalid.validate(); err == nil {
+		t.Error("validate() succeeded with a negative shutdown_timeout")
+	}
+}
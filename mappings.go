@@ -0,0 +1,417 @@
+// mappings.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// MappingsConfig selects where the object name → description mappings are
+// kept. With a collection they live in Couchbase, with a file in a YAML map
+// of object name to description; admin changes are written back to either.
+// Without both, the built-in mappings are used and changes last until
+// restart.
+type MappingsConfig struct {
+	File       string `yaml:"file"`
+	Scope      string `yaml:"scope"`
+	Collection string `yaml:"collection"`
+}
+
+func (m *MappingsConfig) validate() error {
+	if m.Collection == "" {
+		return nil
+	}
+	if m.File != "" {
+		return errors.New("food_mappings: set either file or collection, not both")
+	}
+	if m.Scope == "" {
+		m.Scope = defaultKeyspaceName
+	}
+	for _, name := range []string{m.Scope, m.Collection} {
+		if name != defaultKeyspaceName && !keyspaceNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid keyspace name %q in food_mappings config", name)
+		}
+	}
+	return nil
+}
+
+// FoodMapping ties an object name to the description it is looked up by
+type FoodMapping struct {
+	ObjectName  string `json:"object_name"`
+	Description string `json:"description"`
+}
+
+// mappingStore persists the mappings
+type mappingStore interface {
+	load(ctx context.Context) (map[string]string, error)
+	put(ctx context.Context, name, description string) error
+	remove(ctx context.Context, name string) error
+}
+
+// newMappingStore picks the store configured in food_mappings
+func newMappingStore(config *Config, database *Database) mappingStore {
+	if config.FoodMappings.Collection != "" {
+		collection := database.bucket.Scope(config.FoodMappings.Scope).Collection(config.FoodMappings.Collection)
+		return &couchbaseMappings{
+			cluster:    database.cluster,
+			collection: collection,
+			keyspace:   keyspaceFor(config.CouchDB.Bucket, config.FoodMappings.Scope, config.FoodMappings.Collection),
+		}
+	}
+	return &fileMappings{path: config.FoodMappings.File}
+}
+
+// mappingCache loads the mappings on first use. Concurrent first lookups
+// wait for a single load; a failed load is retried by the next lookup.
+// The map is replaced, never modified, so readers can keep using the one
+// they got.
+type mappingCache struct {
+	store mappingStore
+
+	mu     sync.RWMutex
+	loaded bool
+	terms  map[string]string
+}
+
+var foodMappings *mappingCache
+
+func newMappingCache(store mappingStore) *mappingCache {
+	return &mappingCache{store: store}
+}
+
+// all returns every mapping; the map must not be modified
+func (m *mappingCache) all(ctx context.Context) (map[string]string, error) {
+	m.mu.RLock()
+	if m.loaded {
+		defer m.mu.RUnlock()
+		return m.terms, nil
+	}
+	m.mu.RUnlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.loadLocked(ctx); err != nil {
+		return nil, err
+	}
+	return m.terms, nil
+}
+
+func (m *mappingCache) loadLocked(ctx context.Context) error {
+	if m.loaded {
+		return nil
+	}
+	terms, err := m.store.load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load food mappings: %w", err)
+	}
+	slog.InfoContext(ctx, "loaded food mappings", "mappings", len(terms))
+	m.terms = terms
+	m.loaded = true
+	return nil
+}
+
+// reload replaces the store and drops the loaded mappings, so the next
+// lookup reads them from the new store
+func (m *mappingCache) reload(store mappingStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = store
+	m.loaded = false
+	m.terms = nil
+}
+
+// searchTerm returns the description an object name is looked up by
+func (m *mappingCache) searchTerm(ctx context.Context, name string) (string, bool, error) {
+	terms, err := m.all(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	term, ok := terms[name]
+	return term, ok, nil
+}
+
+// put stores a mapping and reports whether it replaced an existing one
+func (m *mappingCache) put(ctx context.Context, name, description string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.loadLocked(ctx); err != nil {
+		return false, err
+	}
+
+	terms := make(map[string]string, len(m.terms)+1)
+	for k, v := range m.terms {
+		terms[k] = v
+	}
+	_, existed := terms[name]
+	terms[name] = description
+
+	if err := m.store.put(ctx, name, description); err != nil {
+		return false, err
+	}
+	m.terms = terms
+	return existed, nil
+}
+
+// remove deletes a mapping and reports whether it existed
+func (m *mappingCache) remove(ctx context.Context, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.loadLocked(ctx); err != nil {
+		return false, err
+	}
+	if _, ok := m.terms[name]; !ok {
+		return false, nil
+	}
+
+	terms := make(map[string]string, len(m.terms))
+	for k, v := range m.terms {
+		if k != name {
+			terms[k] = v
+		}
+	}
+
+	if err := m.store.remove(ctx, name); err != nil {
+		return false, err
+	}
+	m.terms = terms
+	return true, nil
+}
+
+// fileMappings keeps the mappings in a YAML file, starting from the
+// built-in ones while the file doesn't exist. Without a path nothing is
+// written.
+type fileMappings struct {
+	path  string
+	terms map[string]string
+}
+
+func (f *fileMappings) load(ctx context.Context) (map[string]string, error) {
+	f.terms = make(map[string]string, len(defaultFoodMappings))
+	data, err := os.ReadFile(f.path)
+	switch {
+	case f.path == "" || errors.Is(err, os.ErrNotExist):
+		for name, description := range defaultFoodMappings {
+			f.terms[name] = description
+		}
+	case err != nil:
+		return nil, err
+	default:
+		var raw map[string]string
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("invalid mappings file %s: %w", f.path, err)
+		}
+		for name, description := range raw {
+			f.terms[normalizeFoodName(name)] = description
+		}
+	}
+
+	terms := make(map[string]string, len(f.terms))
+	for k, v := range f.terms {
+		terms[k] = v
+	}
+	return terms, nil
+}
+
+func (f *fileMappings) put(ctx context.Context, name, description string) error {
+	previous, existed := f.terms[name]
+	f.terms[name] = description
+	if err := f.save(); err != nil {
+		if existed {
+			f.terms[name] = previous
+		} else {
+			delete(f.terms, name)
+		}
+		return err
+	}
+	return nil
+}
+
+func (f *fileMappings) remove(ctx context.Context, name string) error {
+	previous := f.terms[name]
+	delete(f.terms, name)
+	if err := f.save(); err != nil {
+		f.terms[name] = previous
+		return err
+	}
+	return nil
+}
+
+// save rewrites the file through a temporary one, so a crash never leaves
+// it half written
+func (f *fileMappings) save() error {
+	if f.path == "" {
+		return nil
+	}
+	data, err := yaml.Marshal(f.terms)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// couchbaseMappings keeps one document per mapping in a collection. An
+// empty collection is seeded with the built-in mappings.
+type couchbaseMappings struct {
+	cluster    *gocb.Cluster
+	collection *gocb.Collection
+	keyspace   string
+}
+
+func mappingKey(name string) string {
+	return "food_mapping::" + name
+}
+
+func (c *couchbaseMappings) load(ctx context.Context) (map[string]string, error) {
+	result, err := runQuery(ctx, c.cluster, fmt.Sprintf(stmtFoodMappings, c.keyspace), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	terms := make(map[string]string)
+	for result.Next() {
+		var mapping FoodMapping
+		if err := result.Row(&mapping); err != nil {
+			return nil, err
+		}
+		terms[mapping.ObjectName] = mapping.Description
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(terms) == 0 {
+		slog.InfoContext(ctx, "no food mappings stored, seeding the built-in ones", "keyspace", c.keyspace)
+		for name, description := range defaultFoodMappings {
+			if err := c.put(ctx, name, description); err != nil {
+				return nil, err
+			}
+			terms[name] = description
+		}
+	}
+	return terms, nil
+}
+
+func (c *couchbaseMappings) put(ctx context.Context, name, description string) error {
+	_, err := c.collection.Upsert(mappingKey(name), FoodMapping{ObjectName: name, Description: description}, &gocb.UpsertOptions{Context: ctx})
+	return err
+}
+
+func (c *couchbaseMappings) remove(ctx context.Context, name string) error {
+	_, err := c.collection.Remove(mappingKey(name), &gocb.RemoveOptions{Context: ctx})
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nil
+	}
+	return err
+}
+
+var foodMappingFields = listSpec[FoodMapping]{
+	fields: map[string]listField[FoodMapping]{
+		"object_name": {value: func(m FoodMapping) any { return m.ObjectName }, text: true},
+		"description": {value: func(m FoodMapping) any { return m.Description }, text: true},
+	},
+	key:         func(m FoodMapping) string { return m.ObjectName },
+	defaultSort: "object_name",
+}
+
+// listFoodMappings returns a page of the mappings, sorted by object name
+// unless ?sort= says otherwise
+func listFoodMappings(c *gin.Context) {
+	q, err := parseListQuery(c, foodMappingFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	terms, err := foodMappings.all(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to load food mappings", err)
+		return
+	}
+
+	mappings := make([]FoodMapping, 0, len(terms))
+	for name, description := range terms {
+		mappings = append(mappings, FoodMapping{ObjectName: name, Description: description})
+	}
+	mappings, page := paginate(mappings, q, foodMappingFields)
+	c.JSON(http.StatusOK, page.response("mappings", mappings))
+}
+
+func getFoodMapping(c *gin.Context) {
+	name := normalizeFoodName(c.Param("name"))
+	description, ok, err := foodMappings.searchTerm(c.Request.Context(), name)
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to load food mappings", err)
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no mapping for %s", name)})
+		return
+	}
+	c.JSON(http.StatusOK, FoodMapping{ObjectName: name, Description: description})
+}
+
+// putFoodMapping creates or replaces the mapping of an object name
+func putFoodMapping(c *gin.Context) {
+	name := normalizeFoodName(c.Param("name"))
+	var request struct {
+		Description string `json:"description"`
+	}
+	if err := bindJSON(c, &request); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	// A blank description would match unintended rows
+	description := strings.TrimSpace(request.Description)
+	if name == "" || description == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "object name and description must not be empty"})
+		return
+	}
+
+	replaced, err := foodMappings.put(c.Request.Context(), name, description)
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to store food mapping", err)
+		return
+	}
+	status := http.StatusCreated
+	if replaced {
+		status = http.StatusOK
+	}
+	c.JSON(status, FoodMapping{ObjectName: name, Description: description})
+}
+
+func deleteFoodMapping(c *gin.Context) {
+	name := normalizeFoodName(c.Param("name"))
+	removed, err := foodMappings.remove(c.Request.Context(), name)
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to delete food mapping", err)
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no mapping for %s", name)})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
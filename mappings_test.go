@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countingMappings is a mapping store that counts its loads, which take a
+// while so concurrent first lookups overlap
+type countingMappings struct {
+	fileMappings
+	loads atomic.Int32
+	fail  atomic.Bool
+}
+
+func (s *countingMappings) load(ctx context.Context) (map[string]string, error) {
+	s.loads.Add(1)
+	time.Sleep(20 * time.Millisecond)
+	if s.fail.Load() {
+		return nil, errors.New("mappings unavailable")
+	}
+	return s.fileMappings.load(ctx)
+}
+
+// useMappings makes store the mappings for the rest of the test
+func useMappings(t *testing.T, store mappingStore) {
+	t.Helper()
+	previous := foodMappings
+	foodMappings = newMappingCache(store)
+	t.Cleanup(func() { foodMappings = previous })
+}
+
+func TestMappingsLoadOnceConcurrently(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests int
+	}{
+		{"single request", 1},
+		{"concurrent cold start", 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, "")
+			cacheFood(t, config.DefaultDataset, defaultFoodMappings["rice"], FoodData{
+				Description:  defaultFoodMappings["rice"],
+				FoodPortions: portions("1 cup", 158),
+			})
+			store := &countingMappings{}
+			useMappings(t, store)
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			found := make(chan bool, tt.requests)
+			for range tt.requests {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: "rice", VolumeCups: 1}))
+					// decode can't fail the test from another goroutine
+					var response MacroResponse
+					err := json.Unmarshal(w.Body.Bytes(), &response)
+					found <- w.Code == http.StatusOK && err == nil && response.Data[0].Found
+				}()
+			}
+			close(start)
+			wg.Wait()
+			close(found)
+
+			for ok := range found {
+				if !ok {
+					t.Error("rice not found through the mappings")
+				}
+			}
+			if loads := store.loads.Load(); loads != 1 {
+				t.Errorf("mappings loaded %d times, want once", loads)
+			}
+		})
+	}
+}
+
+func TestMappingsFailedLoadIsRetried(t *testing.T) {
+	store := &countingMappings{}
+	store.fail.Store(true)
+	cache := newMappingCache(store)
+	ctx := context.Background()
+
+	if _, _, err := cache.searchTerm(ctx, "rice"); err == nil {
+		t.Fatal("searchTerm() succeeded with a failing store")
+	}
+	store.fail.Store(false)
+	term, ok, err := cache.searchTerm(ctx, "rice")
+	if err != nil || !ok || term != defaultFoodMappings["rice"] {
+		t.Fatalf("searchTerm() = %q, %v, %v after the store recovered", term, ok, err)
+	}
+	cache.searchTerm(ctx, "egg")
+	if loads := store.loads.Load(); loads != 2 {
+		t.Errorf("mappings loaded %d times, want a retry and then none", loads)
+	}
+}
+
+func TestFileMappings(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mappings.yaml")
+	if err := os.WriteFile(path, []byte("Quinoa: Quinoa, cooked\nrice: Rice, white, cooked\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("- not a map\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"built-in without a file", "", defaultFoodMappings, false},
+		{"built-in while the file doesn't exist", filepath.Join(dir, "missing.yaml"), defaultFoodMappings, false},
+		{"file replaces the built-in ones", path, map[string]string{"quinoa": "Quinoa, cooked", "rice": "Rice, white, cooked"}, false},
+		{"invalid file", invalid, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			terms, err := (&fileMappings{path: tt.path}).load(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("load() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(terms) != len(tt.want) {
+				t.Fatalf("load() = %v, want %v", terms, tt.want)
+			}
+			for name, description := range tt.want {
+				if terms[name] != description {
+					t.Errorf("%s = %q, want %q", name, terms[name], description)
+				}
+			}
+		})
+	}
+}
+
+func TestFoodMappingsCRUD(t *testing.T) {
+	testConfig(t, "admin:\n  token: s3cret\n")
+	path := writeConfig(t, "rice: Rice, white, cooked\n")
+	useMappings(t, &fileMappings{path: path})
+	router := adminRouter()
+	auth := []string{"Authorization", "Bearer s3cret"}
+
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		body       any
+		wantStatus int
+	}{
+		{"get an existing mapping", http.MethodGet, "/admin/food-mappings/rice", nil, http.StatusOK},
+		{"get a missing mapping", http.MethodGet, "/admin/food-mappings/quinoa", nil, http.StatusNotFound},
+		{"create a mapping", http.MethodPut, "/admin/food-mappings/Quinoa", gin.H{"description": "Quinoa, cooked"}, http.StatusCreated},
+		{"replace a mapping", http.MethodPut, "/admin/food-mappings/rice", gin.H{"description": "Rice, brown, cooked"}, http.StatusOK},
+		{"blank description", http.MethodPut, "/admin/food-mappings/egg", gin.H{"description": "  "}, http.StatusBadRequest},
+		{"delete a mapping", http.MethodDelete, "/admin/food-mappings/rice", nil, http.StatusNoContent},
+		{"delete a missing mapping", http.MethodDelete, "/admin/food-mappings/rice", nil, http.StatusNotFound},
+		{"without the admin token", http.MethodGet, "/admin/food-mappings", nil, http.StatusUnauthorized},
+	}
+	for _, step := range steps {
+		headers := auth
+		if step.wantStatus == http.StatusUnauthorized {
+			headers = nil
+		}
+		if w := doRequest(t, router, step.method, step.path, step.body, headers...); w.Code != step.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", step.name, w.Code, step.wantStatus, w.Body)
+		}
+	}
+
+	list := decode[struct {
+		Mappings []FoodMapping `json:"mappings"`
+	}](t, doRequest(t, router, http.MethodGet, "/admin/food-mappings", nil, auth...), http.StatusOK)
+	if len(list.Mappings) != 1 || list.Mappings[0] != (FoodMapping{ObjectName: "quinoa", Description: "Quinoa, cooked"}) {
+		t.Errorf("mappings = %+v, want only quinoa", list.Mappings)
+	}
+
+	// The file was rewritten, so the changes survive a restart
+	terms, err := (&fileMappings{path: path}).load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(terms) != 1 || terms["quinoa"] != "Quinoa, cooked" {
+		t.Errorf("file holds %v, want only quinoa", terms)
+	}
+}
+
+func TestInvalidMappingsConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		mappings MappingsConfig
+	}{
+		{"both a file and a collection", MappingsConfig{File: "mappings.yaml", Collection: "mappings"}},
+		{"invalid collection name", MappingsConfig{Collection: "food mappings"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.mappings.validate(); err == nil {
+				t.Error("validate() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+// meals.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// MealsConfig controls the meal log. It is disabled unless a collection to
+// store meals in is configured. Listing meals queries the collection by
+// user and date, so it needs an index on (user_id, date).
+type MealsConfig struct {
+	Scope      string `yaml:"scope"`
+	Collection string `yaml:"collection"`
+}
+
+func (m *MealsConfig) enabled() bool {
+	return m.Collection != ""
+}
+
+func (m *MealsConfig) validate() error {
+	if !m.enabled() {
+		return nil
+	}
+	if m.Scope == "" {
+		m.Scope = defaultKeyspaceName
+	}
+	for _, name := range []string{m.Scope, m.Collection} {
+		if name != defaultKeyspaceName && !keyspaceNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid keyspace name %q in meals config", name)
+		}
+	}
+	return nil
+}
+
+const (
+	dateLayout = "2006-01-02"
+
+	// maxSummaryDays bounds the range of a daily summary
+	maxSummaryDays = 366
+)
+
+// Sources of a meal item
+const (
+	mealItemFrame  = "frame"
+	mealItemManual = "manual"
+)
+
+// LogMealRequest logs a meal from persisted frame results, manual entries,
+// or both
+type LogMealRequest struct {
+	// Date is the day the meal counts towards; defaults to today (UTC)
+	Date     string        `json:"date"`
+	Name     string        `json:"name,omitempty"`
+	FrameIDs []string      `json:"frame_ids" binding:"dive,frame_id"`
+	Entries  []ManualEntry `json:"entries"`
+}
+
+// ManualEntry is a food logged with macros the client already knows
+type ManualEntry struct {
+	Name   string `json:"name"`
+	Macros Macros `json:"macros"`
+}
+
+// Meal is a logged meal as stored in Couchbase; macros are kept in kcal
+type Meal struct {
+	ID       string     `json:"id"`
+	UserID   string     `json:"user_id"`
+	Date     string     `json:"date"`
+	Name     string     `json:"name,omitempty"`
+	LoggedAt time.Time  `json:"logged_at"`
+	Items    []MealItem `json:"items"`
+	Totals   Macros     `json:"totals"`
+}
+
+type MealItem struct {
+	Source  string `json:"source"`
+	FrameID string `json:"frame_id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Macros  Macros `json:"macros"`
+}
+
+type MealsResponse struct {
+	Date       string `json:"date"`
+	EnergyUnit string `json:"energy_unit"`
+	Meals      []Meal `json:"meals"`
+	listPage
+}
+
+// DailyTotals aggregates one user's meals of one day
+type DailyTotals struct {
+	Date   string `json:"date"`
+	Meals  int    `json:"meals"`
+	Totals Macros `json:"totals"`
+}
+
+type DailySummaryResponse struct {
+	From       string        `json:"from"`
+	To         string        `json:"to"`
+	EnergyUnit string        `json:"energy_unit"`
+	Days       []DailyTotals `json:"days"`
+}
+
+func mealKey(id string) string {
+	return "meal::" + id
+}
+
+// mealStore keeps the meal log, queried by user
+type mealStore interface {
+	insert(ctx context.Context, meal Meal) error
+	// ofDay returns the user's meals of a date, oldest first
+	ofDay(ctx context.Context, user, date string) ([]Meal, error)
+	// dailyTotals totals the user's meals per day from one date to another
+	// (inclusive), leaving out days without meals
+	dailyTotals(ctx context.Context, user, from, to string) ([]DailyTotals, error)
+}
+
+// couchbaseMeals stores one document per meal in collection, queried at
+// keyspace
+type couchbaseMeals struct {
+	cluster    *gocb.Cluster
+	collection *gocb.Collection
+	keyspace   string
+}
+
+func (s *couchbaseMeals) insert(ctx context.Context, meal Meal) error {
+	_, err := s.collection.Insert(mealKey(meal.ID), meal, &gocb.InsertOptions{Context: ctx})
+	return err
+}
+
+func (s *couchbaseMeals) ofDay(ctx context.Context, user, date string) ([]Meal, error) {
+	return queryAll[Meal](ctx, s.cluster, fmt.Sprintf(stmtMealsOfDay, s.keyspace), []interface{}{user, date})
+}
+
+func (s *couchbaseMeals) dailyTotals(ctx context.Context, user, from, to string) ([]DailyTotals, error) {
+	return queryAll[DailyTotals](ctx, s.cluster, fmt.Sprintf(stmtDailyTotals, s.keyspace), []interface{}{user, from, to})
+}
+
+// queryAll runs a meal query and collects its rows
+func queryAll[T any](ctx context.Context, cluster *gocb.Cluster, statement string, params []interface{}) ([]T, error) {
+	result, err := runQuery(ctx, cluster, statement, params, &gocb.QueryOptions{
+		// A meal that was just logged must show up
+		ScanConsistency: gocb.QueryScanConsistencyRequestPlus,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	rows := make([]T, 0)
+	for result.Next() {
+		var row T
+		if err := result.Row(&row); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, result.Err()
+}
+
+// requireMeals answers 404 while the meal log is disabled
+func requireMeals(c *gin.Context) bool {
+	if db.meals == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "meal logging is not enabled"})
+		return false
+	}
+	return true
+}
+
+// parseDate validates a YYYY-MM-DD date, defaulting to today (UTC)
+func parseDate(field, value string) (string, error) {
+	if value == "" {
+		return time.Now().UTC().Format(dateLayout), nil
+	}
+	if _, err := time.Parse(dateLayout, value); err != nil {
+		return "", fmt.Errorf("%s must be a date formatted YYYY-MM-DD", field)
+	}
+	return value, nil
+}
+
+// inKcal undoes the energy unit a stored response was formatted with
+func inKcal(m Macros, energyUnit string) Macros {
+	if energyUnit == energyKJ {
+		m.Calories /= kJPerKcal
+	}
+	return m
+}
+
+// logMeal stores a meal. Frame items take the totals of a result stored with
+// persist: true; manual items take the macros they are sent with.
+func logMeal(c *gin.Context) {
+	if !requireMeals(c) {
+		return
+	}
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	var request LogMealRequest
+	if err := bindJSON(c, &request); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	date, err := parseDate("date", request.Date)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(request.FrameIDs)+len(request.Entries) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a meal needs at least one frame_id or entry"})
+		return
+	}
+	if len(request.FrameIDs) > 0 && db.frames == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "persisting results is not enabled, so frame_ids can't be logged"})
+		return
+	}
+	for i, entry := range request.Entries {
+		m := entry.Macros
+		if strings.TrimSpace(entry.Name) == "" || m.Calories < 0 || m.Carbs < 0 || m.Fat < 0 || m.Protein < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("entries[%d] needs a name and non-negative macros", i)})
+			return
+		}
+	}
+
+	meal := Meal{
+		ID:       newCorrelationID(),
+		UserID:   user,
+		Date:     date,
+		Name:     request.Name,
+		LoggedAt: time.Now().UTC(),
+		Items:    make([]MealItem, 0, len(request.FrameIDs)+len(request.Entries)),
+	}
+	for _, frameID := range request.FrameIDs {
+		record, found, err := db.frames.get(c.Request.Context(), user, frameID)
+		if err != nil {
+			if timedOut(c) {
+				return
+			}
+			respondError(c, http.StatusBadGateway, "failed to load frame", err)
+			return
+		}
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("no stored result for frame %s", frameID)})
+			return
+		}
+
+		item := MealItem{
+			Source:  mealItemFrame,
+			FrameID: frameID,
+			Macros:  inKcal(record.Response.Summary.Totals, record.Response.EnergyUnit),
+		}
+		meal.Items = append(meal.Items, item)
+		meal.Totals = meal.Totals.add(item.Macros)
+	}
+	for _, entry := range request.Entries {
+		item := MealItem{Source: mealItemManual, Name: entry.Name, Macros: entry.Macros}
+		meal.Items = append(meal.Items, item)
+		meal.Totals = meal.Totals.add(item.Macros)
+	}
+
+	if err := db.meals.insert(c.Request.Context(), meal); err != nil {
+		if timedOut(c) {
+			return
+		}
+		respondError(c, http.StatusBadGateway, "failed to store meal", err)
+		return
+	}
+	c.JSON(http.StatusCreated, meal)
+}
+
+var mealFields = listSpec[Meal]{
+	fields: map[string]listField[Meal]{
+		"name":      {value: func(m Meal) any { return m.Name }, text: true},
+		"logged_at": {value: func(m Meal) any { return m.LoggedAt }},
+		"calories":  {value: func(m Meal) any { return m.Totals.Calories }},
+	},
+	key:         func(m Meal) string { return m.ID },
+	defaultSort: "logged_at",
+}
+
+// listMeals returns the user's meals of ?date= (default today), oldest
+// first unless ?sort= says otherwise
+func listMeals(c *gin.Context) {
+	if !requireMeals(c) {
+		return
+	}
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	q, err := parseListQuery(c, mealFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	date, err := parseDate("date", c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format, err := parseOutputFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	meals, err := db.meals.ofDay(c.Request.Context(), user, date)
+	if err != nil {
+		if timedOut(c) {
+			return
+		}
+		respondError(c, http.StatusBadGateway, "meal query failed", err)
+		return
+	}
+	for i := range meals {
+		meals[i].Totals = format.macros(meals[i].Totals)
+		for j := range meals[i].Items {
+			meals[i].Items[j].Macros = format.macros(meals[i].Items[j].Macros)
+		}
+	}
+
+	response := MealsResponse{Date: date, EnergyUnit: format.energyUnit, Meals: meals}
+	response.Meals, response.listPage = paginate(response.Meals, q, mealFields)
+	c.JSON(http.StatusOK, response)
+}
+
+// dailySummary totals the user's meals per day from ?from= to ?to=
+// (inclusive; both default to today). Days without meals are left out.
+func dailySummary(c *gin.Context) {
+	if !requireMeals(c) {
+		return
+	}
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	from, err := parseDate("from", c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := parseDate("to", c.DefaultQuery("to", from))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fromDay, _ := time.Parse(dateLayout, from)
+	toDay, _ := time.Parse(dateLayout, to)
+	if days := toDay.Sub(fromDay).Hours() / 24; days < 0 || days >= maxSummaryDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("to must be on or after from and at most %d days later", maxSummaryDays-1)})
+		return
+	}
+	format, err := parseOutputFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	days, err := db.meals.dailyTotals(c.Request.Context(), user, from, to)
+	if err != nil {
+		if timedOut(c) {
+			return
+		}
+		respondError(c, http.StatusBadGateway, "daily summary query failed", err)
+		return
+	}
+	for i := range days {
+		days[i].Totals = format.macros(days[i].Totals)
+	}
+
+	response := DailySummaryResponse{From: from, To: to, EnergyUnit: format.energyUnit, Days: days}
+	c.JSON(http.StatusOK, response)
+}
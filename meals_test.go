@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memoryMeals is a meal store kept in memory
+type memoryMeals struct {
+	mu    sync.Mutex
+	meals []Meal
+}
+
+func (s *memoryMeals) insert(_ context.Context, meal Meal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meals = append(s.meals, meal)
+	return nil
+}
+
+func (s *memoryMeals) ofDay(_ context.Context, user, date string) ([]Meal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meals := make([]Meal, 0)
+	for _, meal := range s.meals {
+		if meal.UserID == user && meal.Date == date {
+			meals = append(meals, meal)
+		}
+	}
+	return meals, nil
+}
+
+func (s *memoryMeals) dailyTotals(_ context.Context, user, from, to string) ([]DailyTotals, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byDate := make(map[string]*DailyTotals)
+	for _, meal := range s.meals {
+		if meal.UserID != user || meal.Date < from || meal.Date > to {
+			continue
+		}
+		day, ok := byDate[meal.Date]
+		if !ok {
+			day = &DailyTotals{Date: meal.Date}
+			byDate[meal.Date] = day
+		}
+		day.Meals++
+		day.Totals = day.Totals.add(meal.Totals)
+	}
+	days := make([]DailyTotals, 0, len(byDate))
+	for _, day := range byDate {
+		days = append(days, *day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days, nil
+}
+
+// mealsRouter serves calculate-macros and the meal log with the test foods
+// cached, keeping frames and meals in memory when enabled
+func mealsRouter(t *testing.T, enabled bool) *gin.Engine {
+	t.Helper()
+	router := framesRouter(t, enabled)
+	if enabled {
+		db.meals = &memoryMeals{}
+	}
+	router.POST("/v1/meals", authenticate, logMeal)
+	router.GET("/v1/meals", authenticate, listMeals)
+	router.GET("/v1/daily-summary", authenticate, dailySummary)
+	return router
+}
+
+func TestMealLog(t *testing.T) {
+	router := mealsRouter(t, true)
+	user := []string{userHeader, "alice"}
+
+	frame := volumes(Volume{ObjectName: "rice", VolumeCups: 1})
+	frame.Data.FrameID = "frame-1"
+	frame.Data.Persist = true
+	computed := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros?energy_unit=kJ", frame, user...), http.StatusOK)
+
+	request := LogMealRequest{
+		Date:     "2026-03-01",
+		Name:     "lunch",
+		FrameIDs: []string{"frame-1"},
+		Entries:  []ManualEntry{{Name: "apple", Macros: Macros{Calories: 95, Carbs: 25}}},
+	}
+	meal := decode[Meal](t, doRequest(t, router, http.MethodPost, "/v1/meals", request, user...), http.StatusCreated)
+	if meal.ID == "" || meal.UserID != "alice" || len(meal.Items) != 2 {
+		t.Fatalf("meal = %+v, want alice's meal with two items", meal)
+	}
+	// The frame was computed in kJ but meals are stored in kcal
+	wantFrame := inKcal(computed.Summary.Totals, energyKJ)
+	if got := meal.Items[0].Macros; math.Abs(got.Calories-wantFrame.Calories) > 1e-9 || got.Carbs != wantFrame.Carbs {
+		t.Errorf("frame item = %+v, want %+v", got, wantFrame)
+	}
+	wantTotals := wantFrame.add(Macros{Calories: 95, Carbs: 25})
+	if math.Abs(meal.Totals.Calories-wantTotals.Calories) > 1e-9 || meal.Totals.Carbs != wantTotals.Carbs {
+		t.Errorf("totals = %+v, want %+v", meal.Totals, wantTotals)
+	}
+
+	listed := decode[MealsResponse](t, doRequest(t, router, http.MethodGet, "/v1/meals?date=2026-03-01", nil, user...), http.StatusOK)
+	if len(listed.Meals) != 1 || listed.Meals[0].ID != meal.ID {
+		t.Errorf("meals = %+v, want the logged meal", listed.Meals)
+	}
+	other := decode[MealsResponse](t, doRequest(t, router, http.MethodGet, "/v1/meals?date=2026-03-01", nil, userHeader, "bob"), http.StatusOK)
+	if len(other.Meals) != 0 {
+		t.Errorf("bob's meals = %+v, want none", other.Meals)
+	}
+
+	summary := decode[DailySummaryResponse](t, doRequest(t, router, http.MethodGet, "/v1/daily-summary?from=2026-02-28&to=2026-03-02", nil, user...), http.StatusOK)
+	if len(summary.Days) != 1 || summary.Days[0].Date != "2026-03-01" || summary.Days[0].Meals != 1 {
+		t.Errorf("days = %+v, want one meal on 2026-03-01", summary.Days)
+	}
+}
+
+func TestLogMealRejected(t *testing.T) {
+	user := []string{userHeader, "alice"}
+	apple := []ManualEntry{{Name: "apple", Macros: Macros{Calories: 95}}}
+	tests := []struct {
+		name       string
+		enabled    bool
+		headers    []string
+		request    LogMealRequest
+		wantStatus int
+	}{
+		{"meal logging disabled", false, user, LogMealRequest{Entries: apple}, http.StatusNotFound},
+		{"missing user", true, nil, LogMealRequest{Entries: apple}, http.StatusUnauthorized},
+		{"malformed date", true, user, LogMealRequest{Date: "03/01/2026", Entries: apple}, http.StatusBadRequest},
+		{"empty meal", true, user, LogMealRequest{}, http.StatusBadRequest},
+		{"entry without a name", true, user, LogMealRequest{Entries: []ManualEntry{{Macros: Macros{Calories: 95}}}}, http.StatusBadRequest},
+		{"negative macros", true, user, LogMealRequest{Entries: []ManualEntry{{Name: "apple", Macros: Macros{Fat: -1}}}}, http.StatusBadRequest},
+		{"unknown frame", true, user, LogMealRequest{FrameIDs: []string{"missing"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := mealsRouter(t, tt.enabled)
+			if w := doRequest(t, router, http.MethodPost, "/v1/meals", tt.request, tt.headers...); w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestDailySummaryRange(t *testing.T) {
+	user := []string{userHeader, "alice"}
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{"defaults to today", "", http.StatusOK},
+		{"a full year", "from=2026-01-01&to=2026-12-31", http.StatusOK},
+		{"to before from", "from=2026-03-02&to=2026-03-01", http.StatusBadRequest},
+		{"more than a year", "from=2025-01-01&to=2026-01-02", http.StatusBadRequest},
+		{"malformed from", "from=yesterday", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := mealsRouter(t, true)
+			if w := doRequest(t, router, http.MethodGet, "/v1/daily-summary?"+tt.query, nil, user...); w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestInvalidMealsConfig(t *testing.T) {
+	meals := MealsConfig{Collection: "meal log"}
+	if err := meals.validate(); err == nil {
+		t.Error("validate() succeeded, want an error")
+	}
+}
+
+// persistRice calculates a cup of rice and stores it as frameID
+func persistRice(frameID string) VolumeRequest {
+	request := volumes(Volume{ObjectName: "rice", VolumeCups: 1})
+	request.Data.FrameID = frameID
+	request.Data.Persist = true
+	return request
+}
+
+func TestUserHeaderNeedsTrust(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    bool
+		headers    []string
+		wantStatus int
+	}{
+		{"header refused without trust", false, []string{userHeader, "alice"}, http.StatusForbidden},
+		{"no user without trust", false, nil, http.StatusForbidden},
+		{"trusted header", true, []string{userHeader, "alice"}, http.StatusOK},
+		{"trusted header missing", true, nil, http.StatusUnauthorized},
+		{"trusted header blank", true, []string{userHeader, "  "}, http.StatusUnauthorized},
+	}
+	routes := []struct {
+		method, path string
+		body         any
+		okStatus     int
+	}{
+		{http.MethodPost, "/v1/calculate-macros", persistRice("frame-1"), http.StatusOK},
+		{http.MethodGet, "/v1/frames/frame-1", nil, http.StatusNotFound},
+		{http.MethodPost, "/v1/meals", LogMealRequest{Entries: []ManualEntry{{Name: "apple", Macros: Macros{Calories: 95}}}}, http.StatusCreated},
+		{http.MethodGet, "/v1/meals", nil, http.StatusOK},
+		{http.MethodGet, "/v1/daily-summary", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, route := range routes {
+				router := mealsRouter(t, true)
+				cfg.Auth.TrustUserHeader = tt.trusted
+				want := tt.wantStatus
+				if want == http.StatusOK {
+					want = route.okStatus
+				}
+				w := doRequest(t, router, route.method, route.path, route.body, tt.headers...)
+				if w.Code != want {
+					t.Errorf("%s %s: status = %d, want %d: %s", route.method, route.path, w.Code, want, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestTrustUserHeaderNeedsNoJWKS(t *testing.T) {
+	auth := AuthConfig{JWKSURL: "https://auth.example.com/jwks.json", TrustUserHeader: true}
+	if err := auth.validate(); err == nil {
+		t.Error("validate() accepted trust_user_header with a jwks_url")
+	}
+}
+
+func TestUserDataIsolation(t *testing.T) {
+	router := mealsRouter(t, true)
+	alice := []string{userHeader, "alice"}
+	decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", persistRice("frame-1"), alice...), http.StatusOK)
+	decode[Meal](t, doRequest(t, router, http.MethodPost, "/v1/meals", LogMealRequest{FrameIDs: []string{"frame-1"}}, alice...), http.StatusCreated)
+
+	tests := []struct {
+		name      string
+		user      string
+		wantFrame int
+		wantMeals int
+		wantDays  int
+	}{
+		{"owner sees their data", "alice", http.StatusOK, 1, 1},
+		{"other user sees none of it", "bob", http.StatusNotFound, 0, 0},
+		{"user ID is matched exactly", "Alice", http.StatusNotFound, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := []string{userHeader, tt.user}
+			if w := doRequest(t, router, http.MethodGet, "/v1/frames/frame-1", nil, user...); w.Code != tt.wantFrame {
+				t.Errorf("frame status = %d, want %d: %s", w.Code, tt.wantFrame, w.Body)
+			}
+			meals := decode[MealsResponse](t, doRequest(t, router, http.MethodGet, "/v1/meals", nil, user...), http.StatusOK)
+			if len(meals.Meals) != tt.wantMeals {
+				t.Errorf("meals = %+v, want %d", meals.Meals, tt.wantMeals)
+			}
+			summary := decode[DailySummaryResponse](t, doRequest(t, router, http.MethodGet, "/v1/daily-summary", nil, user...), http.StatusOK)
+			if len(summary.Days) != tt.wantDays {
+				t.Errorf("days = %+v, want %d", summary.Days, tt.wantDays)
+			}
+			// Logging another user's frame fails as if it didn't exist
+			status := http.StatusCreated
+			if tt.wantFrame != http.StatusOK {
+				status = http.StatusBadRequest
+			}
+			if w := doRequest(t, router, http.MethodPost, "/v1/meals", LogMealRequest{FrameIDs: []string{"frame-1"}}, user...); w.Code != status {
+				t.Errorf("logging frame-1: status = %d, want %d: %s", w.Code, status, w.Body)
+			}
+		})
+	}
+}
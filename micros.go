@@ -0,0 +1,134 @@
+// micros.go
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// microNutrient is a nutrient beyond the macros that can be requested with
+// ?nutrients=
+type microNutrient struct {
+	Number string
+	Name   string
+	// Unit is the documented FDC unit, used when a document's nutrient has
+	// no unit metadata
+	Unit string
+}
+
+// microNutrients lists the FNDDS nutrients offered, by FDC nutrient number
+var microNutrients = []microNutrient{
+	{"291", "fiber", "g"},
+	{"269", "sugars", "g"},
+	{"606", "saturated_fat", "g"},
+	{"601", "cholesterol", "mg"},
+	{"307", "sodium", "mg"},
+	{"306", "potassium", "mg"},
+	{"301", "calcium", "mg"},
+	{"303", "iron", "mg"},
+	{"320", "vitamin_a", "µg"},
+	{"401", "vitamin_c", "mg"},
+	{"328", "vitamin_d", "µg"},
+	{"323", "vitamin_e", "mg"},
+	{"430", "vitamin_k", "µg"},
+	{"404", "thiamin", "mg"},
+	{"405", "riboflavin", "mg"},
+	{"406", "niacin", "mg"},
+	{"415", "vitamin_b6", "mg"},
+	{"417", "folate", "µg"},
+	{"418", "vitamin_b12", "µg"},
+}
+
+// NutrientAmount is a nutrient scaled to the calculated weight, in the
+// unit the dataset reports it in and, for mass units, in grams
+type NutrientAmount struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+	Unit   string  `json:"unit"`
+	// Grams isn't rounded, micrograms would mostly round to zero
+	Grams *float64 `json:"grams,omitempty"`
+}
+
+// gramsPerUnit converts the mass units nutrients are reported in to grams
+var gramsPerUnit = map[string]float64{
+	"g":  1,
+	"mg": 1e-3,
+	"µg": 1e-6,
+}
+
+// parseNutrientSelection reads ?nutrients=all or a comma-separated list of
+// nutrient numbers or names, e.g. ?nutrients=fiber,307
+func parseNutrientSelection(c *gin.Context) ([]microNutrient, error) {
+	return selectNutrients(c.Query("nutrients"))
+}
+
+// selectNutrients resolves a nutrients selection; see parseNutrientSelection
+func selectNutrients(raw string) ([]microNutrient, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	if raw == "all" {
+		return microNutrients, nil
+	}
+
+	var selected []microNutrient
+	for _, want := range strings.Split(raw, ",") {
+		want = strings.ToLower(strings.TrimSpace(want))
+		found := false
+		for _, micro := range microNutrients {
+			if micro.Number == want || micro.Name == want {
+				selected = append(selected, micro)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown nutrient %q in nutrients", want)
+		}
+	}
+	return selected, nil
+}
+
+// microNutrientAmounts scales the selected nutrients to grams, keyed by
+// nutrient number. Nutrients the food has no entry for are left out.
+func microNutrientAmounts(nutrients []Nutrient, grams float64, selected []microNutrient) map[string]NutrientAmount {
+	if len(selected) == 0 {
+		return nil
+	}
+
+	amounts := make(map[string]NutrientAmount, len(selected))
+	for _, micro := range selected {
+		for _, nutrient := range nutrients {
+			if nutrient.Nutrient.Number != micro.Number {
+				continue
+			}
+			unit := nutrientUnit(nutrient.Nutrient.UnitName)
+			if unit == "" {
+				unit = micro.Unit
+			}
+			amount := NutrientAmount{
+				Name:   micro.Name,
+				Amount: nutrient.Amount * grams / 100, // nutrients are per 100g
+				Unit:   unit,
+			}
+			if factor, ok := gramsPerUnit[unit]; ok {
+				inGrams := amount.Amount * factor
+				amount.Grams = &inGrams
+			}
+			amounts[micro.Number] = amount
+			break
+		}
+	}
+	return amounts
+}
+
+// nutrientUnit normalizes FDC unit names ("MG", "UG") for responses
+func nutrientUnit(unitName string) string {
+	unit := strings.ToLower(strings.TrimSpace(unitName))
+	if unit == "ug" {
+		return "µg"
+	}
+	return unit
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unitNutrient builds an FDC nutrient with a unit name
+func unitNutrient(number string, amount float64, unitName string) Nutrient {
+	var n Nutrient
+	n.Nutrient.Number = number
+	n.Nutrient.UnitName = unitName
+	n.Amount = amount
+	return n
+}
+
+func TestMicroNutrientUnits(t *testing.T) {
+	tests := []struct {
+		name      string
+		nutrient  Nutrient
+		wantUnit  string
+		wantValue float64
+		wantGrams float64
+	}{
+		{"mg from the metadata", unitNutrient("306", 358, "MG"), "mg", 716, 0.716},
+		{"µg from the metadata", unitNutrient("320", 469, "UG"), "µg", 938, 0.000938},
+		{"g from the metadata", unitNutrient("291", 2.6, "G"), "g", 5.2, 5.2},
+		{"documented unit without metadata", unitNutrient("307", 79, ""), "mg", 158, 0.158},
+		{"documented µg unit without metadata", unitNutrient("418", 0.5, " "), "µg", 1, 0.000001},
+		{"unit without a gram conversion", unitNutrient("320", 100, "IU"), "iu", 200, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amounts := microNutrientAmounts([]Nutrient{tt.nutrient}, 200, microNutrients)
+			got, ok := amounts[tt.nutrient.Nutrient.Number]
+			if !ok {
+				t.Fatalf("amounts = %v, missing %s", amounts, tt.nutrient.Nutrient.Number)
+			}
+			if got.Unit != tt.wantUnit || math.Abs(got.Amount-tt.wantValue) > 1e-9 {
+				t.Errorf("amount = %v %s, want %v %s", got.Amount, got.Unit, tt.wantValue, tt.wantUnit)
+			}
+			if tt.wantGrams == 0 {
+				if got.Grams != nil {
+					t.Errorf("grams = %v, want none for %s", *got.Grams, got.Unit)
+				}
+				return
+			}
+			if got.Grams == nil || math.Abs(*got.Grams-tt.wantGrams) > 1e-12 {
+				t.Errorf("grams = %v, want %v", got.Grams, tt.wantGrams)
+			}
+		})
+	}
+}
+
+func TestMicroNutrientAmountsLeavesOutMissing(t *testing.T) {
+	amounts := microNutrientAmounts([]Nutrient{unitNutrient("306", 358, "MG")}, 100, microNutrients)
+	if len(amounts) != 1 {
+		t.Errorf("amounts = %v, want only potassium", amounts)
+	}
+	if amounts := microNutrientAmounts([]Nutrient{unitNutrient("306", 358, "MG")}, 100, nil); amounts != nil {
+		t.Errorf("amounts = %v without a selection, want none", amounts)
+	}
+}
+
+func TestNutrientSelection(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantNumbers []string
+	}{
+		{"none requested", "", http.StatusOK, nil},
+		{"by name and number", "?nutrients=fiber,307", http.StatusOK, []string{"291", "307"}},
+		{"names are case-insensitive", "?nutrients=%20Potassium", http.StatusOK, []string{"306"}},
+		{"all that the food has", "?nutrients=all", http.StatusOK, []string{"291", "306", "307"}},
+		{"unknown nutrient", "?nutrients=fiber,unobtainium", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, "")
+			food := FoodData{
+				FdcID:        2,
+				Description:  defaultFoodMappings["banana"],
+				FoodPortions: portions("1 cup, sliced", 150),
+				FoodNutrients: []Nutrient{
+					unitNutrient("291", 2.6, "G"),
+					unitNutrient("306", 358, "MG"),
+					unitNutrient("307", 1, "MG"),
+				},
+			}
+			cacheFood(t, config.DefaultDataset, defaultFoodMappings["banana"], food)
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros"+tt.query, volumes(Volume{ObjectName: "banana", VolumeCups: 1}))
+			if tt.wantStatus != http.StatusOK {
+				if w.Code != tt.wantStatus {
+					t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+				}
+				return
+			}
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if len(item.Nutrients) != len(tt.wantNumbers) {
+				t.Fatalf("nutrients = %v, want %v", item.Nutrients, tt.wantNumbers)
+			}
+			for _, number := range tt.wantNumbers {
+				if _, ok := item.Nutrients[number]; !ok {
+					t.Errorf("nutrient %s missing from %v", number, item.Nutrients)
+				}
+			}
+			// One cup, sliced, is 150 g at 358 mg per 100 g
+			if potassium, ok := item.Nutrients["306"]; ok && math.Abs(potassium.Amount-537) > 1e-9 {
+				t.Errorf("potassium = %v, want 537", potassium.Amount)
+			}
+		})
+	}
+}
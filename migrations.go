@@ -0,0 +1,203 @@
+// migrations.go
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// keyspaceRef names a collection of the bucket
+type keyspaceRef struct {
+	scope      string
+	collection string
+}
+
+// couchbaseIndex is a secondary index the statements of queries.go rely on
+type couchbaseIndex struct {
+	name  string
+	on    keyspaceRef
+	keys  string // empty for a primary index
+	usage string
+}
+
+func (i couchbaseIndex) statement(bucket string) string {
+	keyspace := keyspaceFor(bucket, i.on.scope, i.on.collection)
+	if i.keys == "" {
+		return fmt.Sprintf("CREATE PRIMARY INDEX IF NOT EXISTS `%s` ON %s", i.name, keyspace)
+	}
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS `%s` ON %s(%s)", i.name, keyspace, i.keys)
+}
+
+// datasetCollections lists the collections of the datasets, sorted by
+// dataset name
+func datasetCollections(config *Config) []keyspaceRef {
+	names := make([]string, 0, len(config.Datasets))
+	for name := range config.Datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collections := make([]keyspaceRef, 0, len(names))
+	for _, name := range names {
+		dataset := config.Datasets[name]
+		collections = append(collections, keyspaceRef{dataset.Scope, dataset.Collection})
+	}
+	return collections
+}
+
+// requiredCollections lists every collection the configuration reads or
+// writes
+func requiredCollections(config *Config) []keyspaceRef {
+	collections := datasetCollections(config)
+	for _, feature := range []struct{ scope, collection string }{
+		{config.FoodMappings.Scope, config.FoodMappings.Collection},
+		{config.Conversions.Scope, config.Conversions.Collection},
+		{config.Recipes.Scope, config.Recipes.Collection},
+		{config.Synonyms.Scope, config.Synonyms.Collection},
+		{config.UnresolvedFoods.Scope, config.UnresolvedFoods.Collection},
+		{config.Meals.Scope, config.Meals.Collection},
+		{config.Frames.Scope, config.Frames.Collection},
+		{config.Feedback.Scope, config.Feedback.Collection},
+		{config.Idempotency.Scope, config.Idempotency.Collection},
+	} {
+		if feature.collection != "" {
+			collections = append(collections, keyspaceRef{feature.scope, feature.collection})
+		}
+	}
+	return collections
+}
+
+// requiredIndexes lists the indexes behind the configured queries
+func requiredIndexes(config *Config) []couchbaseIndex {
+	var indexes []couchbaseIndex
+	for _, collection := range datasetCollections(config) {
+		indexes = append(indexes,
+			couchbaseIndex{"ix_food_description", collection, "LOWER(description), dataVersion, fdcId", "lookups by description"},
+			couchbaseIndex{"ix_food_fdc_id", collection, "fdcId", "search and lookups by fdcId"},
+			couchbaseIndex{"ix_food_data_version", collection, "dataVersion", "data_version checks"},
+			// Fuzzy matching and coverage scan the collection
+			couchbaseIndex{"ix_food_primary", collection, "", "fuzzy matching and coverage"},
+		)
+	}
+	if config.FoodMappings.Collection != "" {
+		on := keyspaceRef{config.FoodMappings.Scope, config.FoodMappings.Collection}
+		indexes = append(indexes, couchbaseIndex{"ix_mapping_object_name", on, "object_name", "loading food mappings"})
+	}
+	if config.Conversions.enabled() {
+		on := keyspaceRef{config.Conversions.Scope, config.Conversions.Collection}
+		indexes = append(indexes, couchbaseIndex{"ix_conversion_object_name", on, "object_name", "loading conversions"})
+	}
+	if config.Recipes.enabled() {
+		on := keyspaceRef{config.Recipes.Scope, config.Recipes.Collection}
+		indexes = append(indexes, couchbaseIndex{"ix_recipe_name", on, "name", "loading recipes"})
+	}
+	if config.Synonyms.enabled() {
+		on := keyspaceRef{config.Synonyms.Scope, config.Synonyms.Collection}
+		indexes = append(indexes, couchbaseIndex{"ix_synonym_term", on, "term, language", "loading synonyms"})
+	}
+	if config.UnresolvedFoods.enabled() {
+		on := keyspaceRef{config.UnresolvedFoods.Scope, config.UnresolvedFoods.Collection}
+		indexes = append(indexes, couchbaseIndex{"ix_unresolved_count", on, "`count` DESC, object_name", "the unresolved foods report"})
+	}
+	if config.Meals.enabled() {
+		on := keyspaceRef{config.Meals.Scope, config.Meals.Collection}
+		indexes = append(indexes, couchbaseIndex{"ix_meal_user_date", on, "user_id, date, logged_at", "meal queries"})
+	}
+	return indexes
+}
+
+// migrateCouchbase creates the collections and indexes the configuration
+// needs, leaving existing ones alone, so it is safe to run on every start.
+// It waits until the indexes are online.
+func migrateCouchbase(ctx context.Context, config *Config, cluster *gocb.Cluster, bucket *gocb.Bucket) error {
+	manager := bucket.CollectionsV2()
+	scopes := make(map[string]bool)
+	for _, ref := range requiredCollections(config) {
+		if ref.scope != defaultKeyspaceName && !scopes[ref.scope] {
+			err := manager.CreateScope(ref.scope, &gocb.CreateScopeOptions{Context: ctx})
+			if err != nil && !errors.Is(err, gocb.ErrScopeExists) {
+				return fmt.Errorf("failed to create scope %s: %w", ref.scope, err)
+			}
+			scopes[ref.scope] = true
+		}
+		if ref.collection == defaultKeyspaceName {
+			continue
+		}
+		err := manager.CreateCollection(ref.scope, ref.collection, nil, &gocb.CreateCollectionOptions{Context: ctx})
+		switch {
+		case err == nil:
+			slog.Info("created collection", "scope", ref.scope, "collection", ref.collection)
+		case !errors.Is(err, gocb.ErrCollectionExists):
+			return fmt.Errorf("failed to create collection %s.%s: %w", ref.scope, ref.collection, err)
+		}
+	}
+
+	indexes := requiredIndexes(config)
+	names := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		statement := index.statement(config.CouchDB.Bucket)
+		slog.Debug("ensuring index", "statement", statement)
+		result, err := cluster.Query(statement, &gocb.QueryOptions{Adhoc: true, Context: ctx})
+		if err != nil {
+			return fmt.Errorf("failed to create index %s for %s: %w", index.name, index.usage, err)
+		}
+		result.Close()
+		if !slices.Contains(names, index.name) {
+			names = append(names, index.name)
+		}
+	}
+	slog.Info("required indexes exist", "indexes", len(indexes))
+
+	wait := config.CouchDB.WaitForIndexes
+	wait.Names = names
+	return waitForIndexes(clusterIndexes{cluster: cluster}, config.CouchDB.Bucket, wait)
+}
+
+// runMigrate implements the migrate command:
+//
+//	bytemi-fdc-api migrate
+//
+// It creates the configured Couchbase collections and indexes and exits,
+// for deployments that start the API with --skip-migrations.
+func runMigrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bytemi-fdc-api migrate")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	cfg, err = loadAppConfig("")
+	if err != nil {
+		fatal("failed to load config", err)
+	}
+	setupLogging(cfg.Logging.Format)
+	if cfg.Storage != storageCouchbase {
+		// The SQL stores migrate their schema whenever they are opened
+		fatal("nothing to migrate", fmt.Errorf("storage %s migrates on open", cfg.Storage))
+	}
+
+	// The command migrates even where startup is configured not to
+	cfg.CouchDB.SkipMigrations = false
+	start := time.Now()
+	if err := connectStorage(); err != nil {
+		fatal("migration failed", err)
+	}
+	if err := foodRepo.Close(); err != nil {
+		slog.Warn("failed to close database connections", "error", err)
+	}
+	slog.Info("migration complete", "elapsed", time.Since(start).Round(time.Millisecond).String())
+}
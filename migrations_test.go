@@ -0,0 +1,40 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRequiredCollectionsAndIndexes(t *testing.T) {
+	config := &Config{
+		Datasets: map[string]Dataset{
+			"sr":    {Scope: "fdc", Collection: "sr"},
+			"fndds": {Scope: "fdc", Collection: "fndds"},
+		},
+		Meals: MealsConfig{Scope: "app", Collection: "meals"},
+	}
+	config.CouchDB.Bucket = "foods"
+
+	// Datasets come first, sorted by name, then the enabled features
+	want := []keyspaceRef{{"fdc", "fndds"}, {"fdc", "sr"}, {"app", "meals"}}
+	if got := requiredCollections(config); !slices.Equal(got, want) {
+		t.Errorf("requiredCollections() = %v, want %v", got, want)
+	}
+
+	statements := make(map[string]bool)
+	for _, index := range requiredIndexes(config) {
+		statements[index.statement(config.CouchDB.Bucket)] = true
+		if index.name == "ix_conversion_object_name" || index.name == "ix_mapping_object_name" {
+			t.Errorf("index %s for a disabled feature", index.name)
+		}
+	}
+	for _, statement := range []string{
+		"CREATE INDEX IF NOT EXISTS `ix_food_description` ON `foods`.`fdc`.`sr`(LOWER(description), dataVersion, fdcId)",
+		"CREATE PRIMARY INDEX IF NOT EXISTS `ix_food_primary` ON `foods`.`fdc`.`fndds`",
+		"CREATE INDEX IF NOT EXISTS `ix_meal_user_date` ON `foods`.`app`.`meals`(user_id, date, logged_at)",
+	} {
+		if !statements[statement] {
+			t.Errorf("missing %s", statement)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// openapi.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiOperation documents one route. The schemas are generated from the
+// request and response types, so the spec can't drift from the JSON the
+// handlers produce; a route added to main needs an entry here.
+type apiOperation struct {
+	method  string
+	path    string
+	summary string
+	// query lists the query parameters, described in apiQueryParams
+	query []string
+	// request is a value of the body type; nil for none
+	request any
+	status  int
+	// response is a value of the success body type; nil for none
+	response any
+	// security is "user" (JWT), "admin" (admin token) or empty
+	security string
+}
+
+// errorResponse is the body of every error response
+type errorResponse struct {
+	Error         string       `json:"error"`
+	CorrelationID string       `json:"correlation_id,omitempty"`
+	Fields        []FieldError `json:"fields,omitempty"`
+}
+
+var apiQueryParams = map[string]string{
+	"candidates":       "List every food sharing the matched description (duplicates.mode all)",
+	"consensus":        "Average the food across datasets",
+	"data_version":     "Only match foods ingested as this data version",
+	"debug_query":      "Return the executed statements (development mode)",
+	"include_portions": "Return every portion of the matched food",
+	"meta":             "Return processing metadata",
+	"per_gram":         "Return the macros per gram",
+	"per_serving":      "Return the macros of one serving of each food",
+	"variants":         "Return the food as found in every dataset",
+	"nutrients":        "Comma-separated FDC nutrient numbers to return",
+	"lang":             "Preferred description languages, overriding Accept-Language",
+	"energy_unit":      "kcal (default) or kJ",
+	"precision":        "Decimal places to round to, 0-6",
+	"date":             "Day as YYYY-MM-DD; defaults to today (UTC)",
+	"from":             "First day as YYYY-MM-DD",
+	"to":               "Last day as YYYY-MM-DD",
+	"q":                "Words the description must contain",
+	"dataset":          "Dataset to read; defaults to default_dataset",
+	"limit":            "Page size",
+	"cursor":           "next_cursor of the previous page",
+	"sort":             "Field to sort by, descending with a leading -",
+	"filter":           "field:value to match, case-insensitive; a trailing * matches a prefix. Repeatable",
+	"total":            "true to also count the matching items",
+	"sample":           "Number of documents to sample",
+}
+
+// listParams are the query parameters every list endpoint takes
+var listParams = []string{"limit", "cursor", "sort", "filter", "total"}
+
+var apiOperations = []apiOperation{
+	{method: http.MethodGet, path: "/v1/openapi.json", summary: "This OpenAPI spec", status: http.StatusOK, response: map[string]any{}},
+	{method: http.MethodGet, path: "/healthz", summary: "Liveness probe", status: http.StatusOK, response: struct {
+		Status string `json:"status"`
+	}{}},
+	{method: http.MethodGet, path: "/readyz", summary: "Readiness probe", status: http.StatusOK, response: struct {
+		Status string `json:"status"`
+	}{}},
+	{
+		method: http.MethodPost, path: "/v1/calculate-macros", summary: "Compute the macros of the volumes of a frame",
+		query:   []string{"candidates", "consensus", "data_version", "debug_query", "include_portions", "meta", "per_gram", "per_serving", "variants", "nutrients", "lang", "energy_unit", "precision"},
+		request: VolumeRequest{}, status: http.StatusOK, response: MacroResponse{}, security: "user",
+	},
+	{
+		method: http.MethodPost, path: "/v1/jobs/calculate-macros", summary: "Queue frames to be computed in the background",
+		query:   []string{"candidates", "consensus", "data_version", "include_portions", "per_gram", "per_serving", "variants", "nutrients", "lang", "energy_unit", "precision"},
+		request: JobRequest{}, status: http.StatusAccepted, response: JobStatus{}, security: "user",
+	},
+	{method: http.MethodGet, path: "/v1/jobs/:id", summary: "Read the progress and results of a job", status: http.StatusOK, response: JobStatus{}, security: "user"},
+	{method: http.MethodPost, path: "/v1/calculate-macros/inline", summary: "Scale client-supplied nutrients", request: InlineRequest{}, status: http.StatusOK, response: InlineResponse{}},
+	{method: http.MethodPost, path: "/v1/graphql", summary: "Query foods, nutrients and macro calculations with GraphQL", request: GraphQLRequest{}, status: http.StatusOK, response: map[string]any{}, security: "user"},
+	{method: http.MethodPost, path: "/v1/day", summary: "Compute the macros of a day of meals", query: []string{"energy_unit", "precision"}, request: DayRequest{}, status: http.StatusOK, response: DayResponse{}},
+	{method: http.MethodPost, path: "/v1/feedback", summary: "Report a measured weight", request: FeedbackRequest{}, status: http.StatusCreated, response: FeedbackResponse{}},
+	{method: http.MethodGet, path: "/v1/frames/:frame_id", summary: "Read a persisted frame", status: http.StatusOK, response: FrameRecord{}, security: "user"},
+	{method: http.MethodPost, path: "/v1/meals", summary: "Log a meal", request: LogMealRequest{}, status: http.StatusCreated, response: Meal{}, security: "user"},
+	{method: http.MethodGet, path: "/v1/meals", summary: "List the meals of a day", query: append([]string{"date", "energy_unit", "precision"}, listParams...), status: http.StatusOK, response: MealsResponse{}, security: "user"},
+	{method: http.MethodGet, path: "/v1/daily-summary", summary: "Total the logged meals per day", query: []string{"from", "to", "energy_unit", "precision"}, status: http.StatusOK, response: DailySummaryResponse{}, security: "user"},
+	{method: http.MethodPost, path: "/v1/recipes", summary: "Save a recipe", request: RecipeRequest{}, status: http.StatusCreated, response: Recipe{}, security: "user"},
+	{method: http.MethodGet, path: "/v1/recipes/:name", summary: "Read a saved recipe", status: http.StatusOK, response: Recipe{}, security: "user"},
+	{method: http.MethodGet, path: "/v1/stats", summary: "Breaker and cache state", status: http.StatusOK, response: StatsResponse{}},
+	{method: http.MethodGet, path: "/v1/foods/search", summary: "Search foods by description", query: []string{"q", "dataset", "limit", "cursor", "sort"}, status: http.StatusOK, response: SearchResponse{}},
+	{method: http.MethodGet, path: "/v1/foods/:fdcId", summary: "Read a food document", query: []string{"dataset"}, status: http.StatusOK, response: struct {
+		Dataset string          `json:"dataset"`
+		Food    json.RawMessage `json:"food"`
+	}{}},
+	{method: http.MethodGet, path: "/admin/foods/check", summary: "Resolve every mapped food", status: http.StatusOK, response: FoodCheckReport{}, security: "admin"},
+	{method: http.MethodGet, path: "/admin/datasets/:dataset/coverage", summary: "Macro nutrient coverage of a dataset", query: []string{"sample"}, status: http.StatusOK, response: CoverageReport{}, security: "admin"},
+	{method: http.MethodGet, path: "/v1/admin/food-mappings", summary: "List the food mappings", query: listParams, status: http.StatusOK, response: struct {
+		Mappings []FoodMapping `json:"mappings"`
+		listPage
+	}{}, security: "admin"},
+	{method: http.MethodGet, path: "/v1/admin/food-mappings/:name", summary: "Read a food mapping", status: http.StatusOK, response: FoodMapping{}, security: "admin"},
+	{method: http.MethodPut, path: "/v1/admin/food-mappings/:name", summary: "Create or replace a food mapping", request: struct {
+		Description string `json:"description"`
+	}{}, status: http.StatusOK, response: FoodMapping{}, security: "admin"},
+	{method: http.MethodDelete, path: "/v1/admin/food-mappings/:name", summary: "Delete a food mapping", status: http.StatusNoContent, security: "admin"},
+	{method: http.MethodGet, path: "/v1/admin/conversions", summary: "List the conversion overrides", query: listParams, status: http.StatusOK, response: struct {
+		Conversions []FoodConversion `json:"conversions"`
+		listPage
+	}{}, security: "admin"},
+	{method: http.MethodGet, path: "/v1/admin/conversions/:name", summary: "Read a conversion override", status: http.StatusOK, response: FoodConversion{}, security: "admin"},
+	{method: http.MethodPut, path: "/v1/admin/conversions/:name", summary: "Create or replace a conversion override", request: FoodConversion{}, status: http.StatusOK, response: FoodConversion{}, security: "admin"},
+	{method: http.MethodDelete, path: "/v1/admin/conversions/:name", summary: "Delete a conversion override", status: http.StatusNoContent, security: "admin"},
+	{method: http.MethodDelete, path: "/v1/admin/recipes/:name", summary: "Delete a saved recipe", status: http.StatusNoContent, security: "admin"},
+	{method: http.MethodGet, path: "/v1/admin/synonyms", summary: "List the synonym dictionary", query: append([]string{"lang"}, listParams...), status: http.StatusOK, response: struct {
+		Synonyms []FoodSynonym `json:"synonyms"`
+		listPage
+	}{}, security: "admin"},
+	{method: http.MethodGet, path: "/v1/admin/synonyms/:lang/:term", summary: "Read a synonym", status: http.StatusOK, response: FoodSynonym{}, security: "admin"},
+	{method: http.MethodPut, path: "/v1/admin/synonyms/:lang/:term", summary: "Create or replace a synonym", request: struct {
+		ObjectName string `json:"object_name"`
+	}{}, status: http.StatusOK, response: FoodSynonym{}, security: "admin"},
+	{method: http.MethodDelete, path: "/v1/admin/synonyms/:lang/:term", summary: "Delete a stored synonym", status: http.StatusNoContent, security: "admin"},
+	{method: http.MethodGet, path: "/v1/admin/aliases/:term", summary: "Read an alias", status: http.StatusOK, response: FoodSynonym{}, security: "admin"},
+	{method: http.MethodPut, path: "/v1/admin/aliases/:term", summary: "Create or replace an alias", request: struct {
+		ObjectName string `json:"object_name"`
+	}{}, status: http.StatusOK, response: FoodSynonym{}, security: "admin"},
+	{method: http.MethodDelete, path: "/v1/admin/aliases/:term", summary: "Delete a stored alias", status: http.StatusNoContent, security: "admin"},
+	{method: http.MethodGet, path: "/v1/admin/unresolved-foods", summary: "Object names that failed to resolve, most frequent first", query: listParams, status: http.StatusOK, response: struct {
+		UnresolvedFoods []UnresolvedFood `json:"unresolved_foods"`
+		listPage
+	}{}, security: "admin"},
+	{method: http.MethodPost, path: "/v1/admin/cache/flush", summary: "Empty the food cache", status: http.StatusOK, response: struct {
+		Flushed int `json:"flushed"`
+	}{}, security: "admin"},
+}
+
+// routeParamPattern matches gin path parameters such as :fdcId
+var routeParamPattern = regexp.MustCompile(`:([A-Za-z_]+)`)
+
+// openAPISpec builds the spec once; the routes and types don't change at
+// runtime
+var openAPISpec = sync.OnceValue(func() map[string]any {
+	schemas := openAPISchemas{components: make(map[string]any)}
+	errorSchema := schemas.of(reflect.TypeOf(errorResponse{}))
+
+	paths := make(map[string]map[string]any)
+	for _, op := range apiOperations {
+		path := routeParamPattern.ReplaceAllString(op.path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = make(map[string]any)
+		}
+
+		var parameters []any
+		for _, m := range routeParamPattern.FindAllStringSubmatch(op.path, -1) {
+			parameters = append(parameters, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		for _, name := range op.query {
+			parameters = append(parameters, map[string]any{"name": name, "in": "query", "description": apiQueryParams[name], "schema": map[string]any{"type": "string"}})
+		}
+
+		success := map[string]any{"description": http.StatusText(op.status)}
+		if op.response != nil {
+			content := map[string]any{"application/json": map[string]any{"schema": schemas.of(reflect.TypeOf(op.response))}}
+			// The macro response has a v2 form negotiated by Accept
+			if _, ok := op.response.(MacroResponse); ok {
+				content[mediaTypeV1] = content["application/json"]
+				content[mediaTypeV2] = map[string]any{"schema": schemas.of(reflect.TypeOf(MacroResponseV2{}))}
+			}
+			success["content"] = content
+		}
+		operation := map[string]any{
+			"summary": op.summary,
+			"responses": map[string]any{
+				strconv.Itoa(op.status): success,
+				"default":               map[string]any{"description": "Error", "content": map[string]any{"application/json": map[string]any{"schema": errorSchema}}},
+			},
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+		if op.request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemas.of(reflect.TypeOf(op.request))}},
+			}
+		}
+		if op.security != "" {
+			operation["security"] = []any{map[string]any{op.security: []string{}}}
+		}
+		paths[path][strings.ToLower(op.method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "ByteMi Macronutrient API",
+			"version": CalcVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]any{
+				"user":  map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"admin": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+})
+
+// openAPISchemas derives JSON schemas from Go types the way encoding/json
+// encodes them. Named structs become components, referenced by name.
+type openAPISchemas struct {
+	components map[string]any
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (s openAPISchemas) of(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := s.components[t.Name()]; !ok {
+			// Registered before it is built, so recursive types terminate
+			s.components[t.Name()] = nil
+			s.components[t.Name()] = s.object(t)
+		}
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+// object builds the schema of a struct from its exported, JSON-encoded
+// fields, including those of embedded structs. Nothing is marked required: the same types serve requests, where
+// most fields are optional, and responses.
+func (s openAPISchemas) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+			for name, property := range s.object(field.Type)["properties"].(map[string]any) {
+				properties[name] = property
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.of(field.Type)
+	}
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+func serveOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, openAPISpec())
+}
+
+// swaggerUIPage loads Swagger UI from a CDN, so the binary doesn't carry its
+// assets
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>ByteMi Macronutrient API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/v1/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+func serveDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
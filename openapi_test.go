@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	router := setupServer(t, "")
+	spec := decode[struct {
+		OpenAPI    string                    `json:"openapi"`
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}](t, doRequest(t, router, http.MethodGet, "/v1/openapi.json", nil), http.StatusOK)
+	if spec.OpenAPI != "3.0.3" {
+		t.Errorf("openapi = %q, want 3.0.3", spec.OpenAPI)
+	}
+
+	// Every route is documented, so the spec can't fall behind the router
+	for _, route := range router.Routes() {
+		path := routeParamPattern.ReplaceAllString(route.Path, "{$1}")
+		if _, ok := spec.Paths[path][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("%s %s is missing from the spec", route.Method, route.Path)
+		}
+	}
+	response, ok := spec.Components.Schemas["MacroResponse"]
+	if !ok {
+		t.Fatal("MacroResponse schema missing")
+	}
+	properties, _ := response["properties"].(map[string]any)
+	if _, ok := properties["calc_version"]; !ok {
+		t.Errorf("MacroResponse properties = %v, want calc_version", properties)
+	}
+}
+
+func TestDocsConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   int
+	}{
+		{"enabled", "server:\n  docs: true\n", http.StatusOK},
+		{"disabled by default", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, tt.config)
+			w := doRequest(t, router, http.MethodGet, "/docs", nil)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && !strings.Contains(w.Body.String(), "/v1/openapi.json") {
+				t.Error("docs page doesn't load the spec")
+			}
+		})
+	}
+}
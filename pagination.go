@@ -0,0 +1,292 @@
+// pagination.go
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errInvalidCursor is returned for a cursor this server didn't hand out
+var errInvalidCursor = errors.New("invalid cursor")
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// listField is a field a list can be sorted by. value returns a string,
+// int, float64 or time.Time; text fields, which are strings, can also be
+// filtered by. column is the field's N1QL expression, for lists read by
+// query.
+type listField[T any] struct {
+	value  func(T) any
+	text   bool
+	column string
+}
+
+// listSpec describes a list endpoint's items: the fields clients can sort
+// and filter by, and a unique key that orders items with equal sort values
+type listSpec[T any] struct {
+	fields      map[string]listField[T]
+	key         func(T) string
+	keyColumn   string
+	defaultSort string
+}
+
+// listQuery is what a client asked a list endpoint for:
+//
+//	?limit=50                   page size, 100 by default
+//	?cursor=...                 next_cursor of the previous page
+//	?sort=-count                field to sort by, descending with a "-"
+//	?filter=error_code:UNKNOWN_FOOD&filter=object_name:chick*
+//	                            case-insensitive matches, "*" for a prefix
+//	?total=true                 also count the matching items
+type listQuery struct {
+	limit   int
+	sort    string
+	desc    bool
+	filters []listFilter
+	total   bool
+	after   *pageCursor
+}
+
+type listFilter struct {
+	field  string
+	value  string
+	prefix bool
+}
+
+// pageCursor is the position after the last item of a page: its sort value
+// and key. Cursors are opaque to clients; a page resumes after the item
+// (keyset pagination) even when items before it were added or removed.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value any    `json:"v"`
+	Key   string `json:"k"`
+}
+
+// listPage is the paging part of a list response
+type listPage struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      *int   `json:"total,omitempty"`
+}
+
+// response puts a page of items under name, with the paging fields
+func (p listPage) response(name string, items any) gin.H {
+	response := gin.H{name: items}
+	if p.NextCursor != "" {
+		response["next_cursor"] = p.NextCursor
+	}
+	if p.Total != nil {
+		response["total"] = *p.Total
+	}
+	return response
+}
+
+// parseListQuery reads the paging, sorting and filtering parameters of a
+// list request, rejecting fields the list doesn't have
+func parseListQuery[T any](c *gin.Context, spec listSpec[T]) (listQuery, error) {
+	q := listQuery{limit: defaultPageSize, total: c.Query("total") == "true"}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.limit = n
+	}
+
+	sort := c.DefaultQuery("sort", spec.defaultSort)
+	q.sort, q.desc = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+	if _, ok := spec.fields[q.sort]; !ok {
+		return q, fmt.Errorf("can't sort by %q: expected one of %s", q.sort, strings.Join(spec.fieldNames(), ", "))
+	}
+
+	for _, raw := range c.QueryArray("filter") {
+		field, value, ok := strings.Cut(raw, ":")
+		if !ok || value == "" {
+			return q, fmt.Errorf("invalid filter %q: expected field:value", raw)
+		}
+		f, ok := spec.fields[field]
+		if !ok {
+			return q, fmt.Errorf("can't filter by %q: expected one of %s", field, strings.Join(spec.fieldNames(), ", "))
+		}
+		if !f.text {
+			return q, fmt.Errorf("can't filter by %q, only sort", field)
+		}
+		filter := listFilter{field: field, value: strings.ToLower(value)}
+		filter.value, filter.prefix = strings.CutSuffix(filter.value, "*")
+		q.filters = append(q.filters, filter)
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := decodePageCursor(raw)
+		if err != nil || cursor.Sort != sort {
+			return q, errInvalidCursor
+		}
+		q.after = &cursor
+	}
+	return q, nil
+}
+
+func (s listSpec[T]) fieldNames() []string {
+	names := make([]string, 0, len(s.fields))
+	for name := range s.fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (q listQuery) sortParam() string {
+	if q.desc {
+		return "-" + q.sort
+	}
+	return q.sort
+}
+
+func encodePageCursor(cursor pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageCursor(raw string) (pageCursor, error) {
+	var cursor pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
+
+// sortValue makes field values comparable and cursor-safe: numbers become
+// float64 and times Unix milliseconds, as N1QL's STR_TO_MILLIS reads them
+func sortValue(value any) any {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case time.Time:
+		return float64(v.UnixMilli())
+	}
+	return value
+}
+
+func compareSortValues(a, b any) int {
+	if x, ok := a.(float64); ok {
+		y, _ := b.(float64)
+		return cmp.Compare(x, y)
+	}
+	x, _ := a.(string)
+	y, _ := b.(string)
+	return cmp.Compare(x, y)
+}
+
+// paginate filters, sorts and pages items held in memory
+func paginate[T any](items []T, q listQuery, spec listSpec[T]) ([]T, listPage) {
+	matching := make([]T, 0, len(items))
+	for _, item := range items {
+		if matchesFilters(item, q.filters, spec) {
+			matching = append(matching, item)
+		}
+	}
+
+	// position orders an item against the sort value and key of another
+	field := spec.fields[q.sort]
+	position := func(item T, value any, key string) int {
+		c := compareSortValues(sortValue(field.value(item)), value)
+		if q.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		return cmp.Compare(spec.key(item), key)
+	}
+	slices.SortFunc(matching, func(a, b T) int {
+		return position(a, sortValue(field.value(b)), spec.key(b))
+	})
+
+	var page listPage
+	if q.total {
+		total := len(matching)
+		page.Total = &total
+	}
+	if q.after != nil {
+		start, _ := slices.BinarySearchFunc(matching, q.after, func(item T, after *pageCursor) int {
+			if position(item, after.Value, after.Key) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		matching = matching[start:]
+	}
+	matching, page.NextCursor = nextPage(matching, q, spec)
+	return matching, page
+}
+
+func matchesFilters[T any](item T, filters []listFilter, spec listSpec[T]) bool {
+	for _, filter := range filters {
+		value, _ := spec.fields[filter.field].value(item).(string)
+		value = strings.ToLower(value)
+		if filter.prefix && !strings.HasPrefix(value, filter.value) || !filter.prefix && value != filter.value {
+			return false
+		}
+	}
+	return true
+}
+
+// n1qlClauses renders a list query for lists read by query: the filter
+// conditions, the cursor condition (each starting with AND), the ORDER BY
+// and LIMIT, and the args they take, numbered from $first; the filters take
+// the first ones. The limit asks for one item more than the page, telling
+// whether another page follows.
+func n1qlClauses[T any](q listQuery, spec listSpec[T], first int) (filters, cursor, orderLimit string, args []any) {
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(first+len(args)-1)
+	}
+
+	var where strings.Builder
+	for _, filter := range q.filters {
+		column := "LOWER(" + spec.fields[filter.field].column + ")"
+		if filter.prefix {
+			escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.value)
+			fmt.Fprintf(&where, " AND %s LIKE %s", column, arg(escaped+"%"))
+		} else {
+			fmt.Fprintf(&where, " AND %s = %s", column, arg(filter.value))
+		}
+	}
+
+	column := spec.fields[q.sort].column
+	direction, beyond := "", ">"
+	if q.desc {
+		direction, beyond = " DESC", "<"
+	}
+	var after string
+	if q.after != nil {
+		value, key := arg(q.after.Value), arg(q.after.Key)
+		after = fmt.Sprintf(" AND (%s %s %s OR (%s = %s AND %s > %s))", column, beyond, value, column, value, spec.keyColumn, key)
+	}
+	order := fmt.Sprintf(" ORDER BY %s%s, %s LIMIT %s", column, direction, spec.keyColumn, arg(q.limit+1))
+	return where.String(), after, order, args
+}
+
+// nextPage trims the extra item a query read past the page and makes the
+// cursor that continues after the page
+func nextPage[T any](items []T, q listQuery, spec listSpec[T]) ([]T, string) {
+	if len(items) <= q.limit {
+		return items, ""
+	}
+	last := items[q.limit-1]
+	cursor := pageCursor{Sort: q.sortParam(), Value: sortValue(spec.fields[q.sort].value(last)), Key: spec.key(last)}
+	return items[:q.limit], encodePageCursor(cursor)
+}
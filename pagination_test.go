@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listQueryOf parses the list parameters of a query string
+func listQueryOf[T any](t *testing.T, query string, spec listSpec[T]) (listQuery, error) {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+	return parseListQuery(c, spec)
+}
+
+func TestPaginate(t *testing.T) {
+	now := time.Now()
+	foods := []UnresolvedFood{
+		{ObjectName: "dragonfruit", Count: 5, ErrorCode: errorCodeUnknownFood, LastSeen: now},
+		{ObjectName: "cucumber", Count: 3, ErrorCode: errorCodeNoPortionData, LastSeen: now.Add(-time.Hour)},
+		{ObjectName: "durian", Count: 5, ErrorCode: errorCodeUnknownFood, LastSeen: now.Add(-2 * time.Hour)},
+		{ObjectName: "date", Count: 1, ErrorCode: errorCodeUnknownFood, LastSeen: now.Add(-3 * time.Hour)},
+	}
+	tests := []struct {
+		name      string
+		query     string
+		wantPages [][]string
+		wantTotal int // 0 for none
+	}{
+		// Equal counts are ordered by the key
+		{"default sort", "", [][]string{{"dragonfruit", "durian", "cucumber", "date"}}, 0},
+		{"pages", "limit=3", [][]string{{"dragonfruit", "durian", "cucumber"}, {"date"}}, 0},
+		{"ascending", "sort=count&limit=2", [][]string{{"date", "cucumber"}, {"dragonfruit", "durian"}}, 0},
+		{"times", "sort=-last_seen&limit=3", [][]string{{"dragonfruit", "cucumber", "durian"}, {"date"}}, 0},
+		{"exact filter", "filter=error_code:no_portion_data", [][]string{{"cucumber"}}, 0},
+		{"prefix filter", "filter=object_name:d*&filter=error_code:UNKNOWN_FOOD&limit=2", [][]string{{"dragonfruit", "durian"}, {"date"}}, 0},
+		{"total", "filter=object_name:d*&limit=2&total=true", [][]string{{"dragonfruit", "durian"}, {"date"}}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages [][]string
+			cursor := ""
+			for {
+				query := tt.query
+				if cursor != "" {
+					query += "&cursor=" + cursor
+				}
+				q, err := listQueryOf(t, query, unresolvedFoodFields)
+				if err != nil {
+					t.Fatalf("parseListQuery(%q) error = %v", query, err)
+				}
+				items, page := paginate(foods, q, unresolvedFoodFields)
+				if total := page.Total; (total != nil) != (tt.wantTotal != 0) || total != nil && *total != tt.wantTotal {
+					t.Errorf("total = %v, want %d", total, tt.wantTotal)
+				}
+				names := []string{}
+				for _, item := range items {
+					names = append(names, item.ObjectName)
+				}
+				pages = append(pages, names)
+				if page.NextCursor == "" || len(pages) > len(tt.wantPages) {
+					break
+				}
+				cursor = page.NextCursor
+			}
+			if !reflect.DeepEqual(pages, tt.wantPages) {
+				t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestParseListQueryRejects(t *testing.T) {
+	for _, query := range []string{
+		"limit=0",
+		"limit=1001",
+		"sort=name",
+		"filter=count:5",
+		"filter=object_name",
+		"filter=color:red",
+		"cursor=%25%25%25",
+		"sort=count&cursor=" + encodePageCursor(pageCursor{Sort: "-count", Value: 5.0, Key: "durian"}),
+	} {
+		if _, err := listQueryOf(t, query, unresolvedFoodFields); err == nil {
+			t.Errorf("parseListQuery(%q) succeeded, want an error", query)
+		}
+	}
+}
+
+func TestN1QLClauses(t *testing.T) {
+	cursor := encodePageCursor(pageCursor{Sort: "-count", Value: 5.0, Key: "dur'ian"})
+	q, err := listQueryOf(t, "filter=object_name:d%25_*&filter=error_code:unknown_food&limit=2&cursor="+cursor, unresolvedFoodFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters, after, orderLimit, args := n1qlClauses(q, unresolvedFoodFields, 1)
+
+	// Values are only ever passed as args, so the statement text doesn't
+	// change between requests of the same shape
+	wantFilters := " AND LOWER(u.object_name) LIKE $1 AND LOWER(u.error_code) = $2"
+	wantAfter := " AND (u.`count` < $3 OR (u.`count` = $3 AND u.object_name > $4))"
+	wantOrder := " ORDER BY u.`count` DESC, u.object_name LIMIT $5"
+	if filters != wantFilters || after != wantAfter || orderLimit != wantOrder {
+		t.Errorf("clauses = %q %q %q, want %q %q %q", filters, after, orderLimit, wantFilters, wantAfter, wantOrder)
+	}
+	wantArgs := []any{`d\%\_%`, "unknown_food", 5.0, "dur'ian", 3}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %v, want %v", args, wantArgs)
+	}
+	for _, clause := range []string{filters, after, orderLimit} {
+		if strings.Contains(clause, "dur'ian") || strings.Contains(clause, "unknown_food") {
+			t.Errorf("clause %q holds a value", clause)
+		}
+	}
+}
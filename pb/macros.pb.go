@@ -0,0 +1,1138 @@
+// Protobuf form of POST /v1/calculate-macros, for clients that prefer it
+// over JSON. Fields mirror the JSON request and response; see the OpenAPI
+// spec at /v1/openapi.json for their meaning.
+//
+// Regenerate macros.pb.go and macros_grpc.pb.go after changing this file:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative pb/macros.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: pb/macros.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Volume struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	ObjectName         string                 `protobuf:"bytes,1,opt,name=object_name,json=objectName,proto3" json:"object_name,omitempty"`
+	VolumeCups         float64                `protobuf:"fixed64,2,opt,name=volume_cups,json=volumeCups,proto3" json:"volume_cups,omitempty"`
+	UncertaintyCups    float64                `protobuf:"fixed64,3,opt,name=uncertainty_cups,json=uncertaintyCups,proto3" json:"uncertainty_cups,omitempty"`
+	DensityGramsPerCup *float64               `protobuf:"fixed64,4,opt,name=density_grams_per_cup,json=densityGramsPerCup,proto3,oneof" json:"density_grams_per_cup,omitempty"`
+	PortionDescription string                 `protobuf:"bytes,5,opt,name=portion_description,json=portionDescription,proto3" json:"portion_description,omitempty"`
+	EggSize            string                 `protobuf:"bytes,6,opt,name=egg_size,json=eggSize,proto3" json:"egg_size,omitempty"`
+	Unit               string                 `protobuf:"bytes,7,opt,name=unit,proto3" json:"unit,omitempty"`
+	// state is raw, cooked, boiled or fried
+	State         string `protobuf:"bytes,8,opt,name=state,proto3" json:"state,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Volume) Reset() {
+	*x = Volume{}
+	mi := &file_pb_macros_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Volume) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Volume) ProtoMessage() {}
+
+func (x *Volume) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_macros_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Volume.ProtoReflect.Descriptor instead.
+func (*Volume) Descriptor() ([]byte, []int) {
+	return file_pb_macros_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Volume) GetObjectName() string {
+	if x != nil {
+		return x.ObjectName
+	}
+	return ""
+}
+
+func (x *Volume) GetVolumeCups() float64 {
+	if x != nil {
+		return x.VolumeCups
+	}
+	return 0
+}
+
+func (x *Volume) GetUncertaintyCups() float64 {
+	if x != nil {
+		return x.UncertaintyCups
+	}
+	return 0
+}
+
+func (x *Volume) GetDensityGramsPerCup() float64 {
+	if x != nil && x.DensityGramsPerCup != nil {
+		return *x.DensityGramsPerCup
+	}
+	return 0
+}
+
+func (x *Volume) GetPortionDescription() string {
+	if x != nil {
+		return x.PortionDescription
+	}
+	return ""
+}
+
+func (x *Volume) GetEggSize() string {
+	if x != nil {
+		return x.EggSize
+	}
+	return ""
+}
+
+func (x *Volume) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *Volume) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+type CalculateMacrosRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	FrameId string                 `protobuf:"bytes,1,opt,name=frame_id,json=frameId,proto3" json:"frame_id,omitempty"`
+	Volumes []*Volume              `protobuf:"bytes,2,rep,name=volumes,proto3" json:"volumes,omitempty"`
+	Scale   *float64               `protobuf:"fixed64,3,opt,name=scale,proto3,oneof" json:"scale,omitempty"`
+	// The options below are query parameters in the REST API
+	DataVersion string `protobuf:"bytes,4,opt,name=data_version,json=dataVersion,proto3" json:"data_version,omitempty"`
+	PerGram     bool   `protobuf:"varint,5,opt,name=per_gram,json=perGram,proto3" json:"per_gram,omitempty"`
+	// nutrients is "all" or a comma-separated list of nutrient numbers or
+	// names
+	Nutrients string `protobuf:"bytes,6,opt,name=nutrients,proto3" json:"nutrients,omitempty"`
+	// energy_unit is kcal (default) or kJ
+	EnergyUnit string `protobuf:"bytes,7,opt,name=energy_unit,json=energyUnit,proto3" json:"energy_unit,omitempty"`
+	Precision  *int32 `protobuf:"varint,8,opt,name=precision,proto3,oneof" json:"precision,omitempty"`
+	// languages lists the preferred description languages, most preferred
+	// first
+	Languages     []string `protobuf:"bytes,9,rep,name=languages,proto3" json:"languages,omitempty"`
+	PerServing    bool     `protobuf:"varint,10,opt,name=per_serving,json=perServing,proto3" json:"per_serving,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CalculateMacrosRequest) Reset() {
+	*x = CalculateMacrosRequest{}
+	mi := &file_pb_macros_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CalculateMacrosRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CalculateMacrosRequest) ProtoMessage() {}
+
+func (x *CalculateMacrosRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_macros_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CalculateMacrosRequest.ProtoReflect.Descriptor instead.
+func (*CalculateMacrosRequest) Descriptor() ([]byte, []int) {
+	return file_pb_macros_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CalculateMacrosRequest) GetFrameId() string {
+	if x != nil {
+		return x.FrameId
+	}
+	return ""
+}
+
+func (x *CalculateMacrosRequest) GetVolumes() []*Volume {
+	if x != nil {
+		return x.Volumes
+	}
+	return nil
+}
+
+func (x *CalculateMacrosRequest) GetScale() float64 {
+	if x != nil && x.Scale != nil {
+		return *x.Scale
+	}
+	return 0
+}
+
+func (x *CalculateMacrosRequest) GetDataVersion() string {
+	if x != nil {
+		return x.DataVersion
+	}
+	return ""
+}
+
+func (x *CalculateMacrosRequest) GetPerGram() bool {
+	if x != nil {
+		return x.PerGram
+	}
+	return false
+}
+
+func (x *CalculateMacrosRequest) GetNutrients() string {
+	if x != nil {
+		return x.Nutrients
+	}
+	return ""
+}
+
+func (x *CalculateMacrosRequest) GetEnergyUnit() string {
+	if x != nil {
+		return x.EnergyUnit
+	}
+	return ""
+}
+
+func (x *CalculateMacrosRequest) GetPrecision() int32 {
+	if x != nil && x.Precision != nil {
+		return *x.Precision
+	}
+	return 0
+}
+
+func (x *CalculateMacrosRequest) GetLanguages() []string {
+	if x != nil {
+		return x.Languages
+	}
+	return nil
+}
+
+func (x *CalculateMacrosRequest) GetPerServing() bool {
+	if x != nil {
+		return x.PerServing
+	}
+	return false
+}
+
+type Macros struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Calories      float64                `protobuf:"fixed64,1,opt,name=calories,proto3" json:"calories,omitempty"`
+	Carbs         float64                `protobuf:"fixed64,2,opt,name=carbs,proto3" json:"carbs,omitempty"`
+	Fat           float64                `protobuf:"fixed64,3,opt,name=fat,proto3" json:"fat,omitempty"`
+	Protein       float64                `protobuf:"fixed64,4,opt,name=protein,proto3" json:"protein,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Macros) Reset() {
+	*x = Macros{}
+	mi := &file_pb_macros_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Macros) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Macros) ProtoMessage() {}
+
+func (x *Macros) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_macros_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Macros.ProtoReflect.Descriptor instead.
+func (*Macros) Descriptor() ([]byte, []int) {
+	return file_pb_macros_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Macros) GetCalories() float64 {
+	if x != nil {
+		return x.Calories
+	}
+	return 0
+}
+
+func (x *Macros) GetCarbs() float64 {
+	if x != nil {
+		return x.Carbs
+	}
+	return 0
+}
+
+func (x *Macros) GetFat() float64 {
+	if x != nil {
+		return x.Fat
+	}
+	return 0
+}
+
+func (x *Macros) GetProtein() float64 {
+	if x != nil {
+		return x.Protein
+	}
+	return 0
+}
+
+type ServingMacros struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Portion       string                 `protobuf:"bytes,1,opt,name=portion,proto3" json:"portion,omitempty"`
+	Grams         float64                `protobuf:"fixed64,2,opt,name=grams,proto3" json:"grams,omitempty"`
+	Macros        *Macros                `protobuf:"bytes,3,opt,name=macros,proto3" json:"macros,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServingMacros) Reset() {
+	*x = ServingMacros{}
+	mi := &file_pb_macros_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServingMacros) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServingMacros) ProtoMessage() {}
+
+func (x *ServingMacros) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_macros_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServingMacros.ProtoReflect.Descriptor instead.
+func (*ServingMacros) Descriptor() ([]byte, []int) {
+	return file_pb_macros_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ServingMacros) GetPortion() string {
+	if x != nil {
+		return x.Portion
+	}
+	return ""
+}
+
+func (x *ServingMacros) GetGrams() float64 {
+	if x != nil {
+		return x.Grams
+	}
+	return 0
+}
+
+func (x *ServingMacros) GetMacros() *Macros {
+	if x != nil {
+		return x.Macros
+	}
+	return nil
+}
+
+type MacroRange struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Min           *Macros                `protobuf:"bytes,1,opt,name=min,proto3" json:"min,omitempty"`
+	Max           *Macros                `protobuf:"bytes,2,opt,name=max,proto3" json:"max,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MacroRange) Reset() {
+	*x = MacroRange{}
+	mi := &file_pb_macros_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MacroRange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MacroRange) ProtoMessage() {}
+
+func (x *MacroRange) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_macros_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MacroRange.ProtoReflect.Descriptor instead.
+func (*MacroRange) Descriptor() ([]byte, []int) {
+	return file_pb_macros_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *MacroRange) GetMin() *Macros {
+	if x != nil {
+		return x.Min
+	}
+	return nil
+}
+
+func (x *MacroRange) GetMax() *Macros {
+	if x != nil {
+		return x.Max
+	}
+	return nil
+}
+
+type NutrientAmount struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Amount        float64                `protobuf:"fixed64,2,opt,name=amount,proto3" json:"amount,omitempty"`
+	Unit          string                 `protobuf:"bytes,3,opt,name=unit,proto3" json:"unit,omitempty"`
+	Grams         *float64               `protobuf:"fixed64,4,opt,name=grams,proto3,oneof" json:"grams,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NutrientAmount) Reset() {
+	*x = NutrientAmount{}
+	mi := &file_pb_macros_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NutrientAmount) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NutrientAmount) ProtoMessage() {}
+
+func (x *NutrientAmount) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_macros_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NutrientAmount.ProtoReflect.Descriptor instead.
+func (*NutrientAmount) Descriptor() ([]byte, []int) {
+	return file_pb_macros_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *NutrientAmount) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *NutrientAmount) GetAmount() float64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *NutrientAmount) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *NutrientAmount) GetGrams() float64 {
+	if x != nil && x.Grams != nil {
+		return *x.Grams
+	}
+	return 0
+}
+
+type MacroData struct {
+	state              protoimpl.MessageState     `protogen:"open.v1"`
+	Found              bool                       `protobuf:"varint,1,opt,name=found,proto3" json:"found,omitempty"`
+	Dataset            string                     `protobuf:"bytes,2,opt,name=dataset,proto3" json:"dataset,omitempty"`
+	Description        string                     `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`
+	Category           string                     `protobuf:"bytes,4,opt,name=category,proto3" json:"category,omitempty"`
+	DataVersion        string                     `protobuf:"bytes,5,opt,name=data_version,json=dataVersion,proto3" json:"data_version,omitempty"`
+	Macros             *Macros                    `protobuf:"bytes,6,opt,name=macros,proto3" json:"macros,omitempty"`
+	PerGram            *Macros                    `protobuf:"bytes,7,opt,name=per_gram,json=perGram,proto3" json:"per_gram,omitempty"`
+	RequestedFood      string                     `protobuf:"bytes,8,opt,name=requested_food,json=requestedFood,proto3" json:"requested_food,omitempty"`
+	RequestedVolume    float64                    `protobuf:"fixed64,9,opt,name=requested_volume,json=requestedVolume,proto3" json:"requested_volume,omitempty"`
+	RequestedUnit      string                     `protobuf:"bytes,10,opt,name=requested_unit,json=requestedUnit,proto3" json:"requested_unit,omitempty"`
+	CalculatedWeight   float64                    `protobuf:"fixed64,11,opt,name=calculated_weight,json=calculatedWeight,proto3" json:"calculated_weight,omitempty"`
+	DensityOverride    bool                       `protobuf:"varint,12,opt,name=density_override,json=densityOverride,proto3" json:"density_override,omitempty"`
+	CaloriesComputed   bool                       `protobuf:"varint,13,opt,name=calories_computed,json=caloriesComputed,proto3" json:"calories_computed,omitempty"`
+	PortionUsed        string                     `protobuf:"bytes,14,opt,name=portion_used,json=portionUsed,proto3" json:"portion_used,omitempty"`
+	MatchQuality       string                     `protobuf:"bytes,15,opt,name=match_quality,json=matchQuality,proto3" json:"match_quality,omitempty"`
+	DensityGPerMl      float64                    `protobuf:"fixed64,16,opt,name=density_g_per_ml,json=densityGPerMl,proto3" json:"density_g_per_ml,omitempty"`
+	ErrorCode          string                     `protobuf:"bytes,17,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	Confidence         float64                    `protobuf:"fixed64,18,opt,name=confidence,proto3" json:"confidence,omitempty"`
+	UncertaintyClamped bool                       `protobuf:"varint,19,opt,name=uncertainty_clamped,json=uncertaintyClamped,proto3" json:"uncertainty_clamped,omitempty"`
+	PercentError       float64                    `protobuf:"fixed64,20,opt,name=percent_error,json=percentError,proto3" json:"percent_error,omitempty"`
+	Range              *MacroRange                `protobuf:"bytes,21,opt,name=range,proto3" json:"range,omitempty"`
+	Nutrients          map[string]*NutrientAmount `protobuf:"bytes,22,rep,name=nutrients,proto3" json:"nutrients,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	DriStatus          map[string]string          `protobuf:"bytes,23,rep,name=dri_status,json=driStatus,proto3" json:"dri_status,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	NutrientStatus     map[string]string          `protobuf:"bytes,24,rep,name=nutrient_status,json=nutrientStatus,proto3" json:"nutrient_status,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Suggestions        []string                   `protobuf:"bytes,25,rep,name=suggestions,proto3" json:"suggestions,omitempty"`
+	ErrorMessage       string                     `protobuf:"bytes,26,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
+	Per100G            *Macros                    `protobuf:"bytes,27,opt,name=per100g,proto3" json:"per100g,omitempty"`
+	PerServing         *ServingMacros             `protobuf:"bytes,28,opt,name=per_serving,json=perServing,proto3" json:"per_serving,omitempty"`
+	State              string                     `protobuf:"bytes,29,opt,name=state,proto3" json:"state,omitempty"`
+	YieldFactor        float64                    `protobuf:"fixed64,30,opt,name=yield_factor,json=yieldFactor,proto3" json:"yield_factor,omitempty"`
+	ResolvedName       string                     `protobuf:"bytes,31,opt,name=resolved_name,json=resolvedName,proto3" json:"resolved_name,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *MacroData) Reset() {
+	*x = MacroData{}
+	mi := &file_pb_macros_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MacroData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MacroData) ProtoMessage() {}
+
+func (x *MacroData) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_macros_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MacroData.ProtoReflect.Descriptor instead.
+func (*MacroData) Descriptor() ([]byte, []int) {
+	return file_pb_macros_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *MacroData) GetFound() bool {
+	if x != nil {
+		return x.Found
+	}
+	return false
+}
+
+func (x *MacroData) GetDataset() string {
+	if x != nil {
+		return x.Dataset
+	}
+	return ""
+}
+
+func (x *MacroData) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *MacroData) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+func (x *MacroData) GetDataVersion() string {
+	if x != nil {
+		return x.DataVersion
+	}
+	return ""
+}
+
+func (x *MacroData) GetMacros() *Macros {
+	if x != nil {
+		return x.Macros
+	}
+	return nil
+}
+
+func (x *MacroData) GetPerGram() *Macros {
+	if x != nil {
+		return x.PerGram
+	}
+	return nil
+}
+
+func (x *MacroData) GetRequestedFood() string {
+	if x != nil {
+		return x.RequestedFood
+	}
+	return ""
+}
+
+func (x *MacroData) GetRequestedVolume() float64 {
+	if x != nil {
+		return x.RequestedVolume
+	}
+	return 0
+}
+
+func (x *MacroData) GetRequestedUnit() string {
+	if x != nil {
+		return x.RequestedUnit
+	}
+	return ""
+}
+
+func (x *MacroData) GetCalculatedWeight() float64 {
+	if x != nil {
+		return x.CalculatedWeight
+	}
+	return 0
+}
+
+func (x *MacroData) GetDensityOverride() bool {
+	if x != nil {
+		return x.DensityOverride
+	}
+	return false
+}
+
+func (x *MacroData) GetCaloriesComputed() bool {
+	if x != nil {
+		return x.CaloriesComputed
+	}
+	return false
+}
+
+func (x *MacroData) GetPortionUsed() string {
+	if x != nil {
+		return x.PortionUsed
+	}
+	return ""
+}
+
+func (x *MacroData) GetMatchQuality() string {
+	if x != nil {
+		return x.MatchQuality
+	}
+	return ""
+}
+
+func (x *MacroData) GetDensityGPerMl() float64 {
+	if x != nil {
+		return x.DensityGPerMl
+	}
+	return 0
+}
+
+func (x *MacroData) GetErrorCode() string {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ""
+}
+
+func (x *MacroData) GetConfidence() float64 {
+	if x != nil {
+		return x.Confidence
+	}
+	return 0
+}
+
+func (x *MacroData) GetUncertaintyClamped() bool {
+	if x != nil {
+		return x.UncertaintyClamped
+	}
+	return false
+}
+
+func (x *MacroData) GetPercentError() float64 {
+	if x != nil {
+		return x.PercentError
+	}
+	return 0
+}
+
+func (x *MacroData) GetRange() *MacroRange {
+	if x != nil {
+		return x.Range
+	}
+	return nil
+}
+
+func (x *MacroData) GetNutrients() map[string]*NutrientAmount {
+	if x != nil {
+		return x.Nutrients
+	}
+	return nil
+}
+
+func (x *MacroData) GetDriStatus() map[string]string {
+	if x != nil {
+		return x.DriStatus
+	}
+	return nil
+}
+
+func (x *MacroData) GetNutrientStatus() map[string]string {
+	if x != nil {
+		return x.NutrientStatus
+	}
+	return nil
+}
+
+func (x *MacroData) GetSuggestions() []string {
+	if x != nil {
+		return x.Suggestions
+	}
+	return nil
+}
+
+func (x *MacroData) GetErrorMessage() string {
+	if x != nil {
+		return x.ErrorMessage
+	}
+	return ""
+}
+
+func (x *MacroData) GetPer100G() *Macros {
+	if x != nil {
+		return x.Per100G
+	}
+	return nil
+}
+
+func (x *MacroData) GetPerServing() *ServingMacros {
+	if x != nil {
+		return x.PerServing
+	}
+	return nil
+}
+
+func (x *MacroData) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *MacroData) GetYieldFactor() float64 {
+	if x != nil {
+		return x.YieldFactor
+	}
+	return 0
+}
+
+func (x *MacroData) GetResolvedName() string {
+	if x != nil {
+		return x.ResolvedName
+	}
+	return ""
+}
+
+type FrameSummary struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FrameId       string                 `protobuf:"bytes,1,opt,name=frame_id,json=frameId,proto3" json:"frame_id,omitempty"`
+	Totals        *Macros                `protobuf:"bytes,2,opt,name=totals,proto3" json:"totals,omitempty"`
+	Unresolved    int32                  `protobuf:"varint,3,opt,name=unresolved,proto3" json:"unresolved,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FrameSummary) Reset() {
+	*x = FrameSummary{}
+	mi := &file_pb_macros_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FrameSummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FrameSummary) ProtoMessage() {}
+
+func (x *FrameSummary) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_macros_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FrameSummary.ProtoReflect.Descriptor instead.
+func (*FrameSummary) Descriptor() ([]byte, []int) {
+	return file_pb_macros_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *FrameSummary) GetFrameId() string {
+	if x != nil {
+		return x.FrameId
+	}
+	return ""
+}
+
+func (x *FrameSummary) GetTotals() *Macros {
+	if x != nil {
+		return x.Totals
+	}
+	return nil
+}
+
+func (x *FrameSummary) GetUnresolved() int32 {
+	if x != nil {
+		return x.Unresolved
+	}
+	return 0
+}
+
+type MacroResponse struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	CalcVersion string                 `protobuf:"bytes,1,opt,name=calc_version,json=calcVersion,proto3" json:"calc_version,omitempty"`
+	Data        []*MacroData           `protobuf:"bytes,2,rep,name=data,proto3" json:"data,omitempty"`
+	Summary     *FrameSummary          `protobuf:"bytes,3,opt,name=summary,proto3" json:"summary,omitempty"`
+	EnergyUnit  string                 `protobuf:"bytes,4,opt,name=energy_unit,json=energyUnit,proto3" json:"energy_unit,omitempty"`
+	Scale       float64                `protobuf:"fixed64,5,opt,name=scale,proto3" json:"scale,omitempty"`
+	ResultHash  string                 `protobuf:"bytes,6,opt,name=result_hash,json=resultHash,proto3" json:"result_hash,omitempty"`
+	// status is ok, partial or degraded
+	Status        string `protobuf:"bytes,7,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MacroResponse) Reset() {
+	*x = MacroResponse{}
+	mi := &file_pb_macros_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MacroResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MacroResponse) ProtoMessage() {}
+
+func (x *MacroResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_macros_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MacroResponse.ProtoReflect.Descriptor instead.
+func (*MacroResponse) Descriptor() ([]byte, []int) {
+	return file_pb_macros_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *MacroResponse) GetCalcVersion() string {
+	if x != nil {
+		return x.CalcVersion
+	}
+	return ""
+}
+
+func (x *MacroResponse) GetData() []*MacroData {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *MacroResponse) GetSummary() *FrameSummary {
+	if x != nil {
+		return x.Summary
+	}
+	return nil
+}
+
+func (x *MacroResponse) GetEnergyUnit() string {
+	if x != nil {
+		return x.EnergyUnit
+	}
+	return ""
+}
+
+func (x *MacroResponse) GetScale() float64 {
+	if x != nil {
+		return x.Scale
+	}
+	return 0
+}
+
+func (x *MacroResponse) GetResultHash() string {
+	if x != nil {
+		return x.ResultHash
+	}
+	return ""
+}
+
+func (x *MacroResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+var File_pb_macros_proto protoreflect.FileDescriptor
+
+const file_pb_macros_proto_rawDesc = "" +
+	"\n" +
+	"\x0fpb/macros.proto\x12\tbytemi.v1\"\xbd\x02\n" +
+	"\x06Volume\x12\x1f\n" +
+	"\vobject_name\x18\x01 \x01(\tR\n" +
+	"objectName\x12\x1f\n" +
+	"\vvolume_cups\x18\x02 \x01(\x01R\n" +
+	"volumeCups\x12)\n" +
+	"\x10uncertainty_cups\x18\x03 \x01(\x01R\x0funcertaintyCups\x126\n" +
+	"\x15density_grams_per_cup\x18\x04 \x01(\x01H\x00R\x12densityGramsPerCup\x88\x01\x01\x12/\n" +
+	"\x13portion_description\x18\x05 \x01(\tR\x12portionDescription\x12\x19\n" +
+	"\begg_size\x18\x06 \x01(\tR\aeggSize\x12\x12\n" +
+	"\x04unit\x18\a \x01(\tR\x04unit\x12\x14\n" +
+	"\x05state\x18\b \x01(\tR\x05stateB\x18\n" +
+	"\x16_density_grams_per_cup\"\xf2\x02\n" +
+	"\x16CalculateMacrosRequest\x12\x19\n" +
+	"\bframe_id\x18\x01 \x01(\tR\aframeId\x12+\n" +
+	"\avolumes\x18\x02 \x03(\v2\x11.bytemi.v1.VolumeR\avolumes\x12\x19\n" +
+	"\x05scale\x18\x03 \x01(\x01H\x00R\x05scale\x88\x01\x01\x12!\n" +
+	"\fdata_version\x18\x04 \x01(\tR\vdataVersion\x12\x19\n" +
+	"\bper_gram\x18\x05 \x01(\bR\aperGram\x12\x1c\n" +
+	"\tnutrients\x18\x06 \x01(\tR\tnutrients\x12\x1f\n" +
+	"\venergy_unit\x18\a \x01(\tR\n" +
+	"energyUnit\x12!\n" +
+	"\tprecision\x18\b \x01(\x05H\x01R\tprecision\x88\x01\x01\x12\x1c\n" +
+	"\tlanguages\x18\t \x03(\tR\tlanguages\x12\x1f\n" +
+	"\vper_serving\x18\n" +
+	" \x01(\bR\n" +
+	"perServingB\b\n" +
+	"\x06_scaleB\f\n" +
+	"\n" +
+	"_precision\"f\n" +
+	"\x06Macros\x12\x1a\n" +
+	"\bcalories\x18\x01 \x01(\x01R\bcalories\x12\x14\n" +
+	"\x05carbs\x18\x02 \x01(\x01R\x05carbs\x12\x10\n" +
+	"\x03fat\x18\x03 \x01(\x01R\x03fat\x12\x18\n" +
+	"\aprotein\x18\x04 \x01(\x01R\aprotein\"j\n" +
+	"\rServingMacros\x12\x18\n" +
+	"\aportion\x18\x01 \x01(\tR\aportion\x12\x14\n" +
+	"\x05grams\x18\x02 \x01(\x01R\x05grams\x12)\n" +
+	"\x06macros\x18\x03 \x01(\v2\x11.bytemi.v1.MacrosR\x06macros\"V\n" +
+	"\n" +
+	"MacroRange\x12#\n" +
+	"\x03min\x18\x01 \x01(\v2\x11.bytemi.v1.MacrosR\x03min\x12#\n" +
+	"\x03max\x18\x02 \x01(\v2\x11.bytemi.v1.MacrosR\x03max\"u\n" +
+	"\x0eNutrientAmount\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06amount\x18\x02 \x01(\x01R\x06amount\x12\x12\n" +
+	"\x04unit\x18\x03 \x01(\tR\x04unit\x12\x19\n" +
+	"\x05grams\x18\x04 \x01(\x01H\x00R\x05grams\x88\x01\x01B\b\n" +
+	"\x06_grams\"\xe7\v\n" +
+	"\tMacroData\x12\x14\n" +
+	"\x05found\x18\x01 \x01(\bR\x05found\x12\x18\n" +
+	"\adataset\x18\x02 \x01(\tR\adataset\x12 \n" +
+	"\vdescription\x18\x03 \x01(\tR\vdescription\x12\x1a\n" +
+	"\bcategory\x18\x04 \x01(\tR\bcategory\x12!\n" +
+	"\fdata_version\x18\x05 \x01(\tR\vdataVersion\x12)\n" +
+	"\x06macros\x18\x06 \x01(\v2\x11.bytemi.v1.MacrosR\x06macros\x12,\n" +
+	"\bper_gram\x18\a \x01(\v2\x11.bytemi.v1.MacrosR\aperGram\x12%\n" +
+	"\x0erequested_food\x18\b \x01(\tR\rrequestedFood\x12)\n" +
+	"\x10requested_volume\x18\t \x01(\x01R\x0frequestedVolume\x12%\n" +
+	"\x0erequested_unit\x18\n" +
+	" \x01(\tR\rrequestedUnit\x12+\n" +
+	"\x11calculated_weight\x18\v \x01(\x01R\x10calculatedWeight\x12)\n" +
+	"\x10density_override\x18\f \x01(\bR\x0fdensityOverride\x12+\n" +
+	"\x11calories_computed\x18\r \x01(\bR\x10caloriesComputed\x12!\n" +
+	"\fportion_used\x18\x0e \x01(\tR\vportionUsed\x12#\n" +
+	"\rmatch_quality\x18\x0f \x01(\tR\fmatchQuality\x12'\n" +
+	"\x10density_g_per_ml\x18\x10 \x01(\x01R\rdensityGPerMl\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\x11 \x01(\tR\terrorCode\x12\x1e\n" +
+	"\n" +
+	"confidence\x18\x12 \x01(\x01R\n" +
+	"confidence\x12/\n" +
+	"\x13uncertainty_clamped\x18\x13 \x01(\bR\x12uncertaintyClamped\x12#\n" +
+	"\rpercent_error\x18\x14 \x01(\x01R\fpercentError\x12+\n" +
+	"\x05range\x18\x15 \x01(\v2\x15.bytemi.v1.MacroRangeR\x05range\x12A\n" +
+	"\tnutrients\x18\x16 \x03(\v2#.bytemi.v1.MacroData.NutrientsEntryR\tnutrients\x12B\n" +
+	"\n" +
+	"dri_status\x18\x17 \x03(\v2#.bytemi.v1.MacroData.DriStatusEntryR\tdriStatus\x12Q\n" +
+	"\x0fnutrient_status\x18\x18 \x03(\v2(.bytemi.v1.MacroData.NutrientStatusEntryR\x0enutrientStatus\x12 \n" +
+	"\vsuggestions\x18\x19 \x03(\tR\vsuggestions\x12#\n" +
+	"\rerror_message\x18\x1a \x01(\tR\ferrorMessage\x12+\n" +
+	"\aper100g\x18\x1b \x01(\v2\x11.bytemi.v1.MacrosR\aper100g\x129\n" +
+	"\vper_serving\x18\x1c \x01(\v2\x18.bytemi.v1.ServingMacrosR\n" +
+	"perServing\x12\x14\n" +
+	"\x05state\x18\x1d \x01(\tR\x05state\x12!\n" +
+	"\fyield_factor\x18\x1e \x01(\x01R\vyieldFactor\x12#\n" +
+	"\rresolved_name\x18\x1f \x01(\tR\fresolvedName\x1aW\n" +
+	"\x0eNutrientsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12/\n" +
+	"\x05value\x18\x02 \x01(\v2\x19.bytemi.v1.NutrientAmountR\x05value:\x028\x01\x1a<\n" +
+	"\x0eDriStatusEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1aA\n" +
+	"\x13NutrientStatusEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"t\n" +
+	"\fFrameSummary\x12\x19\n" +
+	"\bframe_id\x18\x01 \x01(\tR\aframeId\x12)\n" +
+	"\x06totals\x18\x02 \x01(\v2\x11.bytemi.v1.MacrosR\x06totals\x12\x1e\n" +
+	"\n" +
+	"unresolved\x18\x03 \x01(\x05R\n" +
+	"unresolved\"\xff\x01\n" +
+	"\rMacroResponse\x12!\n" +
+	"\fcalc_version\x18\x01 \x01(\tR\vcalcVersion\x12(\n" +
+	"\x04data\x18\x02 \x03(\v2\x14.bytemi.v1.MacroDataR\x04data\x121\n" +
+	"\asummary\x18\x03 \x01(\v2\x17.bytemi.v1.FrameSummaryR\asummary\x12\x1f\n" +
+	"\venergy_unit\x18\x04 \x01(\tR\n" +
+	"energyUnit\x12\x14\n" +
+	"\x05scale\x18\x05 \x01(\x01R\x05scale\x12\x1f\n" +
+	"\vresult_hash\x18\x06 \x01(\tR\n" +
+	"resultHash\x12\x16\n" +
+	"\x06status\x18\a \x01(\tR\x06status2^\n" +
+	"\fMacroService\x12N\n" +
+	"\x0fCalculateMacros\x12!.bytemi.v1.CalculateMacrosRequest\x1a\x18.bytemi.v1.MacroResponseB$Z\"github.com/prlorence/bytemi-api/pbb\x06proto3"
+
+var (
+	file_pb_macros_proto_rawDescOnce sync.Once
+	file_pb_macros_proto_rawDescData []byte
+)
+
+func file_pb_macros_proto_rawDescGZIP() []byte {
+	file_pb_macros_proto_rawDescOnce.Do(func() {
+		file_pb_macros_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pb_macros_proto_rawDesc), len(file_pb_macros_proto_rawDesc)))
+	})
+	return file_pb_macros_proto_rawDescData
+}
+
+var file_pb_macros_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_pb_macros_proto_goTypes = []any{
+	(*Volume)(nil),                 // 0: bytemi.v1.Volume
+	(*CalculateMacrosRequest)(nil), // 1: bytemi.v1.CalculateMacrosRequest
+	(*Macros)(nil),                 // 2: bytemi.v1.Macros
+	(*ServingMacros)(nil),          // 3: bytemi.v1.ServingMacros
+	(*MacroRange)(nil),             // 4: bytemi.v1.MacroRange
+	(*NutrientAmount)(nil),         // 5: bytemi.v1.NutrientAmount
+	(*MacroData)(nil),              // 6: bytemi.v1.MacroData
+	(*FrameSummary)(nil),           // 7: bytemi.v1.FrameSummary
+	(*MacroResponse)(nil),          // 8: bytemi.v1.MacroResponse
+	nil,                            // 9: bytemi.v1.MacroData.NutrientsEntry
+	nil,                            // 10: bytemi.v1.MacroData.DriStatusEntry
+	nil,                            // 11: bytemi.v1.MacroData.NutrientStatusEntry
+}
+var file_pb_macros_proto_depIdxs = []int32{
+	0,  // 0: bytemi.v1.CalculateMacrosRequest.volumes:type_name -> bytemi.v1.Volume
+	2,  // 1: bytemi.v1.ServingMacros.macros:type_name -> bytemi.v1.Macros
+	2,  // 2: bytemi.v1.MacroRange.min:type_name -> bytemi.v1.Macros
+	2,  // 3: bytemi.v1.MacroRange.max:type_name -> bytemi.v1.Macros
+	2,  // 4: bytemi.v1.MacroData.macros:type_name -> bytemi.v1.Macros
+	2,  // 5: bytemi.v1.MacroData.per_gram:type_name -> bytemi.v1.Macros
+	4,  // 6: bytemi.v1.MacroData.range:type_name -> bytemi.v1.MacroRange
+	9,  // 7: bytemi.v1.MacroData.nutrients:type_name -> bytemi.v1.MacroData.NutrientsEntry
+	10, // 8: bytemi.v1.MacroData.dri_status:type_name -> bytemi.v1.MacroData.DriStatusEntry
+	11, // 9: bytemi.v1.MacroData.nutrient_status:type_name -> bytemi.v1.MacroData.NutrientStatusEntry
+	2,  // 10: bytemi.v1.MacroData.per100g:type_name -> bytemi.v1.Macros
+	3,  // 11: bytemi.v1.MacroData.per_serving:type_name -> bytemi.v1.ServingMacros
+	2,  // 12: bytemi.v1.FrameSummary.totals:type_name -> bytemi.v1.Macros
+	6,  // 13: bytemi.v1.MacroResponse.data:type_name -> bytemi.v1.MacroData
+	7,  // 14: bytemi.v1.MacroResponse.summary:type_name -> bytemi.v1.FrameSummary
+	5,  // 15: bytemi.v1.MacroData.NutrientsEntry.value:type_name -> bytemi.v1.NutrientAmount
+	1,  // 16: bytemi.v1.MacroService.CalculateMacros:input_type -> bytemi.v1.CalculateMacrosRequest
+	8,  // 17: bytemi.v1.MacroService.CalculateMacros:output_type -> bytemi.v1.MacroResponse
+	17, // [17:18] is the sub-list for method output_type
+	16, // [16:17] is the sub-list for method input_type
+	16, // [16:16] is the sub-list for extension type_name
+	16, // [16:16] is the sub-list for extension extendee
+	0,  // [0:16] is the sub-list for field type_name
+}
+
+func init() { file_pb_macros_proto_init() }
+func file_pb_macros_proto_init() {
+	if File_pb_macros_proto != nil {
+		return
+	}
+	file_pb_macros_proto_msgTypes[0].OneofWrappers = []any{}
+	file_pb_macros_proto_msgTypes[1].OneofWrappers = []any{}
+	file_pb_macros_proto_msgTypes[5].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pb_macros_proto_rawDesc), len(file_pb_macros_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pb_macros_proto_goTypes,
+		DependencyIndexes: file_pb_macros_proto_depIdxs,
+		MessageInfos:      file_pb_macros_proto_msgTypes,
+	}.Build()
+	File_pb_macros_proto = out.File
+	file_pb_macros_proto_goTypes = nil
+	file_pb_macros_proto_depIdxs = nil
+}
@@ -0,0 +1,130 @@
+// Protobuf form of POST /v1/calculate-macros, for clients that prefer it
+// over JSON. Fields mirror the JSON request and response; see the OpenAPI
+// spec at /v1/openapi.json for their meaning.
+//
+// Regenerate macros.pb.go and macros_grpc.pb.go after changing this file:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative pb/macros.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: pb/macros.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	MacroService_CalculateMacros_FullMethodName = "/bytemi.v1.MacroService/CalculateMacros"
+)
+
+// MacroServiceClient is the client API for MacroService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MacroServiceClient interface {
+	CalculateMacros(ctx context.Context, in *CalculateMacrosRequest, opts ...grpc.CallOption) (*MacroResponse, error)
+}
+
+type macroServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMacroServiceClient(cc grpc.ClientConnInterface) MacroServiceClient {
+	return &macroServiceClient{cc}
+}
+
+func (c *macroServiceClient) CalculateMacros(ctx context.Context, in *CalculateMacrosRequest, opts ...grpc.CallOption) (*MacroResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MacroResponse)
+	err := c.cc.Invoke(ctx, MacroService_CalculateMacros_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MacroServiceServer is the server API for MacroService service.
+// All implementations must embed UnimplementedMacroServiceServer
+// for forward compatibility.
+type MacroServiceServer interface {
+	CalculateMacros(context.Context, *CalculateMacrosRequest) (*MacroResponse, error)
+	mustEmbedUnimplementedMacroServiceServer()
+}
+
+// UnimplementedMacroServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMacroServiceServer struct{}
+
+func (UnimplementedMacroServiceServer) CalculateMacros(context.Context, *CalculateMacrosRequest) (*MacroResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CalculateMacros not implemented")
+}
+func (UnimplementedMacroServiceServer) mustEmbedUnimplementedMacroServiceServer() {}
+func (UnimplementedMacroServiceServer) testEmbeddedByValue()                      {}
+
+// UnsafeMacroServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MacroServiceServer will
+// result in compilation errors.
+type UnsafeMacroServiceServer interface {
+	mustEmbedUnimplementedMacroServiceServer()
+}
+
+func RegisterMacroServiceServer(s grpc.ServiceRegistrar, srv MacroServiceServer) {
+	// If the following call pancis, it indicates UnimplementedMacroServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&MacroService_ServiceDesc, srv)
+}
+
+func _MacroService_CalculateMacros_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CalculateMacrosRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MacroServiceServer).CalculateMacros(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MacroService_CalculateMacros_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MacroServiceServer).CalculateMacros(ctx, req.(*CalculateMacrosRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MacroService_ServiceDesc is the grpc.ServiceDesc for MacroService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MacroService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "bytemi.v1.MacroService",
+	HandlerType: (*MacroServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CalculateMacros",
+			Handler:    _MacroService_CalculateMacros_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "pb/macros.proto",
+}
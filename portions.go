@@ -0,0 +1,375 @@
+// portions.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Match qualities, telling clients how precisely the weight of the
+// requested volume was derived
+const (
+	matchExactCup        = "exact-cup"        // a plain "1 cup" portion
+	matchScaledCup       = "scaled-cup"       // another cup count, e.g. "2 cups"
+	matchModifierCup     = "modifier-cup"     // a qualified cup, e.g. "1 cup, packed"
+	matchVolumeUnit      = "volume-unit"      // another volume unit, e.g. "1 tbsp"
+	matchFallbackDensity = "fallback-density" // derived from a non-cup portion
+	matchCategoryDensity = "category-density" // the density table's entry for the food's category
+	matchDefaultDensity  = "default-density"  // the density table's default
+	matchNamedPortion    = "named-portion"    // the portion the client asked for
+	matchConversion      = "conversion"       // the food's conversion override
+	matchOverride        = "override"         // the client's own density
+	matchLearnedDensity  = "learned-density"  // aggregated from measured weights
+	matchWeight          = "weight"           // given in grams, no density needed
+	matchRecipe          = "recipe"           // a share of a saved recipe
+)
+
+// portionMatch is the weight of one unit of the requested amount and where
+// it came from
+type portionMatch struct {
+	grams   float64
+	portion string
+	quality string
+	// density is the g/mL a density table match was converted with
+	density float64
+}
+
+// parsedPortion is a portion description broken into its quantity, unit
+// and modifier, e.g. "1 1/2 cups, packed" into 1.5, "cups" and "packed".
+// Volume units are normalized to the units of Volume.Unit; other units,
+// such as "slice" or "small", are lowercased and singular.
+type parsedPortion struct {
+	quantity float64
+	unit     string
+	modifier string
+}
+
+// portionQuantityPattern splits a leading quantity off a portion
+// description
+var portionQuantityPattern = regexp.MustCompile(`^\s*(\d+\s+\d+/\d+|\d+/\d+|\d+(?:\.\d+)?)\s*(.*)$`)
+
+// portionUnits maps the spellings of volume units found in portion
+// descriptions to the units of Volume.Unit
+var portionUnits = map[string]string{
+	"cup":         unitCups,
+	"cups":        unitCups,
+	"c":           unitCups,
+	"tablespoon":  unitTbsp,
+	"tablespoons": unitTbsp,
+	"tbsp":        unitTbsp,
+	"tbs":         unitTbsp,
+	"teaspoon":    unitTsp,
+	"teaspoons":   unitTsp,
+	"tsp":         unitTsp,
+	"fl oz":       unitFlOz,
+	"fluid ounce": unitFlOz,
+	"ml":          unitML,
+	"milliliter":  unitML,
+	"milliliters": unitML,
+}
+
+// parsePortionDescription parses a portion description. A missing quantity
+// is read as 1; ok is false when there is no unit.
+func parsePortionDescription(description string) (parsedPortion, bool) {
+	p := parsedPortion{quantity: 1}
+	rest := strings.ToLower(strings.TrimSpace(description))
+	if m := portionQuantityPattern.FindStringSubmatch(rest); m != nil {
+		quantity, ok := parseQuantity(m[1])
+		if !ok || quantity <= 0 {
+			return parsedPortion{}, false
+		}
+		p.quantity, rest = quantity, m[2]
+	}
+
+	// The unit ends at the first comma or parenthesis, e.g. "cup, sliced"
+	// or "small (6-1/2\" dia)"
+	if i := strings.IndexAny(rest, ",("); i >= 0 {
+		p.modifier = strings.Trim(rest[i:], " ,")
+		rest = rest[:i]
+	}
+	words := strings.Fields(strings.ReplaceAll(rest, ".", ""))
+	if len(words) == 0 {
+		return parsedPortion{}, false
+	}
+
+	// A volume unit may be followed by more of the modifier, e.g.
+	// "cup chopped"
+	for n := min(2, len(words)); n > 0; n-- {
+		if unit, ok := portionUnits[strings.Join(words[:n], " ")]; ok {
+			p.unit = unit
+			if extra := strings.Join(words[n:], " "); extra != "" {
+				p.modifier = strings.TrimSpace(extra + " " + p.modifier)
+			}
+			return p, true
+		}
+	}
+	for i, word := range words {
+		words[i] = singular(word)
+	}
+	p.unit = strings.Join(words, " ")
+	return p, true
+}
+
+// singular strips a plural s, so "2 slices" matches "1 slice"
+func singular(word string) string {
+	if len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// parsePortion parses a food portion. Portions without a description, as
+// in SR Legacy, are read from their amount, measure unit and modifier.
+func parsePortion(portion Portion) (parsedPortion, bool) {
+	if portion.PortionDescription != "" {
+		return parsePortionDescription(portion.PortionDescription)
+	}
+	if portion.Amount <= 0 {
+		return parsedPortion{}, false
+	}
+	description := portion.Modifier
+	if unit := portion.MeasureUnit.Name; unit != "" && unit != "undetermined" {
+		description = unit + ", " + description
+	}
+	p, ok := parsePortionDescription(description)
+	p.quantity = portion.Amount
+	return p, ok
+}
+
+// cups returns how many cups the portion measures; false when its unit
+// isn't a volume
+func (p parsedPortion) cups() (float64, bool) {
+	factor, ok := cupsPerUnit[p.unit]
+	return p.quantity * factor, ok
+}
+
+// parseQuantity parses whole, decimal, fractional ("1/2") and mixed
+// ("1 1/2") quantities
+func parseQuantity(s string) (float64, bool) {
+	total := 0.0
+	for _, field := range strings.Fields(s) {
+		if num, den, isFraction := strings.Cut(field, "/"); isFraction {
+			n, err1 := strconv.ParseFloat(num, 64)
+			d, err2 := strconv.ParseFloat(den, 64)
+			if err1 != nil || err2 != nil || d == 0 {
+				return 0, false
+			}
+			total += n / d
+			continue
+		}
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return 0, false
+		}
+		total += v
+	}
+	return total, true
+}
+
+// hasWeight reports whether a portion can be used for matching. Some
+// records carry portions without a gram weight, which would otherwise turn
+// into zero macros.
+func hasWeight(ctx context.Context, portion Portion) bool {
+	if portion.GramWeight > 0 {
+		return true
+	}
+	slog.WarnContext(ctx, "data warning: skipping portion without gram weight", "portion", portion.PortionDescription, "portion_id", portion.ID, "gram_weight", portion.GramWeight)
+	return false
+}
+
+// portionName is how a portion is reported in PortionUsed
+func portionName(portion Portion) string {
+	if portion.PortionDescription != "" || portion.Amount <= 0 {
+		return portion.PortionDescription
+	}
+	return strings.TrimSpace(fmt.Sprintf("%g %s %s", portion.Amount, portion.MeasureUnit.Name, portion.Modifier))
+}
+
+// findPortionByDescription weighs the portion a client named explicitly.
+// A portion with the same description wins; otherwise the description is
+// parsed and matched by unit and modifier, scaling to the requested
+// quantity, so "2 slices" is weighed by a "1 slice" portion. Volumes may
+// also be converted from any volume portion, e.g. "1 tbsp" from "1 cup".
+func findPortionByDescription(ctx context.Context, description string, portions []Portion) (portionMatch, bool) {
+	description = strings.TrimSpace(description)
+	if description == "" {
+		return portionMatch{}, false
+	}
+	for _, portion := range portions {
+		if strings.EqualFold(strings.TrimSpace(portion.PortionDescription), description) && hasWeight(ctx, portion) {
+			return portionMatch{grams: portion.GramWeight, portion: portion.PortionDescription, quality: matchNamedPortion}, true
+		}
+	}
+
+	requested, ok := parsePortionDescription(description)
+	if !ok {
+		return portionMatch{}, false
+	}
+	// An unmodified portion of the unit stands in for a modified request
+	// ("1 slice, thin"), but only after one with the same modifier
+	var fallback portionMatch
+	for _, portion := range portions {
+		p, ok := parsePortion(portion)
+		if !ok || p.unit != requested.unit || !hasWeight(ctx, portion) {
+			continue
+		}
+		match := portionMatch{grams: portion.GramWeight / p.quantity * requested.quantity, portion: portionName(portion), quality: matchNamedPortion}
+		if p.modifier == requested.modifier {
+			return match, true
+		}
+		if p.modifier == "" && fallback.grams == 0 {
+			fallback = match
+		}
+	}
+	if fallback.grams > 0 {
+		return fallback, true
+	}
+
+	if cups, ok := requested.cups(); ok {
+		if match := bestVolumePortion(ctx, portions); match.grams > 0 {
+			match.grams *= cups
+			return match, true
+		}
+	}
+	return portionMatch{}, false
+}
+
+// findCupGrams derives the weight of one cup of the food from its portions
+// (see bestVolumePortion), or else from its conversion override. The match
+// has zero grams when neither gives a weight.
+func findCupGrams(ctx context.Context, volume Volume, foodData *FoodData) portionMatch {
+	if slog.Default().Enabled(ctx, slog.LevelDebug) {
+		for _, p := range foodData.FoodPortions {
+			slog.DebugContext(ctx, "available portion", "food", volume.ObjectName, "portion", p.PortionDescription, "grams", p.GramWeight)
+		}
+	}
+
+	if best := bestVolumePortion(ctx, foodData.FoodPortions); best.grams > 0 {
+		slog.DebugContext(ctx, "found cup measurement", "portion", best.portion, "grams_per_cup", best.grams, "quality", best.quality)
+		return best
+	}
+
+	slog.InfoContext(ctx, "no cup measurement found", "food", volume.ObjectName)
+	if match := conversionCupGrams(ctx, volume.ObjectName, foodData.FoodPortions); match.grams > 0 {
+		slog.DebugContext(ctx, "using conversion override", "food", volume.ObjectName, "conversion", match.portion, "grams_per_cup", match.grams)
+		return match
+	}
+	// Without an override, eggs are converted from their per-egg weight
+	if normalizeFoodName(volume.ObjectName) == "egg" {
+		return eggCupGrams(ctx, volume.EggSize, foodData.FoodPortions)
+	}
+	return portionMatch{}
+}
+
+// foodServing weighs one serving of a food: the portion the item named, or
+// else the food's first listed portion. It is nil when neither has a
+// weight.
+func foodServing(ctx context.Context, volume Volume, foodData *FoodData, result computation) *ServingMacros {
+	match, ok := findPortionByDescription(ctx, volume.PortionDescription, foodData.FoodPortions)
+	if !ok {
+		match, ok = firstPortion(ctx, foodData.FoodPortions)
+	}
+	perGram, weighed := result.macros.perGram(result.grams)
+	if !ok || !weighed {
+		return nil
+	}
+	return &ServingMacros{Portion: match.portion, Grams: match.grams, Macros: perGram.times(match.grams)}
+}
+
+// firstPortion returns the weighed portion with the lowest sequence number,
+// which FDC lists first as the food's typical serving
+func firstPortion(ctx context.Context, portions []Portion) (portionMatch, bool) {
+	var first *Portion
+	for i, portion := range portions {
+		if hasWeight(ctx, portion) && (first == nil || portion.SequenceNumber < first.SequenceNumber) {
+			first = &portions[i]
+		}
+	}
+	if first == nil {
+		return portionMatch{}, false
+	}
+	return portionMatch{grams: first.GramWeight, portion: portionName(*first), quality: matchNamedPortion}, true
+}
+
+// bestVolumePortion returns the weight of one cup as given by the most
+// precise volume portion. A plain "1 cup" portion is preferred over other
+// cup counts, then over other volume units such as "1 tbsp", and last
+// over volumes with a modifier such as "1 cup, sliced".
+func bestVolumePortion(ctx context.Context, portions []Portion) portionMatch {
+	var best portionMatch
+	rank := map[string]int{"": 0, matchModifierCup: 1, matchVolumeUnit: 2, matchScaledCup: 3, matchExactCup: 4}
+	for _, portion := range portions {
+		p, ok := parsePortion(portion)
+		if !ok {
+			continue
+		}
+		cups, ok := p.cups()
+		if !ok || !hasWeight(ctx, portion) {
+			continue
+		}
+
+		quality := matchScaledCup
+		switch {
+		case p.modifier != "":
+			quality = matchModifierCup
+		case p.unit != unitCups:
+			quality = matchVolumeUnit
+		case p.quantity == 1:
+			quality = matchExactCup
+		}
+		if rank[quality] > rank[best.quality] {
+			best = portionMatch{grams: portion.GramWeight / cups, portion: portionName(portion), quality: quality}
+		}
+	}
+	return best
+}
+
+// eggSize describes one egg size: the portion naming it, its weight relative
+// to a large egg and how many of them fill a cup at the default of
+// defaultEggsPerCup large eggs per cup
+type eggSize struct {
+	portion   string
+	relWeight float64
+	perCup    float64
+}
+
+const (
+	defaultEggSize    = "large"
+	defaultEggsPerCup = 4.5
+)
+
+// eggSizes follows the USDA egg size weights (38, 44, 50 and 56g)
+var eggSizes = map[string]eggSize{
+	"small":  {portion: "1 small", relWeight: 0.76, perCup: 5.5},
+	"medium": {portion: "1 medium", relWeight: 0.88, perCup: 5},
+	"large":  {portion: "1 large", relWeight: 1, perCup: 4.5},
+	"xl":     {portion: "1 extra large", relWeight: 1.12, perCup: 4},
+}
+
+// eggCupGrams approximates the weight of a cup of eggs of the given size.
+// A portion naming the size is preferred; otherwise the generic "1 egg"
+// portion, taken to be a large egg, is scaled to the requested size.
+func eggCupGrams(ctx context.Context, size string, portions []Portion) portionMatch {
+	if size == "" {
+		size = defaultEggSize
+	}
+	egg := eggSizes[size]
+	// eggs.per_cup rescales every size's count by the same factor
+	perCup := egg.perCup * cfg.Eggs.PerCup / defaultEggsPerCup
+
+	for _, portion := range portions {
+		if strings.EqualFold(portion.PortionDescription, egg.portion) && hasWeight(ctx, portion) {
+			return portionMatch{grams: portion.GramWeight * perCup, portion: portion.PortionDescription, quality: matchFallbackDensity}
+		}
+	}
+	for _, portion := range portions {
+		if portion.PortionDescription == "1 egg" && hasWeight(ctx, portion) {
+			return portionMatch{grams: portion.GramWeight * egg.relWeight * perCup, portion: portion.PortionDescription, quality: matchFallbackDensity}
+		}
+	}
+	return portionMatch{}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// portions builds FDC portions from description, grams pairs
+func portions(pairs ...any) []Portion {
+	var result []Portion
+	for i := 0; i+1 < len(pairs); i += 2 {
+		result = append(result, Portion{
+			PortionDescription: pairs[i].(string),
+			GramWeight:         float64(pairs[i+1].(int)),
+		})
+	}
+	return result
+}
+
+func TestEggCupGrams(t *testing.T) {
+	testConfig(t, "")
+	generic := portions("1 egg", 50)
+	named := portions("1 egg", 50, "1 medium", 44, "1 extra large", 56)
+	tests := []struct {
+		name        string
+		size        string
+		portions    []Portion
+		wantGrams   float64
+		wantPortion string
+	}{
+		{"large by default", "", generic, 50 * 4.5, "1 egg"},
+		{"small scales the generic egg", "small", generic, 50 * 0.76 * 5.5, "1 egg"},
+		{"medium scales the generic egg", "medium", generic, 50 * 0.88 * 5, "1 egg"},
+		{"xl scales the generic egg", "xl", generic, 50 * 1.12 * 4, "1 egg"},
+		{"portion naming the size wins", "medium", named, 44 * 5, "1 medium"},
+		{"extra large portion", "xl", named, 56 * 4, "1 extra large"},
+		{"no egg portion", "large", portions("1 cup", 243), 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := eggCupGrams(context.Background(), tt.size, tt.portions)
+			if math.Abs(match.grams-tt.wantGrams) > 1e-9 || match.portion != tt.wantPortion {
+				t.Errorf("eggCupGrams() = %v g from %q, want %v g from %q", match.grams, match.portion, tt.wantGrams, tt.wantPortion)
+			}
+		})
+	}
+}
+
+func TestEggSizesAreProportional(t *testing.T) {
+	testConfig(t, "")
+	generic := portions("1 egg", 50)
+	var previous float64
+	for _, size := range []string{"small", "medium", "large", "xl"} {
+		match := eggCupGrams(context.Background(), size, generic)
+		if match.grams <= 0 {
+			t.Fatalf("%s: no weight", size)
+		}
+		// Bigger eggs weigh more each, but fewer of them fill a cup
+		perEgg := match.grams / eggSizes[size].perCup
+		if perEgg <= previous {
+			t.Errorf("%s egg weighs %v g, not more than the smaller size's %v g", size, perEgg, previous)
+		}
+		previous = perEgg
+	}
+}
+
+func TestPortionDescriptionHint(t *testing.T) {
+	egg := &FoodData{FoodPortions: portions("1 cup, chopped", 136, "1 medium", 44, "1 large", 50)}
+	rice := &FoodData{FoodPortions: portions("1 cup", 158, "1 spoonful", 40)}
+	tests := []struct {
+		name        string
+		food        *FoodData
+		volume      Volume
+		wantGrams   float64
+		wantPortion string
+	}{
+		{"exact portion", egg, Volume{ObjectName: "egg", VolumeCups: 2, PortionDescription: "1 medium"}, 88, "1 medium"},
+		{"case-insensitive", egg, Volume{ObjectName: "egg", VolumeCups: 1, PortionDescription: "1 MEDIUM"}, 44, "1 medium"},
+		{"other portion of the food", rice, Volume{ObjectName: "rice", VolumeCups: 3, PortionDescription: "1 spoonful"}, 120, "1 spoonful"},
+		{"miss falls back to the cup", egg, Volume{ObjectName: "egg", VolumeCups: 1, PortionDescription: "1 jumbo"}, 136, "1 cup, chopped"},
+		{"no hint uses the cup", rice, Volume{ObjectName: "rice", VolumeCups: 1}, 158, "1 cup"},
+	}
+	testConfig(t, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := computeMacros(context.Background(), tt.volume, tt.food)
+			if !ok {
+				t.Fatal("computeMacros() found no portion")
+			}
+			if result.grams != tt.wantGrams || result.match.portion != tt.wantPortion {
+				t.Errorf("calculated weight = %v from %q, want %v from %q", result.grams, result.match.portion, tt.wantGrams, tt.wantPortion)
+			}
+		})
+	}
+}
+
+func TestParsePortionDescription(t *testing.T) {
+	tests := []struct {
+		description string
+		want        parsedPortion
+		wantOK      bool
+	}{
+		{"1 cup", parsedPortion{1, unitCups, ""}, true},
+		{"1/2 cup", parsedPortion{0.5, unitCups, ""}, true},
+		{"1 1/2 cups, packed", parsedPortion{1.5, unitCups, "packed"}, true},
+		{"1 cup chopped", parsedPortion{1, unitCups, "chopped"}, true},
+		{"2 Tbsp.", parsedPortion{2, unitTbsp, ""}, true},
+		{"0.5 fl oz", parsedPortion{0.5, unitFlOz, ""}, true},
+		{"2 slices, thin", parsedPortion{2, "slice", "thin"}, true},
+		{"1 small (6-1/2\" dia)", parsedPortion{1, "small", "(6-1/2\" dia)"}, true},
+		{"glass", parsedPortion{1, "glass", ""}, true},
+		{"0 cups", parsedPortion{}, false},
+		{"1/0 cup", parsedPortion{}, false},
+		{"2", parsedPortion{}, false},
+		{"", parsedPortion{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			got, ok := parsePortionDescription(tt.description)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("parsePortionDescription(%q) = %+v, %v; want %+v, %v", tt.description, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestFindPortionByDescription(t *testing.T) {
+	bread := portions("1 slice", 28, "1 slice, thick", 40, "1 cup, cubes", 30)
+	milk := portions("1 cup", 244)
+	// SR Legacy portions have no description
+	legacy := []Portion{{Amount: 2, GramWeight: 50}}
+	legacy[0].MeasureUnit.Name = "slice"
+	tests := []struct {
+		name        string
+		portions    []Portion
+		description string
+		wantGrams   float64
+		wantPortion string
+	}{
+		{"scaled to the requested quantity", bread, "3 slices", 84, "1 slice"},
+		{"same modifier", bread, "1 slice, thick", 40, "1 slice, thick"},
+		{"unmodified stands in for another modifier", bread, "2 slices, thin", 56, "1 slice"},
+		{"volume converted from a cup", milk, "2 tbsp", 244.0 / 8, "1 cup"},
+		{"described by amount and unit", legacy, "1 slice", 25, "2 slice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, ok := findPortionByDescription(context.Background(), tt.description, tt.portions)
+			if !ok || math.Abs(match.grams-tt.wantGrams) > 1e-9 || match.portion != tt.wantPortion {
+				t.Errorf("findPortionByDescription(%q) = %v g from %q, %v; want %v g from %q", tt.description, match.grams, match.portion, ok, tt.wantGrams, tt.wantPortion)
+			}
+		})
+	}
+	if match, ok := findPortionByDescription(context.Background(), "1 wedge", bread); ok {
+		t.Errorf("findPortionByDescription(1 wedge) = %+v, want no match", match)
+	}
+}
+
+func TestFindCupGramsQuality(t *testing.T) {
+	testConfig(t, "")
+	tests := []struct {
+		name        string
+		objectName  string
+		portions    []Portion
+		wantGrams   float64
+		wantQuality string
+		wantPortion string
+	}{
+		{"exact cup", "rice", portions("1 cup", 158, "1 spoonful", 40), 158, matchExactCup, "1 cup"},
+		{"scaled cup", "rice", portions("2 cups", 316), 158, matchScaledCup, "2 cups"},
+		{"fractional cup", "rice", portions("1/2 cup", 79), 158, matchScaledCup, "1/2 cup"},
+		{"mixed cup count", "rice", portions("1 1/2 cups", 237), 158, matchScaledCup, "1 1/2 cups"},
+		{"modifier cup", "banana", portions("1 cup, sliced", 150, "1 medium", 118), 150, matchModifierCup, "1 cup, sliced"},
+		{"plain cup beats a modified one", "rice", portions("1 cup, packed", 200, "1 cup", 158), 158, matchExactCup, "1 cup"},
+		{"scaled cup beats a modified one", "rice", portions("1 cup, packed", 200, "2 cups", 316), 158, matchScaledCup, "2 cups"},
+		{"egg from its per-egg weight", "egg", portions("1 large", 50), 225, matchFallbackDensity, "1 large"},
+		{"no cup portion", "cucumber", portions("1 slice", 7), 0, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			food := &FoodData{FoodPortions: tt.portions}
+			got := findCupGrams(context.Background(), Volume{ObjectName: tt.objectName, VolumeCups: 1}, food)
+			if math.Abs(got.grams-tt.wantGrams) > 1e-9 || got.quality != tt.wantQuality || got.portion != tt.wantPortion {
+				t.Errorf("findCupGrams() = %v g, %q from %q; want %v g, %q from %q", got.grams, got.quality, got.portion, tt.wantGrams, tt.wantQuality, tt.wantPortion)
+			}
+		})
+	}
+}
+
+func TestZeroGramPortionsAreSkipped(t *testing.T) {
+	zeroFirst := portions("1 cup", 0, "1 cup", 158)
+	zeroFirst[1].PortionDescription = "1 cup, cooked"
+	tests := []struct {
+		name        string
+		portions    []Portion
+		wantGrams   float64
+		wantPortion string
+	}{
+		{"valid second cup is used", zeroFirst, 158, "1 cup, cooked"},
+		{"negative weight is skipped", portions("1 cup", -5, "2 cups", 316), 158, "2 cups"},
+		{"no valid portion", portions("1 cup", 0), 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			food := &FoodData{FoodPortions: tt.portions}
+			got := findCupGrams(context.Background(), Volume{ObjectName: "rice", VolumeCups: 1}, food)
+			if got.grams != tt.wantGrams || got.portion != tt.wantPortion {
+				t.Errorf("findCupGrams() = %v g from %q, want %v g from %q", got.grams, got.portion, tt.wantGrams, tt.wantPortion)
+			}
+			if !strings.Contains(logs.String(), "data warning") {
+				t.Errorf("no data warning logged: %s", logs)
+			}
+		})
+	}
+}
+
+func TestZeroGramNamedPortionFallsBack(t *testing.T) {
+	testConfig(t, "")
+	food := &FoodData{FoodPortions: portions("1 medium", 0, "1 large", 50)}
+	match := eggCupGrams(context.Background(), "medium", food.FoodPortions)
+	if match.grams != 0 {
+		t.Errorf("eggCupGrams() = %v g from %q, want no match for a weightless portion", match.grams, match.portion)
+	}
+	if _, ok := findPortionByDescription(context.Background(), "1 medium", food.FoodPortions); ok {
+		t.Error("findPortionByDescription() matched a weightless portion")
+	}
+}
+
+func TestEggsPerCupFallback(t *testing.T) {
+	// Only per-egg portions, so the cup weight is derived from eggs per cup
+	perEgg := &FoodData{FoodPortions: portions("1 large", 50)}
+	tests := []struct {
+		name      string
+		config    string
+		wantGrams float64
+		wantErr   bool
+	}{
+		{"default is 4.5 large eggs", "", 225, false},
+		{"configured factor", "eggs:\n  per_cup: 5\n", 250, false},
+		{"fractional factor", "eggs:\n  per_cup: 4.25\n", 212.5, false},
+		{"negative factor", "eggs:\n  per_cup: -1\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			if err := yaml.Unmarshal([]byte(tt.config), &config); err != nil {
+				t.Fatal(err)
+			}
+			err := config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			testConfig(t, tt.config)
+			got := findCupGrams(context.Background(), Volume{ObjectName: "egg", VolumeCups: 1}, perEgg)
+			if math.Abs(got.grams-tt.wantGrams) > 1e-9 || got.quality != matchFallbackDensity {
+				t.Errorf("findCupGrams() = %v g (%s), want %v g", got.grams, got.quality, tt.wantGrams)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestStorageConfig(t *testing.T) {
+	const postgres = "storage: postgres\npostgres:\n  dsn: postgres://foods@localhost/foods\n"
+	tests := []struct {
+		name        string
+		config      string
+		wantStorage string
+		wantErr     bool
+	}{
+		{"couchbase by default", "", storageCouchbase, false},
+		{"postgres", postgres, storagePostgres, false},
+		{"sqlite", "storage: sqlite\n", storageSQLite, false},
+		{"sqlite snapshot that doesn't exist", "storage: sqlite\nsqlite:\n  snapshot: missing.json\n", "", true},
+		{"meals need couchbase with sqlite too", "storage: sqlite\nmeals:\n  collection: meals\n", "", true},
+		{"postgres without a dsn", "storage: postgres\n", "", true},
+		{"negative connection limit", postgres + "  max_open_conns: -1\n", "", true},
+		{"feedback needs couchbase", postgres + "feedback:\n  collection: feedback\n", "", true},
+		{"frames need couchbase", postgres + "frames:\n  collection: frames\n", "", true},
+		{"meals need couchbase", postgres + "meals:\n  collection: meals\n", "", true},
+		{"stored mappings need couchbase", postgres + "food_mappings:\n  collection: mappings\n", "", true},
+		{"unknown storage", "storage: mysql\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			if err := yaml.Unmarshal([]byte(tt.config), &config); err != nil {
+				t.Fatal(err)
+			}
+			err := config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && config.Storage != tt.wantStorage {
+				t.Errorf("storage = %q, want %q", config.Storage, tt.wantStorage)
+			}
+		})
+	}
+}
+
+func TestBindList(t *testing.T) {
+	repo := &sqlRepository{placeholder: func(n int) string { return "$" + strconv.Itoa(n) }}
+	args := repo.args()
+	if got := args.add("fndds"); got != "$1" {
+		t.Errorf("add() = %q, want $1", got)
+	}
+	if got := bindList(args, []int{7, 8, 9}); got != "$2, $3, $4" {
+		t.Errorf("bindList() = %q, want $2, $3, $4", got)
+	}
+	if len(args.values) != 4 || args.values[3] != 9 {
+		t.Errorf("values = %v, want the dataset and three IDs in order", args.values)
+	}
+}
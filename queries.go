@@ -0,0 +1,91 @@
+// queries.go
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// N1QL statements, with %s standing for the keyspace, which is fixed per
+// dataset or feature. Every value is a positional parameter, so the text of
+// a statement never varies between requests and its prepared plan can be
+// reused.
+const (
+	stmtFoodsByDescription        = "SELECT RAW r FROM %s r WHERE LOWER(r.description) IN $1 ORDER BY r.fdcId"
+	stmtFoodsByDescriptionVersion = "SELECT RAW r FROM %s r WHERE LOWER(r.description) IN $1 AND r.dataVersion = $2 ORDER BY r.fdcId"
+
+	stmtMatchDescriptions        = "SELECT DISTINCT RAW r.description FROM %s r WHERE ANY t IN $1 SATISFIES CONTAINS(LOWER(r.description), t) END LIMIT $2"
+	stmtMatchDescriptionsVersion = "SELECT DISTINCT RAW r.description FROM %s r WHERE ANY t IN $1 SATISFIES CONTAINS(LOWER(r.description), t) END AND r.dataVersion = $3 LIMIT $2"
+
+	stmtSearchFoods    = "SELECT r.fdcId, r.description, r.foodPortions FROM %s r WHERE EVERY w IN $1 SATISFIES CONTAINS(LOWER(r.description), w) END AND r.fdcId > $2 ORDER BY r.fdcId LIMIT $3"
+	stmtFoodByFDCID    = "SELECT r AS food, META(r).cas AS cas FROM %s r WHERE r.fdcId = $1 LIMIT 1"
+	stmtHasDataVersion = "SELECT RAW 1 FROM %s r WHERE r.dataVersion = $1 LIMIT 1"
+	stmtPing           = "SELECT RAW 1"
+
+	stmtNutrientCoverage = "SELECT RAW ARRAY n.nutrient.number FOR n IN IFMISSINGORNULL(r.foodNutrients, []) END FROM %s r LIMIT $1"
+
+	stmtFoodMappings = "SELECT RAW m FROM %s m WHERE m.object_name IS NOT MISSING"
+	stmtConversions  = "SELECT RAW c FROM %s c WHERE c.object_name IS NOT MISSING"
+	stmtRecipes      = "SELECT RAW r FROM %s r WHERE r.name IS NOT MISSING"
+	stmtSynonyms     = "SELECT RAW s FROM %s s WHERE s.term IS NOT MISSING"
+
+	// Completed by n1qlClauses with the filters, the cursor, and the ORDER
+	// BY and LIMIT of a list query
+	stmtUnresolvedFoods      = "SELECT RAW u FROM %s u WHERE u.`count` IS NOT MISSING%s%s%s"
+	stmtCountUnresolvedFoods = "SELECT RAW COUNT(*) FROM %s u WHERE u.`count` IS NOT MISSING%s"
+
+	stmtMealsOfDay = "SELECT RAW m FROM %s m WHERE m.user_id = $1 AND m.date = $2 ORDER BY m.logged_at"
+	// Dates are YYYY-MM-DD, so they compare in calendar order as strings
+	stmtDailyTotals = `SELECT m.date AS date, COUNT(*) AS meals, {
+		"calories": SUM(m.totals.calories), "carbs": SUM(m.totals.carbs),
+		"fat": SUM(m.totals.fat), "protein": SUM(m.totals.protein)} AS totals
+		FROM %s m WHERE m.user_id = $1 AND m.date BETWEEN $2 AND $3
+		GROUP BY m.date ORDER BY m.date`
+)
+
+// preparedStatements remembers the statements run as prepared statements,
+// reported in /v1/stats
+var preparedStatements = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// runQuery runs a statement with positional parameters. Unless
+// couchdb.adhoc_queries is set it runs as a prepared statement: the SDK
+// prepares each distinct statement once and then executes the cached plan,
+// sparing the query service from parsing and planning it on every request.
+// options may be nil; its parameters and context are set here.
+func runQuery(ctx context.Context, cluster *gocb.Cluster, statement string, params []any, options *gocb.QueryOptions) (*gocb.QueryResult, error) {
+	if options == nil {
+		options = &gocb.QueryOptions{}
+	}
+	options.PositionalParameters = params
+	options.Context = ctx
+	options.Adhoc = cfg.CouchDB.AdhocQueries
+
+	if !options.Adhoc {
+		preparedStatements.Lock()
+		first := !preparedStatements.seen[statement]
+		preparedStatements.seen[statement] = true
+		preparedStatements.Unlock()
+		if first {
+			slog.DebugContext(ctx, "preparing statement", "statement", statement)
+		}
+	}
+	return cluster.Query(statement, options)
+}
+
+// QueryStats describes how N1QL statements are sent
+type QueryStats struct {
+	Adhoc              bool `json:"adhoc"`
+	PreparedStatements int  `json:"prepared_statements"`
+}
+
+func queryStats() QueryStats {
+	preparedStatements.Lock()
+	defer preparedStatements.Unlock()
+	return QueryStats{Adhoc: cfg.CouchDB.AdhocQueries, PreparedStatements: len(preparedStatements.seen)}
+}
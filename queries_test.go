@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStatementsTakeOnlyTheKeyspace(t *testing.T) {
+	statements := map[string]string{
+		"foods by description":         stmtFoodsByDescription,
+		"foods by description version": stmtFoodsByDescriptionVersion,
+		"match descriptions":           stmtMatchDescriptions,
+		"match descriptions version":   stmtMatchDescriptionsVersion,
+		"search foods":                 stmtSearchFoods,
+		"food by fdcId":                stmtFoodByFDCID,
+		"has data version":             stmtHasDataVersion,
+		"nutrient coverage":            stmtNutrientCoverage,
+		"food mappings":                stmtFoodMappings,
+		"conversions":                  stmtConversions,
+		"recipes":                      stmtRecipes,
+		"synonyms":                     stmtSynonyms,
+		"meals of day":                 stmtMealsOfDay,
+		"daily totals":                 stmtDailyTotals,
+	}
+	for name, statement := range statements {
+		t.Run(name, func(t *testing.T) {
+			// Anything else formatted in would make the text, and so the
+			// prepared plan, differ between requests
+			if strings.Count(statement, "%") != 1 || !strings.Contains(statement, "%s") {
+				t.Errorf("statement %q must have the keyspace as its only verb", statement)
+			}
+			if query := fmt.Sprintf(statement, "`b`.`s`.`c`"); strings.Contains(query, "%!") {
+				t.Errorf("statement formats as %q", query)
+			}
+		})
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	for _, adhoc := range []bool{false, true} {
+		t.Run(fmt.Sprint("adhoc ", adhoc), func(t *testing.T) {
+			router := setupServer(t, fmt.Sprintf("couchdb:\n  adhoc_queries: %v\n", adhoc))
+			stats := decode[StatsResponse](t, doRequest(t, router, http.MethodGet, "/v1/stats", nil), http.StatusOK)
+			if stats.Queries.Adhoc != adhoc {
+				t.Errorf("queries.adhoc = %v, want %v", stats.Queries.Adhoc, adhoc)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+// ratelimit.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitConfig limits how fast each client may send requests, so one
+// misbehaving client can't saturate Couchbase. Clients are identified by
+// their X-API-Key header when it is one of APIKeys, otherwise by IP.
+// Disabled while RPS is zero.
+type RateLimitConfig struct {
+	// RPS is the sustained number of requests per second per client
+	RPS float64 `yaml:"rps"`
+	// Burst is how many requests a client may send at once; defaults to
+	// RPS rounded up
+	Burst int `yaml:"burst"`
+	// Idle is how long an unused client's bucket is kept
+	Idle time.Duration `yaml:"idle"`
+	// APIKeys lists the IDs of the known API keys, as logged in api_key_id,
+	// which get a bucket of their own. Other keys are limited by IP, so
+	// made-up keys can't be rotated to get around the limit.
+	APIKeys []string `yaml:"api_keys"`
+}
+
+func (r *RateLimitConfig) enabled() bool {
+	return r.RPS > 0
+}
+
+func (r *RateLimitConfig) validate() error {
+	if r.RPS < 0 || r.Burst < 0 || r.Idle < 0 {
+		return errors.New("rate_limit rps, burst and idle must not be negative")
+	}
+	if !r.enabled() {
+		return nil
+	}
+	if r.Burst == 0 {
+		r.Burst = int(math.Ceil(r.RPS))
+	}
+	if r.Idle == 0 {
+		r.Idle = 10 * time.Minute
+	}
+	for _, id := range r.APIKeys {
+		if !apiKeyIDPattern.MatchString(id) {
+			return fmt.Errorf("invalid rate_limit.api_keys ID %q: expected 16 hex digits", id)
+		}
+	}
+	return nil
+}
+
+// apiKeyHeader identifies API clients. Keys are only logged and counted as
+// a hash prefix.
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyIDPattern is the format of the IDs apiKeyID returns
+var apiKeyIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// Probes are never limited, so an orchestrator can't mistake a busy
+// instance for a dead one
+var rateLimitExempt = map[string]bool{"/healthz": true, "/readyz": true}
+
+// tokenBucket refills at rate tokens per second up to burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	idle  time.Duration
+	// keys holds the IDs of the known API keys
+	keys map[string]bool
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// clientLimiter is swapped whole when the rate limit config is reloaded
+var clientLimiter atomic.Pointer[rateLimiter]
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	if !config.enabled() {
+		return nil
+	}
+	keys := make(map[string]bool, len(config.APIKeys))
+	for _, id := range config.APIKeys {
+		keys[id] = true
+	}
+	return &rateLimiter{
+		rate:    config.RPS,
+		burst:   float64(config.Burst),
+		idle:    config.Idle,
+		keys:    keys,
+		buckets: make(map[string]*tokenBucket),
+		swept:   time.Now(),
+	}
+}
+
+// take spends a token from the client's bucket. When the bucket is empty it
+// returns how long until the next token is available.
+func (l *rateLimiter) take(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// client returns the bucket a request counts against: its API key's when
+// the key is known, otherwise its IP's
+func (l *rateLimiter) client(keyID, ip string) string {
+	if l.keys[keyID] {
+		return "key:" + keyID
+	}
+	return "ip:" + ip
+}
+
+// sweep drops the buckets of clients idle for longer than idle, at most
+// once per idle period. An idle bucket is full again, so dropping it
+// changes nothing for the client.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.idle {
+		return
+	}
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.last) > l.idle {
+			delete(l.buckets, client)
+		}
+	}
+	l.swept = now
+}
+
+// apiKeyID returns a stable, non-secret ID for an API key
+func apiKeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// rateLimit rejects requests beyond the client's rate with 429 and a
+// Retry-After header
+func rateLimit(c *gin.Context) {
+	var id string
+	if key := c.GetHeader(apiKeyHeader); key != "" {
+		id = apiKeyID(key)
+		c.Set(ctxKeyAPIKeyID, id)
+	}
+
+	limiter := clientLimiter.Load()
+	if limiter == nil || rateLimitExempt[c.FullPath()] {
+		c.Next()
+		return
+	}
+	if ok, wait := limiter.take(limiter.client(id, c.ClientIP())); !ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limitedRouter serves /ok and /healthz behind the rate limit of content
+func limitedRouter(t *testing.T, content string) *gin.Engine {
+	t.Helper()
+	config := testConfig(t, content)
+	previous := clientLimiter.Load()
+	clientLimiter.Store(newRateLimiter(config.RateLimit))
+	t.Cleanup(func() { clientLimiter.Store(previous) })
+
+	router := gin.New()
+	router.Use(rateLimit)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/ok", ok)
+	router.GET("/healthz", ok)
+	return router
+}
+
+func TestRateLimitClients(t *testing.T) {
+	// Each client may send two requests before the bucket runs dry; one
+	// token takes over 15 minutes to come back
+	config := fmt.Sprintf("rate_limit:\n  rps: 0.001\n  burst: 2\n  api_keys: [%s, %s]\n", apiKeyID("known-1"), apiKeyID("known-2"))
+	tests := []struct {
+		name      string
+		keys      []string
+		wantCodes []int
+	}{
+		{"no key is limited by IP", []string{"", "", ""}, []int{200, 200, 429}},
+		{"rotating unknown keys is limited by IP", []string{"made-up-1", "made-up-2", "made-up-3"}, []int{200, 200, 429}},
+		{"unknown and missing keys share the IP bucket", []string{"", "made-up-1", ""}, []int{200, 200, 429}},
+		{"known key has its own bucket", []string{"", "", "known-1", "known-1", "known-1"}, []int{200, 200, 200, 200, 429}},
+		{"known keys don't share a bucket", []string{"known-1", "known-1", "known-2", "known-2", "known-1"}, []int{200, 200, 200, 200, 429}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := limitedRouter(t, config)
+			for i, key := range tt.keys {
+				var headers []string
+				if key != "" {
+					headers = []string{apiKeyHeader, key}
+				}
+				w := doRequest(t, router, http.MethodGet, "/ok", nil, headers...)
+				if w.Code != tt.wantCodes[i] {
+					t.Fatalf("request %d with key %q: status = %d, want %d", i, key, w.Code, tt.wantCodes[i])
+				}
+				if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+					t.Errorf("request %d: 429 without Retry-After", i)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitExemptsProbes(t *testing.T) {
+	router := limitedRouter(t, "rate_limit:\n  rps: 0.001\n  burst: 1\n")
+	for i := range 3 {
+		if w := doRequest(t, router, http.MethodGet, "/healthz", nil); w.Code != http.StatusOK {
+			t.Fatalf("probe %d: status = %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+	if w := doRequest(t, router, http.MethodGet, "/ok", nil); w.Code != http.StatusOK {
+		t.Errorf("status = %d after probes, want the bucket untouched", w.Code)
+	}
+}
+
+func TestRateLimitConfigKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		wantErr string
+	}{
+		{"key IDs", []string{apiKeyID("known-1")}, ""},
+		{"raw key", []string{"known-1"}, "invalid rate_limit.api_keys"},
+		{"uppercase ID", []string{strings.ToUpper(apiKeyID("known-1"))}, "invalid rate_limit.api_keys"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := RateLimitConfig{RPS: 1, APIKeys: tt.keys}
+			err := config.validate()
+			if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	const limit = "rate_limit:\n  rps: 0.001\n  burst: 1\n"
+	tests := []struct {
+		name      string
+		config    string
+		wantCodes []int
+	}{
+		// A new X-Forwarded-For per request would otherwise get a fresh
+		// bucket each time
+		{"spoofed header is ignored", limit, []int{200, 429, 429}},
+		{"trusted proxy forwards clients", limit + "server:\n  trusted_proxies: [192.0.2.0/24]\n", []int{200, 200, 200}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Test requests come from 192.0.2.1
+			router := setupServer(t, tt.config)
+			for i, want := range tt.wantCodes {
+				forwarded := fmt.Sprintf("203.0.113.%d", i+1)
+				if w := doRequest(t, router, http.MethodGet, "/v1/openapi.json", nil, "X-Forwarded-For", forwarded); w.Code != want {
+					t.Fatalf("request %d from %s: status = %d, want %d", i, forwarded, w.Code, want)
+				}
+			}
+		})
+	}
+}
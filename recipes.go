@@ -0,0 +1,385 @@
+// recipes.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// RecipesConfig enables saved recipes, which calculate-macros resolves by
+// name before looking in the datasets. It is disabled unless a collection
+// to keep them in is configured.
+type RecipesConfig struct {
+	Scope      string `yaml:"scope"`
+	Collection string `yaml:"collection"`
+}
+
+func (r *RecipesConfig) enabled() bool {
+	return r.Collection != ""
+}
+
+func (r *RecipesConfig) validate() error {
+	if !r.enabled() {
+		return nil
+	}
+	if r.Scope == "" {
+		r.Scope = defaultKeyspaceName
+	}
+	for _, name := range []string{r.Scope, r.Collection} {
+		if name != defaultKeyspaceName && !keyspaceNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid keyspace name %q in recipes config", name)
+		}
+	}
+	return nil
+}
+
+// RecipeRequest saves a recipe under a name. Each ingredient is resolved
+// like an item of calculate-macros.
+type RecipeRequest struct {
+	Name        string   `json:"name" binding:"required,max=100"`
+	Servings    int      `json:"servings" binding:"gt=0"`
+	Ingredients []Volume `json:"ingredients" binding:"required,max=100,dive"`
+	// YieldCups is the volume the finished recipe fills; defaults to the
+	// sum of the ingredients given by volume
+	YieldCups float64 `json:"yield_cups,omitempty" binding:"omitempty,gt=0"`
+}
+
+// Recipe is a saved recipe as stored in Couchbase; macros are kept in kcal
+type Recipe struct {
+	Name        string             `json:"name"`
+	Servings    int                `json:"servings"`
+	YieldCups   float64            `json:"yield_cups"`
+	WeightGrams float64            `json:"weight_grams"`
+	Macros      Macros             `json:"macros"`
+	Ingredients []RecipeIngredient `json:"ingredients"`
+	CreatedBy   string             `json:"created_by,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+}
+
+// RecipeIngredient is an ingredient of a recipe as it was resolved when
+// the recipe was saved
+type RecipeIngredient struct {
+	ObjectName         string  `json:"object_name"`
+	VolumeCups         float64 `json:"volume_cups"`
+	Unit               string  `json:"unit,omitempty"`
+	PortionDescription string  `json:"portion_description,omitempty"`
+	Description        string  `json:"description"`
+	WeightGrams        float64 `json:"weight_grams"`
+	Macros             Macros  `json:"macros"`
+}
+
+// categoryRecipe is the category of items resolved to a saved recipe
+const categoryRecipe = "recipe"
+
+func recipeKey(name string) string {
+	return "recipe::" + name
+}
+
+// foodRecipes caches every recipe; they are read on every item of
+// calculate-macros. Changes update the cache as they are stored.
+var foodRecipes = struct {
+	sync.RWMutex
+	loaded  bool
+	entries map[string]Recipe
+}{}
+
+// loadRecipes returns the cached recipes, reading them from Couchbase on
+// first use. The map must not be modified.
+func loadRecipes(ctx context.Context) (map[string]Recipe, error) {
+	foodRecipes.RLock()
+	if foodRecipes.loaded {
+		defer foodRecipes.RUnlock()
+		return foodRecipes.entries, nil
+	}
+	foodRecipes.RUnlock()
+
+	foodRecipes.Lock()
+	defer foodRecipes.Unlock()
+	if err := loadRecipesLocked(ctx); err != nil {
+		return nil, err
+	}
+	return foodRecipes.entries, nil
+}
+
+func loadRecipesLocked(ctx context.Context) error {
+	if foodRecipes.loaded {
+		return nil
+	}
+	result, err := runQuery(ctx, db.cluster, fmt.Sprintf(stmtRecipes, db.recipesKeyspace), nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load recipes: %w", err)
+	}
+	defer result.Close()
+
+	entries := make(map[string]Recipe)
+	for result.Next() {
+		var recipe Recipe
+		if err := result.Row(&recipe); err != nil {
+			return fmt.Errorf("failed to decode recipe: %w", err)
+		}
+		entries[recipe.Name] = recipe
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("failed to load recipes: %w", err)
+	}
+	slog.InfoContext(ctx, "loaded recipes", "recipes", len(entries))
+	foodRecipes.entries = entries
+	foodRecipes.loaded = true
+	return nil
+}
+
+// updateRecipes stores or, with a nil recipe, removes a recipe through
+// store and then applies the change to the cache
+func updateRecipes(ctx context.Context, name string, recipe *Recipe, store func() error) error {
+	foodRecipes.Lock()
+	defer foodRecipes.Unlock()
+	if err := loadRecipesLocked(ctx); err != nil {
+		return err
+	}
+	if err := store(); err != nil {
+		return err
+	}
+
+	// The map is replaced, never modified, so readers can keep using theirs
+	entries := make(map[string]Recipe, len(foodRecipes.entries)+1)
+	for k, v := range foodRecipes.entries {
+		if k != name {
+			entries[k] = v
+		}
+	}
+	if recipe != nil {
+		entries[name] = *recipe
+	}
+	foodRecipes.entries = entries
+	return nil
+}
+
+// findRecipe returns the saved recipe an object name refers to. Failures
+// to load are logged and treated as no recipe, so the name is still looked
+// up in the datasets.
+func findRecipe(ctx context.Context, objectName string) (Recipe, bool) {
+	if db == nil || db.recipes == nil {
+		return Recipe{}, false
+	}
+	recipes, err := loadRecipes(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load recipes", "error", err)
+		return Recipe{}, false
+	}
+	recipe, ok := recipes[normalizeFoodName(objectName)]
+	return recipe, ok
+}
+
+// isServingPortion tells whether a portion description asks for servings
+// of a recipe
+func isServingPortion(description string) bool {
+	switch strings.ToLower(strings.TrimSpace(description)) {
+	case "serving", "servings", "1 serving":
+		return true
+	}
+	return false
+}
+
+// recipeMacros scales a recipe to the requested amount: a volume of its
+// yield, a weight in grams, or, with portion_description "serving", a
+// number of servings
+func recipeMacros(cc *calcContext, volume Volume, recipe Recipe, macroData MacroData) MacroData {
+	var fraction float64
+	var portion string
+	switch {
+	case isServingPortion(volume.PortionDescription):
+		fraction = volume.VolumeCups / float64(recipe.Servings)
+		portion = fmt.Sprintf("1 serving = 1/%d recipe", recipe.Servings)
+	case volume.byWeight && recipe.WeightGrams > 0:
+		fraction = volume.VolumeCups / recipe.WeightGrams
+		portion = fmt.Sprintf("%g g = 1 recipe", recipe.WeightGrams)
+	case !volume.byWeight && recipe.YieldCups > 0:
+		fraction = volume.VolumeCups / recipe.YieldCups
+		portion = fmt.Sprintf("%g cups = 1 recipe", recipe.YieldCups)
+	default:
+		macroData.ErrorCode = errorCodeNoPortionData
+		macroData.ErrorMessage = fmt.Sprintf("recipe %s has no yield to convert the amount to a share of it", recipe.Name)
+		return macroData
+	}
+
+	macros := recipe.Macros.times(fraction)
+	grams := recipe.WeightGrams * fraction
+	macroData.Found = true
+	macroData.Description = recipe.Name
+	macroData.Category = categoryRecipe
+	macroData.Confidence = 1
+	macroData.Macros = macros
+	macroData.CalculatedWeight = grams
+	macroData.PortionUsed = portion
+	macroData.MatchQuality = matchRecipe
+	macroData.PercentError = percentError(volume)
+	macroData.Range = macroRange(macros, volume)
+	if perGram, ok := macros.perGram(grams); cc.perGram && ok {
+		macroData.PerGram = &perGram
+	}
+	if per100g, ok := recipe.Macros.per100g(recipe.WeightGrams); ok {
+		macroData.Per100g = &per100g
+	}
+	if cc.perServing {
+		macroData.PerServing = &ServingMacros{
+			Portion: fmt.Sprintf("1/%d recipe", recipe.Servings),
+			Grams:   recipe.WeightGrams / float64(recipe.Servings),
+			Macros:  recipe.Macros.times(1 / float64(recipe.Servings)),
+		}
+	}
+	macroData.DRIStatus = driStatus(macros, cfg.DRI)
+	return macroData
+}
+
+// recipeYieldCups sums the ingredients given by volume, in cups
+func recipeYieldCups(ingredients []Volume) float64 {
+	var cups float64
+	for _, ingredient := range ingredients {
+		ingredient.applyUnit()
+		if !ingredient.byWeight && ingredient.PortionDescription == "" {
+			cups += ingredient.VolumeCups
+		}
+	}
+	return cups
+}
+
+func requireRecipes(c *gin.Context) bool {
+	if db.recipes == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "recipes are not enabled"})
+		return false
+	}
+	return true
+}
+
+// createRecipe resolves the ingredients of a recipe, totals their macros
+// and saves the recipe. Names are unique; an existing recipe has to be
+// deleted before its name can be reused.
+func createRecipe(c *gin.Context) {
+	if !requireRecipes(c) {
+		return
+	}
+	var request RecipeRequest
+	if err := bindJSON(c, &request); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	if err := validateVolumes("ingredients", request.Ingredients); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	name := normalizeFoodName(request.Name)
+	if name == "" {
+		respondInvalid(c, invalidField("name", fieldCodeRequired, "is required"))
+		return
+	}
+
+	recipe := Recipe{
+		Name:        name,
+		Servings:    request.Servings,
+		YieldCups:   request.YieldCups,
+		Ingredients: make([]RecipeIngredient, 0, len(request.Ingredients)),
+		CreatedBy:   currentUser(c),
+		CreatedAt:   time.Now().UTC(),
+	}
+	if recipe.YieldCups == 0 {
+		recipe.YieldCups = recipeYieldCups(request.Ingredients)
+	}
+
+	cc := newCalcContext(c)
+	items := make([]MacroData, 0, len(request.Ingredients))
+	for _, ingredient := range request.Ingredients {
+		item := processFoodVolume(ingredient, cc)
+		items = append(items, item)
+		recipe.Ingredients = append(recipe.Ingredients, RecipeIngredient{
+			ObjectName:         ingredient.ObjectName,
+			VolumeCups:         ingredient.VolumeCups,
+			Unit:               ingredient.Unit,
+			PortionDescription: ingredient.PortionDescription,
+			Description:        item.Description,
+			WeightGrams:        item.CalculatedWeight,
+			Macros:             item.Macros,
+		})
+		recipe.WeightGrams += item.CalculatedWeight
+		recipe.Macros = recipe.Macros.add(item.Macros)
+	}
+	// A recipe is only saved with every ingredient accounted for
+	switch responseStatus(items) {
+	case responseStatusDegraded:
+		c.JSON(degradedStatus(c), gin.H{"error": "failed to look up ingredients", "ingredients": items})
+		return
+	case responseStatusPartial:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "some ingredients could not be resolved", "ingredients": items})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := updateRecipes(ctx, name, &recipe, func() error {
+		_, err := db.recipes.Insert(recipeKey(name), recipe, &gocb.InsertOptions{Context: ctx})
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, gocb.ErrDocumentExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("a recipe named %s already exists", name)})
+			return
+		}
+		if timedOut(c) {
+			return
+		}
+		respondError(c, http.StatusBadGateway, "failed to store recipe", err)
+		return
+	}
+	c.JSON(http.StatusCreated, recipe)
+}
+
+func getRecipe(c *gin.Context) {
+	if !requireRecipes(c) {
+		return
+	}
+	name := normalizeFoodName(c.Param("name"))
+	entries, err := loadRecipes(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to load recipes", err)
+		return
+	}
+	recipe, ok := entries[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no recipe named %s", name)})
+		return
+	}
+	c.JSON(http.StatusOK, recipe)
+}
+
+func deleteRecipe(c *gin.Context) {
+	if !requireRecipes(c) {
+		return
+	}
+	name := normalizeFoodName(c.Param("name"))
+	ctx := c.Request.Context()
+	removed := true
+	err := updateRecipes(ctx, name, nil, func() error {
+		_, err := db.recipes.Remove(recipeKey(name), &gocb.RemoveOptions{Context: ctx})
+		if errors.Is(err, gocb.ErrDocumentNotFound) {
+			removed = false
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to delete recipe", err)
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no recipe named %s", name)})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// useRecipes enables recipes with the cache already holding recipes, so
+// they are read without Couchbase
+func useRecipes(t *testing.T, recipes ...Recipe) {
+	t.Helper()
+	previous := db
+	db = &Database{recipes: &gocb.Collection{}}
+	entries := make(map[string]Recipe, len(recipes))
+	for _, recipe := range recipes {
+		entries[recipe.Name] = recipe
+	}
+	foodRecipes.Lock()
+	foodRecipes.loaded, foodRecipes.entries = true, entries
+	foodRecipes.Unlock()
+	t.Cleanup(func() {
+		db = previous
+		foodRecipes.Lock()
+		foodRecipes.loaded, foodRecipes.entries = false, nil
+		foodRecipes.Unlock()
+	})
+}
+
+func TestRecipeMacros(t *testing.T) {
+	friedRice := Recipe{
+		Name:        "grandma_fried_rice",
+		Servings:    4,
+		YieldCups:   6,
+		WeightGrams: 900,
+		Macros:      Macros{Calories: 1200, Carbs: 160, Fat: 40, Protein: 48},
+	}
+	tests := []struct {
+		name         string
+		volume       Volume
+		wantFraction float64
+	}{
+		{"share of the yield", Volume{ObjectName: "Grandma_Fried_Rice", VolumeCups: 1.5}, 0.25},
+		{"servings", Volume{ObjectName: "grandma_fried_rice", VolumeCups: 2, PortionDescription: "serving"}, 0.5},
+		{"weight", Volume{ObjectName: "grandma_fried_rice", VolumeCups: 90, Unit: "g"}, 0.1},
+	}
+	router := setupServer(t, "")
+	useRecipes(t, friedRice)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(tt.volume))
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if !item.Found || item.Category != categoryRecipe || item.MatchQuality != matchRecipe {
+				t.Fatalf("item = %+v, want the recipe", item)
+			}
+			want := friedRice.Macros.times(tt.wantFraction)
+			if !macrosNear(item.Macros, want) || math.Abs(item.CalculatedWeight-900*tt.wantFraction) > 1e-9 {
+				t.Errorf("macros = %+v at %v g, want %+v at %v g", item.Macros, item.CalculatedWeight, want, 900*tt.wantFraction)
+			}
+		})
+	}
+
+	// Foods without a recipe of their name are still looked up
+	useMappings(t, &fileMappings{path: writeConfig(t, "rice: Rice, cooked, NFS\n")})
+	w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: "rice", VolumeCups: 1}))
+	if item := decode[MacroResponse](t, w, http.StatusOK).Data[0]; !item.Found || item.Category == categoryRecipe {
+		t.Errorf("rice = %+v, want the food", item)
+	}
+}
+
+func TestRecipeEndpoints(t *testing.T) {
+	router := setupServer(t, "")
+	request := RecipeRequest{Name: "salad", Servings: 2, Ingredients: []Volume{{ObjectName: "dragonfruit", VolumeCups: 1}}}
+	if w := doRequest(t, router, http.MethodPost, "/v1/recipes", request); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d while disabled, want %d", w.Code, http.StatusNotFound)
+	}
+
+	useRecipes(t, Recipe{Name: "grandma_fried_rice", Servings: 4, YieldCups: 6})
+	if recipe := decode[Recipe](t, doRequest(t, router, http.MethodGet, "/v1/recipes/Grandma_Fried_Rice", nil), http.StatusOK); recipe.Servings != 4 {
+		t.Errorf("recipe = %+v, want 4 servings", recipe)
+	}
+	if w := doRequest(t, router, http.MethodGet, "/v1/recipes/soup", nil); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d for a missing recipe, want %d", w.Code, http.StatusNotFound)
+	}
+	// Recipes are only saved with every ingredient resolved
+	if w := doRequest(t, router, http.MethodPost, "/v1/recipes", request); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d with an unknown ingredient, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+	request.Servings = 0
+	if w := doRequest(t, router, http.MethodPost, "/v1/recipes", request); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d without servings, want %d", w.Code, http.StatusBadRequest)
+	}
+}
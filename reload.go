@@ -0,0 +1,153 @@
+// reload.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// configFile is the config the server loads at startup and on reload
+const configFile = "config.yaml"
+
+// ReloadConfig controls reloading the config without a restart. SIGHUP
+// always reloads; with WatchInterval set, config.yaml and the food mappings
+// file are also checked for changes that often. Only the log level, the
+// cache, the rate limit and the food mappings file are reloaded; other
+// changes are logged and wait for a restart. The Couchbase connection is
+// never touched.
+type ReloadConfig struct {
+	WatchInterval time.Duration `yaml:"watch_interval"`
+}
+
+func (r *ReloadConfig) validate() error {
+	if r.WatchInterval < 0 {
+		return errors.New("reload.watch_interval must not be negative")
+	}
+	return nil
+}
+
+// parseLogLevel reads a validated logging.level
+func parseLogLevel(level string) slog.Level {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return parsed
+}
+
+// configReloader applies reloaded configs. current is the config last
+// applied; cfg itself is left as loaded at startup, since request handlers
+// read it without locking.
+type configReloader struct {
+	current *Config
+	stamps  map[string]string
+}
+
+// watchConfig reloads the config on SIGHUP and, with reload.watch_interval
+// set, whenever config.yaml or the food mappings file changes
+func watchConfig() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	current := *cfg
+	r := &configReloader{current: &current}
+	r.stamps = fileStamps(configFile, r.current.FoodMappings.File)
+
+	var ticks <-chan time.Time
+	if cfg.Reload.WatchInterval > 0 {
+		ticker := time.NewTicker(cfg.Reload.WatchInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-hangups:
+			slog.Info("reloading config on SIGHUP")
+		case <-ticks:
+			if maps.Equal(fileStamps(configFile, r.current.FoodMappings.File), r.stamps) {
+				continue
+			}
+			slog.Info("reloading config on file change")
+		}
+		r.reload()
+	}
+}
+
+// fileStamps identifies the current version of files by their
+// modification time and size; missing files and empty paths are left out
+func fileStamps(paths ...string) map[string]string {
+	stamps := make(map[string]string)
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			stamps[path] = fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return stamps
+}
+
+// reload reads config.yaml and applies what can change at runtime. An
+// invalid file is logged and the running config kept.
+func (r *configReloader) reload() {
+	r.stamps = fileStamps(configFile, r.current.FoodMappings.File)
+	config, err := loadConfig(configFile)
+	if err == nil {
+		// Command line overrides stay in effect
+		config.Storage = r.current.Storage
+		config.CouchDB.SkipMigrations = config.CouchDB.SkipMigrations || r.current.CouchDB.SkipMigrations
+		err = config.validate()
+	}
+	if err != nil {
+		// loadConfig already applied the file's log level
+		logLevel.Set(parseLogLevel(r.current.Logging.Level))
+		slog.Error("failed to reload config, keeping the running config", "error", err)
+		return
+	}
+	old := r.current
+
+	if config.Logging.Level != old.Logging.Level {
+		logLevel.Set(parseLogLevel(config.Logging.Level))
+		slog.Info("reloaded log level", "level", config.Logging.Level)
+	}
+	if config.Cache != old.Cache {
+		foodDataCache.reconfigure(config.Cache)
+		slog.Info("reloaded cache config", "size", config.Cache.Size, "max_bytes", config.Cache.MaxBytes, "ttl", config.Cache.TTL, "disabled", config.Cache.Disabled)
+	}
+	if !reflect.DeepEqual(config.RateLimit, old.RateLimit) {
+		// Clients start over with a full bucket under the new limits
+		clientLimiter.Store(newRateLimiter(config.RateLimit))
+		slog.Info("reloaded rate limit config")
+	}
+	// A file store is re-read on every reload, so edits to the file apply
+	// too; moving to or from a collection needs a restart
+	mappingsReloaded := false
+	if old.FoodMappings.Collection == "" && config.FoodMappings.Collection == "" && foodMappings != nil {
+		foodMappings.reload(&fileMappings{path: config.FoodMappings.File})
+		mappingsReloaded = true
+		slog.Info("reloaded food mappings file", "file", config.FoodMappings.File)
+	}
+
+	// Anything else changed is only picked up by a restart
+	loaded, running := *config, *old
+	loaded.Logging.Level, running.Logging.Level = "", ""
+	loaded.Cache, running.Cache = CacheConfig{}, CacheConfig{}
+	loaded.RateLimit, running.RateLimit = RateLimitConfig{}, RateLimitConfig{}
+	loaded.Reload, running.Reload = ReloadConfig{}, ReloadConfig{}
+	if mappingsReloaded {
+		loaded.FoodMappings, running.FoodMappings = MappingsConfig{}, MappingsConfig{}
+	}
+	if !reflect.DeepEqual(loaded, running) {
+		slog.Warn("config changes other than logging.level, cache, rate_limit and food_mappings.file need a restart")
+	}
+
+	r.current = config
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// inConfigDir runs the rest of the test from a fresh directory, where
+// reloads read their config.yaml
+func inConfigDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	if err := os.Chdir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+	return tmp
+}
+
+func TestConfigReload(t *testing.T) {
+	setupServer(t, "cache:\n  ttl: 1m\n")
+	previousLevel := logLevel.Level()
+	t.Cleanup(func() { logLevel.Set(previousLevel) })
+	logLevel.Set(slog.LevelInfo)
+
+	dir := inConfigDir(t)
+	mappings := filepath.Join(dir, "mappings.yaml")
+	if err := os.WriteFile(mappings, []byte("rice: Rice, white, raw\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	current := *cfg
+	r := &configReloader{current: &current}
+	limiter := clientLimiter.Load()
+
+	write := func(content string) {
+		t.Helper()
+		content += "sqlite:\n  path: " + cfg.SQLite.Path + "\n"
+		if err := os.WriteFile(configFile, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("logging:\n  level: debug\ncache:\n  ttl: 5m\nrate_limit:\n  rps: 2\nfood_mappings:\n  file: " + mappings + "\n")
+	r.reload()
+
+	if got := logLevel.Level(); got != slog.LevelDebug {
+		t.Errorf("log level = %v, want debug", got)
+	}
+	if got := foodDataCache.config.TTL; got != 5*time.Minute {
+		t.Errorf("cache ttl = %v, want 5m", got)
+	}
+	if got := clientLimiter.Load(); got == limiter || got == nil || got.rate != 2 {
+		t.Errorf("rate limiter = %+v, want a new one at 2 rps", got)
+	}
+	if term, ok, err := foodMappings.searchTerm(context.Background(), "rice"); err != nil || !ok || term != "Rice, white, raw" {
+		t.Errorf("rice = %q, %v, %v; want the reloaded mapping", term, ok, err)
+	}
+
+	// An invalid file keeps the running config
+	write("logging:\n  level: loud\n")
+	r.reload()
+	if got := logLevel.Level(); got != slog.LevelDebug || r.current.Logging.Level != "debug" {
+		t.Errorf("log level = %v, want debug kept", got)
+	}
+}
+
+func TestFileStamps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("a"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	before := fileStamps(path, "", filepath.Join(t.TempDir(), "missing.yaml"))
+	if len(before) != 1 {
+		t.Fatalf("stamps = %v, want only the existing file", before)
+	}
+	if err := os.WriteFile(path, []byte("ab"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if after := fileStamps(path); after[path] == before[path] {
+		t.Errorf("stamp %q unchanged after a write", after[path])
+	}
+}
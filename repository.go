@@ -0,0 +1,271 @@
+// repository.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// FoodDocument is a food's document as stored. Revision changes whenever
+// the document is written, where the store tracks it (Couchbase's CAS).
+type FoodDocument struct {
+	Data     json.RawMessage
+	Revision string
+}
+
+// FoodRepository is the food data store behind the lookups and the food
+// endpoints. Datasets are the names configured under datasets; a non-empty
+// version only matches documents ingested as that data version. Failures
+// of the store itself are wrapped in errQueryFailed, or errResultStream
+// when a result was cut short, so they aren't mistaken for "no match".
+type FoodRepository interface {
+	// GetByDescription returns the foods whose description equals one of
+	// the lowercased terms, keyed by lowercased description and ordered by
+	// fdcId
+	GetByDescription(ctx context.Context, dataset string, terms []string, version string, stats *requestStats) (map[string][]FoodData, error)
+	// MatchDescriptions returns up to limit distinct descriptions that
+	// contain at least one of the tokens, for fuzzy matching
+	MatchDescriptions(ctx context.Context, dataset string, tokens []string, version string, limit int, stats *requestStats) ([]string, error)
+	// Search returns up to limit foods with an fdcId above after whose
+	// description contains every word, ordered by fdcId. Only the ID,
+	// description and portions are filled in.
+	Search(ctx context.Context, dataset string, words []string, after, limit int) ([]FoodData, error)
+	// GetByFDCID returns a food's document as stored; false when the
+	// dataset has no food with that ID
+	GetByFDCID(ctx context.Context, dataset string, fdcID int) (FoodDocument, bool, error)
+	// HasDataVersion reports whether any dataset has foods ingested as the
+	// version
+	HasDataVersion(ctx context.Context, version string) (bool, error)
+	// StoreFoods adds food documents, in FDC JSON form, to a dataset,
+	// replacing foods with the same fdcId. A non-zero expiry removes them
+	// again after that long, where the store supports it; zero uses the
+	// dataset's configured expiry.
+	StoreFoods(ctx context.Context, dataset string, documents []json.RawMessage, expiry time.Duration) error
+	// Ping checks that the store can serve lookups
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// foodRepo is the repository the handlers read foods from
+var foodRepo FoodRepository
+
+func (d *Database) GetByDescription(ctx context.Context, dataset string, terms []string, version string, stats *requestStats) (map[string][]FoodData, error) {
+	statement := stmtFoodsByDescription
+	params := []interface{}{terms}
+	if version != "" {
+		statement = stmtFoodsByDescriptionVersion
+		params = append(params, version)
+	}
+	query := fmt.Sprintf(statement, d.keyspaces[dataset])
+
+	slog.DebugContext(ctx, "executing query", "statement", query, "params", params)
+
+	stats.recordQuery(query, params...)
+	result, err := runQuery(ctx, d.cluster, query, params, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer result.Close()
+
+	rows, err := readFoods(result, len(terms))
+	if err != nil {
+		return nil, err
+	}
+	foods := make(map[string][]FoodData, len(terms))
+	for _, food := range rows {
+		slog.DebugContext(ctx, "found food", "description", food.Description, "fdc_id", food.FdcID, "portions", len(food.FoodPortions))
+		key := strings.ToLower(food.Description)
+		foods[key] = append(foods[key], food)
+	}
+	return foods, nil
+}
+
+// queryRows is the part of *gocb.QueryResult rows are read through
+type queryRows interface {
+	Next() bool
+	Row(valuePtr interface{}) error
+	Err() error
+}
+
+// readFoods decodes every row of a query result as a food
+func readFoods(result queryRows, capacity int) ([]FoodData, error) {
+	foods := make([]FoodData, 0, capacity)
+	for result.Next() {
+		var food FoodData
+		if err := result.Row(&food); err != nil {
+			return nil, fmt.Errorf("failed to decode food data: %v", err)
+		}
+		foods = append(foods, food)
+	}
+
+	// An error surfacing after iteration means the result set was cut
+	// short, not that nothing matched
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errResultStream, err)
+	}
+	return foods, nil
+}
+
+func (d *Database) MatchDescriptions(ctx context.Context, dataset string, tokens []string, version string, limit int, stats *requestStats) ([]string, error) {
+	statement := stmtMatchDescriptions
+	params := []interface{}{tokens, limit}
+	if version != "" {
+		statement = stmtMatchDescriptionsVersion
+		params = append(params, version)
+	}
+	query := fmt.Sprintf(statement, d.keyspaces[dataset])
+
+	stats.recordQuery(query, params...)
+	result, err := runQuery(ctx, d.cluster, query, params, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer result.Close()
+
+	var descriptions []string
+	for result.Next() {
+		var description string
+		if err := result.Row(&description); err != nil {
+			continue
+		}
+		descriptions = append(descriptions, description)
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errResultStream, err)
+	}
+	return descriptions, nil
+}
+
+func (d *Database) Search(ctx context.Context, dataset string, words []string, after, limit int) ([]FoodData, error) {
+	query := fmt.Sprintf(stmtSearchFoods, d.keyspaces[dataset])
+	result, err := runQuery(ctx, d.cluster, query, []interface{}{words, after, limit}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer result.Close()
+
+	return readFoods(result, limit)
+}
+
+func (d *Database) GetByFDCID(ctx context.Context, dataset string, fdcID int) (FoodDocument, bool, error) {
+	query := fmt.Sprintf(stmtFoodByFDCID, d.keyspaces[dataset])
+	result, err := runQuery(ctx, d.cluster, query, []interface{}{fdcID}, nil)
+	if err != nil {
+		return FoodDocument{}, false, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer result.Close()
+
+	var row struct {
+		Food json.RawMessage `json:"food"`
+		CAS  uint64          `json:"cas"`
+	}
+	found := result.Next()
+	if found {
+		if err := result.Row(&row); err != nil {
+			return FoodDocument{}, false, fmt.Errorf("failed to decode food data: %v", err)
+		}
+	}
+	if err := result.Err(); err != nil {
+		return FoodDocument{}, false, fmt.Errorf("%w: %v", errResultStream, err)
+	}
+	return FoodDocument{Data: row.Food, Revision: strconv.FormatUint(row.CAS, 10)}, found, nil
+}
+
+// StoreFoods upserts the documents keyed by fdcId in one bulk operation
+func (d *Database) StoreFoods(ctx context.Context, dataset string, documents []json.RawMessage, expiry time.Duration) error {
+	ds := cfg.Datasets[dataset]
+	ops, err := foodUpserts(documents, expiry, ds.Expiry)
+	if err != nil {
+		return err
+	}
+
+	collection := d.bucket.Scope(ds.Scope).Collection(ds.Collection)
+	if err := collection.Do(ops, &gocb.BulkOpOptions{Context: ctx}); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if upsert := op.(*gocb.UpsertOp); upsert.Err != nil {
+			return fmt.Errorf("failed to store %s: %w", upsert.ID, upsert.Err)
+		}
+	}
+	return nil
+}
+
+// foodUpserts builds the upserts storing documents keyed by fdcId. An
+// expiry of zero falls back to the dataset's; with neither, documents
+// don't expire.
+func foodUpserts(documents []json.RawMessage, expiry, datasetExpiry time.Duration) ([]gocb.BulkOp, error) {
+	if expiry == 0 {
+		expiry = datasetExpiry
+	}
+	ops := make([]gocb.BulkOp, 0, len(documents))
+	for _, document := range documents {
+		var food struct {
+			FdcID int `json:"fdcId"`
+		}
+		if err := json.Unmarshal(document, &food); err != nil {
+			return nil, err
+		}
+		ops = append(ops, &gocb.UpsertOp{ID: "fdc::" + strconv.Itoa(food.FdcID), Value: document, Expiry: expiry})
+	}
+	return ops, nil
+}
+
+func (d *Database) HasDataVersion(ctx context.Context, version string) (bool, error) {
+	for _, name := range datasetNames() {
+		query := fmt.Sprintf(stmtHasDataVersion, d.keyspaces[name])
+		result, err := runQuery(ctx, d.cluster, query, []interface{}{version}, nil)
+		if err != nil {
+			return false, err
+		}
+		found := result.Next()
+		err = result.Err()
+		result.Close()
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Ping pings the key-value service and runs a trivial query
+func (d *Database) Ping(ctx context.Context) error {
+	report, err := d.bucket.Ping(&gocb.PingOptions{
+		ServiceTypes: []gocb.ServiceType{gocb.ServiceTypeKeyValue},
+		Context:      ctx,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ping key-value service: %v", err)
+	}
+	for _, endpoint := range report.Services[gocb.ServiceTypeKeyValue] {
+		if endpoint.State != gocb.PingStateOk {
+			return fmt.Errorf("key-value endpoint %s is not ok: %s", endpoint.Remote, endpoint.Error)
+		}
+	}
+
+	result, err := runQuery(ctx, d.cluster, stmtPing, nil, nil)
+	if err != nil {
+		return fmt.Errorf("test query failed: %v", err)
+	}
+	defer result.Close()
+	for result.Next() {
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("test query failed: %v", err)
+	}
+	return nil
+}
+
+func (d *Database) Close() error {
+	return d.cluster.Close(nil)
+}
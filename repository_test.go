@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeRows yields its rows, then reports err, as a query result whose
+// stream fails part way does
+type fakeRows struct {
+	rows []string
+	next int
+	err  error
+}
+
+func (r *fakeRows) Next() bool {
+	if r.next >= len(r.rows) {
+		return false
+	}
+	r.next++
+	return true
+}
+
+func (r *fakeRows) Row(valuePtr interface{}) error {
+	return json.Unmarshal([]byte(r.rows[r.next-1]), valuePtr)
+}
+
+func (r *fakeRows) Err() error {
+	return r.err
+}
+
+func TestReadFoods(t *testing.T) {
+	streamErr := errors.New("stream closed: connection reset")
+	tests := []struct {
+		name      string
+		rows      fakeRows
+		wantIDs   []int
+		wantErr   error
+		wantStore bool
+	}{
+		{"complete result", fakeRows{rows: []string{`{"fdcId": 1}`, `{"fdcId": 2}`}}, []int{1, 2}, nil, false},
+		{"no rows", fakeRows{}, []int{}, nil, false},
+		{"one row then a stream error", fakeRows{rows: []string{`{"fdcId": 1}`}, err: streamErr}, nil, errResultStream, true},
+		{"stream error before any row", fakeRows{err: streamErr}, nil, errResultStream, true},
+		{"undecodable row", fakeRows{rows: []string{`{"fdcId": "one"}`}}, nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			foods, err := readFoods(&tt.rows, 2)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readFoods() error = %v, want %v", err, tt.wantErr)
+			}
+			if (err != nil) != (tt.wantIDs == nil) {
+				t.Fatalf("readFoods() = %v, %v; want foods %v", foods, err, tt.wantIDs)
+			}
+			// A truncated result is a store failure, not "no match"
+			if isDatabaseError(err) != tt.wantStore {
+				t.Errorf("isDatabaseError(%v) = %v, want %v", err, !tt.wantStore, tt.wantStore)
+			}
+			if err != nil {
+				return
+			}
+			ids := make([]int, 0, len(foods))
+			for _, food := range foods {
+				ids = append(ids, food.FdcID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("fdcIds = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+// mockRepo serves foods from memory and counts description lookups; the
+// methods it doesn't implement aren't used by the lookups under test
+type mockRepo struct {
+	FoodRepository
+	foods   map[string][]FoodData
+	err     error
+	lookups int
+}
+
+func (r *mockRepo) GetByDescription(_ context.Context, _ string, terms []string, _ string, stats *requestStats) (map[string][]FoodData, error) {
+	r.lookups++
+	stats.recordQuery("mock lookup", terms)
+	if r.err != nil {
+		return nil, r.err
+	}
+	found := make(map[string][]FoodData)
+	for _, term := range terms {
+		if foods, ok := r.foods[term]; ok {
+			found[term] = foods
+		}
+	}
+	return found, nil
+}
+
+func (r *mockRepo) MatchDescriptions(context.Context, string, []string, string, int, *requestStats) ([]string, error) {
+	return nil, r.err
+}
+
+// useRepo makes repo the food store for the test, with a fresh cache
+func useRepo(t *testing.T, repo FoodRepository) {
+	t.Helper()
+	previousRepo, previousCache := foodRepo, foodDataCache
+	foodRepo = repo
+	foodDataCache = newFoodCache(CacheConfig{Size: 100, TTL: time.Hour})
+	t.Cleanup(func() { foodRepo, foodDataCache = previousRepo, previousCache })
+}
+
+func TestLookupThroughRepository(t *testing.T) {
+	banana := []FoodData{{FdcID: 42, Description: "Banana, raw"}}
+	tests := []struct {
+		name        string
+		repo        *mockRepo
+		objectName  string
+		wantFdcID   int
+		wantErr     bool
+		wantStore   bool
+		wantLookups int
+	}{
+		{"mapped food", &mockRepo{foods: map[string][]FoodData{"banana, raw": banana}}, "Banana", 42, false, false, 1},
+		{"food the store lacks", &mockRepo{}, "banana", 0, true, false, 2},
+		{"store failure", &mockRepo{err: fmt.Errorf("%w: connection reset", errQueryFailed)}, "banana", 0, true, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, "")
+			useRepo(t, tt.repo)
+
+			// Only found foods are cached, so a miss is looked up again
+			var stats requestStats
+			for range 2 {
+				food, err := getFoodData(context.Background(), config.DefaultDataset, tt.objectName, "", &stats)
+				if (err != nil) != tt.wantErr || isDatabaseError(err) != tt.wantStore {
+					t.Fatalf("getFoodData() error = %v, want error %v (store failure %v)", err, tt.wantErr, tt.wantStore)
+				}
+				if err == nil && food.FdcID != tt.wantFdcID {
+					t.Errorf("fdcId = %d, want %d", food.FdcID, tt.wantFdcID)
+				}
+			}
+			if tt.repo.lookups != tt.wantLookups {
+				t.Errorf("store looked up %d times, want %d", tt.repo.lookups, tt.wantLookups)
+			}
+		})
+	}
+}
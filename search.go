@@ -0,0 +1,168 @@
+// search.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FoodSummary is a search hit, enough to pick a food and check its portions
+type FoodSummary struct {
+	FdcID       int              `json:"fdc_id"`
+	Description string           `json:"description"`
+	Portions    []PortionSummary `json:"portions"`
+}
+
+type PortionSummary struct {
+	Description string  `json:"description"`
+	GramWeight  float64 `json:"gram_weight"`
+}
+
+type SearchResponse struct {
+	Dataset string        `json:"dataset"`
+	Foods   []FoodSummary `json:"foods"`
+	listPage
+}
+
+// searchFields are the fields search results can be sorted by. Searches
+// are keyset paginated by fdcId in the store, so that is the only one.
+var searchFields = listSpec[FoodSummary]{
+	fields: map[string]listField[FoodSummary]{
+		"fdc_id": {value: func(f FoodSummary) any { return f.FdcID }},
+	},
+	key:         func(f FoodSummary) string { return strconv.Itoa(f.FdcID) },
+	defaultSort: "fdc_id",
+}
+
+// searchFoods finds foods whose description contains every word of ?q=,
+// ordered by fdcId and paged with ?limit= and ?cursor= like the other list
+// endpoints
+func searchFoods(c *gin.Context) {
+	words := tokenize(c.Query("q"))
+	if len(words) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must contain at least one word"})
+		return
+	}
+
+	dataset := c.DefaultQuery("dataset", cfg.DefaultDataset)
+	if _, ok := cfg.Datasets[dataset]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown dataset: %s", dataset)})
+		return
+	}
+
+	q, err := parseListQuery(c, searchFields)
+	if err == nil && q.desc {
+		err = errors.New("search results can only be sorted by ascending fdc_id")
+	}
+	if err == nil && q.total {
+		// The store pages searches by fdcId and never counts every match
+		err = errors.New("search results have no total")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	after, err := searchAfter(q.after)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// One row more than the page tells whether another page exists
+	foods, err := foodRepo.Search(c.Request.Context(), dataset, words, after, q.limit+1)
+	if err != nil {
+		if !timedOut(c) {
+			respondError(c, http.StatusBadGateway, "search failed", err)
+		}
+		return
+	}
+
+	response := SearchResponse{Dataset: dataset, Foods: make([]FoodSummary, 0, len(foods))}
+	for _, food := range foods {
+		response.Foods = append(response.Foods, summarizeFood(food))
+	}
+	response.Foods, response.NextCursor = nextPage(response.Foods, q, searchFields)
+
+	// Search results have no revision of their own; their content is tagged
+	body, err := json.Marshal(response)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to encode response", err)
+		return
+	}
+	if notModified(c, entityTag(string(body))) {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// searchAfter is the fdcId a search cursor continues after, -1 for none
+func searchAfter(cursor *pageCursor) (int, error) {
+	if cursor == nil {
+		return -1, nil
+	}
+	id, ok := cursor.Value.(float64)
+	if !ok || id < 0 || id != math.Trunc(id) {
+		return 0, errInvalidCursor
+	}
+	return int(id), nil
+}
+
+func summarizeFood(food FoodData) FoodSummary {
+	summary := FoodSummary{
+		FdcID:       food.FdcID,
+		Description: food.Description,
+		Portions:    make([]PortionSummary, 0, len(food.FoodPortions)),
+	}
+	for _, portion := range food.FoodPortions {
+		summary.Portions = append(summary.Portions, PortionSummary{
+			Description: portion.PortionDescription,
+			GramWeight:  portion.GramWeight,
+		})
+	}
+	return summary
+}
+
+// getFood returns the full food document for an FDC ID, with every
+// nutrient and portion as stored
+func getFood(c *gin.Context) {
+	fdcID, err := strconv.Atoi(c.Param("fdcId"))
+	if err != nil || fdcID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fdcId must be a positive integer"})
+		return
+	}
+
+	dataset := c.DefaultQuery("dataset", cfg.DefaultDataset)
+	if _, ok := cfg.Datasets[dataset]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown dataset: %s", dataset)})
+		return
+	}
+
+	document, found, err := foodRepo.GetByFDCID(c.Request.Context(), dataset, fdcID)
+	if err != nil {
+		if !timedOut(c) {
+			respondError(c, http.StatusBadGateway, "food lookup failed", err)
+		}
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no food with fdcId %d in dataset %s", fdcID, dataset)})
+		return
+	}
+
+	// Documents only change on a dataset refresh, which changes the CAS;
+	// stores without one tag the content
+	etag := entityTag(dataset, "cas", document.Revision)
+	if document.Revision == "" {
+		etag = entityTag(dataset, string(document.Data))
+	}
+	if notModified(c, etag) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dataset": dataset, "food": document.Data})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearchPagesWithCursors(t *testing.T) {
+	// "raw" matches six foods of the snapshot
+	raw := []int{2, 4, 5, 6, 8, 9}
+	tests := []struct {
+		name      string
+		q         string
+		limit     string
+		wantPages [][]int
+	}{
+		{"one page", "raw", "", [][]int{raw}},
+		{"exact pages", "raw", "3", [][]int{raw[:3], raw[3:]}},
+		{"short last page", "raw", "4", [][]int{raw[:4], raw[4:]}},
+		{"page per food", "egg", "1", [][]int{{3}, {4}}},
+		{"every word must match", "egg raw", "1", [][]int{{4}}},
+		{"no matches", "quinoa", "", [][]int{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, "")
+			var pages [][]int
+			cursor := ""
+			for {
+				params := url.Values{"q": {tt.q}}
+				if tt.limit != "" {
+					params.Set("limit", tt.limit)
+				}
+				if cursor != "" {
+					params.Set("cursor", cursor)
+				}
+				w := doRequest(t, router, http.MethodGet, "/v1/foods/search?"+params.Encode(), nil)
+				page := decode[SearchResponse](t, w, http.StatusOK)
+
+				ids := []int{}
+				for _, food := range page.Foods {
+					ids = append(ids, food.FdcID)
+				}
+				pages = append(pages, ids)
+				if page.NextCursor == "" {
+					break
+				}
+				if len(pages) > len(tt.wantPages) {
+					t.Fatalf("pages = %v and still a next_cursor", pages)
+				}
+				cursor = page.NextCursor
+			}
+			if !reflect.DeepEqual(pages, tt.wantPages) {
+				t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestSearchRejectsInvalidParameters(t *testing.T) {
+	router := setupServer(t, "")
+	foreign := encodePageCursor(pageCursor{Sort: "name", Value: "rice", Key: "1"})
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{"missing q", "", http.StatusBadRequest},
+		{"q without words", "q=%20,%20", http.StatusBadRequest},
+		{"unknown dataset", "q=raw&dataset=unknown", http.StatusNotFound},
+		{"zero limit", "q=raw&limit=0", http.StatusBadRequest},
+		{"limit above the maximum", "q=raw&limit=1001", http.StatusBadRequest},
+		{"non-numeric limit", "q=raw&limit=all", http.StatusBadRequest},
+		{"cursor that isn't base64", "q=raw&cursor=%25%25%25", http.StatusBadRequest},
+		{"cursor that isn't JSON", "q=raw&cursor=" + url.QueryEscape(base64.RawURLEncoding.EncodeToString([]byte("fdc:12"))), http.StatusBadRequest},
+		{"cursor of another sort", "q=raw&cursor=" + url.QueryEscape(foreign), http.StatusBadRequest},
+		{"cursor without an fdcId", "q=raw&cursor=" + url.QueryEscape(encodePageCursor(pageCursor{Sort: "fdc_id", Value: "2"})), http.StatusBadRequest},
+		{"negative fdcId", "q=raw&cursor=" + url.QueryEscape(encodePageCursor(pageCursor{Sort: "fdc_id", Value: -1.0})), http.StatusBadRequest},
+		{"descending sort", "q=raw&sort=-fdc_id", http.StatusBadRequest},
+		{"filter", "q=raw&filter=fdc_id:2", http.StatusBadRequest},
+		// Searches are paged in the store, which never counts every match
+		{"total", "q=raw&total=true", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodGet, "/v1/foods/search?"+tt.query, nil)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestGetFoodRejectsInvalidParameters(t *testing.T) {
+	config := testConfig(t, "")
+	previous := db
+	db = &Database{keyspaces: map[string]string{config.DefaultDataset: "`fdc`.`_default`.`_default`"}}
+	t.Cleanup(func() { db = previous })
+	router := gin.New()
+	router.GET("/v1/foods/:fdcId", getFood)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"non-numeric fdcId", "/v1/foods/rice", http.StatusBadRequest},
+		{"zero fdcId", "/v1/foods/0", http.StatusBadRequest},
+		{"negative fdcId", "/v1/foods/-5", http.StatusBadRequest},
+		{"unknown dataset", "/v1/foods/2345?dataset=unknown", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodGet, tt.path, nil)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestETagAcrossOrigins(t *testing.T) {
+	router := setupServer(t, "cors:\n  allowed_origins: [\"https://dashboard.bytemi.app\"]\n")
+	origin := []string{"Origin", "https://dashboard.bytemi.app"}
+	first := doRequest(t, router, http.MethodGet, "/v1/foods/1", nil, origin...)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status = %d, ETag = %q", first.Code, etag)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		headers    []string
+		header     string
+		wantListed string
+		wantStatus int
+	}{
+		{"ETag is exposed", http.MethodGet, nil, "Access-Control-Expose-Headers", "ETag", http.StatusOK},
+		{"If-None-Match may be sent", http.MethodOptions, []string{"Access-Control-Request-Method", "GET", "Access-Control-Request-Headers", "If-None-Match"}, "Access-Control-Allow-Headers", "If-None-Match", http.StatusNoContent},
+		{"revalidation answers 304", http.MethodGet, []string{"If-None-Match", etag}, "Access-Control-Expose-Headers", "ETag", http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, tt.method, "/v1/foods/1", nil, append(origin, tt.headers...)...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !slices.Contains(strings.Split(w.Header().Get(tt.header), ", "), tt.wantListed) {
+				t.Errorf("%s = %q, want it to list %s", tt.header, w.Header().Get(tt.header), tt.wantListed)
+			}
+		})
+	}
+}
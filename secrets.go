@@ -0,0 +1,303 @@
+// secrets.go
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// errSecret is returned when a secret can't be resolved; the server must
+// not start without it rather than fall back to other credentials
+var errSecret = errors.New("failed to resolve secret")
+
+// Secret references a secret config value may hold instead of the secret
+// itself:
+//
+//	file:///run/secrets/couchdb_pwd
+//	aws-sm://bytemi/couchdb#pwd          (AWS Secrets Manager, JSON key optional)
+//	gcp-sm://projects/p/secrets/couchdb  (GCP Secret Manager, latest version)
+//	vault://secret/data/bytemi#pwd       (Vault KV, v1 or v2)
+//
+// AWS credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN and AWS_REGION; GCP's from GOOGLE_OAUTH_ACCESS_TOKEN or
+// the metadata server; Vault's from VAULT_ADDR and VAULT_TOKEN.
+const (
+	secretSchemeFile  = "file://"
+	secretSchemeAWS   = "aws-sm://"
+	secretSchemeGCP   = "gcp-sm://"
+	secretSchemeVault = "vault://"
+)
+
+var secretClient = &http.Client{Timeout: 10 * time.Second}
+
+// secretFromFile reads the <ENV>_FILE counterpart of an environment
+// variable, e.g. COUCHDB_PWD_FILE, as mounted by Docker and Kubernetes
+// secrets. Trailing newlines are dropped.
+func secretFromFile(env string) (string, bool, error) {
+	path := os.Getenv(env + "_FILE")
+	if path == "" {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %s_FILE: %v", errSecret, env, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// resolveSecrets replaces secret references in the secret config values by
+// the secrets they name
+func resolveSecrets(ctx context.Context, config *Config) error {
+	for _, o := range config.envOverrides() {
+		if !o.secret || !isSecretReference(*o.value) {
+			continue
+		}
+		value, err := resolveSecret(ctx, *o.value)
+		if err != nil {
+			return fmt.Errorf("%w for %s: %v", errSecret, o.field, err)
+		}
+		*o.value = value
+	}
+	return nil
+}
+
+func isSecretReference(value string) bool {
+	for _, scheme := range []string{secretSchemeFile, secretSchemeAWS, secretSchemeGCP, secretSchemeVault} {
+		if strings.HasPrefix(value, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveSecret(ctx context.Context, reference string) (string, error) {
+	switch {
+	case strings.HasPrefix(reference, secretSchemeFile):
+		data, err := os.ReadFile(strings.TrimPrefix(reference, secretSchemeFile))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(reference, secretSchemeAWS):
+		id, key, _ := strings.Cut(strings.TrimPrefix(reference, secretSchemeAWS), "#")
+		return awsSecret(ctx, id, key)
+	case strings.HasPrefix(reference, secretSchemeGCP):
+		return gcpSecret(ctx, strings.TrimPrefix(reference, secretSchemeGCP))
+	default:
+		path, key, _ := strings.Cut(strings.TrimPrefix(reference, secretSchemeVault), "#")
+		return vaultSecret(ctx, path, key)
+	}
+}
+
+// secretKey picks a key out of a JSON object secret; without a key the
+// secret is used whole
+func secretKey(secret, key string) (string, error) {
+	if key == "" {
+		return secret, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %v", err)
+	}
+	value, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret has no string key %q", key)
+	}
+	return value, nil
+}
+
+// doSecretRequest sends a request to a secret manager and decodes its JSON
+// response into out
+func doSecretRequest(req *http.Request, out any) error {
+	resp, err := secretClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// awsSecret reads a secret from AWS Secrets Manager's GetSecretValue
+func awsSecret(ctx context.Context, id, key string) (string, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	accessKey, secretKeyID := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if region == "" || accessKey == "" || secretKeyID == "" {
+		return "", errors.New("AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	body, _ := json.Marshal(map[string]string{"SecretId": id})
+	host := "secretsmanager." + region + ".amazonaws.com"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+host+"/", strings.NewReader(string(body)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, body, region, "secretsmanager", accessKey, secretKeyID, os.Getenv("AWS_SESSION_TOKEN"), time.Now().UTC())
+
+	var out struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := doSecretRequest(req, &out); err != nil {
+		return "", err
+	}
+	return secretKey(out.SecretString, key)
+}
+
+// signAWSRequest adds an AWS Signature Version 4 to a request
+func signAWSRequest(req *http.Request, body []byte, region, service, accessKey, secretKey, sessionToken string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	// Every header set above is signed, in sorted order
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	headers = append(headers, "x-amz-target")
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method, "/", req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// gcpSecret reads a secret version from GCP Secret Manager; a name without
+// a version reads the latest
+func gcpSecret(ctx context.Context, name string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	token, err := gcpAccessToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://secretmanager.googleapis.com/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var out struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := doSecretRequest(req, &out); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(out.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret payload: %v", err)
+	}
+	return string(data), nil
+}
+
+// gcpAccessToken is GOOGLE_OAUTH_ACCESS_TOKEN or, on GCP, the token of the
+// instance's service account
+func gcpAccessToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var out struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := doSecretRequest(req, &out); err != nil {
+		return "", fmt.Errorf("no GOOGLE_OAUTH_ACCESS_TOKEN and no metadata server token: %v", err)
+	}
+	return out.AccessToken, nil
+}
+
+// vaultSecret reads a key of a Vault KV secret. KV v2 paths include the
+// data segment, e.g. secret/data/bytemi.
+func vaultSecret(ctx context.Context, path, key string) (string, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	if key == "" {
+		return "", errors.New("vault references need a #key")
+	}
+	endpoint, err := url.JoinPath(addr, "v1", path)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	var out struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := doSecretRequest(req, &out); err != nil {
+		return "", err
+	}
+	fields := out.Data
+	// KV v2 nests the secret under data.data
+	if nested, ok := out.Data["data"]; ok {
+		var inner map[string]json.RawMessage
+		if json.Unmarshal(nested, &inner) == nil {
+			fields = inner
+		}
+	}
+	var value string
+	if err := json.Unmarshal(fields[key], &value); err != nil {
+		return "", fmt.Errorf("secret has no string key %q", key)
+	}
+	return value, nil
+}
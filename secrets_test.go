@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSecret writes a secret file as Docker and Kubernetes mount them,
+// with a trailing newline
+func writeSecret(t *testing.T, secret string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSecretFiles(t *testing.T) {
+	t.Setenv("COUCHDB_PWD_FILE", writeSecret(t, "from-env-file"))
+	config, err := loadConfig(writeConfig(t, ""))
+	if err != nil || config.CouchDB.Pwd != "from-env-file" {
+		t.Fatalf("loadConfig() = %q, %v; want the password from COUCHDB_PWD_FILE", config.CouchDB.Pwd, err)
+	}
+
+	t.Setenv("COUCHDB_PWD_FILE", "")
+	config, err = loadConfig(writeConfig(t, "couchdb:\n  pwd: file://"+writeSecret(t, "from-reference")+"\n"))
+	if err != nil || config.CouchDB.Pwd != "from-reference" {
+		t.Fatalf("loadConfig() = %q, %v; want the password from the file reference", config.CouchDB.Pwd, err)
+	}
+
+	// A secret that can't be read stops the server from starting
+	t.Setenv("COUCHDB_PWD_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := loadConfig(writeConfig(t, "")); !errors.Is(err, errSecret) {
+		t.Errorf("loadConfig() error = %v, want %v", err, errSecret)
+	}
+}
+
+func TestVaultSecret(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "t0ken" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/bytemi":
+			w.Write([]byte(`{"data": {"data": {"pwd": "kv2"}, "metadata": {}}}`))
+		case "/v1/kv/bytemi":
+			w.Write([]byte(`{"data": {"pwd": "kv1"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(vault.Close)
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "t0ken")
+
+	tests := []struct {
+		reference string
+		want      string
+		wantErr   bool
+	}{
+		{"vault://secret/data/bytemi#pwd", "kv2", false},
+		{"vault://kv/bytemi#pwd", "kv1", false},
+		{"vault://kv/bytemi#user", "", true},
+		{"vault://kv/bytemi", "", true},
+		{"vault://kv/missing#pwd", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reference, func(t *testing.T) {
+			got, err := resolveSecret(context.Background(), tt.reference)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("resolveSecret() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretKey(t *testing.T) {
+	if got, err := secretKey(`{"user": "app", "pwd": "s3cret"}`, "pwd"); err != nil || got != "s3cret" {
+		t.Errorf("secretKey() = %q, %v; want s3cret", got, err)
+	}
+	if got, err := secretKey("plain", ""); err != nil || got != "plain" {
+		t.Errorf("secretKey() = %q, %v; want the whole secret", got, err)
+	}
+	if _, err := secretKey("plain", "pwd"); err == nil {
+		t.Error("secretKey() of a non-JSON secret succeeded, want an error")
+	}
+}
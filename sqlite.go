@@ -0,0 +1,164 @@
+// sqlite.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// storageSQLite serves foods from an embedded SQLite file, so the API can
+// run without any external database (offline mode)
+const storageSQLite = "sqlite"
+
+// snapshotBatchSize is how many snapshot foods are stored per transaction
+const snapshotBatchSize = 500
+
+// SQLiteConfig configures the sqlite storage backend
+type SQLiteConfig struct {
+	// Path is the database file; defaults to foods.db
+	Path string `yaml:"path"`
+	// Snapshot is an FDC JSON download, e.g. the FNDDS one, loaded into
+	// the default dataset while the database holds no foods
+	Snapshot string `yaml:"snapshot"`
+}
+
+func (s *SQLiteConfig) validate() error {
+	if s.Path == "" {
+		s.Path = "foods.db"
+	}
+	if s.Snapshot != "" {
+		if _, err := os.Stat(s.Snapshot); err != nil {
+			return fmt.Errorf("invalid sqlite.snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// openSQLite opens (creating if needed) the SQLite file, migrates it and
+// loads the snapshot into an empty database
+func openSQLite(config SQLiteConfig, dataset string) (*sqlRepository, error) {
+	slog.Info("opening SQLite food database", "path", config.Path)
+	dsn := "file:" + config.Path + "?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite: %v", err)
+	}
+
+	repo := &sqlRepository{
+		db:          conn,
+		placeholder: func(n int) string { return "?" + strconv.Itoa(n) },
+	}
+	ctx := context.Background()
+	if err := repo.migrate(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var foods int
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM foods").Scan(&foods); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to count foods: %v", err)
+	}
+	if foods == 0 && config.Snapshot != "" {
+		start := time.Now()
+		loaded, err := repo.loadSnapshot(ctx, dataset, config.Snapshot)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to load snapshot %s: %v", config.Snapshot, err)
+		}
+		slog.Info("loaded food snapshot", "path", config.Snapshot, "dataset", dataset, "foods", loaded, "duration", time.Since(start))
+	} else if foods == 0 {
+		slog.Warn("SQLite food database is empty and no sqlite.snapshot is configured")
+	}
+	return repo, nil
+}
+
+// loadSnapshot stores every food of an FDC JSON download in a dataset
+func (r *sqlRepository) loadSnapshot(ctx context.Context, dataset, path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	loaded := 0
+	batch := make([]FoodData, 0, snapshotBatchSize)
+	flush := func() error {
+		if err := r.insertFoods(ctx, dataset, batch); err != nil {
+			return err
+		}
+		loaded += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	err = readFoodDocuments(file, func(document json.RawMessage) error {
+		var food FoodData
+		if err := json.Unmarshal(document, &food); err != nil {
+			return err
+		}
+		batch = append(batch, food)
+		if len(batch) < snapshotBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	return loaded, err
+}
+
+// readFoodDocuments streams the food documents of an FDC JSON download,
+// which wraps them in an object keyed by food type, e.g.
+// {"SurveyFoods": [...]}. Documents are read one at a time so large
+// downloads aren't held in memory.
+func readFoodDocuments(r io.Reader, fn func(json.RawMessage) error) error {
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		// The key names the food type, which doesn't matter here
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+		if err := expectDelim(decoder, '['); err != nil {
+			return err
+		}
+		for decoder.More() {
+			var document json.RawMessage
+			if err := decoder.Decode(&document); err != nil {
+				return err
+			}
+			if err := fn(document); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return errors.New("not an FDC JSON download: expected " + delim.String())
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// snapshot is a small FDC JSON download
+const snapshot = `{"SurveyFoods": [
+	{"fdcId": 3, "description": "Rice, white, cooked", "dataVersion": "2023-10",
+	 "foodNutrients": [{"amount": 28, "nutrient": {"name": "Carbohydrate, by difference", "number": "205", "unitName": "G"}}],
+	 "foodPortions": [{"gramWeight": 158, "portionDescription": "1 cup"}]},
+	{"fdcId": 1, "description": "Banana, raw", "dataVersion": "2023-10",
+	 "foodPortions": [{"gramWeight": 150, "portionDescription": "1 cup, sliced"}]},
+	{"fdcId": 2, "description": "Rice, brown, cooked", "dataVersion": "2021-10"}
+]}`
+
+// openTestSQLite opens a SQLite store in a temporary directory with the
+// snapshot loaded into the fndds dataset
+func openTestSQLite(t *testing.T) *sqlRepository {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foods.json")
+	if err := os.WriteFile(path, []byte(snapshot), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := openSQLite(SQLiteConfig{Path: filepath.Join(dir, "foods.db"), Snapshot: path}, "fndds")
+	if err != nil {
+		t.Fatalf("openSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func fdcIDs(foods []FoodData) []int {
+	ids := make([]int, 0, len(foods))
+	for _, food := range foods {
+		ids = append(ids, food.FdcID)
+	}
+	return ids
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	repo := openTestSQLite(t)
+	ctx := context.Background()
+	stats := &requestStats{}
+
+	byDescription, err := repo.GetByDescription(ctx, "fndds", []string{"rice, white, cooked", "banana, raw", "quinoa"}, "", stats)
+	if err != nil {
+		t.Fatalf("GetByDescription() error = %v", err)
+	}
+	if len(byDescription) != 2 {
+		t.Errorf("GetByDescription() found %d terms, want 2", len(byDescription))
+	}
+	rice := byDescription["rice, white, cooked"]
+	if len(rice) != 1 || len(rice[0].FoodNutrients) != 1 || rice[0].FoodNutrients[0].Amount != 28 || len(rice[0].FoodPortions) != 1 || rice[0].FoodPortions[0].GramWeight != 158 {
+		t.Errorf("rice = %+v, want its nutrient and portion", rice)
+	}
+	if versioned, err := repo.GetByDescription(ctx, "fndds", []string{"rice, brown, cooked"}, "2023-10", stats); err != nil || len(versioned) != 0 {
+		t.Errorf("GetByDescription() for another version = %v, %v; want nothing", versioned, err)
+	}
+	if other, err := repo.GetByDescription(ctx, "sr", []string{"banana, raw"}, "", stats); err != nil || len(other) != 0 {
+		t.Errorf("GetByDescription() in another dataset = %v, %v; want nothing", other, err)
+	}
+
+	descriptions, err := repo.MatchDescriptions(ctx, "fndds", []string{"rice"}, "", 10, stats)
+	slices.Sort(descriptions)
+	if err != nil || !slices.Equal(descriptions, []string{"Rice, brown, cooked", "Rice, white, cooked"}) {
+		t.Errorf("MatchDescriptions() = %v, %v; want both rices", descriptions, err)
+	}
+
+	page, err := repo.Search(ctx, "fndds", []string{"rice", "cooked"}, 0, 1)
+	if err != nil || !slices.Equal(fdcIDs(page), []int{2}) {
+		t.Errorf("Search() first page = %v, %v; want [2]", fdcIDs(page), err)
+	}
+	page, err = repo.Search(ctx, "fndds", []string{"rice", "cooked"}, 2, 1)
+	if err != nil || !slices.Equal(fdcIDs(page), []int{3}) {
+		t.Errorf("Search() after 2 = %v, %v; want [3]", fdcIDs(page), err)
+	}
+
+	document, found, err := repo.GetByFDCID(ctx, "fndds", 1)
+	var food FoodData
+	if err != nil || !found || json.Unmarshal(document.Data, &food) != nil || food.Description != "Banana, raw" {
+		t.Errorf("GetByFDCID(1) = %s, %v, %v; want the banana", document.Data, found, err)
+	}
+	if _, found, err := repo.GetByFDCID(ctx, "fndds", 99); err != nil || found {
+		t.Errorf("GetByFDCID(99) found = %v, %v; want not found", found, err)
+	}
+
+	for version, want := range map[string]bool{"2021-10": true, "1999-01": false} {
+		if got, err := repo.HasDataVersion(ctx, version); err != nil || got != want {
+			t.Errorf("HasDataVersion(%s) = %v, %v; want %v", version, got, err, want)
+		}
+	}
+}
+
+func TestSQLiteSnapshotLoadedOnce(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foods.json")
+	if err := os.WriteFile(path, []byte(snapshot), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := SQLiteConfig{Path: filepath.Join(dir, "foods.db"), Snapshot: path}
+	for i := range 2 {
+		repo, err := openSQLite(config, "fndds")
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		page, err := repo.Search(context.Background(), "fndds", []string{"rice"}, 0, 10)
+		repo.Close()
+		if err != nil || len(page) != 2 {
+			t.Errorf("open %d: Search() = %v, %v; want the two rices once", i, fdcIDs(page), err)
+		}
+	}
+}
+
+func TestReadFoodDocumentsRejectsOtherJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"array of foods", `[{"fdcId": 1}]`},
+		{"food type that isn't a list", `{"SurveyFoods": {"fdcId": 1}}`},
+		{"truncated", `{"SurveyFoods": [{"fdcId": 1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := readFoodDocuments(strings.NewReader(tt.content), func(json.RawMessage) error { return nil })
+			if err == nil {
+				t.Error("readFoodDocuments() succeeded, want an error")
+			}
+		})
+	}
+}
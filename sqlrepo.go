@@ -0,0 +1,451 @@
+// sqlrepo.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqlRepository serves foods from a SQL database holding the foods,
+// nutrients and portions tables of sqlMigrations. The SQL is kept to what
+// every supported database understands; only placeholders differ.
+type sqlRepository struct {
+	db *sql.DB
+	// placeholder returns the n-th (1-based) bind parameter
+	placeholder func(n int) string
+}
+
+// sqlMigrations are applied in order, each once, and recorded in
+// schema_migrations. Never edit a released migration; append a new one.
+var sqlMigrations = []string{
+	`CREATE TABLE foods (
+		dataset TEXT NOT NULL,
+		fdc_id BIGINT NOT NULL,
+		description TEXT NOT NULL,
+		-- JSON object of translated descriptions keyed by locale
+		descriptions TEXT,
+		category TEXT,
+		data_version TEXT,
+		PRIMARY KEY (dataset, fdc_id)
+	);
+	CREATE INDEX foods_description ON foods (dataset, lower(description));
+	CREATE INDEX foods_data_version ON foods (data_version);
+
+	CREATE TABLE nutrients (
+		dataset TEXT NOT NULL,
+		fdc_id BIGINT NOT NULL,
+		number TEXT NOT NULL,
+		name TEXT NOT NULL,
+		unit_name TEXT,
+		amount DOUBLE PRECISION NOT NULL,
+		PRIMARY KEY (dataset, fdc_id, number),
+		FOREIGN KEY (dataset, fdc_id) REFERENCES foods (dataset, fdc_id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE portions (
+		dataset TEXT NOT NULL,
+		fdc_id BIGINT NOT NULL,
+		id BIGINT NOT NULL,
+		sequence_number INTEGER,
+		portion_description TEXT,
+		modifier TEXT,
+		gram_weight DOUBLE PRECISION NOT NULL,
+		measure_unit_id INTEGER,
+		measure_unit_name TEXT,
+		measure_unit_abbreviation TEXT,
+		PRIMARY KEY (dataset, fdc_id, id),
+		FOREIGN KEY (dataset, fdc_id) REFERENCES foods (dataset, fdc_id) ON DELETE CASCADE
+	);`,
+}
+
+// migrate brings the schema up to date
+func (r *sqlRepository) migrate(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	var applied int
+	if err := r.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&applied); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for i := applied; i < len(sqlMigrations); i++ {
+		version := i + 1
+		tx, err := r.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, statement := range strings.Split(sqlMigrations[i], ";") {
+			if strings.TrimSpace(stripSQLComments(statement)) == "" {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d failed: %v", version, err)
+			}
+		}
+		insert := fmt.Sprintf("INSERT INTO schema_migrations (version, applied_at) VALUES (%s, %s)", r.placeholder(1), r.placeholder(2))
+		if _, err := tx.ExecContext(ctx, insert, version, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+	}
+	return nil
+}
+
+func stripSQLComments(statement string) string {
+	lines := strings.Split(statement, "\n")
+	for i, line := range lines {
+		if before, _, ok := strings.Cut(line, "--"); ok {
+			lines[i] = before
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sqlArgs collects bind parameters while a statement is built
+type sqlArgs struct {
+	placeholder func(int) string
+	values      []any
+}
+
+func (r *sqlRepository) args() *sqlArgs {
+	return &sqlArgs{placeholder: r.placeholder}
+}
+
+// add binds a value and returns its placeholder
+func (a *sqlArgs) add(value any) string {
+	a.values = append(a.values, value)
+	return a.placeholder(len(a.values))
+}
+
+// bindList binds several values and returns their placeholders, comma
+// separated
+func bindList[T any](a *sqlArgs, values []T) string {
+	placeholders := make([]string, len(values))
+	for i, v := range values {
+		placeholders[i] = a.add(v)
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+func (r *sqlRepository) GetByDescription(ctx context.Context, dataset string, terms []string, version string, stats *requestStats) (map[string][]FoodData, error) {
+	args := r.args()
+	filter := fmt.Sprintf("dataset = %s AND lower(description) IN (%s)", args.add(dataset), bindList(args, terms))
+	if version != "" {
+		filter += " AND data_version = " + args.add(version)
+	}
+
+	foods, err := r.loadFoods(ctx, filter, "", args, stats)
+	if err != nil {
+		return nil, err
+	}
+	grouped := make(map[string][]FoodData, len(terms))
+	for _, food := range foods {
+		key := strings.ToLower(food.Description)
+		grouped[key] = append(grouped[key], food)
+	}
+	return grouped, nil
+}
+
+func (r *sqlRepository) MatchDescriptions(ctx context.Context, dataset string, tokens []string, version string, limit int, stats *requestStats) ([]string, error) {
+	args := r.args()
+	matches := make([]string, len(tokens))
+	for i, token := range tokens {
+		matches[i] = fmt.Sprintf("lower(description) LIKE %s", args.add("%"+token+"%"))
+	}
+	filter := fmt.Sprintf("dataset = %s AND (%s)", args.add(dataset), strings.Join(matches, " OR "))
+	if version != "" {
+		filter += " AND data_version = " + args.add(version)
+	}
+	query := fmt.Sprintf("SELECT DISTINCT description FROM foods WHERE %s LIMIT %d", filter, limit)
+
+	stats.recordQuery(query, args.values...)
+	rows, err := r.db.QueryContext(ctx, query, args.values...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer rows.Close()
+
+	var descriptions []string
+	for rows.Next() {
+		var description string
+		if err := rows.Scan(&description); err != nil {
+			continue
+		}
+		descriptions = append(descriptions, description)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errResultStream, err)
+	}
+	return descriptions, nil
+}
+
+func (r *sqlRepository) Search(ctx context.Context, dataset string, words []string, after, limit int) ([]FoodData, error) {
+	args := r.args()
+	filter := "dataset = " + args.add(dataset)
+	for _, word := range words {
+		filter += " AND lower(description) LIKE " + args.add("%"+word+"%")
+	}
+	filter += " AND fdc_id > " + args.add(after)
+
+	// Search results only carry portions, but loading nutrients too keeps
+	// one code path; pages are small
+	return r.loadFoods(ctx, filter, fmt.Sprintf(" LIMIT %d", limit), args, &requestStats{})
+}
+
+// GetByFDCID leaves the revision empty: the tables don't track one
+func (r *sqlRepository) GetByFDCID(ctx context.Context, dataset string, fdcID int) (FoodDocument, bool, error) {
+	args := r.args()
+	filter := fmt.Sprintf("dataset = %s AND fdc_id = %s", args.add(dataset), args.add(fdcID))
+	foods, err := r.loadFoods(ctx, filter, "", args, &requestStats{})
+	if err != nil || len(foods) == 0 {
+		return FoodDocument{}, false, err
+	}
+	document, err := json.Marshal(foods[0])
+	if err != nil {
+		return FoodDocument{}, false, err
+	}
+	return FoodDocument{Data: document}, true, nil
+}
+
+func (r *sqlRepository) HasDataVersion(ctx context.Context, version string) (bool, error) {
+	var found int
+	query := fmt.Sprintf("SELECT 1 FROM foods WHERE data_version = %s LIMIT 1", r.placeholder(1))
+	err := r.db.QueryRowContext(ctx, query, version).Scan(&found)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	return true, nil
+}
+
+func (r *sqlRepository) StoreFoods(ctx context.Context, dataset string, documents []json.RawMessage, expiry time.Duration) error {
+	if expiry > 0 {
+		return errors.New("document expiry needs storage couchbase")
+	}
+	foods := make([]FoodData, len(documents))
+	for i, document := range documents {
+		if err := json.Unmarshal(document, &foods[i]); err != nil {
+			return err
+		}
+	}
+	return r.insertFoods(ctx, dataset, foods)
+}
+
+func (r *sqlRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+func (r *sqlRepository) Close() error {
+	return r.db.Close()
+}
+
+// loadFoods reads the foods matching a filter on the foods table, ordered
+// by fdcId, with their nutrients and portions
+func (r *sqlRepository) loadFoods(ctx context.Context, filter, suffix string, args *sqlArgs, stats *requestStats) ([]FoodData, error) {
+	query := "SELECT dataset, fdc_id, description, descriptions, category, data_version FROM foods WHERE " + filter + " ORDER BY fdc_id" + suffix
+	stats.recordQuery(query, args.values...)
+	rows, err := r.db.QueryContext(ctx, query, args.values...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer rows.Close()
+
+	var (
+		foods   []FoodData
+		dataset string
+	)
+	for rows.Next() {
+		var (
+			food                                FoodData
+			descriptions, category, dataVersion sql.NullString
+		)
+		if err := rows.Scan(&dataset, &food.FdcID, &food.Description, &descriptions, &category, &dataVersion); err != nil {
+			return nil, fmt.Errorf("failed to decode food data: %v", err)
+		}
+		if descriptions.Valid {
+			if err := json.Unmarshal([]byte(descriptions.String), &food.Descriptions); err != nil {
+				return nil, fmt.Errorf("failed to decode descriptions of food %d: %v", food.FdcID, err)
+			}
+		}
+		food.FoodCategory.Description = category.String
+		food.DataVersion = dataVersion.String
+		foods = append(foods, food)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errResultStream, err)
+	}
+	if len(foods) == 0 {
+		return foods, nil
+	}
+
+	byID := make(map[int]*FoodData, len(foods))
+	ids := make([]int, len(foods))
+	for i := range foods {
+		byID[foods[i].FdcID] = &foods[i]
+		ids[i] = foods[i].FdcID
+	}
+	if err := r.loadNutrients(ctx, dataset, ids, byID, stats); err != nil {
+		return nil, err
+	}
+	if err := r.loadPortions(ctx, dataset, ids, byID, stats); err != nil {
+		return nil, err
+	}
+	return foods, nil
+}
+
+func (r *sqlRepository) loadNutrients(ctx context.Context, dataset string, ids []int, byID map[int]*FoodData, stats *requestStats) error {
+	args := r.args()
+	query := fmt.Sprintf("SELECT fdc_id, number, name, unit_name, amount FROM nutrients WHERE dataset = %s AND fdc_id IN (%s) ORDER BY fdc_id, number", args.add(dataset), bindList(args, ids))
+	stats.recordQuery(query, args.values...)
+	rows, err := r.db.QueryContext(ctx, query, args.values...)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			fdcID    int
+			nutrient Nutrient
+			unitName sql.NullString
+		)
+		if err := rows.Scan(&fdcID, &nutrient.Nutrient.Number, &nutrient.Nutrient.Name, &unitName, &nutrient.Amount); err != nil {
+			return fmt.Errorf("failed to decode nutrient: %v", err)
+		}
+		nutrient.Nutrient.UnitName = unitName.String
+		food := byID[fdcID]
+		food.FoodNutrients = append(food.FoodNutrients, nutrient)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: %v", errResultStream, err)
+	}
+	return nil
+}
+
+func (r *sqlRepository) loadPortions(ctx context.Context, dataset string, ids []int, byID map[int]*FoodData, stats *requestStats) error {
+	args := r.args()
+	query := fmt.Sprintf(`SELECT fdc_id, id, sequence_number, portion_description, modifier, gram_weight,
+		measure_unit_id, measure_unit_name, measure_unit_abbreviation
+		FROM portions WHERE dataset = %s AND fdc_id IN (%s) ORDER BY fdc_id, sequence_number, id`, args.add(dataset), bindList(args, ids))
+	stats.recordQuery(query, args.values...)
+	rows, err := r.db.QueryContext(ctx, query, args.values...)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			fdcID                                       int
+			portion                                     Portion
+			sequence, unitID                            sql.NullInt64
+			description, modifier, unitName, unitAbbrev sql.NullString
+		)
+		if err := rows.Scan(&fdcID, &portion.ID, &sequence, &description, &modifier, &portion.GramWeight, &unitID, &unitName, &unitAbbrev); err != nil {
+			return fmt.Errorf("failed to decode portion: %v", err)
+		}
+		portion.SequenceNumber = int(sequence.Int64)
+		portion.PortionDescription = description.String
+		portion.Modifier = modifier.String
+		portion.MeasureUnit.ID = int(unitID.Int64)
+		portion.MeasureUnit.Name = unitName.String
+		portion.MeasureUnit.Abbreviation = unitAbbrev.String
+		food := byID[fdcID]
+		food.FoodPortions = append(food.FoodPortions, portion)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: %v", errResultStream, err)
+	}
+	return nil
+}
+
+// insertFoods stores foods in a dataset in one transaction, replacing any
+// food already stored under the same fdcId
+func (r *sqlRepository) insertFoods(ctx context.Context, dataset string, foods []FoodData) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, food := range foods {
+		if err := insertFood(ctx, tx, r.placeholder, dataset, food); err != nil {
+			return fmt.Errorf("failed to store food %d: %v", food.FdcID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func insertFood(ctx context.Context, tx *sql.Tx, placeholder func(int) string, dataset string, food FoodData) error {
+	exec := func(statement string, values ...any) error {
+		args := &sqlArgs{placeholder: placeholder}
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(statement, bindList(args, values)), args.values...)
+		return err
+	}
+
+	// Nutrients and portions go with the food through ON DELETE CASCADE
+	args := &sqlArgs{placeholder: placeholder}
+	remove := fmt.Sprintf("DELETE FROM foods WHERE dataset = %s AND fdc_id = %s", args.add(dataset), args.add(food.FdcID))
+	if _, err := tx.ExecContext(ctx, remove, args.values...); err != nil {
+		return err
+	}
+
+	var descriptions sql.NullString
+	if len(food.Descriptions) > 0 {
+		encoded, err := json.Marshal(food.Descriptions)
+		if err != nil {
+			return err
+		}
+		descriptions = sql.NullString{String: string(encoded), Valid: true}
+	}
+	err := exec("INSERT INTO foods (dataset, fdc_id, description, descriptions, category, data_version) VALUES (%s)",
+		dataset, food.FdcID, food.Description, descriptions, nullString(food.category()), nullString(food.DataVersion))
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(food.FoodNutrients))
+	for _, n := range food.FoodNutrients {
+		// The table holds one amount per nutrient; repeated entries in
+		// the source are dropped
+		if seen[n.Nutrient.Number] {
+			continue
+		}
+		seen[n.Nutrient.Number] = true
+		err := exec("INSERT INTO nutrients (dataset, fdc_id, number, name, unit_name, amount) VALUES (%s)",
+			dataset, food.FdcID, n.Nutrient.Number, n.Nutrient.Name, nullString(n.Nutrient.UnitName), n.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range food.FoodPortions {
+		err := exec(`INSERT INTO portions (dataset, fdc_id, id, sequence_number, portion_description, modifier, gram_weight,
+			measure_unit_id, measure_unit_name, measure_unit_abbreviation) VALUES (%s)`,
+			dataset, food.FdcID, p.ID, p.SequenceNumber, nullString(p.PortionDescription), nullString(p.Modifier), p.GramWeight,
+			p.MeasureUnit.ID, nullString(p.MeasureUnit.Name), nullString(p.MeasureUnit.Abbreviation))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
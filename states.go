@@ -0,0 +1,129 @@
+// states.go
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Preparation states a food can be requested in
+const (
+	stateRaw    = "raw"
+	stateCooked = "cooked"
+	stateBoiled = "boiled"
+	stateFried  = "fried"
+)
+
+// StatesConfig converts between the preparation states of a food. Cooking
+// changes a food's weight, mostly by water gained or lost, so 100 g of
+// cooked rice holds far less than 100 g of raw rice.
+type StatesConfig struct {
+	// YieldFactors maps object names to the weight of the food in a state
+	// per gram of it raw, e.g. rice: {cooked: 2.6}. Boiled and fried fall
+	// back to cooked. Entries replace the built-in factors of the same
+	// object name.
+	YieldFactors map[string]map[string]float64 `yaml:"yield_factors"`
+}
+
+func (s *StatesConfig) validate() error {
+	factors := make(map[string]map[string]float64, len(defaultYieldFactors)+len(s.YieldFactors))
+	for name, states := range defaultYieldFactors {
+		factors[name] = states
+	}
+	for name, states := range s.YieldFactors {
+		for state, factor := range states {
+			switch {
+			case state != stateCooked && state != stateBoiled && state != stateFried:
+				return fmt.Errorf("states.yield_factors[%q]: invalid state %q: expected cooked, boiled or fried", name, state)
+			case factor <= 0:
+				return fmt.Errorf("states.yield_factors[%q][%q] must be positive", name, state)
+			}
+		}
+		factors[normalizeFoodName(name)] = states
+	}
+	s.YieldFactors = factors
+	return nil
+}
+
+// defaultYieldFactors are cooking yields of common foods, after the USDA
+// Table of Cooking Yields for Meat and Poultry and typical package yields
+var defaultYieldFactors = map[string]map[string]float64{
+	"rice":    {stateCooked: 2.6},
+	"pasta":   {stateCooked: 2.2},
+	"chicken": {stateCooked: 0.75, stateFried: 0.7},
+	"beef":    {stateCooked: 0.7},
+}
+
+// lookupName is the name a volume's food is looked up by. A mapping for
+// the name qualified by the state, e.g. "rice:raw", is preferred over the
+// plain name's; names without any mapping are matched fuzzily with the
+// state as one more word, so descriptions in that state score higher.
+func lookupName(volume Volume, mappings map[string]string) string {
+	name := normalizeFoodName(volume.ObjectName)
+	if volume.State == "" {
+		return name
+	}
+	if qualified := name + ":" + volume.State; mappings[qualified] != "" {
+		return qualified
+	}
+	if _, ok := mappings[name]; ok {
+		return name
+	}
+	return name + " " + volume.State
+}
+
+// describedState reads the preparation state off a food description, e.g.
+// boiled for "Egg, whole, boiled or poached". It is empty when the
+// description doesn't tell.
+func describedState(description string) string {
+	words := strings.FieldsFunc(strings.ToLower(description), func(r rune) bool { return !unicode.IsLetter(r) })
+	found := ""
+	for _, word := range words {
+		switch word {
+		case stateFried, stateBoiled:
+			return word
+		case stateRaw, "uncooked":
+			found = stateRaw
+		case stateCooked, "baked", "roasted", "grilled", "steamed", "stewed", "poached":
+			if found == "" {
+				found = stateCooked
+			}
+		}
+	}
+	return found
+}
+
+// yieldFactor is the weight of a food in a state per gram of it raw
+func yieldFactor(name, state string) (float64, bool) {
+	if state == stateRaw {
+		return 1, true
+	}
+	states := cfg.States.YieldFactors[name]
+	if factor, ok := states[state]; ok {
+		return factor, true
+	}
+	factor, ok := states[stateCooked]
+	return factor, ok
+}
+
+// stateYieldFactor is what the nutrients of the matched food are scaled by
+// for a gram of the food in the requested state: a gram of raw rice
+// becomes 2.6 g of cooked rice, so it holds the nutrients of 2.6 g of a
+// cooked rice description. It is 1 when the states agree or either is
+// unknown, or when the food has no yield factors.
+func stateYieldFactor(name, requested, description string) float64 {
+	described := describedState(description)
+	if requested == "" || described == "" || requested == described {
+		return 1
+	}
+	from, ok := yieldFactor(name, described)
+	if !ok {
+		return 1
+	}
+	to, ok := yieldFactor(name, requested)
+	if !ok {
+		return 1
+	}
+	return from / to
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestDescribedState(t *testing.T) {
+	tests := map[string]string{
+		"Rice, cooked, NFS":             stateCooked,
+		"Rice, white, raw":              stateRaw,
+		"Egg, whole, boiled or poached": stateBoiled,
+		"Chicken breast, roasted":       stateCooked,
+		"Potato, fried, from raw":       stateFried,
+		"Banana":                        "",
+	}
+	for description, want := range tests {
+		if got := describedState(description); got != want {
+			t.Errorf("describedState(%q) = %q, want %q", description, got, want)
+		}
+	}
+}
+
+func TestStateYieldFactor(t *testing.T) {
+	setupServer(t, "states:\n  yield_factors:\n    Quinoa:\n      cooked: 3\n")
+	tests := []struct {
+		name        string
+		food        string
+		requested   string
+		description string
+		want        float64
+	}{
+		{"no state requested", "rice", "", "Rice, cooked, NFS", 1},
+		{"same state", "rice", stateCooked, "Rice, cooked, NFS", 1},
+		{"raw from cooked", "rice", stateRaw, "Rice, cooked, NFS", 2.6},
+		{"cooked from raw", "rice", stateCooked, "Rice, white, raw", 1 / 2.6},
+		{"boiled falls back to cooked", "rice", stateBoiled, "Rice, white, raw", 1 / 2.6},
+		{"configured factor", "quinoa", stateRaw, "Quinoa, cooked", 3},
+		{"no yield factors", "banana", stateCooked, "Banana, raw", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stateYieldFactor(tt.food, tt.requested, tt.description); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("stateYieldFactor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateLookups(t *testing.T) {
+	router := setupServer(t, "")
+	useMappings(t, &fileMappings{path: writeConfig(t, "rice: Rice, cooked, NFS\n")})
+
+	response := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(
+		Volume{ObjectName: "rice", VolumeCups: 1},
+		Volume{ObjectName: "rice", VolumeCups: 1, State: stateRaw},
+	)), http.StatusOK)
+	cooked, raw := response.Data[0], response.Data[1]
+	if !cooked.Found || !raw.Found || cooked.YieldFactor != 0 || raw.YieldFactor != 2.6 || raw.State != stateRaw {
+		t.Fatalf("items = %+v, %+v; want raw rice scaled by 2.6", cooked, raw)
+	}
+	if want := cooked.Macros.times(2.6); !macrosNear(raw.Macros, want) {
+		t.Errorf("raw macros = %+v, want %+v", raw.Macros, want)
+	}
+
+	// A raw mapping of its own is looked up instead of converting
+	useMappings(t, &fileMappings{path: writeConfig(t, "rice: Rice, cooked, NFS\nrice:raw: Rice, white, raw\n")})
+	item := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(
+		Volume{ObjectName: "rice", VolumeCups: 1, State: stateRaw},
+	)), http.StatusOK).Data[0]
+	if !item.Found || item.YieldFactor != 0 || item.Description != "Rice, white, raw" {
+		t.Errorf("item = %+v, want raw rice without a yield factor", item)
+	}
+}
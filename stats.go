@@ -0,0 +1,23 @@
+// stats.go
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatsResponse reports the runtime state of the lookup pipeline
+type StatsResponse struct {
+	Breaker BreakerStats `json:"breaker"`
+	Cache   CacheStats   `json:"cache"`
+	Queries QueryStats   `json:"queries"`
+}
+
+func getStats(c *gin.Context) {
+	c.JSON(http.StatusOK, StatsResponse{
+		Breaker: foodBreaker.stats(),
+		Cache:   foodDataCache.snapshot(),
+		Queries: queryStats(),
+	})
+}
@@ -0,0 +1,388 @@
+// synonyms.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// SynonymsConfig controls the synonym dictionary, which translates object
+// names in other languages, such as the Tagalog "kanin", and aliases in any
+// language, such as "white rice", to the names the food mappings know
+// ("rice"). The built-in synonyms always apply; synonyms of its own need a
+// collection to keep them in.
+type SynonymsConfig struct {
+	Scope      string `yaml:"scope"`
+	Collection string `yaml:"collection"`
+	// DefaultLanguage is tried after the languages a request states with
+	// ?lang= or Accept-Language, e.g. "fil"
+	DefaultLanguage string `yaml:"default_language"`
+}
+
+func (s *SynonymsConfig) enabled() bool {
+	return s.Collection != ""
+}
+
+func (s *SynonymsConfig) validate() error {
+	s.DefaultLanguage = normalizeLanguage(s.DefaultLanguage)
+	if !s.enabled() {
+		return nil
+	}
+	if s.Scope == "" {
+		s.Scope = defaultKeyspaceName
+	}
+	for _, name := range []string{s.Scope, s.Collection} {
+		if name != defaultKeyspaceName && !keyspaceNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid keyspace name %q in synonyms config", name)
+		}
+	}
+	return nil
+}
+
+// aliasLanguage is the language of aliases, which apply whatever the
+// request's languages
+const aliasLanguage = "*"
+
+// FoodSynonym translates a term of a language, or an alias of any
+// language, to an object name
+type FoodSynonym struct {
+	Language   string `json:"language"`
+	Term       string `json:"term"`
+	ObjectName string `json:"object_name"`
+}
+
+// defaultSynonyms are the synonyms used until the dictionary holds its own
+// for the same language and term. Filipino is tagged both fil and tl.
+var defaultSynonyms = func() []FoodSynonym {
+	var synonyms []FoodSynonym
+	for term, objectName := range map[string]string{
+		"kanin":    "rice",
+		"itlog":    "egg",
+		"saging":   "banana",
+		"pipino":   "cucumber",
+		"mansanas": "apple",
+		"melon":    "sugar-melon",
+	} {
+		for _, language := range []string{"fil", "tl"} {
+			synonyms = append(synonyms, FoodSynonym{Language: language, Term: term, ObjectName: objectName})
+		}
+	}
+	for term, objectName := range map[string]string{
+		"eggs":            "egg",
+		"scrambled eggs":  "egg",
+		"boiled egg":      "egg",
+		"hard boiled egg": "egg",
+		"white rice":      "rice",
+		"steamed rice":    "rice",
+		"bananas":         "banana",
+		"cantaloupe":      "sugar-melon",
+	} {
+		synonyms = append(synonyms, FoodSynonym{Language: aliasLanguage, Term: term, ObjectName: objectName})
+	}
+	return synonyms
+}()
+
+// synonymParams reads the language and term of a synonym route; the alias
+// routes have no language
+func synonymParams(c *gin.Context) (language, term string) {
+	language = aliasLanguage
+	if lang := c.Param("lang"); lang != "" {
+		language = normalizeLanguage(lang)
+	}
+	return language, normalizeFoodName(c.Param("term"))
+}
+
+// synonymLabel names a synonym in messages
+func synonymLabel(language, term string) string {
+	if language == aliasLanguage {
+		return "alias " + term
+	}
+	return language + " synonym " + term
+}
+
+func normalizeLanguage(language string) string {
+	return strings.ToLower(strings.TrimSpace(language))
+}
+
+func synonymKey(language, term string) string {
+	return "synonym::" + language + "::" + term
+}
+
+// foodSynonyms caches the dictionary, built-in synonyms included, keyed by
+// synonymKey. Admin changes update the cache as they are stored.
+var foodSynonyms = struct {
+	sync.RWMutex
+	loaded  bool
+	entries map[string]FoodSynonym
+}{}
+
+// loadSynonyms returns the cached dictionary, reading it from Couchbase on
+// first use. The map must not be modified.
+func loadSynonyms(ctx context.Context) (map[string]FoodSynonym, error) {
+	foodSynonyms.RLock()
+	if foodSynonyms.loaded {
+		defer foodSynonyms.RUnlock()
+		return foodSynonyms.entries, nil
+	}
+	foodSynonyms.RUnlock()
+
+	foodSynonyms.Lock()
+	defer foodSynonyms.Unlock()
+	if err := loadSynonymsLocked(ctx); err != nil {
+		return nil, err
+	}
+	return foodSynonyms.entries, nil
+}
+
+func loadSynonymsLocked(ctx context.Context) error {
+	if foodSynonyms.loaded {
+		return nil
+	}
+	entries := make(map[string]FoodSynonym, len(defaultSynonyms))
+	for _, synonym := range defaultSynonyms {
+		entries[synonymKey(synonym.Language, synonym.Term)] = synonym
+	}
+	if db == nil || db.synonyms == nil {
+		foodSynonyms.entries = entries
+		foodSynonyms.loaded = true
+		return nil
+	}
+
+	result, err := runQuery(ctx, db.cluster, fmt.Sprintf(stmtSynonyms, db.synonymsKeyspace), nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load synonyms: %w", err)
+	}
+	defer result.Close()
+
+	stored := 0
+	for result.Next() {
+		var synonym FoodSynonym
+		if err := result.Row(&synonym); err != nil {
+			return fmt.Errorf("failed to decode synonym: %w", err)
+		}
+		entries[synonymKey(synonym.Language, synonym.Term)] = synonym
+		stored++
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("failed to load synonyms: %w", err)
+	}
+	slog.InfoContext(ctx, "loaded synonyms", "synonyms", stored)
+	foodSynonyms.entries = entries
+	foodSynonyms.loaded = true
+	return nil
+}
+
+// updateSynonyms stores or, with a nil synonym, removes a synonym through
+// store and then applies the change to the cache. A removed synonym falls
+// back to the built-in one of the same key, if any.
+func updateSynonyms(ctx context.Context, key string, synonym *FoodSynonym, store func() error) error {
+	foodSynonyms.Lock()
+	defer foodSynonyms.Unlock()
+	if err := loadSynonymsLocked(ctx); err != nil {
+		return err
+	}
+	if err := store(); err != nil {
+		return err
+	}
+
+	// The map is replaced, never modified, so readers can keep using theirs
+	entries := make(map[string]FoodSynonym, len(foodSynonyms.entries)+1)
+	for k, v := range foodSynonyms.entries {
+		if k != key {
+			entries[k] = v
+		}
+	}
+	if synonym == nil {
+		for _, builtin := range defaultSynonyms {
+			if synonymKey(builtin.Language, builtin.Term) == key {
+				synonym = &builtin
+			}
+		}
+	}
+	if synonym != nil {
+		entries[key] = *synonym
+	}
+	foodSynonyms.entries = entries
+	return nil
+}
+
+// synonymLanguages lists the languages object names of a request are
+// translated from, most preferred first: each requested language, then its
+// base language (fil-PH, then fil), then synonyms.default_language, and
+// last the aliases
+func synonymLanguages(languages []string) []string {
+	var tags []string
+	for _, language := range languages {
+		language = normalizeLanguage(language)
+		tags = append(tags, language)
+		if base, _, found := strings.Cut(language, "-"); found {
+			tags = append(tags, base)
+		}
+	}
+	if cfg.Synonyms.DefaultLanguage != "" {
+		tags = append(tags, cfg.Synonyms.DefaultLanguage)
+	}
+	return append(tags, aliasLanguage)
+}
+
+// findSynonym translates an object name from the first of the languages
+// that has a synonym for it. Failures to load are logged and treated as no
+// synonym, so the name is still looked up as is.
+func findSynonym(ctx context.Context, objectName string, languages []string) (FoodSynonym, bool) {
+	tags := synonymLanguages(languages)
+	synonyms, err := loadSynonyms(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load synonyms", "error", err)
+		return FoodSynonym{}, false
+	}
+	term := normalizeFoodName(objectName)
+	for _, language := range tags {
+		if synonym, ok := synonyms[synonymKey(language, term)]; ok {
+			return synonym, true
+		}
+	}
+	return FoodSynonym{}, false
+}
+
+// translate replaces the object name of a volume by the name its synonym
+// stands for, if it has one in the request's languages
+func (cc *calcContext) translate(volume Volume) Volume {
+	if synonym, ok := findSynonym(cc.ctx, volume.ObjectName, cc.languages); ok {
+		slog.DebugContext(cc.ctx, "translated object name", "term", volume.ObjectName, "language", synonym.Language, "object_name", synonym.ObjectName)
+		volume.ObjectName = synonym.ObjectName
+	}
+	return volume
+}
+
+func requireSynonyms(c *gin.Context) bool {
+	if db.synonyms == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "the synonym dictionary is not enabled"})
+		return false
+	}
+	return true
+}
+
+var synonymFields = listSpec[FoodSynonym]{
+	fields: map[string]listField[FoodSynonym]{
+		"language":    {value: func(s FoodSynonym) any { return s.Language }, text: true},
+		"term":        {value: func(s FoodSynonym) any { return s.Term }, text: true},
+		"object_name": {value: func(s FoodSynonym) any { return s.ObjectName }, text: true},
+	},
+	key:         func(s FoodSynonym) string { return synonymKey(s.Language, s.Term) },
+	defaultSort: "language",
+}
+
+// listSynonyms returns a page of the dictionary, built-in synonyms
+// included, sorted by language and term unless ?sort= says otherwise;
+// ?lang= limits it to one language
+func listSynonyms(c *gin.Context) {
+	q, err := parseListQuery(c, synonymFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entries, err := loadSynonyms(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to load synonyms", err)
+		return
+	}
+
+	language := normalizeLanguage(c.Query("lang"))
+	synonyms := make([]FoodSynonym, 0, len(entries))
+	for _, synonym := range entries {
+		if language == "" || synonym.Language == language {
+			synonyms = append(synonyms, synonym)
+		}
+	}
+	synonyms, page := paginate(synonyms, q, synonymFields)
+	c.JSON(http.StatusOK, page.response("synonyms", synonyms))
+}
+
+func getSynonym(c *gin.Context) {
+	language, term := synonymParams(c)
+	entries, err := loadSynonyms(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to load synonyms", err)
+		return
+	}
+	synonym, ok := entries[synonymKey(language, term)]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no %s", synonymLabel(language, term))})
+		return
+	}
+	c.JSON(http.StatusOK, synonym)
+}
+
+// putSynonym creates or replaces the synonym of a term in a language, or
+// an alias
+func putSynonym(c *gin.Context) {
+	if !requireSynonyms(c) {
+		return
+	}
+	var request struct {
+		ObjectName string `json:"object_name" binding:"required"`
+	}
+	if err := bindJSON(c, &request); err != nil {
+		respondInvalid(c, err)
+		return
+	}
+	language, term := synonymParams(c)
+	synonym := FoodSynonym{Language: language, Term: term, ObjectName: normalizeFoodName(request.ObjectName)}
+	if synonym.Language == "" || synonym.Term == "" || synonym.ObjectName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "language, term and object name must not be empty"})
+		return
+	}
+	if synonym.Term == synonym.ObjectName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a term can't be its own synonym"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := synonymKey(synonym.Language, synonym.Term)
+	err := updateSynonyms(ctx, key, &synonym, func() error {
+		_, err := db.synonyms.Upsert(key, synonym, &gocb.UpsertOptions{Context: ctx})
+		return err
+	})
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to store synonym", err)
+		return
+	}
+	c.JSON(http.StatusOK, synonym)
+}
+
+// deleteSynonym removes a stored synonym. Built-in synonyms can't be
+// removed, only overridden.
+func deleteSynonym(c *gin.Context) {
+	if !requireSynonyms(c) {
+		return
+	}
+	language, term := synonymParams(c)
+	ctx := c.Request.Context()
+	key := synonymKey(language, term)
+	removed := true
+	err := updateSynonyms(ctx, key, nil, func() error {
+		_, err := db.synonyms.Remove(key, &gocb.RemoveOptions{Context: ctx})
+		if errors.Is(err, gocb.ErrDocumentNotFound) {
+			removed = false
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		respondError(c, http.StatusBadGateway, "failed to delete synonym", err)
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no stored %s", synonymLabel(language, term))})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
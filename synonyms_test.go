@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestFindSynonym(t *testing.T) {
+	setupServer(t, "synonyms:\n  default_language: FIL\n")
+	tests := []struct {
+		name      string
+		term      string
+		languages []string
+		want      string
+	}{
+		{"requested language", "Kanin", []string{"tl"}, "rice"},
+		{"regional tag falls back to its base", "itlog", []string{"fil-PH"}, "egg"},
+		{"default language", "saging", []string{"es"}, "banana"},
+		{"alias of any language", "White Rice", nil, "rice"},
+		{"no synonym", "rice", []string{"fil"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synonym, ok := findSynonym(context.Background(), tt.term, tt.languages)
+			if ok != (tt.want != "") || synonym.ObjectName != tt.want {
+				t.Errorf("findSynonym(%q, %v) = %+v, %v; want %q", tt.term, tt.languages, synonym, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslatedObjectNames(t *testing.T) {
+	router := setupServer(t, "")
+	useMappings(t, &fileMappings{path: writeConfig(t, "rice: Rice, cooked, NFS\n")})
+	request := volumes(Volume{ObjectName: "kanin", VolumeCups: 1})
+
+	item := decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", request, "Accept-Language", "fil-PH, en;q=0.5"), http.StatusOK).Data[0]
+	if !item.Found || item.RequestedFood != "kanin" || item.ResolvedName != "rice" {
+		t.Errorf("item = %+v, want kanin resolved to rice", item)
+	}
+	item = decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros?lang=tl", request), http.StatusOK).Data[0]
+	if !item.Found || item.ResolvedName != "rice" {
+		t.Errorf("item = %+v with ?lang=tl, want kanin resolved to rice", item)
+	}
+	// Without a language, kanin is just an unknown English name
+	item = decode[MacroResponse](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", request), http.StatusOK).Data[0]
+	if item.ResolvedName != "" {
+		t.Errorf("item = %+v without a language, want it untranslated", item)
+	}
+}
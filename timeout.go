@@ -0,0 +1,73 @@
+// timeout.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TimeoutConfig bounds how long a request may take. Routes are keyed by the
+// path they are registered under, e.g. "/v1/calculate-macros"; routes not
+// listed get Default, and a zero duration means no deadline.
+type TimeoutConfig struct {
+	Default time.Duration            `yaml:"default"`
+	Routes  map[string]time.Duration `yaml:"routes"`
+}
+
+func (t *TimeoutConfig) validate() error {
+	if t.Default < 0 {
+		return errors.New("server.timeouts.default must not be negative")
+	}
+	for route, timeout := range t.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("invalid server.timeouts route %q: expected a path starting with /", route)
+		}
+		if timeout < 0 {
+			return fmt.Errorf("server.timeouts for %s must not be negative", route)
+		}
+	}
+	return nil
+}
+
+func (t *TimeoutConfig) forRoute(route string) time.Duration {
+	if timeout, ok := t.Routes[route]; ok {
+		return timeout
+	}
+	return t.Default
+}
+
+// routeTimeout puts the route's configured deadline on the request context,
+// which the Couchbase lookups run under
+func routeTimeout(c *gin.Context) {
+	timeout := cfg.Server.Timeouts.forRoute(c.FullPath())
+	if timeout <= 0 {
+		c.Next()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	if !c.Writer.Written() {
+		timedOut(c)
+	}
+}
+
+// timedOut answers 504 when the request's deadline passed while it was
+// being handled; handlers check it before writing partial results
+func timedOut(c *gin.Context) bool {
+	if !errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+		return false
+	}
+	c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+	return true
+}
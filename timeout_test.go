@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// slowRepo delays every lookup and search, giving up when the request's
+// deadline passes as the Couchbase SDK does
+type slowRepo struct {
+	FoodRepository
+	delay time.Duration
+}
+
+func (r slowRepo) wait(ctx context.Context) error {
+	select {
+	case <-time.After(r.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r slowRepo) GetByDescription(ctx context.Context, dataset string, terms []string, version string, stats *requestStats) (map[string][]FoodData, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.FoodRepository.GetByDescription(ctx, dataset, terms, version, stats)
+}
+
+func (r slowRepo) Search(ctx context.Context, dataset string, words []string, after, limit int) ([]FoodData, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.FoodRepository.Search(ctx, dataset, words, after, limit)
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	// Lookups take 50ms; search gets a longer budget than calculate-macros
+	const timeouts = "server:\n  timeouts:\n    default: 10ms\n    routes:\n      /v1/foods/search: 500ms\n      /v1/calculate-macros: 20ms\n"
+	calculate := func(router http.Handler) int {
+		return doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: "rice", VolumeCups: 1})).Code
+	}
+	search := func(router http.Handler) int {
+		return doRequest(t, router, http.MethodGet, "/v1/foods/search?q=rice", nil).Code
+	}
+	tests := []struct {
+		name       string
+		config     string
+		request    func(http.Handler) int
+		wantStatus int
+	}{
+		{"calculate-macros over its timeout", timeouts, calculate, http.StatusGatewayTimeout},
+		{"search within its longer timeout", timeouts, search, http.StatusOK},
+		{"search over the default timeout", "server:\n  timeouts:\n    default: 20ms\n", search, http.StatusGatewayTimeout},
+		{"calculate-macros with a generous timeout", "server:\n  timeouts:\n    routes:\n      /v1/calculate-macros: 500ms\n", calculate, http.StatusOK},
+		{"no timeout configured", "", calculate, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupServer(t, tt.config)
+			foodRepo = slowRepo{FoodRepository: foodRepo, delay: 50 * time.Millisecond}
+			if status := tt.request(router); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestInvalidTimeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts TimeoutConfig
+	}{
+		{"negative default", TimeoutConfig{Default: -time.Second}},
+		{"negative route timeout", TimeoutConfig{Routes: map[string]time.Duration{"/v1/foods/search": -time.Second}}},
+		{"route without a leading slash", TimeoutConfig{Routes: map[string]time.Duration{"v1/foods/search": time.Second}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.timeouts.validate(); err == nil {
+				t.Error("validate() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// tls.go
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"sync"
+	"time"
+)
+
+// Client certificate policies selectable with server.tls.client_auth
+const (
+	clientAuthNone     = "none"
+	clientAuthOptional = "optional"
+	clientAuthRequire  = "require"
+)
+
+// TLSConfig serves HTTPS (and TLS gRPC) when CertFile and KeyFile are set.
+// Rotated certificates are picked up without a restart: the files are
+// checked for changes at most every ReloadInterval (default 1m) as clients
+// connect.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile verifies client certificates against these CAs, for the
+	// mutually authenticated link from the vision service
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuth is "require" (default with a client CA) to reject clients
+	// without a valid certificate, "optional" to verify one only when
+	// presented, or "none"
+	ClientAuth     string        `yaml:"client_auth"`
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+func (t *TLSConfig) enabled() bool {
+	return t.CertFile != ""
+}
+
+func (t *TLSConfig) validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("server.tls: set both cert_file and key_file")
+	}
+	if !t.enabled() {
+		if t.ClientCAFile != "" {
+			return errors.New("server.tls.client_ca_file needs cert_file and key_file")
+		}
+		return nil
+	}
+	switch t.ClientAuth {
+	case "":
+		t.ClientAuth = clientAuthNone
+		if t.ClientCAFile != "" {
+			t.ClientAuth = clientAuthRequire
+		}
+	case clientAuthNone:
+	case clientAuthOptional, clientAuthRequire:
+		if t.ClientCAFile == "" {
+			return fmt.Errorf("server.tls.client_auth %q needs client_ca_file", t.ClientAuth)
+		}
+	default:
+		return fmt.Errorf("invalid server.tls.client_auth %q: expected none, optional or require", t.ClientAuth)
+	}
+	if t.ReloadInterval == 0 {
+		t.ReloadInterval = time.Minute
+	}
+	if t.ReloadInterval < 0 {
+		return errors.New("server.tls.reload_interval must be positive")
+	}
+	return nil
+}
+
+// certReloader hands out the TLS config of the current certificate files,
+// reloading them when they change. A rotation that fails to load is logged
+// and the previous certificate kept.
+type certReloader struct {
+	config TLSConfig
+
+	mu      sync.Mutex
+	checked time.Time
+	stamps  map[string]string
+	current *tls.Config
+}
+
+func newCertReloader(config TLSConfig) (*certReloader, error) {
+	r := &certReloader{config: config}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+// tlsConfig is the config servers are started with; every handshake gets
+// the config of the current certificates
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.get(), nil
+		},
+	}
+}
+
+func (r *certReloader) get() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= r.config.ReloadInterval {
+		r.checked = time.Now()
+		if !maps.Equal(fileStamps(r.paths()...), r.stamps) {
+			if err := r.load(); err != nil {
+				slog.Error("failed to reload TLS certificates, keeping the current ones", "error", err)
+			} else {
+				slog.Info("reloaded TLS certificates", "cert_file", r.config.CertFile)
+			}
+		}
+	}
+	return r.current
+}
+
+func (r *certReloader) load() error {
+	stamps := fileStamps(r.paths()...)
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	// The config replaces the servers' own, so it offers HTTP/2, which gRPC
+	// requires, itself
+	config := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2", "http/1.1"}}
+	if r.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in client CA file %s", r.config.ClientCAFile)
+		}
+		config.ClientCAs = pool
+	}
+	switch r.config.ClientAuth {
+	case clientAuthRequire:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case clientAuthOptional:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	r.current = config
+	r.stamps = stamps
+	return nil
+}
+
+func (r *certReloader) paths() []string {
+	return []string{r.config.CertFile, r.config.KeyFile, r.config.ClientCAFile}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, signed by parent or self-signed
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write stores the certificate and key as PEM files in dir
+func (c *testCert) write(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestTLSConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  TLSConfig
+		wantErr bool
+		want    string
+	}{
+		{"disabled", TLSConfig{}, false, ""},
+		{"cert without key", TLSConfig{CertFile: "cert.pem"}, true, ""},
+		{"client CA without TLS", TLSConfig{ClientCAFile: "ca.pem"}, true, ""},
+		{"no client certificates by default", TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}, false, clientAuthNone},
+		{"client CA requires certificates", TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientCAFile: "ca.pem"}, false, clientAuthRequire},
+		{"optional needs a client CA", TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuth: clientAuthOptional}, true, ""},
+		{"unknown client auth", TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuth: "always"}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && tt.config.ClientAuth != tt.want {
+				t.Errorf("client_auth = %q, want %q", tt.config.ClientAuth, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "test CA", nil, true)
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "server", ca, false).write(t, dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := newCertReloader(config)
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = certs.tlsConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certificates ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates}}}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err := get(); err == nil {
+		t.Error("request without a client certificate succeeded, want it rejected")
+	}
+	if err := get(newTestCert(t, "stranger", nil, false).tlsCertificate()); err == nil {
+		t.Error("request with an unknown client certificate succeeded, want it rejected")
+	}
+	if err := get(newTestCert(t, "vision", ca, false).tlsCertificate()); err != nil {
+		t.Errorf("request with a client certificate of the CA failed: %v", err)
+	}
+}
+
+func TestCertRotation(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestCert(t, "first", nil, false)
+	certFile, keyFile := first.write(t, dir)
+	certs, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+	served := func() string {
+		leaf, err := x509.ParseCertificate(certs.get().Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	newTestCert(t, "second", nil, false).write(t, dir)
+	if got := served(); got != "second" {
+		t.Errorf("served %q after rotation, want second", got)
+	}
+
+	// A broken rotation keeps the last good certificate
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := served(); got != "second" {
+		t.Errorf("served %q after a broken rotation, want second kept", got)
+	}
+}
@@ -0,0 +1,37 @@
+// units.go
+package main
+
+// Units accepted in Volume.Unit. Volume units are converted to cups; grams
+// skip the density lookup entirely. Adding one means adding it to the
+// oneof binding of Volume.Unit too.
+const (
+	unitCups  = "cups"
+	unitML    = "ml"
+	unitGrams = "g"
+	unitTbsp  = "tbsp"
+	unitTsp   = "tsp"
+	unitFlOz  = "fl_oz"
+)
+
+// cupsPerUnit converts the volume units to US cups
+var cupsPerUnit = map[string]float64{
+	unitCups: 1,
+	unitML:   1 / mlPerCup,
+	unitTbsp: 1.0 / 16,
+	unitTsp:  1.0 / 48,
+	unitFlOz: 1.0 / 8,
+}
+
+// applyUnit converts volume_cups and uncertainty_cups from the volume's unit
+// to cups. Weights stay as they are and mark the volume as byWeight, the
+// fields then holding grams.
+func (v *Volume) applyUnit() {
+	if v.Unit == unitGrams {
+		v.byWeight = true
+		return
+	}
+	if factor, ok := cupsPerUnit[v.Unit]; ok {
+		v.VolumeCups *= factor
+		v.UncertaintyCups *= factor
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVolumeUnits(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount      float64
+		unit        string
+		wantGrams   float64
+		wantQuality string
+	}{
+		{"cups by default", 1, "", 158, matchExactCup},
+		{"cups", 0.5, unitCups, 79, matchExactCup},
+		{"milliliters", 236.5882365, unitML, 158, matchExactCup},
+		{"tablespoons", 8, unitTbsp, 79, matchExactCup},
+		{"teaspoons", 48, unitTsp, 158, matchExactCup},
+		{"fluid ounces", 4, unitFlOz, 79, matchExactCup},
+		{"grams skip the density", 100, unitGrams, 100, matchWeight},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, "")
+			cacheTestFoods(t, config.DefaultDataset)
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: "rice", VolumeCups: tt.amount, Unit: tt.unit}))
+			response := decode[MacroResponse](t, w, http.StatusOK)
+			got := response.Data[0]
+			if math.Abs(got.CalculatedWeight-tt.wantGrams) > 1e-9 || got.MatchQuality != tt.wantQuality {
+				t.Errorf("weight = %v (%s), want %v (%s)", got.CalculatedWeight, got.MatchQuality, tt.wantGrams, tt.wantQuality)
+			}
+			if got.RequestedVolume != tt.amount || got.RequestedUnit != tt.unit {
+				t.Errorf("requested = %v %q, want the amount as sent", got.RequestedVolume, got.RequestedUnit)
+			}
+			if wantCarbs := tt.wantGrams * 28 / 100; math.Abs(got.Macros.Carbs-wantCarbs) > 1e-9 {
+				t.Errorf("carbs = %v, want %v", got.Macros.Carbs, wantCarbs)
+			}
+		})
+	}
+}
+
+func TestInvalidUnit(t *testing.T) {
+	testConfig(t, "")
+	router := gin.New()
+	router.POST("/v1/calculate-macros", calculateMacros)
+
+	w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", volumes(Volume{ObjectName: "rice", VolumeCups: 1, Unit: "pints"}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+}
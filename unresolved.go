@@ -0,0 +1,266 @@
+// unresolved.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// UnresolvedFoodsConfig persists the unresolved object names, so the
+// report covers every instance and survives restarts. Counts are kept in
+// memory and added to the collection every FlushInterval (default 30s).
+// Without a collection the report covers this instance since startup.
+type UnresolvedFoodsConfig struct {
+	Scope         string        `yaml:"scope"`
+	Collection    string        `yaml:"collection"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+func (u *UnresolvedFoodsConfig) enabled() bool {
+	return u.Collection != ""
+}
+
+func (u *UnresolvedFoodsConfig) validate() error {
+	if !u.enabled() {
+		return nil
+	}
+	if u.Scope == "" {
+		u.Scope = defaultKeyspaceName
+	}
+	for _, name := range []string{u.Scope, u.Collection} {
+		if name != defaultKeyspaceName && !keyspaceNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid keyspace name %q in unresolved_foods config", name)
+		}
+	}
+	if u.FlushInterval == 0 {
+		u.FlushInterval = 30 * time.Second
+	}
+	if u.FlushInterval < 0 {
+		return fmt.Errorf("unresolved_foods.flush_interval must be positive")
+	}
+	return nil
+}
+
+// maxUnresolvedNames bounds the names tracked; once reached, the least
+// recently seen name makes room for a new one
+const maxUnresolvedNames = 1000
+
+// UnresolvedFood is an object name that failed to resolve, as seen in
+// traffic
+type UnresolvedFood struct {
+	ObjectName string    `json:"object_name"`
+	Count      int       `json:"count"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	// ErrorCode is the error of the last failure, e.g. UNKNOWN_FOOD for a
+	// name that needs a mapping or synonym, NO_PORTION_DATA for a food
+	// that needs a density
+	ErrorCode string `json:"error_code"`
+}
+
+func unresolvedKey(name string) string {
+	return "unresolved::" + name
+}
+
+// unresolvedFoods tracks the object names that failed to resolve since
+// startup. pending holds the failures not yet added to the collection.
+var unresolvedFoods = struct {
+	sync.Mutex
+	entries map[string]*UnresolvedFood
+	pending map[string]*UnresolvedFood
+}{entries: make(map[string]*UnresolvedFood), pending: make(map[string]*UnresolvedFood)}
+
+// recordUnresolved counts a failed item. Database failures say nothing
+// about the name, so they aren't counted.
+func recordUnresolved(item MacroData) {
+	if item.Found || item.ErrorCode == "" || item.ErrorCode == errorCodeDatabase {
+		return
+	}
+	name := normalizeFoodName(item.RequestedFood)
+	now := time.Now().UTC()
+
+	unresolvedFoods.Lock()
+	defer unresolvedFoods.Unlock()
+	entry, ok := unresolvedFoods.entries[name]
+	if !ok {
+		if len(unresolvedFoods.entries) >= maxUnresolvedNames {
+			evictUnresolvedLocked()
+		}
+		entry = &UnresolvedFood{ObjectName: name, FirstSeen: now}
+		unresolvedFoods.entries[name] = entry
+	}
+	entry.Count++
+	entry.LastSeen = now
+	entry.ErrorCode = item.ErrorCode
+
+	if db == nil || db.unresolved == nil {
+		return
+	}
+	pending, ok := unresolvedFoods.pending[name]
+	if !ok {
+		pending = &UnresolvedFood{ObjectName: name, FirstSeen: now}
+		unresolvedFoods.pending[name] = pending
+	}
+	pending.Count++
+	pending.LastSeen = now
+	pending.ErrorCode = item.ErrorCode
+}
+
+// flushUnresolvedEvery adds the pending failures to the collection every
+// interval
+func flushUnresolvedEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		flushUnresolved(ctx)
+		cancel()
+	}
+}
+
+// flushUnresolved adds the pending failures to the collection. A name's
+// first failure creates its document; later ones add to the count. Failed
+// writes are logged and their counts kept for the next flush.
+func flushUnresolved(ctx context.Context) {
+	unresolvedFoods.Lock()
+	pending := unresolvedFoods.pending
+	unresolvedFoods.pending = make(map[string]*UnresolvedFood)
+	unresolvedFoods.Unlock()
+
+	failed := 0
+	for name, food := range pending {
+		if err := storeUnresolved(ctx, *food); err != nil {
+			slog.WarnContext(ctx, "failed to store unresolved food", "food", name, "error", err)
+			failed++
+			unresolvedFoods.Lock()
+			if again, ok := unresolvedFoods.pending[name]; ok {
+				food.Count += again.Count
+				food.LastSeen = again.LastSeen
+				food.ErrorCode = again.ErrorCode
+			}
+			unresolvedFoods.pending[name] = food
+			unresolvedFoods.Unlock()
+		}
+	}
+	if len(pending) > 0 {
+		slog.DebugContext(ctx, "flushed unresolved foods", "foods", len(pending), "failed", failed)
+	}
+}
+
+func storeUnresolved(ctx context.Context, food UnresolvedFood) error {
+	key := unresolvedKey(food.ObjectName)
+	_, err := db.unresolved.Insert(key, food, &gocb.InsertOptions{Context: ctx})
+	if !errors.Is(err, gocb.ErrDocumentExists) {
+		return err
+	}
+	_, err = db.unresolved.MutateIn(key, []gocb.MutateInSpec{
+		gocb.IncrementSpec("count", int64(food.Count), nil),
+		gocb.UpsertSpec("last_seen", food.LastSeen, nil),
+		gocb.UpsertSpec("error_code", food.ErrorCode, nil),
+	}, &gocb.MutateInOptions{Context: ctx})
+	return err
+}
+
+func evictUnresolvedLocked() {
+	var oldest *UnresolvedFood
+	for _, entry := range unresolvedFoods.entries {
+		if oldest == nil || entry.LastSeen.Before(oldest.LastSeen) {
+			oldest = entry
+		}
+	}
+	if oldest != nil {
+		delete(unresolvedFoods.entries, oldest.ObjectName)
+	}
+}
+
+var unresolvedFoodFields = listSpec[UnresolvedFood]{
+	fields: map[string]listField[UnresolvedFood]{
+		"object_name": {value: func(u UnresolvedFood) any { return u.ObjectName }, text: true, column: "u.object_name"},
+		"error_code":  {value: func(u UnresolvedFood) any { return u.ErrorCode }, text: true, column: "u.error_code"},
+		"count":       {value: func(u UnresolvedFood) any { return u.Count }, column: "u.`count`"},
+		"first_seen":  {value: func(u UnresolvedFood) any { return u.FirstSeen }, column: "STR_TO_MILLIS(u.first_seen)"},
+		"last_seen":   {value: func(u UnresolvedFood) any { return u.LastSeen }, column: "STR_TO_MILLIS(u.last_seen)"},
+	},
+	key:         func(u UnresolvedFood) string { return u.ObjectName },
+	keyColumn:   "u.object_name",
+	defaultSort: "-count",
+}
+
+// listUnresolvedFoods reports the unresolved object names, most frequent
+// first, so the next synonyms, mappings and densities to add are the top
+// ones. With a collection it covers every instance up to their last
+// flush. The total is always reported.
+func listUnresolvedFoods(c *gin.Context) {
+	q, err := parseListQuery(c, unresolvedFoodFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	q.total = true
+
+	if db.unresolved != nil {
+		foods, page, err := queryUnresolvedFoods(c.Request.Context(), q)
+		if err != nil {
+			if timedOut(c) {
+				return
+			}
+			respondError(c, http.StatusBadGateway, "failed to load unresolved foods", err)
+			return
+		}
+		c.JSON(http.StatusOK, page.response("unresolved_foods", foods))
+		return
+	}
+
+	unresolvedFoods.Lock()
+	foods := make([]UnresolvedFood, 0, len(unresolvedFoods.entries))
+	for _, entry := range unresolvedFoods.entries {
+		foods = append(foods, *entry)
+	}
+	unresolvedFoods.Unlock()
+
+	foods, page := paginate(foods, q, unresolvedFoodFields)
+	c.JSON(http.StatusOK, page.response("unresolved_foods", foods))
+}
+
+// queryUnresolvedFoods reads a page of the unresolved object names and how
+// many match from the collection
+func queryUnresolvedFoods(ctx context.Context, q listQuery) ([]UnresolvedFood, listPage, error) {
+	var page listPage
+	filters, cursor, orderLimit, args := n1qlClauses(q, unresolvedFoodFields, 1)
+	query := fmt.Sprintf(stmtUnresolvedFoods, db.unresolvedKeyspace, filters, cursor, orderLimit)
+	result, err := runQuery(ctx, db.cluster, query, args, nil)
+	if err != nil {
+		return nil, page, err
+	}
+	foods := []UnresolvedFood{}
+	for result.Next() {
+		var food UnresolvedFood
+		if err := result.Row(&food); err != nil {
+			result.Close()
+			return nil, page, fmt.Errorf("failed to decode unresolved food: %w", err)
+		}
+		foods = append(foods, food)
+	}
+	if err := result.Close(); err != nil {
+		return nil, page, err
+	}
+	foods, page.NextCursor = nextPage(foods, q, unresolvedFoodFields)
+
+	query = fmt.Sprintf(stmtCountUnresolvedFoods, db.unresolvedKeyspace, filters)
+	result, err = runQuery(ctx, db.cluster, query, args[:len(q.filters)], nil)
+	if err != nil {
+		return nil, page, err
+	}
+	var total int
+	if err := result.One(&total); err != nil {
+		return nil, page, err
+	}
+	page.Total = &total
+	return foods, page, nil
+}
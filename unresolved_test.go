@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// resetUnresolved starts the test without any unresolved foods recorded
+func resetUnresolved(t *testing.T) {
+	t.Helper()
+	unresolvedFoods.Lock()
+	entries, pending := unresolvedFoods.entries, unresolvedFoods.pending
+	unresolvedFoods.entries = make(map[string]*UnresolvedFood)
+	unresolvedFoods.pending = make(map[string]*UnresolvedFood)
+	unresolvedFoods.Unlock()
+	t.Cleanup(func() {
+		unresolvedFoods.Lock()
+		unresolvedFoods.entries, unresolvedFoods.pending = entries, pending
+		unresolvedFoods.Unlock()
+	})
+}
+
+func TestUnresolvedFoods(t *testing.T) {
+	router := setupServer(t, "admin:\n  token: s3cret\n")
+	useMappings(t, &fileMappings{path: writeConfig(t, "rice: Rice, cooked, NFS\ncucumber: Cucumber, raw\n")})
+	resetUnresolved(t)
+
+	request := volumes(
+		Volume{ObjectName: "dragonfruit", VolumeCups: 1},
+		Volume{ObjectName: "Dragonfruit", VolumeCups: 1},
+		Volume{ObjectName: "cucumber", VolumeCups: 1},
+		Volume{ObjectName: "rice", VolumeCups: 1},
+	)
+	doRequest(t, router, http.MethodPost, "/v1/calculate-macros", request)
+
+	report := decode[struct {
+		UnresolvedFoods []UnresolvedFood `json:"unresolved_foods"`
+		Total           int              `json:"total"`
+	}](t, doRequest(t, router, http.MethodGet, "/v1/admin/unresolved-foods", nil, "Authorization", "Bearer s3cret"), http.StatusOK)
+	if report.Total != 2 || len(report.UnresolvedFoods) != 2 {
+		t.Fatalf("report = %+v, want dragonfruit and cucumber", report)
+	}
+	if got := report.UnresolvedFoods[0]; got.ObjectName != "dragonfruit" || got.Count != 2 || got.ErrorCode != errorCodeUnknownFood {
+		t.Errorf("most frequent = %+v, want dragonfruit seen twice", got)
+	}
+	if got := report.UnresolvedFoods[1]; got.ObjectName != "cucumber" || got.ErrorCode != errorCodeNoPortionData {
+		t.Errorf("second = %+v, want cucumber without portion data", got)
+	}
+
+	if w := doRequest(t, router, http.MethodGet, "/v1/admin/unresolved-foods?limit=0", nil, "Authorization", "Bearer s3cret"); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d with limit=0, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestUnresolvedFoodsConfig(t *testing.T) {
+	config := UnresolvedFoodsConfig{Collection: "unresolved"}
+	if err := config.validate(); err != nil || config.Scope != defaultKeyspaceName || config.FlushInterval != 30*time.Second {
+		t.Errorf("validate() = %v with %+v, want the defaults filled in", err, config)
+	}
+	for _, config := range []UnresolvedFoodsConfig{
+		{Collection: "un-resolved!"},
+		{Collection: "unresolved", FlushInterval: -time.Second},
+	} {
+		if err := config.validate(); err == nil {
+			t.Errorf("validate() of %+v succeeded, want an error", config)
+		}
+	}
+}
+
+func TestUnresolvedFoodsPending(t *testing.T) {
+	previous := db
+	t.Cleanup(func() { db = previous })
+	resetUnresolved(t)
+	failed := MacroData{RequestedFood: "Dragonfruit", ErrorCode: errorCodeUnknownFood}
+
+	// Without a collection nothing waits to be flushed
+	db = &Database{}
+	recordUnresolved(failed)
+	if len(unresolvedFoods.pending) != 0 {
+		t.Fatalf("pending = %v without a collection, want none", unresolvedFoods.pending)
+	}
+
+	db = &Database{unresolved: &gocb.Collection{}}
+	recordUnresolved(failed)
+	recordUnresolved(failed)
+	recordUnresolved(MacroData{RequestedFood: "rice", ErrorCode: errorCodeDatabase})
+	pending := unresolvedFoods.pending
+	if len(pending) != 1 || pending["dragonfruit"] == nil || pending["dragonfruit"].Count != 2 {
+		t.Errorf("pending = %v, want dragonfruit twice", pending)
+	}
+	if entry := unresolvedFoods.entries["dragonfruit"]; entry == nil || entry.Count != 3 {
+		t.Errorf("entry = %+v, want every failure since startup", entry)
+	}
+}
@@ -0,0 +1,190 @@
+// validation.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// frameIDPattern is the format of client-chosen frame IDs, which become
+// part of document keys
+var frameIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`)
+
+// Codes of field errors
+const (
+	fieldCodeRequired     = "REQUIRED"
+	fieldCodeInvalidJSON  = "INVALID_JSON"
+	fieldCodeInvalidType  = "INVALID_TYPE"
+	fieldCodeInvalidValue = "INVALID_VALUE"
+	fieldCodeInvalidFmt   = "INVALID_FORMAT"
+	fieldCodeNotPositive  = "MUST_BE_POSITIVE"
+	fieldCodeNegative     = "MUST_NOT_BE_NEGATIVE"
+	fieldCodeTooMany      = "TOO_MANY_ITEMS"
+	fieldCodeTooLong      = "TOO_LONG"
+	fieldCodeOutOfRange   = "OUT_OF_RANGE"
+)
+
+// FieldError is one problem with a request body. Field is the JSON path of
+// the value, e.g. data.volumes[2].volume_cups, and empty when the body as a
+// whole is at fault.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists what is wrong with a request body
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		if f.Field == "" {
+			messages = append(messages, f.Message)
+		} else {
+			messages = append(messages, f.Field+" "+f.Message)
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// invalidField returns a ValidationError for a single field
+func invalidField(field, code, format string, args ...any) error {
+	return &ValidationError{Fields: []FieldError{{Field: field, Code: code, Message: fmt.Sprintf(format, args...)}}}
+}
+
+// requestValidator is gin's validator, set up on first use to name fields
+// by their JSON names and to know the repo's own tags
+var requestValidator = sync.OnceValue(func() *validator.Validate {
+	v := binding.Validator.Engine().(*validator.Validate)
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterValidation("frame_id", func(fl validator.FieldLevel) bool {
+		return frameIDPattern.MatchString(fl.Field().String())
+	})
+	return v
+})
+
+// validateRequest checks v against its binding tags
+func validateRequest(v any) error {
+	err := requestValidator().Struct(v)
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	invalid := &ValidationError{Fields: make([]FieldError, 0, len(errs))}
+	for _, fe := range errs {
+		// The namespace starts with the Go type name, e.g. VolumeRequest.data
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		code, message := describeFieldError(fe)
+		invalid.Fields = append(invalid.Fields, FieldError{Field: field, Code: code, Message: message})
+	}
+	return invalid
+}
+
+func describeFieldError(fe validator.FieldError) (code, message string) {
+	switch fe.Tag() {
+	case "required":
+		return fieldCodeRequired, "is required"
+	case "gt":
+		if fe.Param() == "0" {
+			return fieldCodeNotPositive, "must be positive"
+		}
+		return fieldCodeOutOfRange, "must be greater than " + fe.Param()
+	case "gte":
+		if fe.Param() == "0" {
+			return fieldCodeNegative, "must not be negative"
+		}
+		return fieldCodeOutOfRange, "must be at least " + fe.Param()
+	case "max":
+		switch fe.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return fieldCodeTooMany, fmt.Sprintf("must have at most %s items", fe.Param())
+		case reflect.String:
+			return fieldCodeTooLong, fmt.Sprintf("must be at most %s characters", fe.Param())
+		}
+		return fieldCodeOutOfRange, "must be at most " + fe.Param()
+	case "oneof":
+		return fieldCodeInvalidValue, "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "frame_id":
+		return fieldCodeInvalidFmt, "must be 1 to 128 letters, digits, '.', '_', ':' or '-', starting with a letter or digit"
+	}
+	return fieldCodeInvalidValue, "is invalid (" + fe.Tag() + ")"
+}
+
+// decodeError describes why a body couldn't be decoded, naming the field
+// where encoding/json can tell
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return invalidField("", fieldCodeInvalidJSON, "malformed JSON at offset %d: %v", syntaxErr.Offset, syntaxErr)
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return invalidField("", fieldCodeInvalidType, "must be a JSON %s", jsonKind(typeErr.Type))
+		}
+		// encoding/json writes indexes as path elements: data.volumes.0.unit
+		field := jsonIndexPattern.ReplaceAllString(typeErr.Field, "[$1]")
+		return invalidField(field, fieldCodeInvalidType, "must be a %s, not a %s", jsonKind(typeErr.Type), typeErr.Value)
+	case errors.Is(err, errNumberPrecision):
+		return invalidField("", fieldCodeOutOfRange, "%v", err)
+	}
+	return invalidField("", fieldCodeInvalidJSON, "malformed JSON: %v", err)
+}
+
+var jsonIndexPattern = regexp.MustCompile(`\.(\d+)\b`)
+
+// jsonKind names the JSON type a Go type is decoded from
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonKind(t.Elem())
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
+
+// respondInvalid answers 400 for a request that failed decoding or
+// validation, listing the invalid fields
+func respondInvalid(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondTooLarge(c, tooLarge.Limit)
+		return
+	}
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		respondError(c, http.StatusBadRequest, "invalid request body", err)
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error(), "fields": invalid.Fields})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestValidation(t *testing.T) {
+	tooMany := make([]Volume, 101)
+	for i := range tooMany {
+		tooMany[i] = Volume{ObjectName: "rice", VolumeCups: 1}
+	}
+	tests := []struct {
+		name      string
+		body      any
+		wantField string
+		wantCode  string
+	}{
+		{"empty object name", volumes(Volume{VolumeCups: 1}), "data.volumes[0].object_name", fieldCodeRequired},
+		{"zero volume", volumes(Volume{ObjectName: "rice"}), "data.volumes[0].volume_cups", fieldCodeNotPositive},
+		{"negative uncertainty", volumes(Volume{ObjectName: "rice", VolumeCups: 1, UncertaintyCups: -1}), "data.volumes[0].uncertainty_cups", fieldCodeNegative},
+		{"unknown unit", volumes(Volume{ObjectName: "rice", VolumeCups: 1, Unit: "pint"}), "data.volumes[0].unit", fieldCodeInvalidValue},
+		{"too many volumes", volumes(tooMany...), "data.volumes", fieldCodeTooMany},
+		{"frame id format", `{"data": {"frame_id": "../frame", "volumes": []}}`, "data.frame_id", fieldCodeInvalidFmt},
+		{"wrong type", `{"data": {"volumes": [{"object_name": "rice", "volume_cups": "1"}]}}`, "data.volumes[0].volume_cups", fieldCodeInvalidType},
+		{"malformed JSON", `{"data": `, "", fieldCodeInvalidJSON},
+	}
+	router := setupServer(t, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := decode[struct {
+				Error  string       `json:"error"`
+				Fields []FieldError `json:"fields"`
+			}](t, doRequest(t, router, http.MethodPost, "/v1/calculate-macros", tt.body), http.StatusBadRequest)
+			if len(response.Fields) != 1 || response.Fields[0].Field != tt.wantField || response.Fields[0].Code != tt.wantCode {
+				t.Errorf("fields = %+v, want %s %s", response.Fields, tt.wantField, tt.wantCode)
+			}
+			if strings.Contains(response.Error, "Key: ") || strings.Contains(response.Error, "Go struct") {
+				t.Errorf("error = %q, want it without validator or encoding/json internals", response.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+// version.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireDataVersion answers 404 when ?data_version names a version no
+// dataset holds, so a mistyped version isn't reported as every food
+// missing. It reports whether the request may proceed.
+func requireDataVersion(c *gin.Context) bool {
+	version := c.Query("data_version")
+	if version == "" {
+		return true
+	}
+
+	exists, err := foodRepo.HasDataVersion(c.Request.Context(), version)
+	if err != nil {
+		if !timedOut(c) {
+			respondError(c, http.StatusBadGateway, "failed to look up data version", err)
+		}
+		return false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown data_version: %s", version)})
+		return false
+	}
+	return true
+}
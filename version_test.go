@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+)
+
+// storeVersion stores a copy of a snapshot food ingested as a data
+// version, under another fdcId and with every nutrient amount scaled
+func storeVersion(t *testing.T, fdcID, versionID int, version string, scale float64) {
+	t.Helper()
+	ctx := context.Background()
+	document, ok, err := foodRepo.GetByFDCID(ctx, cfg.DefaultDataset, fdcID)
+	if err != nil || !ok {
+		t.Fatalf("food %d not in the snapshot: %v", fdcID, err)
+	}
+	var food FoodData
+	if err := json.Unmarshal(document.Data, &food); err != nil {
+		t.Fatal(err)
+	}
+	food.FdcID = versionID
+	food.DataVersion = version
+	for i := range food.FoodNutrients {
+		food.FoodNutrients[i].Amount *= scale
+	}
+	data, err := json.Marshal(food)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := foodRepo.StoreFoods(ctx, cfg.DefaultDataset, []json.RawMessage{data}, 0); err != nil {
+		t.Fatalf("failed to store version: %v", err)
+	}
+}
+
+func TestDataVersion(t *testing.T) {
+	// One cup, sliced, of the snapshot banana has 34.2 g of carbs; the
+	// 2024-04 banana has twice the nutrients
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantVersion string
+		wantCarbs   float64
+	}{
+		{"any version", "", http.StatusOK, "", 34.2},
+		{"older version", "?data_version=2023-10", http.StatusOK, "2023-10", 34.2},
+		{"latest version", "?data_version=2024-04", http.StatusOK, "2024-04", 68.4},
+		{"unknown version", "?data_version=2019-01", http.StatusNotFound, "", 0},
+	}
+	router := setupServer(t, "")
+	storeVersion(t, 5, 205, "2023-10", 1)
+	storeVersion(t, 5, 305, "2024-04", 2)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros"+tt.query, volumes(Volume{ObjectName: "banana", VolumeCups: 1}))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			item := decode[MacroResponse](t, w, http.StatusOK).Data[0]
+			if item.DataVersion != tt.wantVersion || math.Abs(item.Macros.Carbs-tt.wantCarbs) > 1e-9 {
+				t.Errorf("data_version = %q, carbs = %v; want %q, %v", item.DataVersion, item.Macros.Carbs, tt.wantVersion, tt.wantCarbs)
+			}
+		})
+	}
+}
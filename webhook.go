@@ -0,0 +1,123 @@
+// webhook.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WebhookConfig controls delivery of results to client-supplied callbacks
+type WebhookConfig struct {
+	// AllowedHosts lists the only hosts callbacks may be sent to; webhooks
+	// are disabled while it is empty
+	AllowedHosts []string      `yaml:"allowed_hosts"`
+	MaxAttempts  int           `yaml:"max_attempts"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+func (w *WebhookConfig) validate() error {
+	if w.MaxAttempts == 0 {
+		w.MaxAttempts = 3
+	}
+	if w.Timeout == 0 {
+		w.Timeout = 10 * time.Second
+	}
+	if w.MaxAttempts < 0 || w.Timeout < 0 {
+		return errors.New("webhooks.max_attempts and webhooks.timeout must be positive")
+	}
+	for i, host := range w.AllowedHosts {
+		w.AllowedHosts[i] = strings.ToLower(strings.TrimSpace(host))
+	}
+	return nil
+}
+
+// webhookClient never follows redirects, so an allowed host can't bounce
+// deliveries to an internal address
+var webhookClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookBackoff is the wait before the first retry; it doubles after
+// every failed attempt
+var webhookBackoff = time.Second
+
+// validateCallbackURL only accepts https URLs on an allowlisted host, which
+// keeps the callback from being used to reach internal services
+func validateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("callback_url is not a valid URL")
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("callback_url must use https")
+	}
+	if u.User != nil {
+		return fmt.Errorf("callback_url must not contain credentials")
+	}
+	if !slices.Contains(cfg.Webhooks.AllowedHosts, strings.ToLower(u.Hostname())) {
+		return fmt.Errorf("callback_url host %q is not allowed", u.Hostname())
+	}
+	return nil
+}
+
+// sendWebhook posts the response to the callback URL in the background,
+// retrying with exponential backoff on network errors, 429s and 5xx
+func sendWebhook(ctx context.Context, callbackURL, frameID string, response MacroResponse) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		slog.ErrorContext(ctx, "webhook not sent", "frame_id", frameID, "error", err)
+		return
+	}
+
+	// Deliveries outlive the request; keep its request ID for the logs
+	ctx = context.WithoutCancel(ctx)
+
+	go func() {
+		backoff := webhookBackoff
+		for attempt := 1; attempt <= cfg.Webhooks.MaxAttempts; attempt++ {
+			err := postWebhook(callbackURL, frameID, body)
+			if err == nil {
+				slog.InfoContext(ctx, "webhook delivered", "frame_id", frameID, "url", callbackURL)
+				return
+			}
+			slog.WarnContext(ctx, "webhook failed", "frame_id", frameID, "attempt", attempt, "max_attempts", cfg.Webhooks.MaxAttempts, "error", err)
+			if attempt < cfg.Webhooks.MaxAttempts {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		}
+	}()
+}
+
+func postWebhook(callbackURL, frameID string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Frame-ID", frameID)
+
+	client := *webhookClient
+	client.Timeout = cfg.Webhooks.Timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookDelivery is a callback the test server received
+type webhookDelivery struct {
+	frameID  string
+	response MacroResponse
+}
+
+// webhookServer starts an https callback receiver that fails the first
+// failures deliveries with 503, and points webhookClient at it
+func webhookServer(t *testing.T, failures int) (*httptest.Server, <-chan webhookDelivery) {
+	t.Helper()
+	deliveries := make(chan webhookDelivery, 10)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var response MacroResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Errorf("webhook body isn't a MacroResponse: %s", body)
+		}
+		deliveries <- webhookDelivery{frameID: r.Header.Get("X-Frame-ID"), response: response}
+	}))
+	t.Cleanup(server.Close)
+
+	previousClient, previousBackoff := webhookClient, webhookBackoff
+	t.Cleanup(func() { webhookClient, webhookBackoff = previousClient, previousBackoff })
+	client := *webhookClient
+	client.Transport = server.Client().Transport
+	webhookClient = &client
+	webhookBackoff = time.Millisecond
+	return server, deliveries
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	tests := []struct {
+		name         string
+		allowed      string
+		url          func(server string) string
+		failures     int
+		wantStatus   int
+		wantDelivery bool
+	}{
+		{"delivers the computed response", "127.0.0.1", func(s string) string { return s + "/hook" }, 0, http.StatusOK, true},
+		{"retries failed deliveries", "127.0.0.1", func(s string) string { return s + "/hook" }, 2, http.StatusOK, true},
+		{"host not on the allowlist", "hooks.example.com", func(s string) string { return s + "/hook" }, 0, http.StatusBadRequest, false},
+		{"webhooks disabled without an allowlist", "", func(s string) string { return s + "/hook" }, 0, http.StatusBadRequest, false},
+		{"plain http", "127.0.0.1", func(s string) string {
+			u, _ := url.Parse(s)
+			u.Scheme = "http"
+			return u.String()
+		}, 0, http.StatusBadRequest, false},
+		{"credentials in the URL", "127.0.0.1", func(s string) string {
+			u, _ := url.Parse(s)
+			u.User = url.UserPassword("admin", "secret")
+			return u.String()
+		}, 0, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ""
+			if tt.allowed != "" {
+				config = "webhooks:\n  allowed_hosts: [" + tt.allowed + "]\n"
+			}
+			testConfig(t, noFuzzy+config)
+			router := gin.New()
+			router.POST("/v1/calculate-macros", calculateMacros)
+			server, deliveries := webhookServer(t, tt.failures)
+
+			// An unknown food is computed without a query
+			body := volumes(Volume{ObjectName: "quinoa", VolumeCups: 1})
+			body.Data.FrameID = "frame-1"
+			body.Data.CallbackURL = tt.url(server.URL)
+			w := doRequest(t, router, http.MethodPost, "/v1/calculate-macros", body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			// Rejected callbacks fail the request before anything is sent
+			if !tt.wantDelivery {
+				return
+			}
+
+			select {
+			case delivery := <-deliveries:
+				// The callback gets the same response as the caller
+				response := decode[MacroResponse](t, w, http.StatusOK)
+				if delivery.frameID != "frame-1" || !reflect.DeepEqual(delivery.response, response) {
+					t.Errorf("delivered %+v for frame %q, want %+v", delivery.response, delivery.frameID, response)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("webhook not delivered")
+			}
+		})
+	}
+}